@@ -0,0 +1,8 @@
+package api
+
+import _ "embed"
+
+// OpenAPI describes the API served under /api/v1
+//
+//go:embed openapi.json
+var OpenAPI []byte
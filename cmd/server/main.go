@@ -2,16 +2,12 @@ package main
 
 import (
 	"log"
-	"vox-server/internal/server"
+	"os"
+	"vox-server/internal/cli"
 )
 
 func main() {
-	s, err := server.StartServer(false)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	if err := s.RunServer(); err != nil {
+	if err := cli.New().Run(os.Args[1:]); err != nil {
 		log.Fatal(err)
 	}
 }
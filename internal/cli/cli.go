@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+	"vox-server/internal/server"
+)
+
+const usage = `usage: vox-server [--config <file>] <command> [arguments]
+
+The config file is taken from --config, VOX_CONFIG, ./config.yaml or
+/etc/vox-server/config.yaml, in that order. Environment variables override it.
+
+commands:
+  serve              run the server (the default)
+  migrate            show or change the schema version of the database
+  user               create, delete, promote users or reset their password
+  bootstrap-admin    create the first admin of an empty deployment
+  config             validate or print the config
+  keys rotate        replace the key signing ID tokens
+
+Run 'vox-server <command> -h' for the arguments of a command.
+`
+
+// closeTimeout bounds storing the audit events and closing the database after a command
+const closeTimeout = 10 * time.Second
+
+// App runs the commands of the server binary
+type App struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// set by the --config flag of Run
+	ConfigPath string
+	// LoadConfig reads the config, i.e. server.LoadConfig of the ConfigPath
+	LoadConfig func() (*server.Config, error)
+	// OpenServer builds the server the commands work with, i.e. on the main database
+	OpenServer func(cfg *server.Config) (*server.Server, error)
+}
+
+func New() *App {
+	app := &App{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		OpenServer: func(cfg *server.Config) (*server.Server, error) {
+			return server.StartServerWithConfig(cfg, false)
+		},
+	}
+	app.LoadConfig = func() (*server.Config, error) {
+		return server.LoadConfig(app.ConfigPath)
+	}
+
+	return app
+}
+
+// extractConfigFlag removes '--config <file>' or '--config=<file>' (with one or two dashes)
+// from anywhere in the arguments, so that it applies to every command
+func extractConfigFlag(args []string) (string, []string, error) {
+	var path string
+	rest := make([]string, 0, len(args))
+
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !strings.HasPrefix(args[i], "-") || name != "config" {
+			rest = append(rest, args[i])
+			continue
+		}
+
+		if !hasValue {
+			if i+1 == len(args) {
+				return "", nil, errors.New("--config needs a file")
+			}
+			i++
+			value = args[i]
+		}
+		path = value
+	}
+
+	return path, rest, nil
+}
+
+// Run runs the command of the arguments, without a command the server is run
+func (app *App) Run(args []string) error {
+	path, args, err := extractConfigFlag(args)
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		app.ConfigPath = path
+	}
+
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		err = app.serve(args)
+	case "migrate":
+		err = app.migrate(args)
+	case "user":
+		err = app.user(args)
+	case "bootstrap-admin":
+		err = app.bootstrapAdmin(args)
+	case "config":
+		err = app.config(args)
+	case "keys":
+		err = app.keys(args)
+	case "help":
+		fmt.Fprint(app.Stdout, usage)
+	default:
+		fmt.Fprint(app.Stderr, usage)
+		err = fmt.Errorf("unknown command '%s'", command)
+	}
+
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return err
+}
+
+func (app *App) flagSet(name, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(app.Stderr)
+	flags.Usage = func() {
+		fmt.Fprint(app.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// withServer opens the server for the command and closes it afterwards,
+// so that the audit events of the command are stored
+func (app *App) withServer(fn func(s *server.Server) error) error {
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	s, err := app.OpenServer(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+
+	return errors.Join(fn(s), s.Close(ctx))
+}
+
+// operator is the actor of the audit events of commands
+func operator() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
+
+// password reads the first line of stdin, or generates a password to print when reading is not asked for
+func (app *App) password(fromStdin bool) (string, bool, error) {
+	if fromStdin {
+		line, err := bufio.NewReader(app.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", false, fmt.Errorf("failed to read password: %w", err)
+		}
+
+		password := strings.TrimRight(line, "\r\n")
+		if password == "" {
+			return "", false, errors.New("no password on stdin")
+		}
+		return password, false, nil
+	}
+
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", false, err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), true, nil
+}
@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+const configUsage = `usage: vox-server config <command>
+
+commands:
+  validate   check the config without connecting anywhere
+  print      print the effective config, with its secrets redacted
+`
+
+func (app *App) config(args []string) error {
+	if len(args) != 1 {
+		fmt.Fprint(app.Stderr, configUsage)
+		return errors.New("expected exactly one config command")
+	}
+
+	switch args[0] {
+	case "validate":
+		cfg, err := app.LoadConfig()
+		if err != nil {
+			return err
+		}
+
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+
+		fmt.Fprintln(app.Stdout, "Config is valid.")
+		return nil
+	case "print":
+		cfg, err := app.LoadConfig()
+		if err != nil {
+			return err
+		}
+
+		encoder := yaml.NewEncoder(app.Stdout)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(cfg.Redacted()); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(app.Stdout, configUsage)
+		return nil
+	default:
+		fmt.Fprint(app.Stderr, configUsage)
+		return fmt.Errorf("unknown config command '%s'", args[0])
+	}
+}
@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"vox-server/internal/server"
+)
+
+const keysUsage = `usage: vox-server keys rotate
+
+Writes a new key signing ID tokens to the configured signing key path. The replaced key
+is kept next to it and published in the JWKS until the next rotation, so that the tokens
+it signed can still be verified. Restart the servers to sign with the new key.
+`
+
+func (app *App) keys(args []string) error {
+	if len(args) != 1 || args[0] != "rotate" {
+		fmt.Fprint(app.Stderr, keysUsage)
+		return errors.New("expected 'keys rotate'")
+	}
+
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	id, previousID, err := server.RotateSigningKey(cfg.OAuth.SigningKeyPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(app.Stdout, "Signing key rotated, the new key id is '%s'.\n", id)
+	if previousID != "" {
+		fmt.Fprintf(app.Stdout, "The previous key '%s' stays published until the next rotation.\n", previousID)
+	}
+	return nil
+}
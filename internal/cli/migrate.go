@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"vox-server/internal/migrator"
+)
+
+const migrateUsage = `usage: vox-server migrate [flags] <command>
+
+commands:
+  status        show the schema version and the pending migrations
+  up            apply every pending migration
+  down          revert the latest migration
+  to <version>  migrate up or down to the version
+
+flags:
+`
+
+func (app *App) migrate(args []string) error {
+	flags := app.flagSet("migrate", migrateUsage)
+	test := flags.Bool("test", false, "migrate the test database")
+	createDB := flags.Bool("create-db", false, "create the database when it doesn't exist")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		return err
+	}
+	databaseURL := cfg.DatabaseURLFor(*test)
+
+	if *createDB {
+		created, err := migrator.CreateDatabase(context.Background(), databaseURL)
+		if err != nil {
+			return err
+		}
+		if created {
+			fmt.Fprintln(app.Stdout, "Database created.")
+		}
+	}
+
+	m, err := migrator.New(databaseURL)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	var applied bool
+	switch flags.Arg(0) {
+	case "status":
+	case "up":
+		applied, err = m.Up()
+	case "down":
+		applied, err = m.Down()
+	case "to":
+		version, parseErr := strconv.ParseUint(flags.Arg(1), 10, 64)
+		if parseErr != nil {
+			flags.Usage()
+			return fmt.Errorf("invalid version '%s'", flags.Arg(1))
+		}
+		applied, err = m.To(uint(version))
+	default:
+		flags.Usage()
+		return errors.New("unknown migrate command")
+	}
+	if err != nil {
+		return err
+	}
+
+	if flags.Arg(0) != "status" {
+		if applied {
+			fmt.Fprintln(app.Stdout, "Migrations applied successfully.")
+		} else {
+			fmt.Fprintln(app.Stdout, "No new migrations to apply.")
+		}
+	}
+
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+	printStatus(app.Stdout, status)
+
+	return nil
+}
+
+func printStatus(w io.Writer, status migrator.Status) {
+	fmt.Fprintf(w, "version: %d\n", status.Version)
+	fmt.Fprintf(w, "dirty:   %t\n", status.Dirty)
+	fmt.Fprintf(w, "latest:  %d\n", status.Latest)
+
+	if len(status.Pending) == 0 {
+		fmt.Fprintln(w, "pending: none")
+		return
+	}
+
+	fmt.Fprintln(w, "pending:")
+	for _, version := range status.Pending {
+		fmt.Fprintf(w, "  %d\n", version)
+	}
+}
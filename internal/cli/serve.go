@@ -0,0 +1,31 @@
+package cli
+
+const serveUsage = `usage: vox-server serve [flags]
+
+flags:
+`
+
+func (app *App) serve(args []string) error {
+	flags := app.flagSet("serve", serveUsage)
+	createDB := flags.Bool("create-db", false, "create the database on startup when it doesn't exist")
+	autoMigrate := flags.Bool("auto-migrate", false, "apply the pending migrations on startup")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	// the flags enable what the config doesn't
+	cfg.DB.Create = cfg.DB.Create || *createDB
+	cfg.DB.AutoMigrate = cfg.DB.AutoMigrate || *autoMigrate
+
+	s, err := app.OpenServer(cfg)
+	if err != nil {
+		return err
+	}
+
+	return s.RunServer()
+}
@@ -0,0 +1,293 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"vox-server/internal/models"
+	"vox-server/internal/server"
+	"vox-server/internal/storage"
+)
+
+const userUsage = `usage: vox-server user <command> [flags] [login]
+
+commands:
+  create                    create a user, see 'user create -h'
+  delete <login>            delete a user
+  promote [flags] <login>   change the role of a user, admin by default
+  reset-password [flags] <login>
+                            set a new password, generated unless read from stdin
+`
+
+func (app *App) user(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(app.Stderr, userUsage)
+		return errors.New("missing user command")
+	}
+
+	switch args[0] {
+	case "create":
+		return app.userCreate(args[1:])
+	case "delete":
+		return app.userDelete(args[1:])
+	case "promote":
+		return app.userPromote(args[1:])
+	case "reset-password":
+		return app.userResetPassword(args[1:])
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(app.Stdout, userUsage)
+		return nil
+	default:
+		fmt.Fprint(app.Stderr, userUsage)
+		return fmt.Errorf("unknown user command '%s'", args[0])
+	}
+}
+
+// loginArg is the single positional argument of a command
+func loginArg(args []string) (string, error) {
+	if len(args) != 1 || args[0] == "" {
+		return "", errors.New("expected exactly one login")
+	}
+	return args[0], nil
+}
+
+const userCreateUsage = `usage: vox-server user create [flags]
+
+flags:
+`
+
+func (app *App) userCreate(args []string) error {
+	flags := app.flagSet("user create", userCreateUsage)
+	login := flags.String("login", "", "login of the user (required)")
+	email := flags.String("email", "", "email of the user (required)")
+	username := flags.String("username", "", "display name, the login when empty")
+	admin := flags.Bool("admin", false, "give the user the admin role")
+	passwordStdin := flags.Bool("password-stdin", false, "read the password from the first line of stdin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *login == "" || *email == "" {
+		flags.Usage()
+		return errors.New("-login and -email are required")
+	}
+
+	role := models.RoleUser
+	if *admin {
+		role = models.RoleAdmin
+	}
+
+	return app.createUser(*login, *email, *username, role, *passwordStdin)
+}
+
+func (app *App) createUser(login, email, username, role string, passwordStdin bool) error {
+	password, generated, err := app.password(passwordStdin)
+	if err != nil {
+		return err
+	}
+
+	return app.withServer(func(s *server.Server) error {
+		return app.addUser(s, login, email, username, role, password, generated)
+	})
+}
+
+func (app *App) addUser(s *server.Server, login, email, username, role, password string, generated bool) error {
+	if username == "" {
+		username = login
+	}
+
+	user := &models.User{
+		Login:    login,
+		Username: username,
+		Email:    email,
+		Password: password,
+		Role:     role,
+	}
+
+	// a running server publishes the event once the user is committed
+	err := s.Storage().WithTx(context.Background(), func(tx storage.Storage) error {
+		if err := tx.Users().Create(user); err != nil {
+			return err
+		}
+		return s.PublishWebhooks(tx, models.AuditUserRegistered, operator(), login)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.AuditOperator(models.AuditUserRegistered, operator(), login, map[string]any{"role": role})
+
+	fmt.Fprintf(app.Stdout, "User '%s' created with the role '%s'.\n", login, role)
+	if generated {
+		fmt.Fprintf(app.Stdout, "Password: %s\n", password)
+	}
+	return nil
+}
+
+const userDeleteUsage = `usage: vox-server user delete <login>
+`
+
+func (app *App) userDelete(args []string) error {
+	flags := app.flagSet("user delete", userDeleteUsage)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	login, err := loginArg(flags.Args())
+	if err != nil {
+		flags.Usage()
+		return err
+	}
+
+	return app.withServer(func(s *server.Server) error {
+		user, err := s.Storage().Users().FindByLogin(login)
+		if err != nil {
+			return fmt.Errorf("user '%s' not found", login)
+		}
+
+		err = s.Storage().WithTx(context.Background(), func(tx storage.Storage) error {
+			if err := tx.Users().DeleteByLogin(login); err != nil {
+				return err
+			}
+			return s.PublishWebhooks(tx, models.AuditUserDeleted, operator(), login)
+		})
+		if err != nil {
+			return err
+		}
+
+		s.AuditOperator(models.AuditUserDeleted, operator(), login, map[string]any{"email": user.Email})
+
+		fmt.Fprintf(app.Stdout, "User '%s' deleted.\n", login)
+		return nil
+	})
+}
+
+const userPromoteUsage = `usage: vox-server user promote [flags] <login>
+
+flags:
+`
+
+func (app *App) userPromote(args []string) error {
+	flags := app.flagSet("user promote", userPromoteUsage)
+	role := flags.String("role", models.RoleAdmin, "role to give, 'user' demotes an admin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	login, err := loginArg(flags.Args())
+	if err != nil {
+		flags.Usage()
+		return err
+	}
+
+	if *role != models.RoleUser && *role != models.RoleAdmin {
+		return fmt.Errorf("unknown role '%s'", *role)
+	}
+
+	return app.withServer(func(s *server.Server) error {
+		user, err := s.Storage().Users().FindByLogin(login)
+		if err != nil {
+			return fmt.Errorf("user '%s' not found", login)
+		}
+
+		if user.Role == *role {
+			fmt.Fprintf(app.Stdout, "User '%s' has the role '%s' already.\n", login, *role)
+			return nil
+		}
+
+		previous := user.Role
+		updated := *user
+		updated.Role = *role
+		if err := s.Storage().Users().Update(&updated); err != nil {
+			return err
+		}
+
+		s.AuditOperator(models.AuditRoleChanged, operator(), login, map[string]any{
+			"from": previous,
+			"to":   *role,
+		})
+
+		fmt.Fprintf(app.Stdout, "User '%s' now has the role '%s'.\n", login, *role)
+		return nil
+	})
+}
+
+const userResetPasswordUsage = `usage: vox-server user reset-password [flags] <login>
+
+flags:
+`
+
+func (app *App) userResetPassword(args []string) error {
+	flags := app.flagSet("user reset-password", userResetPasswordUsage)
+	passwordStdin := flags.Bool("password-stdin", false, "read the password from the first line of stdin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	login, err := loginArg(flags.Args())
+	if err != nil {
+		flags.Usage()
+		return err
+	}
+
+	password, generated, err := app.password(*passwordStdin)
+	if err != nil {
+		return err
+	}
+
+	return app.withServer(func(s *server.Server) error {
+		err := s.Storage().WithTx(context.Background(), func(tx storage.Storage) error {
+			if err := tx.Users().UpdatePassword(login, password); err != nil {
+				return err
+			}
+			return s.PublishWebhooks(tx, models.AuditPasswordChanged, operator(), login)
+		})
+		if err != nil {
+			return err
+		}
+
+		s.AuditOperator(models.AuditPasswordChanged, operator(), login, nil)
+
+		fmt.Fprintf(app.Stdout, "Password of '%s' reset.\n", login)
+		if generated {
+			fmt.Fprintf(app.Stdout, "Password: %s\n", password)
+		}
+		return nil
+	})
+}
+
+const bootstrapAdminUsage = `usage: vox-server bootstrap-admin [flags]
+
+Creates an admin when the deployment has no users yet, and does nothing otherwise.
+
+flags:
+`
+
+func (app *App) bootstrapAdmin(args []string) error {
+	flags := app.flagSet("bootstrap-admin", bootstrapAdminUsage)
+	login := flags.String("login", "admin", "login of the admin")
+	email := flags.String("email", "", "email of the admin (required)")
+	passwordStdin := flags.Bool("password-stdin", false, "read the password from the first line of stdin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *email == "" {
+		flags.Usage()
+		return errors.New("-email is required")
+	}
+
+	password, generated, err := app.password(*passwordStdin)
+	if err != nil {
+		return err
+	}
+
+	return app.withServer(func(s *server.Server) error {
+		if !s.Storage().Users().IsEmpty() {
+			fmt.Fprintln(app.Stdout, "Users exist already, nothing to do. Use 'user promote' to make one an admin.")
+			return nil
+		}
+
+		return app.addUser(s, *login, *email, "", models.RoleAdmin, password, generated)
+	})
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const apiKeyTokenPrefix = "vox"
+
+// APIKeyUsageResolution is the precision of LastUsedAt, a key used again within it isn't touched
+const APIKeyUsageResolution = time.Minute
+
+// Scopes an API key can be granted. A key may only call routes whose scope it carries,
+// JWT sessions are not restricted by scopes
+const (
+	ScopeProfileRead  = "profile:read"
+	ScopeProfileWrite = "profile:write"
+	ScopeKeysRead     = "keys:read"
+	ScopeKeysWrite    = "keys:write"
+	ScopeClientsRead  = "clients:read"
+	ScopeClientsWrite = "clients:write"
+	ScopeAdmin        = "admin" // has effect only for keys of admins
+)
+
+var APIKeyScopes = []string{
+	ScopeProfileRead,
+	ScopeProfileWrite,
+	ScopeKeysRead,
+	ScopeKeysWrite,
+	ScopeClientsRead,
+	ScopeClientsWrite,
+	ScopeAdmin,
+}
+
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserLogin  string     `validate:"required" json:"user_login"`
+	Name       string     `validate:"required,lte=64" json:"name"`
+	Prefix     string     `validate:"required" json:"prefix"`
+	KeyHash    string     `validate:"required" json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+
+	// plain key, is known only right after NewAPIKey and is never stored
+	Key string `validate:"-" json:"key,omitempty"`
+}
+
+// NewAPIKey generates a key of the form "vox_<prefix>_<secret>".
+// Only the prefix (to look the key up) and the sha256 of the whole key are kept
+func NewAPIKey(login, name string, scopes []string) (*APIKey, error) {
+	prefix, err := randomString(6)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%s_%s_%s", apiKeyTokenPrefix, prefix, secret)
+
+	return &APIKey{
+		UserLogin: login,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   HashSecret(key),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+		Key:       key,
+	}, nil
+}
+
+// ParseAPIKeyPrefix extracts the lookup prefix from a plain key
+func ParseAPIKeyPrefix(key string) (string, error) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyTokenPrefix || parts[1] == "" || parts[2] == "" {
+		return "", fmt.Errorf("malformed api key")
+	}
+
+	return parts[1], nil
+}
+
+func (k *APIKey) Validate() error {
+	if validate == nil {
+		validate = newValidator()
+	}
+
+	if err := validate.Struct(k); err != nil {
+		return fmt.Errorf("validation error: %w", err)
+	}
+
+	for _, scope := range k.Scopes {
+		if !slices.Contains(APIKeyScopes, scope) {
+			return invalid("unknown scope '%s'", scope)
+		}
+	}
+
+	return nil
+}
+
+func (k *APIKey) CompareKey(key string) bool {
+	return subtle.ConstantTimeCompare([]byte(k.KeyHash), []byte(HashSecret(key))) == 1
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+func (k *APIKey) IsRevoked() bool {
+	return k.RevokedAt != nil
+}
+
+// NeedsTouch reports whether LastUsedAt is older than APIKeyUsageResolution at now
+func (k *APIKey) NeedsTouch(now time.Time) bool {
+	return k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= APIKeyUsageResolution
+}
+
+func (k *APIKey) Sanitize() {
+	k.Key = ""
+}
+
+// HashSecret is used for high-entropy random secrets (api keys, client secrets, codes),
+// bcrypt is not needed for them
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	// '_' is the key separator, so it must not appear inside the parts
+	return strings.ReplaceAll(base64.RawURLEncoding.EncodeToString(b), "_", "-"), nil
+}
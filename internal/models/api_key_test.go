@@ -0,0 +1,101 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+	"vox-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKey_New(t *testing.T) {
+	key, err := models.NewAPIKey("user", "ci bot", []string{models.ScopeProfileRead})
+	assert.NoError(t, err)
+	assert.NoError(t, key.Validate())
+
+	prefix, err := models.ParseAPIKeyPrefix(key.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, key.Prefix, prefix)
+
+	assert.True(t, key.CompareKey(key.Key))
+	assert.False(t, key.CompareKey(key.Key+"x"))
+	assert.NotContains(t, key.KeyHash, key.Key)
+
+	assert.True(t, key.HasScope(models.ScopeProfileRead))
+	assert.False(t, key.HasScope(models.ScopeKeysWrite))
+
+	key.Sanitize()
+	assert.Empty(t, key.Key)
+}
+
+func TestAPIKey_NeedsTouch(t *testing.T) {
+	key, err := models.NewAPIKey("user", "ci bot", []string{models.ScopeProfileRead})
+	assert.NoError(t, err)
+
+	now := time.Now()
+	// default case : never used
+	assert.True(t, key.NeedsTouch(now))
+
+	// case : used within the resolution
+	usedAt := now.Add(-time.Second)
+	key.LastUsedAt = &usedAt
+	assert.False(t, key.NeedsTouch(now))
+
+	// case : used before it
+	usedAt = now.Add(-models.APIKeyUsageResolution)
+	assert.True(t, key.NeedsTouch(now))
+}
+
+func TestAPIKey_Validate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		scopes  []string
+		keyName string
+		isValid bool
+	}{
+		{
+			name:    "valid",
+			scopes:  []string{models.ScopeKeysRead, models.ScopeKeysWrite},
+			keyName: "deploy",
+			isValid: true,
+		},
+		{
+			name:    "no scopes",
+			scopes:  nil,
+			keyName: "deploy",
+			isValid: true,
+		},
+		{
+			name:    "empty name",
+			scopes:  []string{models.ScopeKeysRead},
+			keyName: "",
+			isValid: false,
+		},
+		{
+			name:    "unknown scope",
+			scopes:  []string{"users:delete"},
+			keyName: "deploy",
+			isValid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := models.NewAPIKey("user", tc.keyName, tc.scopes)
+			assert.NoError(t, err)
+
+			if tc.isValid {
+				assert.NoError(t, key.Validate())
+			} else {
+				assert.Error(t, key.Validate())
+			}
+		})
+	}
+}
+
+func TestParseAPIKeyPrefix(t *testing.T) {
+	for _, key := range []string{"", "vox_", "vox__secret", "abc_prefix_secret", "token"} {
+		_, err := models.ParseAPIKeyPrefix(key)
+		assert.Error(t, err, key)
+	}
+}
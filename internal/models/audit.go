@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Actions of security-relevant events
+const (
+	AuditUserRegistered     = "user.registered"
+	AuditUserDeleted        = "user.deleted"
+	AuditPasswordChanged    = "user.password_changed"
+	AuditRoleChanged        = "user.role_changed"
+	AuditLoginSucceeded     = "session.login"
+	AuditLoginFailed        = "session.login_failed"
+	AuditLogout             = "session.logout"
+	AuditIdentityLinked     = "identity.linked"
+	AuditAPIKeyCreated      = "api_key.created"
+	AuditAPIKeyRevoked      = "api_key.revoked"
+	AuditOAuthClientCreated = "oauth_client.created"
+	AuditOAuthClientDeleted = "oauth_client.deleted"
+	AuditWebhookCreated     = "webhook.created"
+	AuditWebhookUpdated     = "webhook.updated"
+	AuditWebhookDeleted     = "webhook.deleted"
+	AuditWebhookDisabled    = "webhook.disabled"
+	AuditJobRetried         = "job.retried"
+	AuditConfigReloaded     = "config.reloaded"
+)
+
+// AuditEvent is an append-only record of who did what.
+// Actor is empty for anonymous requests (e.g. a failed login)
+type AuditEvent struct {
+	ID        int64           `json:"id"`
+	Time      time.Time       `json:"time"`
+	Actor     string          `json:"actor,omitempty"`
+	Target    string          `json:"target,omitempty"`
+	Action    string          `json:"action"`
+	IP        string          `json:"ip,omitempty"`
+	UserAgent string          `json:"user_agent,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	Metadata  json.RawMessage `json:"metadata,omitempty"`
+}
+
+// AuditFilter selects events, newest first. Empty fields match everything
+type AuditFilter struct {
+	Actor  string
+	Target string
+	Action string
+	Since  time.Time
+	Until  time.Time
+	Before int64 // keyset pagination: only events with smaller ids
+	Limit  int
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// IdempotencyRecord holds the first response to a request carrying an Idempotency-Key,
+// replayed to the retries of the request until it expires. Its status is 0 while the
+// first request is in flight
+type IdempotencyRecord struct {
+	// the login of the user sending the request, empty for anonymous requests
+	UserLogin string
+	Key       string
+	// identifies the request, a retry has to be the same request
+	Fingerprint string
+
+	Status int
+	Header http.Header
+	Body   []byte
+
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+func NewIdempotencyRecord(login, key, fingerprint string, ttl time.Duration) *IdempotencyRecord {
+	now := time.Now().UTC()
+
+	return &IdempotencyRecord{
+		UserLogin:   login,
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+	}
+}
+
+// RequestFingerprint hashes what makes two requests the same
+func RequestFingerprint(method, uri string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + uri + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// IsCompleted reports whether the first request got its response
+func (r *IdempotencyRecord) IsCompleted() bool {
+	return r.Status != 0
+}
+
+func (r *IdempotencyRecord) IsExpired(now time.Time) bool {
+	return !now.Before(r.ExpiresAt)
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Identity links an account of an external OpenID Connect provider to a local user
+type Identity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	UserLogin string    `json:"user_login"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Statuses of a job
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead" // ran out of attempts, kept until it is retried by hand
+)
+
+var JobStatuses = []string{JobPending, JobRunning, JobSucceeded, JobDead}
+
+// Job is a unit of background work, run by the handler of its kind at least once: a server
+// stopped in the middle of a job leaves it to the others once its lease ends
+type Job struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// unique among the stored jobs when set, e.g. a run of a recurring job
+	Key         string          `json:"key,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"` // counted when claimed
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`                 // not run before, also the time of the next attempt
+	LockedUntil *time.Time      `json:"locked_until,omitempty"` // the lease of the running attempt
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+// NewJob returns a pending job of the kind, due now. The payload is encoded as JSON
+func NewJob(kind string, payload any, maxAttempts int) (*Job, error) {
+	if kind == "" {
+		return nil, errors.New("job kind is required")
+	}
+
+	var encoded json.RawMessage
+	if payload != nil {
+		var err error
+		if encoded, err = json.Marshal(payload); err != nil {
+			return nil, fmt.Errorf("failed to encode payload of job '%s': %w", kind, err)
+		}
+	}
+
+	now := time.Now().UTC()
+	return &Job{
+		Kind:        kind,
+		Payload:     encoded,
+		Status:      JobPending,
+		MaxAttempts: maxAttempts,
+		RunAt:       now,
+		CreatedAt:   now,
+	}, nil
+}
+
+func (j *Job) IsFinished() bool {
+	return j.Status == JobSucceeded || j.Status == JobDead
+}
+
+// Decode decodes the payload of the job into v
+func (j *Job) Decode(v any) error {
+	if err := json.Unmarshal(j.Payload, v); err != nil {
+		return fmt.Errorf("invalid payload of job '%s': %w", j.Kind, err)
+	}
+	return nil
+}
+
+// Retry makes a dead job pending again, with all its attempts
+func (j *Job) Retry(now time.Time) {
+	j.Status = JobPending
+	j.Attempts = 0
+	j.RunAt = now
+	j.LockedUntil = nil
+	j.FinishedAt = nil
+}
+
+// JobFilter selects jobs, newest first. Empty fields match everything
+type JobFilter struct {
+	Kind   string
+	Status string
+	Limit  int
+}
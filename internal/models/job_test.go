@@ -0,0 +1,48 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+	"vox-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewJob(t *testing.T) {
+	job, err := models.NewJob("test.kind", map[string]string{"login": "alice"}, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, models.JobPending, job.Status)
+	assert.Equal(t, 3, job.MaxAttempts)
+	assert.False(t, job.RunAt.After(time.Now()), "due now")
+
+	var payload struct {
+		Login string `json:"login"`
+	}
+	assert.NoError(t, job.Decode(&payload))
+	assert.Equal(t, "alice", payload.Login)
+
+	_, err = models.NewJob("", nil, 3)
+	assert.Error(t, err)
+
+	_, err = models.NewJob("test.kind", func() {}, 3)
+	assert.Error(t, err, "the payload isn't JSON")
+}
+
+func TestJob_Retry(t *testing.T) {
+	job, _ := models.NewJob("test.kind", nil, 3)
+	finishedAt := time.Now().UTC()
+	job.Status = models.JobDead
+	job.Attempts = 3
+	job.LastError = "failed"
+	job.FinishedAt = &finishedAt
+	assert.True(t, job.IsFinished())
+
+	now := finishedAt.Add(time.Hour)
+	job.Retry(now)
+	assert.Equal(t, models.JobPending, job.Status)
+	assert.Zero(t, job.Attempts)
+	assert.Equal(t, now, job.RunAt)
+	assert.Nil(t, job.FinishedAt)
+	assert.Equal(t, "failed", job.LastError, "kept until the next attempt")
+	assert.False(t, job.IsFinished())
+}
@@ -0,0 +1,187 @@
+package models
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// OpenID Connect scopes, a client may also request APIKeyScopes except admin
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+var OIDCScopes = []string{
+	ScopeOpenID,
+	ScopeProfile,
+	ScopeEmail,
+}
+
+const (
+	CodeChallengeS256 = "S256"
+
+	AuthorizationCodeTTL = 5 * time.Minute
+)
+
+// OAuthScopes are the scopes a client may request, admin powers are never delegated to clients
+var OAuthScopes = slices.DeleteFunc(append(slices.Clone(OIDCScopes), APIKeyScopes...), func(scope string) bool {
+	return scope == ScopeAdmin
+})
+
+func IsKnownOAuthScope(scope string) bool {
+	return slices.Contains(OAuthScopes, scope)
+}
+
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	SecretHash   string    `json:"-"`
+	OwnerLogin   string    `validate:"required" json:"owner"`
+	Name         string    `validate:"required,lte=64" json:"name"`
+	RedirectURIs []string  `validate:"required,min=1,dive,url" json:"redirect_uris"`
+	Public       bool      `json:"public"` // public clients (SPA, CLI) have no secret and must use PKCE
+	CreatedAt    time.Time `json:"created_at"`
+
+	// plain secret, is known only right after NewOAuthClient and is never stored
+	Secret string `validate:"-" json:"client_secret,omitempty"`
+}
+
+func NewOAuthClient(owner, name string, redirectURIs []string, public bool) (*OAuthClient, error) {
+	client := &OAuthClient{
+		OwnerLogin:   owner,
+		Name:         name,
+		RedirectURIs: redirectURIs,
+		Public:       public,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	if !public {
+		secret, err := randomString(32)
+		if err != nil {
+			return nil, err
+		}
+
+		client.Secret = secret
+		client.SecretHash = HashSecret(secret)
+	}
+
+	return client, nil
+}
+
+func (c *OAuthClient) Validate() error {
+	if validate == nil {
+		validate = newValidator()
+	}
+
+	if err := validate.Struct(c); err != nil {
+		return fmt.Errorf("validation error: %w", err)
+	}
+
+	for _, uri := range c.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || u.Fragment != "" || (u.Scheme != "https" && u.Scheme != "http") {
+			return invalid("invalid redirect uri '%s'", uri)
+		}
+	}
+
+	if !c.Public && c.SecretHash == "" {
+		return invalid("confidential client must have a secret")
+	}
+
+	return nil
+}
+
+func (c *OAuthClient) CompareSecret(secret string) bool {
+	if c.Public {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.SecretHash), []byte(HashSecret(secret))) == 1
+}
+
+// HasRedirectURI requires an exact match, as recommended by OAuth 2.0 Security BCP
+func (c *OAuthClient) HasRedirectURI(uri string) bool {
+	return slices.Contains(c.RedirectURIs, uri)
+}
+
+func (c *OAuthClient) Sanitize() {
+	c.Secret = ""
+}
+
+type AuthorizationCode struct {
+	CodeHash            string
+	ClientID            string
+	UserLogin           string
+	RedirectURI         string
+	Scopes              []string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	AuthTime            time.Time
+	ExpiresAt           time.Time
+
+	// plain code, is known only right after NewAuthorizationCode and is never stored
+	Code string
+}
+
+func NewAuthorizationCode(clientID, login, redirectURI string, scopes []string) (*AuthorizationCode, error) {
+	code, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+
+	return &AuthorizationCode{
+		CodeHash:    HashSecret(code),
+		ClientID:    clientID,
+		UserLogin:   login,
+		RedirectURI: redirectURI,
+		Scopes:      scopes,
+		AuthTime:    now,
+		ExpiresAt:   now.Add(AuthorizationCodeTTL),
+		Code:        code,
+	}, nil
+}
+
+// VerifyChallenge checks the PKCE code_verifier (RFC 7636).
+// A code issued without a challenge accepts only an empty verifier
+func (c *AuthorizationCode) VerifyChallenge(verifier string) bool {
+	if c.CodeChallenge == "" {
+		return verifier == ""
+	}
+
+	if c.CodeChallengeMethod != CodeChallengeS256 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(c.CodeChallenge)) == 1
+}
+
+func (c *AuthorizationCode) IsExpired(now time.Time) bool {
+	return !now.Before(c.ExpiresAt)
+}
+
+// Consent remembers the scopes a user has already granted to a client
+type Consent struct {
+	UserLogin string    `json:"user_login"`
+	ClientID  string    `json:"client_id"`
+	Scopes    []string  `json:"scopes"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+func (c *Consent) Covers(scopes []string) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(c.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
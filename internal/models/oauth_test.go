@@ -0,0 +1,87 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+	"vox-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOAuthClient_Validate(t *testing.T) {
+	testCases := []struct {
+		name         string
+		redirectURIs []string
+		public       bool
+		isValid      bool
+	}{
+		{
+			name:         "valid confidential",
+			redirectURIs: []string{"https://wiki.local/callback"},
+			isValid:      true,
+		},
+		{
+			name:         "valid public",
+			redirectURIs: []string{"http://127.0.0.1:9000/callback"},
+			public:       true,
+			isValid:      true,
+		},
+		{
+			name:         "no redirect uris",
+			redirectURIs: nil,
+			isValid:      false,
+		},
+		{
+			name:         "redirect uri with fragment",
+			redirectURIs: []string{"https://wiki.local/callback#token"},
+			isValid:      false,
+		},
+		{
+			name:         "custom scheme",
+			redirectURIs: []string{"javascript://alert"},
+			isValid:      false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := models.NewOAuthClient("owner", "wiki", tc.redirectURIs, tc.public)
+			assert.NoError(t, err)
+
+			if tc.isValid {
+				assert.NoError(t, client.Validate())
+			} else {
+				assert.Error(t, client.Validate())
+			}
+		})
+	}
+}
+
+func TestOAuthClient_CompareSecret(t *testing.T) {
+	confidential, _ := models.NewOAuthClient("owner", "wiki", []string{"https://wiki.local/cb"}, false)
+	assert.NotEmpty(t, confidential.Secret)
+	assert.True(t, confidential.CompareSecret(confidential.Secret))
+	assert.False(t, confidential.CompareSecret("guess"))
+
+	public, _ := models.NewOAuthClient("owner", "cli", []string{"http://127.0.0.1/cb"}, true)
+	assert.Empty(t, public.Secret)
+	assert.False(t, public.CompareSecret(""))
+}
+
+func TestAuthorizationCode_VerifyChallenge(t *testing.T) {
+	code, err := models.NewAuthorizationCode("client", "user", "https://wiki.local/cb", []string{"openid"})
+	assert.NoError(t, err)
+	assert.False(t, code.IsExpired(time.Now()))
+	assert.True(t, code.IsExpired(time.Now().Add(models.AuthorizationCodeTTL)))
+
+	// without a challenge only an empty verifier passes
+	assert.True(t, code.VerifyChallenge(""))
+	assert.False(t, code.VerifyChallenge("verifier"))
+
+	// RFC 7636 Appendix B
+	code.CodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	code.CodeChallengeMethod = models.CodeChallengeS256
+	assert.True(t, code.VerifyChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+	assert.False(t, code.VerifyChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXz"))
+	assert.False(t, code.VerifyChallenge(""))
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Session is the server-side state behind a pair of issued tokens.
+// Revoking a session invalidates every token carrying its id
+type Session struct {
+	ID        string     `json:"id"`
+	UserLogin string     `json:"user_login"`
+	ClientID  string     `json:"client_id,omitempty"` // empty for first-party logins
+	Scopes    []string   `json:"scopes,omitempty"`    // empty for first-party logins, i.e. unrestricted
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func NewSession(login, clientID string, scopes []string, ttl time.Duration) *Session {
+	now := time.Now().UTC()
+
+	return &Session{
+		UserLogin: login,
+		ClientID:  clientID,
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+}
+
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// IsRestricted reports whether the session is limited to its scopes
+func (s *Session) IsRestricted() bool {
+	return s.ClientID != ""
+}
+
+func (s *Session) HasScope(scope string) bool {
+	return slices.Contains(s.Scopes, scope)
+}
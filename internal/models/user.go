@@ -1,6 +1,17 @@
 package models
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// tracer is looked up on every use, so that it follows the current global provider
+func tracer() trace.Tracer {
+	return otel.Tracer("vox-server/internal/models")
+}
 
 func encryptString(str string) (string, error) {
 	b, err := bcrypt.GenerateFromPassword([]byte(str), bcrypt.MinCost)
@@ -11,12 +22,19 @@ func encryptString(str string) (string, error) {
 	return string(b), nil
 }
 
+// Roles of a user, admins may manage other users and read the audit log
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	Login             string `validate:"required,lte=20" json:"login"`
 	Username          string `validate:"required,lte=20" json:"username"`
 	Email             string `validate:"required,email" json:"email"`
 	Password          string `validate:"required,min=8,max=40" json:"password,omitempty"`
 	EncryptedPassword string `validate:"omitempty" json:"-"`
+	Role              string `validate:"omitempty,oneof=user admin" json:"role"`
 }
 
 func (u *User) BeforeCreate() error {
@@ -29,13 +47,38 @@ func (u *User) BeforeCreate() error {
 		u.EncryptedPassword = enc
 	}
 
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
+
 	return nil
 }
 
+// BeforeCreateContext is BeforeCreate recorded as a span of the context,
+// hashing dominates the latency of registrations
+func (u *User) BeforeCreateContext(ctx context.Context) error {
+	_, span := tracer().Start(ctx, "bcrypt.GenerateFromPassword")
+	defer span.End()
+
+	return u.BeforeCreate()
+}
+
 func (u *User) Sanitize() {
 	u.Password = ""
 }
 
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
 func (u *User) ComparePassword(password string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(u.EncryptedPassword), []byte(password)) == nil
 }
+
+// ComparePasswordContext is ComparePassword recorded as a span of the context
+func (u *User) ComparePasswordContext(ctx context.Context, password string) bool {
+	_, span := tracer().Start(ctx, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+
+	return u.ComparePassword(password)
+}
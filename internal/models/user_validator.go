@@ -1,7 +1,9 @@
 package models
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 
 	"github.com/go-playground/validator"
@@ -9,24 +11,51 @@ import (
 
 var validate *validator.Validate
 
+// ErrInvalid is wrapped by the errors of the checks of Validate the validator doesn't make,
+// the errors of the validator are validator.ValidationErrors
+var ErrInvalid = errors.New("invalid")
+
+// invalidError keeps the message of the check, unlike a wrap of ErrInvalid by fmt.Errorf
+type invalidError string
+
+func (e invalidError) Error() string        { return string(e) }
+func (e invalidError) Is(target error) bool { return target == ErrInvalid }
+
+func invalid(format string, args ...any) error {
+	return invalidError(fmt.Sprintf(format, args...))
+}
+
+// newValidator names the fields of its errors as the json of the API does
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
 // if password_check == true => field 'password_check' must be filled
 func (u *User) Validate(password_check bool) error {
 	if validate == nil {
-		validate = validator.New()
+		validate = newValidator()
 	}
 
 	if strings.HasPrefix(u.Login, " ") || strings.HasSuffix(u.Login, " ") {
-		return fmt.Errorf("login should not start or end with spaces")
+		return invalid("login should not start or end with spaces")
 	}
 	if strings.HasPrefix(u.Username, " ") || strings.HasSuffix(u.Username, " ") {
-		return fmt.Errorf("username should not start or end with spaces")
+		return invalid("username should not start or end with spaces")
 	}
 
 	if hasSpecialCharacters(&u.Login) {
-		return fmt.Errorf("login contains special characters")
+		return invalid("login contains special characters")
 	}
 	if hasSpecialCharacters(&u.Username) {
-		return fmt.Errorf("username contains special characters")
+		return invalid("username contains special characters")
 	}
 
 	var err error
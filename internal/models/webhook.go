@@ -0,0 +1,153 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const webhookSecretPrefix = "whsec"
+
+// WebhookEvents are the events endpoints can subscribe to, named as the audit actions they are published by
+var WebhookEvents = []string{
+	AuditUserRegistered,
+	AuditUserDeleted,
+	AuditPasswordChanged,
+}
+
+func IsWebhookEvent(event string) bool {
+	return slices.Contains(WebhookEvents, event)
+}
+
+// Statuses of a webhook delivery
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookEndpoint receives the events it subscribed to. Unlike the secrets of keys and clients
+// its secret is stored in plain, the deliveries are signed with it
+type WebhookEndpoint struct {
+	ID         string     `json:"id"`
+	OwnerLogin string     `validate:"required" json:"owner"`
+	URL        string     `validate:"required,url" json:"url"`
+	Events     []string   `validate:"required,min=1" json:"events"`
+	Secret     string     `validate:"required" json:"secret,omitempty"`
+	Failures   int        `json:"failures"` // consecutive deliveries that failed for good
+	CreatedAt  time.Time  `json:"created_at"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+}
+
+func NewWebhookEndpoint(owner, url string, events []string) (*WebhookEndpoint, error) {
+	secret, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebhookEndpoint{
+		OwnerLogin: owner,
+		URL:        url,
+		Events:     events,
+		Secret:     webhookSecretPrefix + "_" + secret,
+		CreatedAt:  time.Now().UTC(),
+	}, nil
+}
+
+func (e *WebhookEndpoint) Validate() error {
+	if validate == nil {
+		validate = newValidator()
+	}
+
+	if err := validate.Struct(e); err != nil {
+		return fmt.Errorf("validation error: %w", err)
+	}
+
+	u, err := url.Parse(e.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return invalid("invalid webhook url '%s'", e.URL)
+	}
+
+	for _, event := range e.Events {
+		if !IsWebhookEvent(event) {
+			return invalid("unknown webhook event '%s'", event)
+		}
+	}
+
+	return nil
+}
+
+func (e *WebhookEndpoint) IsEnabled() bool {
+	return e.DisabledAt == nil
+}
+
+func (e *WebhookEndpoint) Subscribed(event string) bool {
+	return slices.Contains(e.Events, event)
+}
+
+// Sign returns the Vox-Signature header of a delivery sent at the time: "t=<unix time>,v1=<hex>",
+// where v1 is the HMAC-SHA256 of "<unix time>.<payload>" keyed with the secret.
+// Receivers reject signatures with an old time to prevent replays
+func (e *WebhookEndpoint) Sign(at time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(e.Secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func (e *WebhookEndpoint) Sanitize() {
+	e.Secret = ""
+}
+
+// WebhookPayload is the body of the deliveries of an event
+type WebhookPayload struct {
+	ID   string          `json:"id"` // the same for every delivery of the event, receivers dedupe by it
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// WebhookDelivery is an event sent to an endpoint, retried until it succeeds or runs out of attempts.
+// A replay is a new delivery of the same event
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	EndpointID     string          `json:"endpoint_id"`
+	EventID        string          `json:"event_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status,omitempty"` // of the last attempt
+	Error          string          `json:"error,omitempty"`           // of the last attempt
+	CreatedAt      time.Time       `json:"created_at"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"` // only while pending
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+func NewWebhookDelivery(endpointID, eventID, event string, payload json.RawMessage) *WebhookDelivery {
+	now := time.Now().UTC()
+	return &WebhookDelivery{
+		EndpointID:    endpointID,
+		EventID:       eventID,
+		Event:         event,
+		Payload:       payload,
+		Status:        WebhookDeliveryPending,
+		CreatedAt:     now,
+		NextAttemptAt: &now,
+	}
+}
+
+// Replay is a new pending delivery of the event of the delivery
+func (d *WebhookDelivery) Replay() *WebhookDelivery {
+	return NewWebhookDelivery(d.EndpointID, d.EventID, d.Event, d.Payload)
+}
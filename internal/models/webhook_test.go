@@ -0,0 +1,80 @@
+package models_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+	"vox-server/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookEndpoint_Validate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		url     string
+		events  []string
+		isValid bool
+	}{
+		{
+			name:    "valid",
+			url:     "https://hooks.example.org/vox",
+			events:  []string{models.AuditUserRegistered, models.AuditUserDeleted},
+			isValid: true,
+		},
+		{
+			name:    "no events",
+			url:     "https://hooks.example.org/vox",
+			isValid: false,
+		},
+		{
+			name:    "unknown event",
+			url:     "https://hooks.example.org/vox",
+			events:  []string{models.AuditLoginSucceeded},
+			isValid: false,
+		},
+		{
+			name:    "custom scheme",
+			url:     "ftp://hooks.example.org/vox",
+			events:  []string{models.AuditUserRegistered},
+			isValid: false,
+		},
+		{
+			name:    "relative url",
+			url:     "/vox",
+			events:  []string{models.AuditUserRegistered},
+			isValid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			endpoint, err := models.NewWebhookEndpoint("owner", tc.url, tc.events)
+			assert.NoError(t, err)
+
+			if tc.isValid {
+				assert.NoError(t, endpoint.Validate())
+			} else {
+				assert.Error(t, endpoint.Validate())
+			}
+		})
+	}
+}
+
+func TestWebhookEndpoint_Sign(t *testing.T) {
+	endpoint, _ := models.NewWebhookEndpoint("owner", "https://hooks.example.org/vox", []string{models.AuditUserRegistered})
+	assert.True(t, strings.HasPrefix(endpoint.Secret, "whsec_"))
+
+	payload := []byte(`{"type":"user.registered"}`)
+	at := time.Unix(1700000000, 0)
+
+	mac := hmac.New(sha256.New, []byte(endpoint.Secret))
+	mac.Write([]byte("1700000000." + string(payload)))
+	assert.Equal(t, "t=1700000000,v1="+hex.EncodeToString(mac.Sum(nil)), endpoint.Sign(at, payload))
+
+	other, _ := models.NewWebhookEndpoint("owner", endpoint.URL, endpoint.Events)
+	assert.NotEqual(t, endpoint.Sign(at, payload), other.Sign(at, payload), "every endpoint has its own secret")
+}
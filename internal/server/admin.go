@@ -0,0 +1,236 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+// requireAdmin rejects requests of users without the admin role
+func (server *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(userContextKey).(*models.User)
+		if !ok || user == nil {
+			server.error(w, r, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		if !user.IsAdmin() {
+			server.error(w, r, http.StatusForbidden, errors.New("admin role required"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func parseAuditFilter(query url.Values) (models.AuditFilter, error) {
+	filter := models.AuditFilter{
+		Actor:  query.Get("actor"),
+		Target: query.Get("target"),
+		Action: query.Get("action"),
+	}
+
+	var err error
+	if v := query.Get("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, fmt.Errorf("invalid 'since': %w", err)
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, fmt.Errorf("invalid 'until': %w", err)
+		}
+	}
+	if v := query.Get("before"); v != "" {
+		if filter.Before, err = strconv.ParseInt(v, 10, 64); err != nil || filter.Before <= 0 {
+			return filter, errors.New("invalid 'before'")
+		}
+	}
+
+	return filter, nil
+}
+
+func (server *Server) handleAdminAudit() http.HandlerFunc {
+	type response struct {
+		Events []*models.AuditEvent `json:"events"`
+		// pass as 'before' to get the next page, absent on the last page
+		Next int64 `json:"next,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseAuditFilter(r.URL.Query())
+		if err != nil {
+			server.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		filter.Limit = defaultAuditPageSize
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 || filter.Limit > maxAuditPageSize {
+				server.error(w, r, http.StatusBadRequest, fmt.Errorf("'limit' must be between 1 and %d", maxAuditPageSize))
+				return
+			}
+		}
+
+		// the admin sees the events caused right before the query
+		server.auditLog.Flush()
+
+		events, err := server.store(r.Context()).Audit().Find(filter)
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		resp := response{Events: events}
+		if len(events) == filter.Limit {
+			resp.Next = events[len(events)-1].ID
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		server.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+// handleAdminAuditExport streams every matching event as newline delimited JSON
+func (server *Server) handleAdminAuditExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseAuditFilter(r.URL.Query())
+		if err != nil {
+			server.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		filter.Limit = maxAuditPageSize
+
+		server.auditLog.Flush()
+
+		events, err := server.store(r.Context()).Audit().Find(filter)
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+		w.WriteHeader(http.StatusOK)
+
+		encoder := json.NewEncoder(w)
+		for len(events) > 0 {
+			for _, event := range events {
+				if err := encoder.Encode(event); err != nil {
+					return // the client went away
+				}
+			}
+
+			if len(events) < filter.Limit {
+				return
+			}
+
+			filter.Before = events[len(events)-1].ID
+			if events, err = server.store(r.Context()).Audit().Find(filter); err != nil {
+				// the status is sent already, the truncated export is all we can do
+				server.requestLogger(r).Error("audit export failed", "error", err)
+				return
+			}
+		}
+	}
+}
+
+func (server *Server) handleAdminUsersRole() http.HandlerFunc {
+	type request struct {
+		Role string `json:"role"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin := r.Context().Value(userContextKey).(*models.User)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			server.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if req.Role != models.RoleUser && req.Role != models.RoleAdmin {
+			server.error(w, r, http.StatusBadRequest, fmt.Errorf("unknown role '%s'", req.Role))
+			return
+		}
+
+		login := mux.Vars(r)["login"]
+		if login == admin.Login {
+			// so that the last admin can't lock everyone out
+			server.error(w, r, http.StatusConflict, errors.New("admins can't change their own role"))
+			return
+		}
+
+		user, err := server.store(r.Context()).Users().FindByLogin(login)
+		if err != nil {
+			server.error(w, r, http.StatusNotFound, fmt.Errorf("user '%s' not found", login))
+			return
+		}
+
+		previous := user.Role
+		updated := *user
+		updated.Role = req.Role
+		if err := server.store(r.Context()).Users().Update(&updated); err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		server.audit(r, models.AuditRoleChanged, admin.Login, login, map[string]any{
+			"from": previous,
+			"to":   req.Role,
+		})
+
+		updated.Sanitize()
+		server.respond(w, r, http.StatusOK, updated)
+	}
+}
+
+func (server *Server) handleAdminUsersDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin := r.Context().Value(userContextKey).(*models.User)
+
+		login := mux.Vars(r)["login"]
+		if login == admin.Login {
+			server.error(w, r, http.StatusConflict, errors.New("admins can't delete themselves"))
+			return
+		}
+
+		user, err := server.store(r.Context()).Users().FindByLogin(login)
+		if err != nil {
+			server.error(w, r, http.StatusNotFound, fmt.Errorf("user '%s' not found", login))
+			return
+		}
+
+		err = server.store(r.Context()).WithTx(r.Context(), func(tx storage.Storage) error {
+			if err := tx.Users().DeleteByLogin(login); err != nil {
+				return err
+			}
+			return server.PublishWebhooks(tx, models.AuditUserDeleted, admin.Login, login)
+		})
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		server.introspections.InvalidateSubject(login)
+
+		server.audit(r, models.AuditUserDeleted, admin.Login, login, map[string]any{
+			"email": user.Email,
+		})
+
+		server.respond(w, r, http.StatusNoContent, nil)
+	}
+}
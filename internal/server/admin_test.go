@@ -0,0 +1,187 @@
+package server_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func promote(t *testing.T, s *server.Server, login string) {
+	t.Helper()
+
+	user, err := s.Storage().Users().FindByLogin(login)
+	require.NoError(t, err)
+
+	updated := *user
+	updated.Role = models.RoleAdmin
+	require.NoError(t, s.Storage().Users().Update(&updated))
+}
+
+type auditPage struct {
+	Events []models.AuditEvent `json:"events"`
+	Next   int64               `json:"next"`
+}
+
+func queryAudit(t *testing.T, s *server.Server, auth, query string) auditPage {
+	t.Helper()
+
+	rec := doRequest(s, http.MethodGet, "/admin/audit?"+query, auth, nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var page auditPage
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&page))
+
+	return page
+}
+
+func actions(events []models.AuditEvent) []string {
+	result := []string{}
+	for _, event := range events {
+		result = append(result, event.Action)
+	}
+	return result
+}
+
+func TestAdmin_Audit(t *testing.T) {
+	s := newTestServer(t)
+
+	admin := "Bearer " + registerUser(t, s, "alice")
+	promote(t, s, "alice")
+	bob := "Bearer " + registerUser(t, s, "bob")
+
+	// case : regular users have no access to the admin api
+	rec := doRequest(s, http.MethodGet, "/admin/audit", bob, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/sessions", "", map[string]string{
+		"login_or_email": "bob",
+		"password":       "wrong password",
+	})
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = doRequest(s, http.MethodPut, "/private/password", bob, map[string]string{
+		"current_password": "password",
+		"new_password":     "n3wPassword",
+	})
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+
+	rec = doRequest(s, http.MethodPut, "/admin/users/bob/role", admin, map[string]string{"role": models.RoleAdmin})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"role":"admin"`)
+
+	page := queryAudit(t, s, admin, "target=bob")
+	assert.Equal(t, []string{
+		models.AuditRoleChanged,
+		models.AuditPasswordChanged,
+		models.AuditLoginFailed,
+		models.AuditUserRegistered,
+	}, actions(page.Events))
+	assert.Zero(t, page.Next)
+
+	changed := page.Events[0]
+	assert.Equal(t, "alice", changed.Actor)
+	assert.Equal(t, "192.0.2.1", changed.IP)
+	assert.NotEmpty(t, changed.RequestID)
+	assert.JSONEq(t, `{"from":"user","to":"admin"}`, string(changed.Metadata))
+
+	// pagination
+	page = queryAudit(t, s, admin, "target=bob&limit=3")
+	require.Len(t, page.Events, 3)
+	require.NotZero(t, page.Next)
+	page = queryAudit(t, s, admin, fmt.Sprintf("target=bob&limit=3&before=%d", page.Next))
+	assert.Equal(t, []string{models.AuditUserRegistered}, actions(page.Events))
+
+	page = queryAudit(t, s, admin, "action="+models.AuditLoginFailed)
+	require.Len(t, page.Events, 1)
+	assert.Empty(t, page.Events[0].Actor)
+
+	rec = doRequest(s, http.MethodGet, "/admin/audit?since=yesterday", admin, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// case : admins can't lock themselves out
+	rec = doRequest(s, http.MethodPut, "/admin/users/alice/role", admin, map[string]string{"role": models.RoleUser})
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = doRequest(s, http.MethodDelete, "/admin/users/alice", admin, nil)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = doRequest(s, http.MethodDelete, "/admin/users/bob", admin, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	rec = doRequest(s, http.MethodDelete, "/admin/users/bob", admin, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// the export holds every event, one per line
+	rec = doRequest(s, http.MethodGet, "/admin/audit/export", admin, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+
+	exported := []models.AuditEvent{}
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var event models.AuditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		exported = append(exported, event)
+	}
+	assert.Equal(t, models.AuditUserDeleted, exported[0].Action)
+	assert.Equal(t, queryAudit(t, s, admin, "limit=500").Events, exported)
+}
+
+func TestAdmin_APIKeyScope(t *testing.T) {
+	s := newTestServer(t)
+
+	auth := "Bearer " + registerUser(t, s, "alice")
+	promote(t, s, "alice")
+
+	// case : keys of admins act as admins only with the admin scope
+	key := createAPIKey(t, s, auth, models.ScopeProfileRead)
+	rec := doRequest(s, http.MethodGet, "/admin/audit", "Bot "+key.Key, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	key = createAPIKey(t, s, auth, models.ScopeAdmin)
+	rec = doRequest(s, http.MethodGet, "/admin/audit", "Bot "+key.Key, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestAdmin_UserCache(t *testing.T) {
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.Storage.UserCache.Enabled = true
+	cfg.Storage.UserCache.Size = 100
+	cfg.Storage.UserCache.TTL = time.Hour
+	cfg.Storage.UserCache.NegativeTTL = time.Hour
+
+	s, err := server.NewInMemoryServer(cfg)
+	require.NoError(t, err)
+
+	admin := "Bearer " + registerUser(t, s, "alice")
+	promote(t, s, "alice")
+	bob := "Bearer " + registerUser(t, s, "bob")
+
+	rec := doRequest(s, http.MethodGet, "/admin/audit", bob, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// default case : the role change is seen by the next request despite the cached user
+	rec = doRequest(s, http.MethodPut, "/admin/users/bob/role", admin, map[string]string{"role": models.RoleAdmin})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = doRequest(s, http.MethodGet, "/admin/audit", bob, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// case : deleted users are rejected
+	rec = doRequest(s, http.MethodDelete, "/admin/users/bob", admin, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	rec = doRequest(s, http.MethodGet, "/admin/audit", bob, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `vox_storage_user_lookups_total{cache="hit"}`)
+	assert.Contains(t, rec.Body.String(), `vox_storage_user_lookups_total{cache="miss"}`)
+}
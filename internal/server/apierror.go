@@ -0,0 +1,219 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/go-playground/validator"
+)
+
+// Codes of the API errors. Clients may rely on them, unlike on the details
+const (
+	CodeBadRequest         = "bad_request"
+	CodeInvalidJSON        = "invalid_json"
+	CodeValidationFailed   = "validation_failed"
+	CodeUnauthorized       = "unauthorized"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeInvalidToken       = "invalid_token"
+	CodeForbidden          = "forbidden"
+	CodeInsufficientScope  = "insufficient_scope"
+	CodeCSRFFailed         = "csrf_failed"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeIdempotencyBusy    = "idempotency_key_in_use"
+	CodeIdempotencyReuse   = "idempotency_key_reused"
+	CodeIdempotencyUsed    = "idempotency_key_used"
+	CodePayloadTooLarge    = "payload_too_large"
+	CodeUnprocessable      = "unprocessable"
+	CodeBadGateway         = "bad_gateway"
+	CodeInternal           = "internal_error"
+)
+
+// problemTypePrefix prefixes the code in the type of a problem
+const problemTypePrefix = "urn:vox-server:problem:"
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodePayloadTooLarge,
+	http.StatusUnprocessableEntity:   CodeUnprocessable,
+	http.StatusBadGateway:            CodeBadGateway,
+}
+
+// FieldError tells what is wrong with a field of the request
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// APIError is an error response, written as an RFC 7807 problem
+type APIError struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Fields   []FieldError `json:"errors,omitempty"`
+	// the id of the request in the logs and the Request-ID header
+	RequestID string `json:"request_id,omitempty"`
+
+	// the cause, logged but never written
+	Err error `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	if e.Detail != "" {
+		return e.Code + ": " + e.Detail
+	}
+	return e.Code
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// apiError gives a stable code to an error, its message is the detail
+func apiError(code string, err error) *APIError {
+	return &APIError{Code: code, Detail: err.Error(), Err: err}
+}
+
+// toAPIError turns the error of a handler into the problem to write. The messages
+// of the errors of the validator, the JSON decoder and the storage aren't written,
+// neither are the ones of the server errors
+func toAPIError(status int, err error) *APIError {
+	var apiErr *APIError
+	var tooLarge *http.MaxBytesError
+	var invalid validator.ValidationErrors
+	var syntax *json.SyntaxError
+	var mistyped *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &apiErr):
+		e := *apiErr
+		if e.Status == 0 {
+			e.Status = status
+		}
+		return &e
+	case errors.As(err, &tooLarge):
+		return &APIError{
+			Status: http.StatusRequestEntityTooLarge,
+			Code:   CodePayloadTooLarge,
+			Detail: fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit),
+			Err:    err,
+		}
+	case errors.As(err, &invalid):
+		e := &APIError{
+			Status: http.StatusUnprocessableEntity,
+			Code:   CodeValidationFailed,
+			Detail: "some fields are invalid",
+			Err:    err,
+		}
+		for _, field := range invalid {
+			e.Fields = append(e.Fields, FieldError{Field: field.Field(), Code: field.Tag(), Message: fieldMessage(field)})
+		}
+		return e
+	case errors.Is(err, models.ErrInvalid):
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: CodeValidationFailed, Detail: err.Error(), Err: err}
+	case errors.As(err, &mistyped):
+		return &APIError{
+			Status: http.StatusBadRequest,
+			Code:   CodeInvalidJSON,
+			Detail: "request body has a field of the wrong type",
+			Fields: []FieldError{{Field: mistyped.Field, Code: "type", Message: "must be " + jsonKind(mistyped.Type)}},
+			Err:    err,
+		}
+	case errors.As(err, &syntax), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return &APIError{Status: http.StatusBadRequest, Code: CodeInvalidJSON, Detail: "request body is not valid JSON", Err: err}
+	case errors.Is(err, storage.ErrConflict):
+		return &APIError{Status: http.StatusConflict, Code: CodeConflict, Detail: "already exists", Err: err}
+	case errors.Is(err, storage.ErrNotFound):
+		return &APIError{Status: http.StatusNotFound, Code: CodeNotFound, Detail: "not found", Err: err}
+	}
+
+	if status >= http.StatusInternalServerError {
+		return &APIError{Status: status, Code: CodeInternal, Detail: "internal error", Err: err}
+	}
+
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeBadRequest
+	}
+	return &APIError{Status: status, Code: code, Detail: err.Error(), Err: err}
+}
+
+// fieldMessage describes the failed rule of a field, the way the tag of the model states it
+func fieldMessage(field validator.FieldError) string {
+	switch field.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be an email address"
+	case "url":
+		return "must be a URL"
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(field.Param(), " ", ", ")
+	case "min", "gte":
+		if field.Kind() == reflect.Slice {
+			return "must have at least " + field.Param() + " items"
+		}
+		return "must be at least " + field.Param() + " characters long"
+	case "max", "lte":
+		if field.Kind() == reflect.Slice {
+			return "must have at most " + field.Param() + " items"
+		}
+		return "must be at most " + field.Param() + " characters long"
+	}
+	return "is invalid"
+}
+
+// jsonKind names the JSON value a Go type is decoded from
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	}
+	return "a " + t.Kind().String()
+}
+
+// error writes the error as an application/problem+json response. The status is the one
+// of the error when it has one, e.g. 413 for a body beyond the limit of limitBody
+func (server *Server) error(w http.ResponseWriter, r *http.Request, code int, err error) {
+	problem := toAPIError(code, err)
+	problem.Type = problemTypePrefix + problem.Code
+	problem.Title = http.StatusText(problem.Status)
+	problem.Instance = r.URL.Path
+	if id, ok := r.Context().Value(requestIDContextKey).(string); ok {
+		problem.RequestID = id
+	}
+
+	if problem.Status >= http.StatusInternalServerError {
+		server.requestLogger(r).Error("request failed", "error_code", problem.Code, "error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	server.respond(w, r, problem.Status, problem)
+}
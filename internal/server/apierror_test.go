@@ -0,0 +1,151 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"vox-server/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeProblem(t *testing.T, rec *httptest.ResponseRecorder) *server.APIError {
+	t.Helper()
+
+	require.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+
+	problem := &server.APIError{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(problem))
+	assert.Equal(t, rec.Code, problem.Status)
+	assert.Equal(t, "urn:vox-server:problem:"+problem.Code, problem.Type)
+	assert.Equal(t, http.StatusText(rec.Code), problem.Title)
+	assert.Equal(t, rec.Header().Get("Request-ID"), problem.RequestID)
+	return problem
+}
+
+func TestAPIError_Validation(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doRequest(s, http.MethodPost, "/users", "", map[string]string{
+		"login":    "alice",
+		"username": "alice",
+		"email":    "not an email",
+		"password": "short",
+	})
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "User.", "the struct paths of the validator aren't leaked")
+
+	problem := decodeProblem(t, rec)
+	assert.Equal(t, server.CodeValidationFailed, problem.Code)
+	assert.Equal(t, "/users", problem.Instance)
+	assert.NotEmpty(t, problem.RequestID)
+	assert.Equal(t, []server.FieldError{
+		{Field: "email", Code: "email", Message: "must be an email address"},
+		{Field: "password", Code: "min", Message: "must be at least 8 characters long"},
+	}, problem.Fields)
+
+	rec = doRequest(s, http.MethodPost, "/sessions", "", map[string]string{"login_or_email": "alice"})
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	problem = decodeProblem(t, rec)
+	assert.Equal(t, server.CodeValidationFailed, problem.Code)
+	assert.Equal(t, []server.FieldError{{Field: "password", Code: "required", Message: "is required"}}, problem.Fields)
+}
+
+func TestAPIError_InvalidJSON(t *testing.T) {
+	s := newTestServer(t)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, body := range []string{"", "{", "{login: 1}"} {
+		rec := post(body)
+		require.Equal(t, http.StatusBadRequest, rec.Code, body)
+		problem := decodeProblem(t, rec)
+		assert.Equal(t, server.CodeInvalidJSON, problem.Code)
+		assert.Equal(t, "request body is not valid JSON", problem.Detail)
+	}
+
+	rec := post(`{"login": 42}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "Go struct", "the types of the handler aren't leaked")
+	problem := decodeProblem(t, rec)
+	assert.Equal(t, server.CodeInvalidJSON, problem.Code)
+	assert.Equal(t, []server.FieldError{{Field: "login", Code: "type", Message: "must be a string"}}, problem.Fields)
+}
+
+func TestAPIError_Codes(t *testing.T) {
+	s := newTestServer(t)
+	bearer := "Bearer " + registerUser(t, s, "alice")
+
+	cases := []struct {
+		name           string
+		method, path   string
+		auth           string
+		payload        any
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"no credentials", http.MethodGet, "/private/whoami", "", nil, http.StatusUnauthorized, server.CodeUnauthorized},
+		{"malformed token", http.MethodGet, "/private/whoami", "Bearer nope", nil, http.StatusUnauthorized, server.CodeInvalidToken},
+		{"wrong password", http.MethodPost, "/sessions", "", map[string]string{"login_or_email": "alice", "password": "nope"}, http.StatusUnauthorized, server.CodeInvalidCredentials},
+		{"unknown user", http.MethodPost, "/sessions", "", map[string]string{"login_or_email": "bob", "password": "nope"}, http.StatusUnauthorized, server.CodeInvalidCredentials},
+		{"not an admin", http.MethodGet, "/admin/audit", bearer, nil, http.StatusForbidden, server.CodeForbidden},
+		{"unknown route", http.MethodGet, "/nope", "", nil, http.StatusNotFound, server.CodeNotFound},
+		{"wrong method", http.MethodGet, "/users", "", nil, http.StatusMethodNotAllowed, server.CodeMethodNotAllowed},
+		{"unknown api key", http.MethodDelete, "/private/keys/nope", bearer, nil, http.StatusNotFound, server.CodeNotFound},
+		{"taken login", http.MethodPost, "/users", "", map[string]string{"login": "alice", "username": "alice", "email": "other@example.org", "password": "password"}, http.StatusConflict, server.CodeConflict},
+		{"taken email", http.MethodPost, "/users", "", map[string]string{"login": "other", "username": "other", "email": "alice@example.org", "password": "password"}, http.StatusConflict, server.CodeConflict},
+		{"invalid login", http.MethodPost, "/users", "", map[string]string{"login": "a-b", "username": "ab", "email": "ab@example.org", "password": "password"}, http.StatusUnprocessableEntity, server.CodeValidationFailed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := doRequest(s, tc.method, tc.path, tc.auth, tc.payload)
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+
+			problem := decodeProblem(t, rec)
+			assert.Equal(t, tc.expectedCode, problem.Code)
+			assert.NotEmpty(t, problem.Detail)
+		})
+	}
+}
+
+func TestAPIError_AuthDetails(t *testing.T) {
+	s := newTestServer(t)
+	deleted := "Bearer " + registerUser(t, s, "bob")
+	require.NoError(t, s.Storage().Users().DeleteByLogin("bob"))
+
+	// the cause of a rejection isn't written, whatever it is
+	for _, auth := range []string{"Bearer nope", deleted} {
+		rec := doRequest(s, http.MethodGet, "/private/whoami", auth, nil)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		problem := decodeProblem(t, rec)
+		assert.Equal(t, server.CodeInvalidToken, problem.Code)
+		assert.Equal(t, "invalid or expired token", problem.Detail)
+	}
+
+	rec := doRequest(s, http.MethodGet, "/private/whoami", "Bot vox_nope_nope", nil)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "invalid or revoked api key", decodeProblem(t, rec).Detail)
+}
+
+func TestAPIError_PayloadTooLarge(t *testing.T) {
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.HTTP.MaxBodyBytes = 16
+	s, err := server.NewInMemoryServer(cfg)
+	require.NoError(t, err)
+
+	rec := doRequest(s, http.MethodPost, "/users", "", map[string]string{"login": strings.Repeat("a", 32)})
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	problem := decodeProblem(t, rec)
+	assert.Equal(t, server.CodePayloadTooLarge, problem.Code)
+	assert.Equal(t, "request body exceeds 16 bytes", problem.Detail)
+}
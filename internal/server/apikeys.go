@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"vox-server/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// authentificateAPIKey resolves a plain key from the 'Authorization: Bot <key>' header
+func (server *Server) authentificateAPIKey(ctx context.Context, plainKey string) (*models.APIKey, error) {
+	prefix, err := models.ParseAPIKeyPrefix(plainKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := server.store(ctx).APIKeys().FindByPrefix(prefix)
+	if err != nil {
+		return nil, lookupError("key", err)
+	}
+	if !key.CompareKey(plainKey) {
+		return nil, errors.New("unknown key")
+	}
+
+	if key.IsRevoked() {
+		return nil, errors.New("key is revoked")
+	}
+
+	// read-only calls don't write on every request, the usage is recorded once per APIKeyUsageResolution
+	now := time.Now().UTC()
+	if key.NeedsTouch(now) {
+		if err := server.store(ctx).APIKeys().TouchLastUsed(key.ID, now); err != nil {
+			server.logger.Warn("failed to update api key usage", "key_id", key.ID, "error", err)
+		}
+	}
+
+	return key, nil
+}
+
+// checkScope fails for requests authentificated by an API key or by a token issued
+// to an OAuth client without the given scope. First-party sessions are not restricted
+func checkScope(ctx context.Context, scope string) error {
+	key, ok := ctx.Value(apiKeyContextKey).(*models.APIKey)
+	if ok && !key.HasScope(scope) {
+		return apiError(CodeInsufficientScope, fmt.Errorf("api key has no '%s' scope", scope))
+	}
+
+	session, ok := ctx.Value(sessionContextKey).(*models.Session)
+	if ok && session.IsRestricted() && !session.HasScope(scope) {
+		return apiError(CodeInsufficientScope, fmt.Errorf("token has no '%s' scope", scope))
+	}
+
+	return nil
+}
+
+// requireScope rejects the requests checkScope fails for
+func (server *Server) requireScope(scope string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := checkScope(r.Context(), scope); err != nil {
+				if _, ok := r.Context().Value(sessionContextKey).(*models.Session); ok {
+					w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+				}
+				server.error(w, r, http.StatusForbidden, err)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (server *Server) handleAPIKeysList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(userContextKey).(*models.User)
+		if !ok || user == nil {
+			server.error(w, r, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		keys, err := server.store(r.Context()).APIKeys().FindByUser(user.Login)
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		server.respond(w, r, http.StatusOK, keys)
+	}
+}
+
+func (server *Server) handleAPIKeysCreate() http.HandlerFunc {
+	type request struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(userContextKey).(*models.User)
+		if !ok || user == nil {
+			server.error(w, r, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			server.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		// a key can't issue a key more powerful than itself
+		if parent, ok := r.Context().Value(apiKeyContextKey).(*models.APIKey); ok {
+			for _, scope := range req.Scopes {
+				if !parent.HasScope(scope) {
+					server.error(w, r, http.StatusForbidden, apiError(CodeInsufficientScope, fmt.Errorf("api key has no '%s' scope", scope)))
+					return
+				}
+			}
+		}
+
+		key, err := models.NewAPIKey(user.Login, req.Name, req.Scopes)
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to generate api key: %w", err))
+			return
+		}
+
+		if err := server.store(r.Context()).APIKeys().Create(key); err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		server.audit(r, models.AuditAPIKeyCreated, user.Login, key.ID, map[string]any{
+			"name":   key.Name,
+			"scopes": key.Scopes,
+		})
+
+		// the plain key is shown only once, in this response
+		w.Header().Set("Cache-Control", "no-store")
+		server.respond(w, r, http.StatusCreated, key)
+	}
+}
+
+func (server *Server) handleAPIKeysRevoke() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(userContextKey).(*models.User)
+		if !ok || user == nil {
+			server.error(w, r, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		key, err := server.store(r.Context()).APIKeys().FindByID(id)
+		if err != nil || key.UserLogin != user.Login {
+			server.error(w, r, http.StatusNotFound, fmt.Errorf("api key '%s' not found", id))
+			return
+		}
+
+		if err := server.store(r.Context()).APIKeys().Revoke(key.ID); err != nil {
+			server.error(w, r, http.StatusConflict, err)
+			return
+		}
+
+		server.audit(r, models.AuditAPIKeyRevoked, user.Login, key.ID, nil)
+
+		server.respond(w, r, http.StatusNoContent, nil)
+	}
+}
@@ -0,0 +1,198 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"vox-server/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T) *server.Server {
+	t.Helper()
+
+	s, err := server.NewInMemoryServer(&server.Config{Env: server.EnvLocal})
+	require.NoError(t, err)
+
+	return s
+}
+
+func doRequest(s http.Handler, method, path, auth string, payload any) *httptest.ResponseRecorder {
+	b := &bytes.Buffer{}
+	if payload != nil {
+		json.NewEncoder(b).Encode(payload)
+	}
+
+	req := httptest.NewRequest(method, path, b)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+// registerUser creates a user and returns its access token
+func registerUser(t *testing.T, s http.Handler, login string) string {
+	t.Helper()
+
+	rec := doRequest(s, http.MethodPost, "/users", "", map[string]string{
+		"login":    login,
+		"username": login,
+		"email":    login + "@example.org",
+		"password": "password",
+	})
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+
+	return resp.AccessToken
+}
+
+type apiKeyResponse struct {
+	ID     string   `json:"id"`
+	Key    string   `json:"key"`
+	Scopes []string `json:"scopes"`
+}
+
+func createAPIKey(t *testing.T, s http.Handler, auth string, scopes ...string) apiKeyResponse {
+	t.Helper()
+
+	rec := doRequest(s, http.MethodPost, "/private/keys", auth, map[string]any{
+		"name":   "bot",
+		"scopes": scopes,
+	})
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var key apiKeyResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&key))
+	require.NotEmpty(t, key.Key)
+
+	return key
+}
+
+func TestServer_APIKeyAuthentification(t *testing.T) {
+	s := newTestServer(t)
+	bearer := "Bearer " + registerUser(t, s, "owner")
+
+	key := createAPIKey(t, s, bearer, "profile:read")
+	bot := "Bot " + key.Key
+
+	testCases := []struct {
+		name         string
+		method       string
+		path         string
+		auth         string
+		payload      any
+		expectedCode int
+	}{
+		{
+			name:         "scope granted",
+			method:       http.MethodGet,
+			path:         "/private/whoami",
+			auth:         bot,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "scope missing",
+			method:       http.MethodGet,
+			path:         "/private/keys",
+			auth:         bot,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "unknown key",
+			method:       http.MethodGet,
+			path:         "/private/whoami",
+			auth:         "Bot vox_nope_nope",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "malformed key",
+			method:       http.MethodGet,
+			path:         "/private/whoami",
+			auth:         "Bot garbage",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "unknown scheme",
+			method:       http.MethodGet,
+			path:         "/private/whoami",
+			auth:         "Basic " + key.Key,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "jwt is not restricted by scopes",
+			method:       http.MethodGet,
+			path:         "/private/keys",
+			auth:         bearer,
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := doRequest(s, tc.method, tc.path, tc.auth, tc.payload)
+			assert.Equal(t, tc.expectedCode, rec.Code, rec.Body.String())
+		})
+	}
+}
+
+func TestServer_APIKeyLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	bearer := "Bearer " + registerUser(t, s, "owner")
+	other := "Bearer " + registerUser(t, s, "stranger")
+
+	key := createAPIKey(t, s, bearer, "keys:read", "keys:write", "profile:read")
+	bot := "Bot " + key.Key
+
+	// a key can't escalate its scopes
+	rec := doRequest(s, http.MethodPost, "/private/keys", bot, map[string]any{
+		"name":   "child",
+		"scopes": []string{"keys:read", "profile:read"},
+	})
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	limited := createAPIKey(t, s, bearer, "keys:write")
+	rec = doRequest(s, http.MethodPost, "/private/keys", "Bot "+limited.Key, map[string]any{
+		"name":   "child",
+		"scopes": []string{"profile:read"},
+	})
+	assert.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+
+	// listing never exposes plain keys
+	rec = doRequest(s, http.MethodGet, "/private/keys", bot, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var keys []map[string]any
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&keys))
+	assert.Len(t, keys, 3)
+	for _, k := range keys {
+		assert.NotContains(t, k, "key")
+		assert.NotContains(t, k, "key_hash")
+	}
+
+	// last use is tracked
+	for _, k := range keys {
+		if k["id"] == key.ID {
+			assert.NotNil(t, k["last_used_at"])
+		}
+	}
+
+	// other users can't revoke the key
+	rec = doRequest(s, http.MethodDelete, "/private/keys/"+key.ID, other, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = doRequest(s, http.MethodDelete, "/private/keys/"+key.ID, bearer, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = doRequest(s, http.MethodGet, "/private/whoami", bot, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
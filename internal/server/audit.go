@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+)
+
+const (
+	defaultAuditBufferSize = 1024
+	auditBatchSize         = 100
+	auditWriteAttempts     = 3
+)
+
+// auditLog stores events in the background, in batches. It never drops an event silently:
+// when the buffer is full the caller stores its event itself, and events the storage
+// refused after a few attempts are written to the logger at error level
+type auditLog struct {
+	repository storage.AuditRepository
+	logger     *slog.Logger
+	queue      chan *models.AuditEvent
+	stopped    chan struct{}
+
+	mu      sync.Mutex
+	idle    *sync.Cond
+	pending int // queued, but not stored yet
+	closed  bool
+}
+
+func newAuditLog(repository storage.AuditRepository, logger *slog.Logger, size int) *auditLog {
+	if size <= 0 {
+		size = defaultAuditBufferSize
+	}
+
+	a := &auditLog{
+		repository: repository,
+		logger:     logger,
+		queue:      make(chan *models.AuditEvent, size),
+		stopped:    make(chan struct{}),
+	}
+	a.idle = sync.NewCond(&a.mu)
+
+	go a.run()
+
+	return a
+}
+
+func (a *auditLog) Record(event *models.AuditEvent) {
+	a.mu.Lock()
+	if !a.closed {
+		select {
+		case a.queue <- event:
+			a.pending++
+			a.mu.Unlock()
+			return
+		default:
+		}
+	}
+	a.mu.Unlock()
+
+	// the buffer is full or the log is closed: slow the caller down instead of losing the event
+	a.write(event)
+}
+
+// Flush waits until every queued event is stored
+func (a *auditLog) Flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for a.pending > 0 {
+		a.idle.Wait()
+	}
+}
+
+// Close stores the queued events and stops the background writer.
+// Events recorded afterwards are stored by their callers
+func (a *auditLog) Close(ctx context.Context) error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+
+	select {
+	case <-a.stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("audit events are still being stored: %w", ctx.Err())
+	}
+}
+
+func (a *auditLog) run() {
+	defer close(a.stopped)
+
+	batch := make([]*models.AuditEvent, 0, auditBatchSize)
+	for event := range a.queue {
+		batch = append(batch[:0], event)
+
+	collect:
+		for len(batch) < auditBatchSize {
+			select {
+			case event, ok := <-a.queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, event)
+			default:
+				break collect
+			}
+		}
+
+		a.write(batch...)
+
+		a.mu.Lock()
+		a.pending -= len(batch)
+		a.idle.Broadcast()
+		a.mu.Unlock()
+	}
+}
+
+func (a *auditLog) write(events ...*models.AuditEvent) {
+	var err error
+	for attempt := range auditWriteAttempts {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+
+		if err = a.repository.Append(events...); err == nil {
+			return
+		}
+	}
+
+	for _, event := range events {
+		a.logger.Error("failed to store audit event",
+			"error", err,
+			"action", event.Action,
+			"actor", event.Actor,
+			"target", event.Target,
+			"ip", event.IP,
+			"user_agent", event.UserAgent,
+			"request_id", event.RequestID,
+			"time", event.Time,
+			"metadata", string(event.Metadata),
+		)
+	}
+}
+
+func newAuditEvent(logger *slog.Logger, action, actor, target string, metadata map[string]any) *models.AuditEvent {
+	event := &models.AuditEvent{
+		Time:   time.Now().UTC(),
+		Actor:  actor,
+		Target: target,
+		Action: action,
+	}
+
+	if len(metadata) > 0 {
+		raw, err := json.Marshal(metadata)
+		if err != nil {
+			logger.Warn("failed to encode audit metadata", "action", action, "error", err)
+		}
+		event.Metadata = raw
+	}
+
+	return event
+}
+
+// audit records an event caused by the request
+func (server *Server) audit(r *http.Request, action, actor, target string, metadata map[string]any) {
+	event := newAuditEvent(server.requestLogger(r), action, actor, target, metadata)
+	event.IP = remoteIP(r)
+	event.UserAgent = r.UserAgent()
+
+	if id, ok := r.Context().Value(requestIDContextKey).(string); ok {
+		event.RequestID = id
+	}
+
+	server.auditLog.Record(event)
+}
+
+// AuditOperator records an event caused outside of any request, e.g. by a command of an operator.
+// Close stores the recorded events
+func (server *Server) AuditOperator(action, actor, target string, metadata map[string]any) {
+	event := newAuditEvent(server.logger, action, actor, target, metadata)
+	server.auditLog.Record(event)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
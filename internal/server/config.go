@@ -1,9 +1,22 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"vox-server/internal/migrator"
 
 	"github.com/ilyakaznacheev/cleanenv"
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -12,37 +25,231 @@ const (
 	EnvProd  = "prod"
 )
 
+// Config is read in layers: the defaults of the env-default tags, the config file,
+// the environment and the secret files. Zero values count as unset, the defaults apply to them
 type Config struct {
-	Env  string `yaml:"env" env:"ENV,required" envDefault:"local"`
-	Port string `yaml:"address" env:"ADDRESS" envDefault:"8085"`
+	Env  string `yaml:"env" env:"ENV" env-default:"local"`
+	Port string `yaml:"address" env:"ADDRESS" env-default:":8085"`
 	DB   struct {
-		Host     string `yaml:"host" env:"DB_HOST" envDefault:"localhost"`
-		Port     string `yaml:"port" env:"DB_PORT" envDefault:"5432"`
-		User     string `yaml:"user" env:"DB_USER" envDefault:"postgres"`
-		Password string `yaml:"password" env:"DB_PASSWORD" envDefault:"pass"`
-		Name     string `yaml:"name" env:"DB_NAME" envDefault:"gitserver"`
-		TestName string `yaml:"test_name" env:"DB_TEST_NAME" envDefault:"gitserver_test"`
+		Host     string `yaml:"host" env:"DB_HOST" env-default:"localhost"`
+		Port     string `yaml:"port" env:"DB_PORT" env-default:"5432"`
+		User     string `yaml:"user" env:"DB_USER" env-default:"postgres"`
+		Password string `yaml:"password" env:"DB_PASSWORD" env-default:"pass"`
+		Name     string `yaml:"name" env:"DB_NAME" env-default:"gitserver"`
+		TestName string `yaml:"test_name" env:"DB_TEST_NAME" env-default:"gitserver_test"`
+		// create the database on startup when it doesn't exist
+		Create bool `yaml:"create" env:"DB_CREATE" env-default:"false"`
+		// apply the pending migrations on startup, otherwise the server isn't ready until 'migrate up'
+		AutoMigrate bool `yaml:"auto_migrate" env:"DB_AUTO_MIGRATE" env-default:"false"`
 	} `yaml:"db"`
-	DatabaseURL     string `env:"DATABASE_URL"`
-	TestDatabaseURL string `env:"TEST_DATABASE_URL"`
+	Storage struct {
+		// postgres or sqlite. Without database urls, the sqlite databases are the files
+		// <db.name>.db and <db.test_name>.db, the other db settings only apply to postgres
+		Driver string `yaml:"driver" env:"STORAGE_DRIVER" env-default:"postgres"`
+		// users looked up by login or email (e.g. by each authenticated request) are cached in memory.
+		// The writes of this replica invalidate them, the ones of other replicas apply once they expire
+		// unless a notifier is set
+		UserCache struct {
+			Enabled bool `yaml:"enabled" env:"STORAGE_USER_CACHE_ENABLED" env-default:"false"`
+			// entries kept, the least recently used one is evicted beyond it
+			Size int           `yaml:"size" env:"STORAGE_USER_CACHE_SIZE" env-default:"10000"`
+			TTL  time.Duration `yaml:"ttl" env:"STORAGE_USER_CACHE_TTL" env-default:"30s"`
+			// unknown logins and emails are cached this long, 0 doesn't cache them
+			NegativeTTL time.Duration `yaml:"negative_ttl" env:"STORAGE_USER_CACHE_NEGATIVE_TTL" env-default:"5s"`
+		} `yaml:"user_cache"`
+	} `yaml:"storage"`
+	JWT struct {
+		// signs access and refresh tokens (HS256), required in prod.
+		// Local and dev setups generate one per process, so tokens don't survive restarts
+		Secret string `yaml:"secret" env:"JWT_SECRET"`
+	} `yaml:"jwt"`
+	OAuth struct {
+		// public URL of the server, derived from the request when empty
+		Issuer string `yaml:"issuer" env:"OAUTH_ISSUER"`
+		// PEM encoded RSA key signing ID tokens, an ephemeral key is generated when empty
+		SigningKeyPath string `yaml:"signing_key_path" env:"OAUTH_SIGNING_KEY_PATH"`
+		// answers of /oauth/introspect about active tokens are cached this long, 0 disables the cache.
+		// Logouts invalidate them, other revocations (e.g. by the cli) apply once they expire
+		IntrospectionCacheTTL time.Duration `yaml:"introspection_cache_ttl" env:"OAUTH_INTROSPECTION_CACHE_TTL" env-default:"10s"`
+	} `yaml:"oauth"`
+	HTTP struct {
+		ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT" env-default:"5s"`
+		ReadTimeout       time.Duration `yaml:"read_timeout" env:"HTTP_READ_TIMEOUT" env-default:"15s"`
+		// also bounds streamed responses, e.g. the audit export
+		WriteTimeout time.Duration `yaml:"write_timeout" env:"HTTP_WRITE_TIMEOUT" env-default:"30s"`
+		IdleTimeout  time.Duration `yaml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT" env-default:"2m"`
+		// in-flight requests are cut off when they don't finish in time after SIGINT or SIGTERM,
+		// the registered components get the same time to close
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT" env-default:"20s"`
+		// /readyz reports not-ready this long before the listener closes, so that load balancers stop sending requests
+		ShutdownDelay  time.Duration `yaml:"shutdown_delay" env:"HTTP_SHUTDOWN_DELAY" env-default:"0s"`
+		MaxHeaderBytes ByteSize      `yaml:"max_header_bytes" env:"HTTP_MAX_HEADER_BYTES" env-default:"1MiB"`
+		// larger request bodies are rejected with 413
+		MaxBodyBytes ByteSize `yaml:"max_body_bytes" env:"HTTP_MAX_BODY_BYTES" env-default:"1MiB"`
+	} `yaml:"http"`
+	TLS struct {
+		// PEM files of the certificate chain and its key, the listeners serve TLS and HTTP/2 with them.
+		// Replaced files are picked up without a restart
+		CertFile string `yaml:"cert_file" env:"TLS_CERT_FILE"`
+		KeyFile  string `yaml:"key_file" env:"TLS_KEY_FILE"`
+		// 1.2 or 1.3
+		MinVersion string `yaml:"min_version" env:"TLS_MIN_VERSION" env-default:"1.2"`
+		// TLS 1.2 suites by name, e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, Go's secure defaults when empty.
+		// The suites of TLS 1.3 aren't configurable
+		CipherSuites []string `yaml:"cipher_suites" env:"TLS_CIPHER_SUITES"`
+		// the certificate files are checked for changes this often
+		ReloadInterval time.Duration `yaml:"reload_interval" env:"TLS_RELOAD_INTERVAL" env-default:"1m"`
+		// serve a certificate for localhost generated at startup, local env only
+		SelfSigned bool `yaml:"self_signed" env:"TLS_SELF_SIGNED"`
+	} `yaml:"tls"`
+	CORS struct {
+		// origins of browser apps calling the API, e.g. https://app.example.org, "*" allows any.
+		// Without origins the local env allows any, dev and prod allow none
+		AllowedOrigins   []string      `yaml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS"`
+		AllowedMethods   []string      `yaml:"allowed_methods" env:"CORS_ALLOWED_METHODS" env-default:"GET,POST,PUT,PATCH,DELETE"`
+		AllowedHeaders   []string      `yaml:"allowed_headers" env:"CORS_ALLOWED_HEADERS" env-default:"Authorization,Content-Type,Idempotency-Key"`
+		ExposedHeaders   []string      `yaml:"exposed_headers" env:"CORS_EXPOSED_HEADERS" env-default:"Request-ID,Idempotent-Replayed"`
+		AllowCredentials bool          `yaml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS"`
+		MaxAge           time.Duration `yaml:"max_age" env:"CORS_MAX_AGE" env-default:"10m"`
+	} `yaml:"cors"`
+	Security struct {
+		// max age of Strict-Transport-Security, sent outside the local env
+		HSTSMaxAge time.Duration `yaml:"hsts_max_age" env:"SECURITY_HSTS_MAX_AGE" env-default:"8760h"`
+	} `yaml:"security"`
+	Health struct {
+		// bounds each dependency check of /readyz
+		Timeout time.Duration `yaml:"timeout" env:"HEALTH_TIMEOUT" env-default:"2s"`
+	} `yaml:"health"`
+	Reload struct {
+		// the config file is checked for changes this often, 0 only reloads it on SIGHUP
+		WatchInterval time.Duration `yaml:"watch_interval" env:"RELOAD_WATCH_INTERVAL"`
+	} `yaml:"reload"`
+	Admin struct {
+		// listener of the operational endpoints (/metrics), disabled when empty
+		Address string `yaml:"address" env:"ADMIN_ADDRESS" env-default:":9090"`
+		// CA bundle of the client certificates the admin listener requires, needs TLS
+		ClientCAFile string `yaml:"client_ca_file" env:"ADMIN_CLIENT_CA_FILE"`
+	} `yaml:"admin"`
+	GRPC struct {
+		// listener of the gRPC API of the internal services, disabled when empty.
+		// It serves TLS with the certificate of the tls section
+		Address string `yaml:"address" env:"GRPC_ADDRESS" env-default:":9091"`
+	} `yaml:"grpc"`
+	Log struct {
+		Format string `yaml:"format" env:"LOG_FORMAT" env-default:"text"` // text or json
+		Level  string `yaml:"level" env:"LOG_LEVEL"`                      // debug for local and dev, info for prod when empty
+		// route template -> log one of every n requests, e.g. "/healthz": 100. Failed requests are always logged
+		Sampling map[string]int `yaml:"sampling"`
+	} `yaml:"log"`
+	Tracing struct {
+		Exporter string `yaml:"exporter" env:"TRACING_EXPORTER" env-default:"none"` // none or otlp
+		// OTLP/HTTP collector url, e.g. http://localhost:4318, the OTEL_EXPORTER_OTLP_* variables apply when empty
+		Endpoint string `yaml:"endpoint" env:"TRACING_ENDPOINT"`
+		// share of traces started here that are recorded, traces of sampled callers are always recorded.
+		// 0 counts as unset, the exporter 'none' turns tracing off
+		SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" env-default:"1"`
+	} `yaml:"tracing"`
+	Idempotency struct {
+		// responses to requests with an Idempotency-Key are replayed to their retries this long
+		TTL time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL" env-default:"24h"`
+	} `yaml:"idempotency"`
+	Webhooks struct {
+		// bounds each delivery attempt
+		Timeout time.Duration `yaml:"timeout" env:"WEBHOOKS_TIMEOUT" env-default:"10s"`
+		// attempts of a delivery before it fails for good
+		MaxAttempts int `yaml:"max_attempts" env:"WEBHOOKS_MAX_ATTEMPTS" env-default:"8"`
+		// wait before the first retry, doubled for each next one up to the max backoff
+		Backoff    time.Duration `yaml:"backoff" env:"WEBHOOKS_BACKOFF" env-default:"30s"`
+		MaxBackoff time.Duration `yaml:"max_backoff" env:"WEBHOOKS_MAX_BACKOFF" env-default:"1h"`
+		// endpoints are disabled after this many consecutive deliveries failed for good
+		DisableAfter int `yaml:"disable_after" env:"WEBHOOKS_DISABLE_AFTER" env-default:"5"`
+		// pending deliveries are looked for this often, and right after each event
+		PollInterval time.Duration `yaml:"poll_interval" env:"WEBHOOKS_POLL_INTERVAL" env-default:"5s"`
+	} `yaml:"webhooks"`
+	Jobs struct {
+		// jobs run at the same time by this server
+		Workers int `yaml:"workers" env:"JOBS_WORKERS" env-default:"4"`
+		// attempts of a job before it is dead
+		MaxAttempts int `yaml:"max_attempts" env:"JOBS_MAX_ATTEMPTS" env-default:"10"`
+		// wait before the first retry, doubled for each next one up to the max backoff
+		Backoff    time.Duration `yaml:"backoff" env:"JOBS_BACKOFF" env-default:"10s"`
+		MaxBackoff time.Duration `yaml:"max_backoff" env:"JOBS_MAX_BACKOFF" env-default:"1h"`
+		// bounds each attempt, a job still running afterwards is run again by another server
+		Lease time.Duration `yaml:"lease" env:"JOBS_LEASE" env-default:"5m"`
+		// due jobs are looked for this often, and right after each enqueued job
+		PollInterval time.Duration `yaml:"poll_interval" env:"JOBS_POLL_INTERVAL" env-default:"1s"`
+		// succeeded and dead jobs are deleted this long after they finished
+		Retention time.Duration `yaml:"retention" env:"JOBS_RETENTION" env-default:"168h"`
+	} `yaml:"jobs"`
+	Audit struct {
+		// events waiting to be stored, a full buffer makes requests store their events themselves
+		BufferSize int `yaml:"buffer_size" env:"AUDIT_BUFFER_SIZE" env-default:"1024"`
+	} `yaml:"audit"`
+	OIDCProviders   []OIDCProvider `yaml:"oidc_providers"`
+	DatabaseURL     string         `yaml:"database_url" env:"DATABASE_URL"`
+	TestDatabaseURL string         `yaml:"test_database_url" env:"TEST_DATABASE_URL"`
+
+	// the file the config was read from, empty when there was none
+	file string
+}
+
+// OIDCProvider is an external identity provider users can sign in with
+type OIDCProvider struct {
+	Name         string `yaml:"name"` // used in the login and callback urls
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// file holding the client secret, e.g. a mounted Kubernetes secret
+	ClientSecretFile string   `yaml:"client_secret_file"`
+	Scopes           []string `yaml:"scopes"`
+	// link an unknown identity to the user with the same email if the provider verified it
+	LinkVerifiedEmail bool `yaml:"link_verified_email"`
+	// create a local user for an identity that can't be linked, if the provider verified its email
+	AutoRegister bool `yaml:"auto_register"`
 }
 
-// TODO : change initialization configPath
-var configPath string = "/home/timno/Documents/GoProjects/vox-server/internal/configs/local.yaml"
+// ConfigEnvVar names the config file when no path is given
+const ConfigEnvVar = "VOX_CONFIG"
+
+// configSearchPaths are tried in order when neither a path nor VOX_CONFIG names a file
+var configSearchPaths = []string{"config.yaml", "/etc/vox-server/config.yaml"}
 
+// NewConfig loads the config from the file named by VOX_CONFIG or found in the search paths
 func NewConfig() (*Config, error) {
-	cfg := &Config{}
+	return LoadConfig("")
+}
 
-	err := cleanenv.ReadConfig(configPath, cfg)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+// LoadConfig reads and validates the config. Without a path it falls back to VOX_CONFIG,
+// then to the search paths. Without any file the config comes from the environment only
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		path = os.Getenv(ConfigEnvVar)
+	}
+	if path == "" {
+		for _, candidate := range configSearchPaths {
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+				break
+			}
+		}
 	}
 
-	err = cleanenv.ReadEnv(cfg)
-	if err != nil {
+	cfg := &Config{file: path}
+
+	if path != "" {
+		if err := readConfigFile(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cleanenv.ReadEnv(cfg); err != nil {
 		return nil, fmt.Errorf("failed to read environment variables: %w", err)
 	}
 
+	if err := readSecretFiles(cfg); err != nil {
+		return nil, err
+	}
+
 	if cfg.DatabaseURL == "" {
 		cfg.DatabaseURL = generateDBURL(cfg, cfg.DB.Name)
 	}
@@ -50,10 +257,366 @@ func NewConfig() (*Config, error) {
 		cfg.TestDatabaseURL = generateDBURL(cfg, cfg.DB.TestName)
 	}
 
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
 	return cfg, nil
 }
 
+// readConfigFile decodes a YAML file strictly, so that a misspelt key isn't silently ignored
+func readConfigFile(path string, cfg *Config) error {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+	default:
+		return fmt.Errorf("config file must be YAML, not '%s'", ext)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file '%s': %w", path, err)
+	}
+
+	return nil
+}
+
+// secretFields can be read from the file named by <VAR>_FILE, e.g. DB_PASSWORD_FILE,
+// as Docker and Kubernetes mount secrets
+func secretFields(cfg *Config) map[string]*string {
+	return map[string]*string{
+		"DB_PASSWORD":       &cfg.DB.Password,
+		"JWT_SECRET":        &cfg.JWT.Secret,
+		"DATABASE_URL":      &cfg.DatabaseURL,
+		"TEST_DATABASE_URL": &cfg.TestDatabaseURL,
+	}
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	// editors and echo end files with a newline
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func readSecretFiles(cfg *Config) error {
+	fields := secretFields(cfg)
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		path := os.Getenv(name + "_FILE")
+		if path == "" {
+			continue
+		}
+
+		if _, ok := os.LookupEnv(name); ok {
+			errs = append(errs, fmt.Errorf("%s and %s_FILE are both set", name, name))
+			continue
+		}
+
+		secret, err := readSecretFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read %s_FILE: %w", name, err))
+			continue
+		}
+		*fields[name] = secret
+	}
+
+	for i := range cfg.OIDCProviders {
+		provider := &cfg.OIDCProviders[i]
+		if provider.ClientSecretFile == "" {
+			continue
+		}
+
+		if provider.ClientSecret != "" {
+			errs = append(errs, fmt.Errorf("oidc provider '%s' has both a client secret and a client secret file", provider.Name))
+			continue
+		}
+
+		secret, err := readSecretFile(provider.ClientSecretFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read the client secret of oidc provider '%s': %w", provider.Name, err))
+			continue
+		}
+		provider.ClientSecret = secret
+	}
+
+	return errors.Join(errs...)
+}
+
+// File is the path the config was read from, empty when it came from the environment only
+func (cfg *Config) File() string {
+	return cfg.file
+}
+
+// StorageDriver is the driver of the storage, postgres when unset
+func (cfg *Config) StorageDriver() string {
+	if cfg.Storage.Driver == "" {
+		return migrator.DriverPostgres
+	}
+	return cfg.Storage.Driver
+}
+
+// DatabaseURLFor returns the url of the test database or of the main one
+func (cfg *Config) DatabaseURLFor(test bool) string {
+	if test {
+		return cfg.TestDatabaseURL
+	}
+	return cfg.DatabaseURL
+}
+
+// Validate checks the whole config the way the server does when it starts, without
+// connecting anywhere. Every problem is reported, prefixed by the key of the config file
+func (cfg *Config) Validate() error {
+	var errs []error
+	check := func(key string, err error) {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, err := range joined.Unwrap() {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			}
+		} else if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	switch cfg.Env {
+	case EnvLocal, EnvDev, EnvProd:
+		level, err := newLogLevel(cfg.Env, cfg.Log.Level)
+		check("log.level", err)
+		if err == nil {
+			_, err = SetupLogger(io.Discard, cfg.Env, cfg.Log.Format, level)
+			check("log.format", err)
+		}
+	default:
+		check("env", fmt.Errorf("must be one of %s, %s or %s, not '%s'", EnvLocal, EnvDev, EnvProd, cfg.Env))
+	}
+
+	for key, d := range map[string]time.Duration{
+		"http.read_header_timeout": cfg.HTTP.ReadHeaderTimeout,
+		"http.read_timeout":        cfg.HTTP.ReadTimeout,
+		"http.write_timeout":       cfg.HTTP.WriteTimeout,
+		"http.idle_timeout":        cfg.HTTP.IdleTimeout,
+		"http.shutdown_timeout":    cfg.HTTP.ShutdownTimeout,
+		"http.shutdown_delay":      cfg.HTTP.ShutdownDelay,
+		"health.timeout":           cfg.Health.Timeout,
+		"cors.max_age":             cfg.CORS.MaxAge,
+		"security.hsts_max_age":    cfg.Security.HSTSMaxAge,
+		"reload.watch_interval":    cfg.Reload.WatchInterval,
+		"tls.reload_interval":      cfg.TLS.ReloadInterval,
+		"webhooks.timeout":         cfg.Webhooks.Timeout,
+		"webhooks.backoff":         cfg.Webhooks.Backoff,
+		"webhooks.max_backoff":     cfg.Webhooks.MaxBackoff,
+		"webhooks.poll_interval":   cfg.Webhooks.PollInterval,
+		"jobs.backoff":             cfg.Jobs.Backoff,
+		"jobs.max_backoff":         cfg.Jobs.MaxBackoff,
+		"jobs.lease":               cfg.Jobs.Lease,
+		"jobs.poll_interval":       cfg.Jobs.PollInterval,
+		"jobs.retention":           cfg.Jobs.Retention,
+	} {
+		if d < 0 {
+			check(key, errors.New("must not be negative"))
+		}
+	}
+	if cfg.HTTP.MaxHeaderBytes < 0 {
+		check("http.max_header_bytes", errors.New("must not be negative"))
+	}
+	if cfg.HTTP.MaxBodyBytes < 0 {
+		check("http.max_body_bytes", errors.New("must not be negative"))
+	}
+	if cfg.OAuth.IntrospectionCacheTTL < 0 {
+		check("oauth.introspection_cache_ttl", errors.New("must not be negative"))
+	}
+	if cfg.Idempotency.TTL < 0 {
+		check("idempotency.ttl", errors.New("must not be negative"))
+	}
+	if cfg.Webhooks.MaxAttempts < 0 {
+		check("webhooks.max_attempts", errors.New("must not be negative"))
+	}
+	if cfg.Webhooks.DisableAfter < 0 {
+		check("webhooks.disable_after", errors.New("must not be negative"))
+	}
+	if cfg.Jobs.Workers < 0 {
+		check("jobs.workers", errors.New("must not be negative"))
+	}
+	if cfg.Jobs.MaxAttempts < 0 {
+		check("jobs.max_attempts", errors.New("must not be negative"))
+	}
+	if cfg.Audit.BufferSize < 0 {
+		check("audit.buffer_size", errors.New("must not be negative"))
+	}
+	if cfg.Storage.UserCache.Size < 0 {
+		check("storage.user_cache.size", errors.New("must not be negative"))
+	}
+	if cfg.Storage.UserCache.TTL < 0 {
+		check("storage.user_cache.ttl", errors.New("must not be negative"))
+	}
+	if cfg.Storage.UserCache.NegativeTTL < 0 {
+		check("storage.user_cache.negative_ttl", errors.New("must not be negative"))
+	}
+
+	_, err := newLogSampler(cfg.Log.Sampling)
+	check("log.sampling", err)
+
+	_, err = newCORSPolicy(cfg)
+	check("cors", err)
+
+	cfg.validateTLS(check)
+
+	switch cfg.Storage.Driver {
+	case migrator.DriverPostgres, migrator.DriverSQLite, "":
+		for key, databaseURL := range map[string]string{
+			"database_url":      cfg.DatabaseURL,
+			"test_database_url": cfg.TestDatabaseURL,
+		} {
+			check(key, cfg.checkDatabaseURL(databaseURL))
+		}
+	default:
+		check("storage.driver", fmt.Errorf("unknown storage driver '%s'", cfg.Storage.Driver))
+	}
+
+	switch cfg.Tracing.Exporter {
+	case TraceExporterNone, TraceExporterOTLP, "":
+	default:
+		check("tracing.exporter", fmt.Errorf("unknown trace exporter '%s'", cfg.Tracing.Exporter))
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		check("tracing.sample_ratio", errors.New("must be between 0 and 1"))
+	}
+
+	if cfg.Env == EnvProd && len(cfg.JWT.Secret) < minJWTSecretLength {
+		check("jwt.secret", fmt.Errorf("must be at least %d bytes in prod", minJWTSecretLength))
+	}
+
+	if cfg.OAuth.SigningKeyPath != "" {
+		_, err := loadSigningKey(cfg.OAuth.SigningKeyPath)
+		check("oauth.signing_key_path", err)
+	} else if cfg.Env == EnvProd {
+		check("oauth.signing_key_path", errors.New("is required in prod, an ephemeral key doesn't survive restarts"))
+	}
+
+	_, err = newOIDCProviders(cfg.OIDCProviders)
+	check("oidc_providers", err)
+
+	slices.SortFunc(errs, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+	return errors.Join(errs...)
+}
+
+// checkDatabaseURL checks that the url is one of the storage driver. Postgres also accepts
+// connection strings of the form "host=... dbname=...", only their scheme is checked
+func (cfg *Config) checkDatabaseURL(databaseURL string) error {
+	sqlite := strings.HasPrefix(databaseURL, migrator.DriverSQLite+"://")
+	switch {
+	case databaseURL == "":
+		return nil
+	case cfg.StorageDriver() == migrator.DriverSQLite && !sqlite:
+		return errors.New("must be of the form sqlite://path with the sqlite storage driver")
+	case cfg.StorageDriver() == migrator.DriverPostgres && sqlite:
+		return errors.New("is a sqlite url, the storage driver is postgres")
+	}
+	return nil
+}
+
+// Redacted is a copy of the config safe to print, its passwords and secrets are replaced
+func (cfg *Config) Redacted() *Config {
+	redactedCfg := *cfg
+
+	if redactedCfg.DB.Password != "" {
+		redactedCfg.DB.Password = redacted
+	}
+	if redactedCfg.JWT.Secret != "" {
+		redactedCfg.JWT.Secret = redacted
+	}
+
+	redactedCfg.DatabaseURL = redactURL(cfg.DatabaseURL)
+	redactedCfg.TestDatabaseURL = redactURL(cfg.TestDatabaseURL)
+
+	redactedCfg.OIDCProviders = slices.Clone(cfg.OIDCProviders)
+	for i := range redactedCfg.OIDCProviders {
+		if redactedCfg.OIDCProviders[i].ClientSecret != "" {
+			redactedCfg.OIDCProviders[i].ClientSecret = redacted
+		}
+	}
+
+	return &redactedCfg
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// it may hold a password we can't find
+		return redacted
+	}
+	return u.Redacted()
+}
+
 func generateDBURL(cfg *Config, dbName string) string {
-	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		cfg.DB.User, cfg.DB.Password, cfg.DB.Host, cfg.DB.Port, dbName)
+	if cfg.StorageDriver() == migrator.DriverSQLite {
+		return "sqlite://" + dbName + ".db"
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.DB.User, cfg.DB.Password),
+		Host:     net.JoinHostPort(cfg.DB.Host, cfg.DB.Port),
+		Path:     "/" + dbName,
+		RawQuery: "sslmode=disable",
+	}
+	return u.String()
+}
+
+// ByteSize is a size in bytes written with an optional unit, e.g. 512KiB, 1MiB or 10MB
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	// longest suffixes first, "B" ends them all
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+func (size *ByteSize) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+
+	unit := ByteSize(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size '%s', expected e.g. 512KiB or 1MiB", text)
+	}
+
+	*size = ByteSize(n) * unit
+	return nil
+}
+
+func (size ByteSize) MarshalText() ([]byte, error) {
+	return []byte(size.String()), nil
+}
+
+func (size ByteSize) String() string {
+	for _, u := range []struct {
+		suffix string
+		size   ByteSize
+	}{{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}} {
+		if size != 0 && size%u.size == 0 {
+			return strconv.FormatInt(int64(size/u.size), 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(size), 10) + "B"
 }
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// corsPolicy answers the cross-origin requests of browsers, see the cors section of the config
+type corsPolicy struct {
+	anyOrigin   bool
+	origins     []string
+	methods     []string
+	headers     []string // lower case
+	exposed     string
+	credentials bool
+	maxAge      string
+}
+
+func newCORSPolicy(cfg *Config) (*corsPolicy, error) {
+	origins := cfg.CORS.AllowedOrigins
+	if len(origins) == 0 && cfg.Env == EnvLocal {
+		// e.g. the dev server of a frontend on another port
+		origins = []string{"*"}
+	}
+
+	policy := &corsPolicy{
+		exposed:     strings.Join(cfg.CORS.ExposedHeaders, ", "),
+		credentials: cfg.CORS.AllowCredentials,
+		maxAge:      strconv.Itoa(int(cfg.CORS.MaxAge.Seconds())),
+	}
+
+	var errs []error
+	for _, origin := range origins {
+		if origin == "*" {
+			policy.anyOrigin = true
+			continue
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			errs = append(errs, fmt.Errorf("invalid origin '%s', expected e.g. https://app.example.org", origin))
+			continue
+		}
+		// browsers send the scheme and host lowercased
+		policy.origins = append(policy.origins, strings.ToLower(u.Scheme+"://"+u.Host))
+	}
+
+	if policy.anyOrigin && policy.credentials {
+		errs = append(errs, errors.New("credentials can't be allowed for any origin"))
+	}
+
+	for _, method := range cfg.CORS.AllowedMethods {
+		policy.methods = append(policy.methods, strings.ToUpper(method))
+	}
+	for _, header := range cfg.CORS.AllowedHeaders {
+		policy.headers = append(policy.headers, strings.ToLower(header))
+	}
+
+	return policy, errors.Join(errs...)
+}
+
+func (policy *corsPolicy) allowsOrigin(origin string) bool {
+	return policy.anyOrigin || slices.Contains(policy.origins, strings.ToLower(origin))
+}
+
+// allowsPreflight checks the method and headers a preflight request asks for
+func (policy *corsPolicy) allowsPreflight(r *http.Request) bool {
+	if !slices.Contains(policy.methods, r.Header.Get("Access-Control-Request-Method")) {
+		return false
+	}
+
+	for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		header = strings.ToLower(strings.TrimSpace(header))
+		if header != "" && !slices.Contains(policy.headers, header) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// cors answers preflight requests and marks the responses readable by the allowed origins.
+// It wraps the router, since the router rejects OPTIONS requests to its routes
+func (server *Server) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		policy := server.corsPolicy.Load()
+		w.Header().Add("Vary", "Origin")
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !policy.allowsOrigin(origin) {
+			if preflight {
+				server.error(w, r, http.StatusForbidden, fmt.Errorf("origin '%s' is not allowed", origin))
+				return
+			}
+			// the browser doesn't let the page read the response
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if policy.anyOrigin && !policy.credentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if policy.credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if policy.exposed != "" {
+				w.Header().Set("Access-Control-Expose-Headers", policy.exposed)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		if !policy.allowsPreflight(r) {
+			server.error(w, r, http.StatusForbidden, errors.New("method or headers are not allowed"))
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.methods, ", "))
+		if len(policy.headers) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.headers, ", "))
+		}
+		w.Header().Set("Access-Control-Max-Age", policy.maxAge)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
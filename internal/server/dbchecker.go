@@ -1,150 +1,76 @@
 package server
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"os/exec"
-	"strings"
-
-	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"vox-server/internal/migrator"
 )
 
-var dbNames = []string{"gitserver", "gitserver_test"}
-
-func isPsqlInstalled() bool {
-	_, err := exec.LookPath("psql")
-	return err == nil
-}
-
-func isMigrateInstalled() bool {
-	_, err := exec.LookPath("migrate")
-	return err == nil
-}
+// migrationsChecker is not ready while the schema is behind the migrations embedded in the binary
+func migrationsChecker(db *sql.DB, driver string) HealthCheckerFunc {
+	return func(ctx context.Context) error {
+		latest, err := migrator.Latest(driver)
+		if err != nil {
+			return err
+		}
 
-func checkDBsExists(dbName string) (bool, error) {
-	cmd := exec.Command("psql", "-lqt")
-	output, err := cmd.Output()
-	if err != nil {
-		return false, fmt.Errorf("failed to list databases: %v", err)
-	}
+		var version uint
+		var dirty bool
+		err = db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to read schema version: %w", err)
+		}
 
-	if strings.Contains(string(output), dbName) {
-		return true, nil
-	}
-	return false, nil
-}
+		if dirty {
+			return fmt.Errorf("migration %d failed halfway, the schema is dirty", version)
+		}
+		if version < latest {
+			return fmt.Errorf("migrations are pending: the schema is at %d, the latest is %d", version, latest)
+		}
 
-func createDB(cfg *Config, dbName string) error {
-	cmd := exec.Command("createdb", "-U", cfg.DB.User, "-h", cfg.DB.Host, "-p", cfg.DB.Port, dbName)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to create database %s: %v, output: %s", dbName, err, string(output))
+		return nil
 	}
-	return nil
 }
 
-func runMigrations(dbURL string) error {
-	migrationsPath := "file://migrations"
-
-	m, err := migrate.New(migrationsPath, dbURL)
-	if err != nil {
-		return fmt.Errorf("failed to create migrate instance: %v", err)
+// createDatabase creates the database on startup if the config allows it
+func createDatabase(ctx context.Context, cfg *Config, databaseURL string) error {
+	if !cfg.DB.Create {
+		return nil
 	}
-	defer m.Close()
 
-	if err := m.Up(); err != nil {
-		if err == migrate.ErrNoChange {
-			fmt.Println("No new migrations to apply.")
-		} else {
-			return fmt.Errorf("failed to apply migrations: %v", err)
-		}
-	} else {
-		fmt.Println("Migrations applied successfully.")
+	if _, err := migrator.CreateDatabase(ctx, databaseURL); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-func validateConfigDBData(cfg *Config) error {
-	dbURL := cfg.DatabaseURL
-	db, err := sql.Open("postgres", dbURL)
-	if err != nil {
-		return fmt.Errorf("connection error to the main database: %w", err)
-	}
-	defer db.Close()
-	if err := db.Ping(); err != nil {
-		return fmt.Errorf("couldn't connect to the main database: %w", err)
+// migrateDatabase applies the pending migrations on startup if the config allows it,
+// otherwise the server isn't ready until they are applied with 'migrate up'
+func (server *Server) migrateDatabase(databaseURL string) error {
+	if !server.config.DB.AutoMigrate {
+		return nil
 	}
 
-	testDBURL := cfg.TestDatabaseURL
-	testDB, err := sql.Open("postgres", testDBURL)
+	m, err := migrator.New(databaseURL)
 	if err != nil {
-		return fmt.Errorf("error connecting to the test database: %w", err)
-	}
-	defer testDB.Close()
-	if err := testDB.Ping(); err != nil {
-		return fmt.Errorf("couldn't connect to the test database: %w", err)
-	}
-
-	return nil
-}
-
-func ConfigurationDBs(cfg *Config) error {
-	if !isPsqlInstalled() {
-		return fmt.Errorf("psql is not installed. Please install PostgreSQL to continue")
+		return err
 	}
+	defer m.Close()
 
-	if !isMigrateInstalled() {
-		return fmt.Errorf("migrate is not installed. Please install Migrate to continue")
+	applied, err := m.Up()
+	if err != nil {
+		return err
 	}
 
-	missingDBs := []string{}
-
-	for _, dbName := range dbNames {
-		exists, err := checkDBsExists(dbName)
+	if applied {
+		status, err := m.Status()
 		if err != nil {
 			return err
 		}
-		if !exists {
-			missingDBs = append(missingDBs, dbName)
-		}
-	}
-
-	if len(missingDBs) > 0 {
-		fmt.Printf("The following databases are missing: %v\n", missingDBs)
-		fmt.Print("Do you want to create them? (yes/no): ")
-		var response string
-		fmt.Scanln(&response)
-
-		if strings.ToLower(response) != "yes" && strings.ToLower(response) != "y" {
-			return fmt.Errorf("database initialization aborted")
-		}
-
-		for _, dbName := range missingDBs {
-			err := createDB(cfg, dbName)
-			if err != nil {
-				return err
-			}
-
-			var dbURL string
-			if dbName == "gitserver" {
-				dbURL = cfg.DatabaseURL
-			} else if dbName == "gitserver_test" {
-				dbURL = cfg.TestDatabaseURL
-			} else {
-				return fmt.Errorf("unknown database name: %s", dbName)
-			}
-
-			if err := runMigrations(dbURL); err != nil {
-				return fmt.Errorf("failed to run migrations on database %s: %v", dbName, err)
-			}
-		}
-	}
-
-	if err := validateConfigDBData(cfg); err != nil {
-		return err
+		server.logger.Info("Migrations are applied", "version", status.Version)
 	}
 
 	return nil
@@ -0,0 +1,456 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcStateCookieName = "vox_oidc_state"
+	oidcStateTTL        = 10 * time.Minute
+)
+
+var errIdentityNotLinked = errors.New("identity is not linked to any user")
+
+// oidcProvider is discovered lazily, so an unavailable provider doesn't block startup
+type oidcProvider struct {
+	config OIDCProvider
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+}
+
+func newOIDCProviders(configs []OIDCProvider) (map[string]*oidcProvider, error) {
+	providers := make(map[string]*oidcProvider, len(configs))
+
+	for _, cfg := range configs {
+		if cfg.Name == "" || cfg.Issuer == "" || cfg.ClientID == "" {
+			return nil, fmt.Errorf("oidc provider '%s' must have a name, an issuer and a client id", cfg.Name)
+		}
+
+		if _, ok := providers[cfg.Name]; ok {
+			return nil, fmt.Errorf("oidc provider '%s' is configured twice", cfg.Name)
+		}
+
+		providers[cfg.Name] = &oidcProvider{config: cfg}
+	}
+
+	return providers, nil
+}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oidc.Provider, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider == nil {
+		provider, err := oidc.NewProvider(ctx, p.config.Issuer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to discover oidc provider '%s': %w", p.config.Name, err)
+		}
+
+		p.provider = provider
+		p.verifier = provider.Verifier(&oidc.Config{ClientID: p.config.ClientID})
+	}
+
+	return p.provider, p.verifier, nil
+}
+
+func (p *oidcProvider) oauth2Config(endpoint oauth2.Endpoint, redirectURL string) *oauth2.Config {
+	scopes := p.config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, models.ScopeEmail, models.ScopeProfile}
+	}
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+
+	return &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		Endpoint:     endpoint,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+	}
+}
+
+// oidcLoginState survives the round trip to the provider in a signed cookie
+type oidcLoginState struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	ReturnTo string `json:"return_to,omitempty"`
+
+	jwt.StandardClaims
+}
+
+func parseOIDCLoginState(token string) (*oidcLoginState, error) {
+	state := &oidcLoginState{}
+
+	_, err := jwt.ParseWithClaims(token, state, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return GetJWTKey(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// idTokenClaims are the claims used for linking and registration
+type idTokenClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+}
+
+func (server *Server) oidcRedirectURL(r *http.Request, name string) string {
+	return server.issuer(r) + "/auth/oidc/" + name + "/callback"
+}
+
+// safeReturnTo accepts only same-origin paths
+func safeReturnTo(returnTo string) string {
+	if strings.HasPrefix(returnTo, "/") && !strings.HasPrefix(returnTo, "//") && !strings.HasPrefix(returnTo, "/\\") {
+		return returnTo
+	}
+	return "/"
+}
+
+func (server *Server) oidcProviderNames() []string {
+	names := make([]string, 0, len(server.oidcProviders))
+	for name := range server.oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// handleOIDCLogin redirects the user to the external provider
+func (server *Server) handleOIDCLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["provider"]
+		provider, ok := server.oidcProviders[name]
+		if !ok {
+			server.error(w, r, http.StatusNotFound, fmt.Errorf("unknown oidc provider '%s'", name))
+			return
+		}
+
+		op, _, err := provider.discover(r.Context())
+		if err != nil {
+			server.error(w, r, http.StatusBadGateway, err)
+			return
+		}
+
+		state := &oidcLoginState{
+			Provider: name,
+			State:    oauth2.GenerateVerifier(),
+			Nonce:    oauth2.GenerateVerifier(),
+			Verifier: oauth2.GenerateVerifier(),
+			ReturnTo: safeReturnTo(r.URL.Query().Get("return_to")),
+			StandardClaims: jwt.StandardClaims{
+				ExpiresAt: time.Now().Add(oidcStateTTL).Unix(),
+			},
+		}
+
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, state).SignedString(GetJWTKey())
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to sign login state: %w", err))
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookieName,
+			Value:    signed,
+			Path:     "/auth/oidc/",
+			MaxAge:   int(oidcStateTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   server.config.Env != EnvLocal,
+			SameSite: http.SameSiteLaxMode, // the provider redirects back with a top-level GET
+		})
+
+		cfg := provider.oauth2Config(op.Endpoint(), server.oidcRedirectURL(r, name))
+		http.Redirect(w, r, cfg.AuthCodeURL(state.State, oidc.Nonce(state.Nonce), oauth2.S256ChallengeOption(state.Verifier)), http.StatusFound)
+	}
+}
+
+// handleOIDCCallback verifies the ID token, links the identity and starts a session
+func (server *Server) handleOIDCCallback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["provider"]
+		provider, ok := server.oidcProviders[name]
+		if !ok {
+			server.error(w, r, http.StatusNotFound, fmt.Errorf("unknown oidc provider '%s'", name))
+			return
+		}
+
+		cookie, err := r.Cookie(oidcStateCookieName)
+		if err != nil {
+			server.error(w, r, http.StatusBadRequest, errors.New("login state is missing"))
+			return
+		}
+
+		// the state is single use
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookieName,
+			Path:     "/auth/oidc/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   server.config.Env != EnvLocal,
+		})
+
+		state, err := parseOIDCLoginState(cookie.Value)
+		if err != nil || state.Provider != name ||
+			subtle.ConstantTimeCompare([]byte(state.State), []byte(r.URL.Query().Get("state"))) != 1 {
+			server.error(w, r, http.StatusBadRequest, errors.New("login state mismatch"))
+			return
+		}
+
+		if errCode := r.URL.Query().Get("error"); errCode != "" {
+			server.oidcLoginFailed(w, r, http.StatusUnauthorized, CodeUnauthorized, "provider rejected the login",
+				fmt.Errorf("provider rejected the login: %s", errCode))
+			return
+		}
+
+		op, verifier, err := provider.discover(r.Context())
+		if err != nil {
+			server.error(w, r, http.StatusBadGateway, err)
+			return
+		}
+
+		cfg := provider.oauth2Config(op.Endpoint(), server.oidcRedirectURL(r, name))
+		token, err := cfg.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(state.Verifier))
+		if err != nil {
+			server.oidcLoginFailed(w, r, http.StatusUnauthorized, CodeUnauthorized, "provider rejected the authorization code",
+				fmt.Errorf("failed to exchange code: %w", err))
+			return
+		}
+
+		rawIDToken, ok := token.Extra("id_token").(string)
+		if !ok {
+			server.error(w, r, http.StatusUnauthorized, errors.New("provider returned no id token"))
+			return
+		}
+
+		idToken, err := verifier.Verify(r.Context(), rawIDToken)
+		if err != nil {
+			server.oidcLoginFailed(w, r, http.StatusUnauthorized, CodeInvalidToken, "invalid id token",
+				fmt.Errorf("invalid id token: %w", err))
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(state.Nonce)) != 1 {
+			server.error(w, r, http.StatusUnauthorized, errors.New("invalid id token: nonce mismatch"))
+			return
+		}
+
+		claims := &idTokenClaims{}
+		if err := idToken.Claims(claims); err != nil {
+			server.oidcLoginFailed(w, r, http.StatusUnauthorized, CodeInvalidToken, "invalid id token",
+				fmt.Errorf("invalid id token: %w", err))
+			return
+		}
+
+		user, err := server.resolveIdentity(r, provider.config, idToken.Subject, claims)
+		if errors.Is(err, errIdentityNotLinked) {
+			server.metrics.login("oidc", false)
+			server.audit(r, models.AuditLoginFailed, "", "", map[string]any{
+				"provider": name,
+				"subject":  idToken.Subject,
+				"reason":   "identity not linked",
+			})
+			server.oidcLoginFailed(w, r, http.StatusForbidden, CodeForbidden, "identity is not linked to any user", err)
+			return
+		}
+		if errors.Is(err, storage.ErrConflict) {
+			server.oidcLoginFailed(w, r, http.StatusConflict, CodeConflict, "identity can't be linked", err)
+			return
+		}
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if _, _, err := server.createSession(w, r, user, user.Login); err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		server.metrics.login("oidc", true)
+		server.audit(r, models.AuditLoginSucceeded, user.Login, user.Login, map[string]any{
+			"method":   "oidc",
+			"provider": name,
+		})
+
+		http.Redirect(w, r, safeReturnTo(state.ReturnTo), http.StatusFound)
+	}
+}
+
+// oidcLoginFailed answers a failed login with a fixed detail. The cause, e.g. an error of the
+// provider or the storage, is logged only
+func (server *Server) oidcLoginFailed(w http.ResponseWriter, r *http.Request, status int, code, detail string, err error) {
+	server.requestLogger(r).Warn("oidc login failed", "provider", mux.Vars(r)["provider"], "error", err)
+	server.error(w, r, status, &APIError{Code: code, Detail: detail, Err: err})
+}
+
+// resolveIdentity finds the user of an external identity. An unknown identity is linked to:
+//   - the user signed in right now, i.e. the user links an account explicitly;
+//   - the user with the same email, if the provider verified it and linking is enabled;
+//   - a new user, if auto registration is enabled and the provider verified the email.
+func (server *Server) resolveIdentity(r *http.Request, cfg OIDCProvider, subject string, claims *idTokenClaims) (*models.User, error) {
+	if identity, err := server.store(r.Context()).Identities().Find(cfg.Name, subject); err == nil {
+		return server.store(r.Context()).Users().FindByLogin(identity.UserLogin)
+	}
+
+	var user *models.User
+	if current, _, err := server.userFromSessionCookie(r); err == nil {
+		user = current
+	} else if cfg.LinkVerifiedEmail && claims.EmailVerified && claims.Email != "" {
+		user, _ = server.store(r.Context()).Users().FindByEmail(claims.Email)
+	}
+
+	if user == nil && !cfg.AutoRegister {
+		return nil, errIdentityNotLinked
+	}
+
+	// a registered user is linked in the same transaction, with the publication of the event
+	registered := user == nil
+	err := server.store(r.Context()).WithTx(r.Context(), func(tx storage.Storage) error {
+		if registered {
+			var err error
+			if user, err = server.registerExternalUser(tx, claims); err != nil {
+				return err
+			}
+			if err := server.PublishWebhooks(tx, models.AuditUserRegistered, user.Login, user.Login); err != nil {
+				return err
+			}
+		}
+
+		err := tx.Identities().Create(&models.Identity{
+			Provider:  cfg.Name,
+			Subject:   subject,
+			UserLogin: user.Login,
+			Email:     claims.Email,
+			CreatedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to link identity: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if registered {
+		server.audit(r, models.AuditUserRegistered, user.Login, user.Login, map[string]any{"provider": cfg.Name})
+	}
+
+	server.requestLogger(r).Info("external identity linked", "provider", cfg.Name, "login", user.Login)
+	server.audit(r, models.AuditIdentityLinked, user.Login, user.Login, map[string]any{
+		"provider": cfg.Name,
+		"subject":  subject,
+	})
+
+	return user, nil
+}
+
+// registerExternalUser creates a user with an unusable random password,
+// the user signs in through the provider only. The email must be verified by the provider,
+// otherwise anyone could take the address of another user and have it linked later
+func (server *Server) registerExternalUser(store storage.Storage, claims *idTokenClaims) (*models.User, error) {
+	if claims.Email == "" {
+		return nil, fmt.Errorf("%w: provider shared no email", errIdentityNotLinked)
+	}
+	if !claims.EmailVerified {
+		return nil, fmt.Errorf("%w: provider did not verify the email", errIdentityNotLinked)
+	}
+
+	base := alphanumeric(claims.PreferredUsername)
+	if base == "" {
+		base = alphanumeric(strings.Split(claims.Email, "@")[0])
+	}
+	if base == "" {
+		base = "user"
+	}
+	base = base[:min(len(base), 16)]
+
+	login := base
+	for i := 1; ; i++ {
+		if _, err := store.Users().FindByLogin(login); err != nil {
+			break
+		}
+		if i > 999 {
+			return nil, fmt.Errorf("failed to find a free login for '%s'", base)
+		}
+		login = base + strconv.Itoa(i)
+	}
+
+	username := alphanumeric(claims.Name)
+	if username == "" {
+		username = login
+	}
+
+	user := &models.User{
+		Login:    login,
+		Username: username[:min(len(username), 20)],
+		Email:    claims.Email,
+		Password: oauth2.GenerateVerifier()[:40],
+	}
+
+	if err := store.Users().Create(user); err != nil {
+		return nil, fmt.Errorf("failed to register user: %w", err)
+	}
+
+	user.Sanitize()
+
+	return user, nil
+}
+
+func alphanumeric(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+func (server *Server) handleIdentitiesList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(userContextKey).(*models.User)
+		if !ok || user == nil {
+			server.error(w, r, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		identities, err := server.store(r.Context()).Identities().FindByUser(user.Login)
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		server.respond(w, r, http.StatusOK, identities)
+	}
+}
@@ -0,0 +1,364 @@
+package server_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"vox-server/internal/server"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockIssuer is a minimal OpenID Connect provider approving every authorization
+// for the configured account
+type mockIssuer struct {
+	*httptest.Server
+
+	key     *rsa.PrivateKey // published in the JWKS
+	signer  *rsa.PrivateKey // signs ID tokens, differs from key to forge signatures
+	account map[string]any
+	nonce   string // overrides the nonce of the authorization request
+
+	mu             sync.Mutex
+	authorizations map[string]url.Values // code -> authorization request
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	m := &mockIssuer{
+		key:            key,
+		signer:         key,
+		authorizations: map[string]url.Values{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "mock",
+				"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		code := uuid.New().String()
+
+		m.mu.Lock()
+		m.authorizations[code] = r.URL.Query()
+		m.mu.Unlock()
+
+		redirect, _ := url.Parse(r.URL.Query().Get("redirect_uri"))
+		q := redirect.Query()
+		q.Set("code", code)
+		q.Set("state", r.URL.Query().Get("state"))
+		redirect.RawQuery = q.Encode()
+
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		m.mu.Lock()
+		auth, ok := m.authorizations[r.PostForm.Get("code")]
+		delete(m.authorizations, r.PostForm.Get("code"))
+		m.mu.Unlock()
+
+		clientID, secret, _ := r.BasicAuth()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || clientID != "vox" || secret != "s3cret" ||
+			auth.Get("redirect_uri") != r.PostForm.Get("redirect_uri") ||
+			auth.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		nonce := auth.Get("nonce")
+		if m.nonce != "" {
+			nonce = m.nonce
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   m.URL,
+			"aud":   clientID,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": nonce,
+		}
+		for k, v := range m.account {
+			claims[k] = v
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "mock"
+		idToken, _ := token.SignedString(m.signer)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "mock-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+
+	return m
+}
+
+// signIn walks the browser through the provider and returns the response of the callback,
+// its body is closed with the test
+func signIn(t *testing.T, browser *http.Client, vox *httptest.Server, provider string) *http.Response {
+	t.Helper()
+
+	resp, err := browser.Get(vox.URL + "/auth/oidc/" + provider + "/login?return_to=/home")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	// the provider authorizes right away and redirects back
+	resp, err = browser.Get(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	resp, err = browser.Get(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+// loginProblem decodes the problem answering a failed login
+func loginProblem(t *testing.T, resp *http.Response) *server.APIError {
+	t.Helper()
+
+	problem := &server.APIError{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(problem))
+	return problem
+}
+
+func sessionCookie(browser *http.Client, vox *httptest.Server) string {
+	u, _ := url.Parse(vox.URL)
+	for _, cookie := range browser.Jar.Cookies(u) {
+		if cookie.Name == "vox_session" {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func whoami(t *testing.T, s http.Handler, token string) string {
+	t.Helper()
+
+	rec := doRequest(s, http.MethodGet, "/private/whoami", "Bearer "+token, nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&user))
+
+	return user.Login
+}
+
+func TestExternalOIDC_Login(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.OIDCProviders = []server.OIDCProvider{
+		{
+			Name:              "corp",
+			Issuer:            issuer.URL,
+			ClientID:          "vox",
+			ClientSecret:      "s3cret",
+			LinkVerifiedEmail: true,
+		},
+		{
+			Name:         "partner",
+			Issuer:       issuer.URL,
+			ClientID:     "vox",
+			ClientSecret: "s3cret",
+			AutoRegister: true,
+		},
+		{
+			Name:         "misconfigured",
+			Issuer:       issuer.URL,
+			ClientID:     "vox",
+			ClientSecret: "wrong",
+		},
+	}
+	s, err := server.NewInMemoryServer(cfg)
+	require.NoError(t, err)
+	vox := httptest.NewServer(s)
+	defer vox.Close()
+
+	registerUser(t, s, "alice")
+	registerUser(t, s, "bob")
+
+	t.Run("verified email is linked", func(t *testing.T) {
+		issuer.account = map[string]any{"sub": "1", "email": "alice@example.org", "email_verified": true}
+
+		browser := newBrowser(t)
+		resp := signIn(t, browser, vox, "corp")
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+		assert.Equal(t, "/home", resp.Header.Get("Location"))
+
+		token := sessionCookie(browser, vox)
+		assert.Equal(t, "alice", whoami(t, s, token))
+
+		rec := doRequest(s, http.MethodGet, "/private/identities", "Bearer "+token, nil)
+		assert.Contains(t, rec.Body.String(), `"provider":"corp"`)
+	})
+
+	t.Run("linked identity keeps its user", func(t *testing.T) {
+		issuer.account = map[string]any{"sub": "1", "email": "alice@elsewhere.example", "email_verified": true}
+
+		browser := newBrowser(t)
+		resp := signIn(t, browser, vox, "corp")
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+		assert.Equal(t, "alice", whoami(t, s, sessionCookie(browser, vox)))
+	})
+
+	t.Run("unverified email is not linked", func(t *testing.T) {
+		issuer.account = map[string]any{"sub": "2", "email": "bob@example.org", "email_verified": false}
+
+		resp := signIn(t, newBrowser(t), vox, "corp")
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Equal(t, "identity is not linked to any user", loginProblem(t, resp).Detail)
+	})
+
+	t.Run("signed in user links explicitly", func(t *testing.T) {
+		issuer.account = map[string]any{"sub": "2", "email": "bob@example.org", "email_verified": false}
+
+		browser := newBrowser(t)
+		resp, err := browser.Post(vox.URL+"/sessions", "application/json",
+			strings.NewReader(`{"login_or_email": "bob", "password": "password"}`))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = signIn(t, browser, vox, "corp")
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+
+		// the identity is linked now, a fresh browser signs in as bob
+		other := newBrowser(t)
+		resp = signIn(t, other, vox, "corp")
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+		assert.Equal(t, "bob", whoami(t, s, sessionCookie(other, vox)))
+	})
+
+	t.Run("unknown identity is registered", func(t *testing.T) {
+		issuer.account = map[string]any{
+			"sub":                "3",
+			"email":              "new.user@partner.example",
+			"email_verified":     true,
+			"preferred_username": "new.user",
+		}
+
+		browser := newBrowser(t)
+		resp := signIn(t, browser, vox, "partner")
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+		assert.Equal(t, "newuser", whoami(t, s, sessionCookie(browser, vox)))
+	})
+
+	t.Run("unverified email is not registered", func(t *testing.T) {
+		issuer.account = map[string]any{
+			"sub":            "4",
+			"email":          "carol@example.org",
+			"email_verified": false,
+		}
+
+		resp := signIn(t, newBrowser(t), vox, "partner")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		_, err := s.Storage().Users().FindByEmail("carol@example.org")
+		assert.Error(t, err, "the address stays free for its owner")
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		issuer.account = map[string]any{"sub": "1", "email": "alice@example.org", "email_verified": true}
+		issuer.nonce = "replayed"
+		defer func() { issuer.nonce = "" }()
+
+		resp := signIn(t, newBrowser(t), vox, "corp")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("forged signature", func(t *testing.T) {
+		issuer.account = map[string]any{"sub": "1", "email": "alice@example.org", "email_verified": true}
+		forger, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		issuer.signer = forger
+		defer func() { issuer.signer = issuer.key }()
+
+		resp := signIn(t, newBrowser(t), vox, "corp")
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		problem := loginProblem(t, resp)
+		assert.Equal(t, server.CodeInvalidToken, problem.Code)
+		assert.Equal(t, "invalid id token", problem.Detail, "the error of the verifier isn't shown")
+	})
+
+	t.Run("rejected code", func(t *testing.T) {
+		issuer.account = map[string]any{"sub": "1", "email": "alice@example.org", "email_verified": true}
+
+		resp := signIn(t, newBrowser(t), vox, "misconfigured")
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		problem := loginProblem(t, resp)
+		assert.Equal(t, "provider rejected the authorization code", problem.Detail)
+		assert.NotContains(t, problem.Detail, "invalid_grant", "the answer of the provider isn't shown")
+	})
+
+	t.Run("state mismatch", func(t *testing.T) {
+		browser := newBrowser(t)
+		resp, err := browser.Get(vox.URL + "/auth/oidc/corp/login")
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		resp, err = browser.Get(vox.URL + "/auth/oidc/corp/callback?code=whatever&state=forged")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		rec := doRequest(s, http.MethodGet, "/auth/oidc/nope/login", "", nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
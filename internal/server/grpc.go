@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	voxv1 "vox-server/api/vox/v1"
+	"vox-server/internal/models"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// rpcErrorDomain qualifies the codes of the API errors in the details of a status
+const rpcErrorDomain = "vox-server"
+
+var rpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.AlreadyExists,
+	http.StatusUnprocessableEntity: codes.InvalidArgument,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+}
+
+// NewGRPCServer serves the identity service, the health checks and the reflection
+// of the services. Serve runs it on the configured gRPC address
+func (server *Server) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(server.logRPC, server.authentificateRPC))
+
+	srv := grpc.NewServer(opts...)
+	voxv1.RegisterIdentityServiceServer(srv, &identityService{server: server})
+	healthpb.RegisterHealthServer(srv, &healthService{server: server})
+	reflection.Register(srv)
+
+	return srv
+}
+
+// rpcError turns the error of a call into a status, the way server.error turns the error
+// of a request into a problem. The code of the problem is the reason of an ErrorInfo
+func (server *Server) rpcError(ctx context.Context, code int, err error) error {
+	problem := toAPIError(code, err)
+
+	rpcCode, ok := rpcCodes[problem.Status]
+	if !ok {
+		rpcCode = codes.Internal
+	}
+	if problem.Status >= http.StatusInternalServerError {
+		server.logger.ErrorContext(ctx, "call failed", "error_code", problem.Code, "error", err)
+	}
+
+	st, err := status.New(rpcCode, problem.Detail).WithDetails(&errdetails.ErrorInfo{
+		Reason: problem.Code,
+		Domain: rpcErrorDomain,
+	})
+	if err != nil {
+		return status.Error(rpcCode, problem.Detail)
+	}
+	return st.Err()
+}
+
+func (server *Server) logRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	code := status.Code(err)
+	level := slog.LevelInfo
+	switch code {
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable:
+		level = slog.LevelError
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", info.FullMethod),
+		slog.String("grpc_code", code.String()),
+		slog.Duration("took", time.Since(start)),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		attrs = append(attrs, slog.String("remote_addr", p.Addr.String()))
+	}
+
+	server.logger.LogAttrs(ctx, level, "call", attrs...)
+	return resp, err
+}
+
+// authentificateRPC authentificates the calls of the identity service with the 'authorization'
+// metadata, as authentificateUser does the requests, and requires an admin as the /admin routes do
+func (server *Server) authentificateRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !strings.HasPrefix(info.FullMethod, "/"+voxv1.IdentityService_ServiceDesc.ServiceName+"/") {
+		// e.g. the health checks
+		return handler(ctx, req)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	headers := md.Get("authorization")
+	if len(headers) == 0 {
+		return nil, server.rpcError(ctx, http.StatusUnauthorized, errors.New("unauthorized"))
+	}
+
+	authCtx, err := server.authentificate(ctx, headers[0])
+	if err != nil {
+		return nil, server.rpcError(ctx, http.StatusUnauthorized, err)
+	}
+	ctx = authCtx
+
+	if user := ctx.Value(userContextKey).(*models.User); !user.IsAdmin() {
+		return nil, server.rpcError(ctx, http.StatusForbidden, errors.New("admin role required"))
+	}
+
+	if err := checkScope(ctx, models.ScopeAdmin); err != nil {
+		return nil, server.rpcError(ctx, http.StatusForbidden, err)
+	}
+
+	return handler(ctx, req)
+}
+
+// auditRPC records an event caused by a call
+func (server *Server) auditRPC(ctx context.Context, action, actor, target string, details map[string]any) {
+	event := newAuditEvent(server.logger, action, actor, target, details)
+	if p, ok := peer.FromContext(ctx); ok {
+		event.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(event.IP); err == nil {
+			event.IP = host
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if agents := md.Get("user-agent"); len(agents) > 0 {
+			event.UserAgent = agents[0]
+		}
+	}
+
+	server.auditLog.Record(event)
+}
+
+// identityService implements the gRPC API of api/vox/v1/vox.proto
+type identityService struct {
+	voxv1.UnimplementedIdentityServiceServer
+	server *Server
+}
+
+func (s *identityService) GetUser(ctx context.Context, req *voxv1.GetUserRequest) (*voxv1.GetUserResponse, error) {
+	var user *models.User
+	var err error
+
+	switch key := req.GetKey().(type) {
+	case *voxv1.GetUserRequest_Login:
+		user, err = s.server.store(ctx).Users().FindByLogin(key.Login)
+	case *voxv1.GetUserRequest_Email:
+		user, err = s.server.store(ctx).Users().FindByEmail(key.Email)
+	default:
+		return nil, s.server.rpcError(ctx, http.StatusBadRequest, errors.New("login or email is required"))
+	}
+	if err != nil {
+		return nil, s.server.rpcError(ctx, http.StatusNotFound, errors.New("user not found"))
+	}
+
+	return &voxv1.GetUserResponse{User: &voxv1.User{
+		Login:    user.Login,
+		Username: user.Username,
+		Email:    user.Email,
+		Role:     user.Role,
+	}}, nil
+}
+
+func (s *identityService) ValidateToken(ctx context.Context, req *voxv1.ValidateTokenRequest) (*voxv1.ValidateTokenResponse, error) {
+	claims, session, err := s.server.activeToken(ctx, req.GetToken())
+	if err != nil {
+		return &voxv1.ValidateTokenResponse{Active: false}, nil
+	}
+
+	return &voxv1.ValidateTokenResponse{
+		Active:    true,
+		Subject:   session.UserLogin,
+		SessionId: session.ID,
+		ClientId:  session.ClientID,
+		Scopes:    claims.Scopes(),
+		TokenType: claims.TokenType,
+		ExpiresAt: timestamppb.New(time.Unix(claims.ExpiresAt, 0)),
+	}, nil
+}
+
+func (s *identityService) RevokeSession(ctx context.Context, req *voxv1.RevokeSessionRequest) (*voxv1.RevokeSessionResponse, error) {
+	session, err := s.server.store(ctx).Sessions().FindByID(req.GetSessionId())
+	if err != nil {
+		return nil, s.server.rpcError(ctx, http.StatusNotFound, fmt.Errorf("session '%s' not found", req.GetSessionId()))
+	}
+
+	if err := s.server.store(ctx).Sessions().Revoke(session.ID); err != nil {
+		return nil, s.server.rpcError(ctx, http.StatusInternalServerError, err)
+	}
+	s.server.introspections.InvalidateSession(session.ID)
+
+	caller := ctx.Value(userContextKey).(*models.User)
+	s.server.auditRPC(ctx, models.AuditLogout, caller.Login, session.UserLogin, map[string]any{"session_id": session.ID})
+
+	return &voxv1.RevokeSessionResponse{}, nil
+}
+
+// healthService answers the gRPC health checks as /readyz answers, for the server ("")
+// and for the identity service
+type healthService struct {
+	healthpb.UnimplementedHealthServer
+	server *Server
+}
+
+func (s *healthService) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	switch req.GetService() {
+	case "", voxv1.IdentityService_ServiceDesc.ServiceName:
+	default:
+		return nil, status.Errorf(codes.NotFound, "unknown service '%s'", req.GetService())
+	}
+
+	select {
+	case <-s.server.Stopping():
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING}, nil
+	default:
+	}
+
+	if s.server.health.Check(ctx).Status != HealthStatusOK {
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING}, nil
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
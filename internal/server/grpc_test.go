@@ -0,0 +1,187 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+	voxv1 "vox-server/api/vox/v1"
+	"vox-server/internal/models"
+	"vox-server/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newGRPCClient serves the gRPC API of the server in-process
+func newGRPCClient(t *testing.T, s *server.Server) *grpc.ClientConn {
+	t.Helper()
+
+	l := bufconn.Listen(1 << 20)
+	srv := s.NewGRPCServer()
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func withAuth(auth string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", auth)
+}
+
+// requireStatus checks the code of the status of the error and the code of its API error
+func requireStatus(t *testing.T, err error, code codes.Code, reason string) {
+	t.Helper()
+
+	st, ok := status.FromError(err)
+	require.True(t, ok, "%v is not a status", err)
+	require.Equal(t, code, st.Code(), st.Message())
+
+	require.Len(t, st.Details(), 1)
+	info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+	require.True(t, ok)
+	assert.Equal(t, reason, info.Reason)
+	assert.Equal(t, "vox-server", info.Domain)
+}
+
+func TestGRPC_Auth(t *testing.T) {
+	s := newTestServer(t)
+	client := voxv1.NewIdentityServiceClient(newGRPCClient(t, s))
+
+	bearer := "Bearer " + registerUser(t, s, "alice")
+	root := "Bearer " + registerUser(t, s, "root")
+	promote(t, s, "root")
+
+	unscoped := createAPIKey(t, s, root, models.ScopeProfileRead)
+	scoped := createAPIKey(t, s, root, models.ScopeAdmin)
+
+	req := &voxv1.GetUserRequest{Key: &voxv1.GetUserRequest_Login{Login: "alice"}}
+
+	_, err := client.GetUser(context.Background(), req)
+	requireStatus(t, err, codes.Unauthenticated, server.CodeUnauthorized)
+
+	_, err = client.GetUser(withAuth("Bearer nope"), req)
+	requireStatus(t, err, codes.Unauthenticated, server.CodeInvalidToken)
+
+	_, err = client.GetUser(withAuth(bearer), req)
+	requireStatus(t, err, codes.PermissionDenied, server.CodeForbidden)
+
+	_, err = client.GetUser(withAuth("Bot "+unscoped.Key), req)
+	requireStatus(t, err, codes.PermissionDenied, server.CodeInsufficientScope)
+
+	for _, auth := range []string{root, "Bot " + scoped.Key} {
+		resp, err := client.GetUser(withAuth(auth), req)
+		require.NoError(t, err)
+		assert.Equal(t, "alice", resp.GetUser().GetLogin())
+	}
+}
+
+func TestGRPC_IdentityService(t *testing.T) {
+	s := newTestServer(t)
+	client := voxv1.NewIdentityServiceClient(newGRPCClient(t, s))
+
+	token := registerUser(t, s, "alice")
+	root := withAuth("Bearer " + registerUser(t, s, "root"))
+	promote(t, s, "root")
+
+	t.Run("get user", func(t *testing.T) {
+		resp, err := client.GetUser(root, &voxv1.GetUserRequest{Key: &voxv1.GetUserRequest_Email{Email: "alice@example.org"}})
+		require.NoError(t, err)
+		assert.Equal(t, "alice", resp.GetUser().GetLogin())
+		assert.Equal(t, "alice", resp.GetUser().GetUsername())
+		assert.Equal(t, models.RoleUser, resp.GetUser().GetRole())
+
+		_, err = client.GetUser(root, &voxv1.GetUserRequest{Key: &voxv1.GetUserRequest_Login{Login: "bob"}})
+		requireStatus(t, err, codes.NotFound, server.CodeNotFound)
+
+		_, err = client.GetUser(root, &voxv1.GetUserRequest{})
+		requireStatus(t, err, codes.InvalidArgument, server.CodeBadRequest)
+	})
+
+	var sessionID string
+	t.Run("validate token", func(t *testing.T) {
+		resp, err := client.ValidateToken(root, &voxv1.ValidateTokenRequest{Token: token})
+		require.NoError(t, err)
+		assert.True(t, resp.GetActive())
+		assert.Equal(t, "alice", resp.GetSubject())
+		assert.Equal(t, "access", resp.GetTokenType())
+		assert.Empty(t, resp.GetClientId())
+		assert.NotEmpty(t, resp.GetSessionId())
+		assert.WithinDuration(t, time.Now().Add(server.AccessTokenTTL), resp.GetExpiresAt().AsTime(), time.Minute)
+		sessionID = resp.GetSessionId()
+
+		resp, err = client.ValidateToken(root, &voxv1.ValidateTokenRequest{Token: "nope"})
+		require.NoError(t, err)
+		assert.False(t, resp.GetActive())
+		assert.Empty(t, resp.GetSubject())
+	})
+
+	t.Run("revoke session", func(t *testing.T) {
+		_, err := client.RevokeSession(root, &voxv1.RevokeSessionRequest{SessionId: sessionID})
+		require.NoError(t, err)
+
+		resp, err := client.ValidateToken(root, &voxv1.ValidateTokenRequest{Token: token})
+		require.NoError(t, err)
+		assert.False(t, resp.GetActive())
+
+		rec := doRequest(s, http.MethodGet, "/private/whoami", "Bearer "+token, nil)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, "the HTTP API rejects the tokens of the session too")
+
+		_, err = client.RevokeSession(root, &voxv1.RevokeSessionRequest{SessionId: "nope"})
+		requireStatus(t, err, codes.NotFound, server.CodeNotFound)
+
+		require.NoError(t, s.Close(context.Background()))
+		events, err := s.Storage().Audit().Find(models.AuditFilter{Action: models.AuditLogout})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, "root", events[0].Actor)
+		assert.Equal(t, "alice", events[0].Target)
+	})
+}
+
+func TestGRPC_HealthAndReflection(t *testing.T) {
+	s := newTestServer(t)
+	conn := newGRPCClient(t, s)
+
+	health := healthpb.NewHealthClient(conn)
+	for _, service := range []string{"", "vox.v1.IdentityService"} {
+		resp, err := health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+	}
+
+	_, err := health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "nope"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}))
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+
+	var services []string
+	for _, service := range resp.GetListServicesResponse().GetService() {
+		services = append(services, service.GetName())
+	}
+	assert.Contains(t, services, "vox.v1.IdentityService")
+	assert.Contains(t, services, "grpc.health.v1.Health")
+}
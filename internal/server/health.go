@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHealthTimeout = 2 * time.Second
+
+	HealthStatusOK          = "ok"
+	HealthStatusUnavailable = "unavailable"
+	HealthStatusStopping    = "stopping"
+)
+
+// HealthChecker reports whether a dependency can serve requests
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// HealthCheckerFunc adapts a function to a HealthChecker, e.g. (*sql.DB).PingContext
+type HealthCheckerFunc func(ctx context.Context) error
+
+func (f HealthCheckerFunc) CheckHealth(ctx context.Context) error {
+	return f(ctx)
+}
+
+// HealthResult is the outcome of one check
+type HealthResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthReport is ok when every check is
+type HealthReport struct {
+	Status string                  `json:"status"`
+	Checks map[string]HealthResult `json:"checks"`
+}
+
+// Err describes the failed checks, it is nil when the report is ok
+func (report HealthReport) Err() error {
+	names := slices.Sorted(maps.Keys(report.Checks))
+
+	var errs []error
+	for _, name := range names {
+		if result := report.Checks[name]; result.Status != HealthStatusOK {
+			errs = append(errs, fmt.Errorf("%s: %s", name, result.Error))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// HealthRegistry runs the registered checks concurrently, each bounded by the timeout
+type HealthRegistry struct {
+	timeout atomic.Int64 // time.Duration
+
+	mu       sync.RWMutex
+	names    []string
+	checkers map[string]HealthChecker
+}
+
+func NewHealthRegistry(timeout time.Duration) *HealthRegistry {
+	registry := &HealthRegistry{checkers: make(map[string]HealthChecker)}
+	registry.SetTimeout(timeout)
+
+	return registry
+}
+
+// SetTimeout bounds the checks started from now on, the default applies to a zero timeout
+func (registry *HealthRegistry) SetTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+	registry.timeout.Store(int64(timeout))
+}
+
+// Register adds a checker, a checker of the same name is replaced
+func (registry *HealthRegistry) Register(name string, checker HealthChecker) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, ok := registry.checkers[name]; !ok {
+		registry.names = append(registry.names, name)
+	}
+	registry.checkers[name] = checker
+}
+
+func (registry *HealthRegistry) Check(ctx context.Context) HealthReport {
+	registry.mu.RLock()
+	names := slices.Clone(registry.names)
+	checkers := make([]HealthChecker, len(names))
+	for i, name := range names {
+		checkers[i] = registry.checkers[name]
+	}
+	registry.mu.RUnlock()
+
+	results := make([]HealthResult, len(names))
+
+	var wg sync.WaitGroup
+	for i, checker := range checkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = registry.check(ctx, checker)
+		}()
+	}
+	wg.Wait()
+
+	report := HealthReport{Status: HealthStatusOK, Checks: make(map[string]HealthResult, len(names))}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != HealthStatusOK {
+			report.Status = HealthStatusUnavailable
+		}
+	}
+
+	return report
+}
+
+func (registry *HealthRegistry) check(ctx context.Context, checker HealthChecker) HealthResult {
+	timeout := time.Duration(registry.timeout.Load())
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+
+	// a checker ignoring its context doesn't hold up the report
+	done := make(chan error, 1)
+	go func() { done <- checker.CheckHealth(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := HealthResult{
+		Status:    HealthStatusOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = HealthStatusUnavailable
+		result.Error = err.Error()
+		if errors.Is(err, context.DeadlineExceeded) {
+			result.Error = "timed out after " + timeout.String()
+		}
+	}
+
+	return result
+}
+
+// RegisterHealthChecker adds a dependency to the readiness of the server
+func (server *Server) RegisterHealthChecker(name string, checker HealthChecker) {
+	server.health.Register(name, checker)
+}
+
+// handleHealthz reports that the process is alive, it checks no dependency
+func (server *Server) handleHealthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		server.respond(w, r, http.StatusOK, map[string]string{"status": HealthStatusOK})
+	}
+}
+
+// handleReadyz reports whether the server can serve requests. It is not ready
+// once the shutdown begins, so that load balancers stop sending requests
+func (server *Server) handleReadyz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+
+		select {
+		case <-server.Stopping():
+			server.respond(w, r, http.StatusServiceUnavailable, HealthReport{
+				Status: HealthStatusStopping,
+				Checks: map[string]HealthResult{},
+			})
+			return
+		default:
+		}
+
+		report := server.health.Check(r.Context())
+
+		code := http.StatusOK
+		if report.Status != HealthStatusOK {
+			code = http.StatusServiceUnavailable
+		}
+
+		server.respond(w, r, code, report)
+	}
+}
@@ -0,0 +1,98 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+	"vox-server/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readyz(t *testing.T, h http.Handler) (int, server.HealthReport) {
+	t.Helper()
+
+	rec := doRequest(h, http.MethodGet, "/readyz", "", nil)
+
+	var report server.HealthReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	return rec.Code, report
+}
+
+func TestHealth_Probes(t *testing.T) {
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.Health.Timeout = 50 * time.Millisecond
+
+	s, err := server.NewInMemoryServer(cfg)
+	require.NoError(t, err)
+
+	rec := doRequest(s, http.MethodGet, "/healthz", "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+
+	code, report := readyz(t, s)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, server.HealthStatusOK, report.Status)
+
+	s.RegisterHealthChecker("cache", server.HealthCheckerFunc(func(context.Context) error { return nil }))
+	s.RegisterHealthChecker("queue", server.HealthCheckerFunc(func(context.Context) error {
+		return errors.New("connection refused")
+	}))
+	s.RegisterHealthChecker("search", server.HealthCheckerFunc(func(context.Context) error {
+		time.Sleep(time.Second) // ignores its context
+		return nil
+	}))
+
+	start := time.Now()
+	code, report = readyz(t, s)
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "a hung check is cut off by the timeout")
+
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, server.HealthStatusUnavailable, report.Status)
+	require.Len(t, report.Checks, 3)
+	assert.Equal(t, server.HealthStatusOK, report.Checks["cache"].Status)
+	assert.Equal(t, "connection refused", report.Checks["queue"].Error)
+	assert.Equal(t, "timed out after 50ms", report.Checks["search"].Error)
+	assert.GreaterOrEqual(t, report.Checks["search"].LatencyMS, 50.0)
+
+	// the liveness doesn't depend on the dependencies
+	assert.Equal(t, http.StatusOK, doRequest(s, http.MethodGet, "/healthz", "", nil).Code)
+
+	// the admin listener serves the probes too
+	code, _ = readyz(t, s.AdminHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	assert.EqualError(t, report.Err(), "queue: connection refused\nsearch: timed out after 50ms")
+}
+
+func TestHealth_NotReadyDuringShutdown(t *testing.T) {
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.HTTP.ShutdownDelay = 300 * time.Millisecond
+
+	s, url, stop := newServingServer(t, cfg)
+
+	resp, err := http.Get(url + "/readyz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- stop() }()
+	<-s.Stopping()
+
+	// the listener is still open during the delay, but reports not-ready
+	resp, err = http.Get(url + "/readyz")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var report server.HealthReport
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, server.HealthStatusStopping, report.Status)
+
+	assert.NoError(t, <-stopped)
+}
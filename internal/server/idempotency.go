@@ -0,0 +1,254 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"vox-server/internal/models"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	defaultIdempotencyTTL    = 24 * time.Hour
+	// the responses carrying credentials are kept for the retries of a flaky network only
+	idempotentCredentialsTTL = 10 * time.Minute
+)
+
+func (server *Server) idempotencyTTL() time.Duration {
+	if server.config.Idempotency.TTL > 0 {
+		return server.config.Idempotency.TTL
+	}
+	return defaultIdempotencyTTL
+}
+
+// idempotencyRecorder keeps the response of the first request of a key for its retries
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	code   int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *idempotencyRecorder) WriteHeader(statusCode int) {
+	if w.code == 0 {
+		w.code = statusCode
+		w.header = w.ResponseWriter.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// idempotent makes the retries of POST, PATCH and DELETE requests carrying an Idempotency-Key
+// safe. The first response of a key of the user is stored and replayed to the retries with
+// the same fingerprint. Anonymous requests have no user, their keys are scoped by the request
+// so that a client can't claim the key of another one. The client address isn't part of the
+// scope, mobile clients change it between their retries. The responses of server errors aren't
+// stored, so that the retries run again. The responses marked no-store carry tokens or secrets:
+// they are sealed with a key derived from the request, kept for a short while only
+func (server *Server) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost, http.MethodPatch, http.MethodDelete:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			server.error(w, r, http.StatusBadRequest, fmt.Errorf("%s is longer than %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			server.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := models.RequestFingerprint(r.Method, r.URL.RequestURI(), body)
+
+		var login string
+		if user, ok := r.Context().Value(userContextKey).(*models.User); ok {
+			login = user.Login
+		} else {
+			// scoped by the request, see above
+			key = fingerprint + ":" + key
+		}
+
+		records := server.store(r.Context()).Idempotency()
+		record := models.NewIdempotencyRecord(login, key, fingerprint, server.idempotencyTTL())
+
+		if err := records.Create(record); err != nil {
+			existing, findErr := records.Find(login, key)
+			if findErr != nil {
+				// the store failed, the key isn't in use
+				server.error(w, r, http.StatusInternalServerError, err)
+				return
+			}
+
+			switch {
+			case existing.Fingerprint != fingerprint:
+				server.error(w, r, http.StatusUnprocessableEntity, apiError(CodeIdempotencyReuse,
+					errors.New("the idempotency key was used for another request")))
+			case !existing.IsCompleted():
+				server.error(w, r, http.StatusConflict, apiError(CodeIdempotencyBusy,
+					errors.New("the first request of the idempotency key is in progress")))
+			default:
+				header, response := existing.Header, existing.Body
+				if isNoStore(existing.Header) {
+					header, response, err = server.openIdempotentResponse(existing, body)
+					if err != nil {
+						// sealed with another secret, e.g. before a restart
+						server.error(w, r, http.StatusConflict, apiError(CodeIdempotencyUsed,
+							errors.New("the first request of the idempotency key succeeded, its credentials can't be shown again")))
+						return
+					}
+				}
+
+				for name, values := range header {
+					w.Header()[name] = values
+				}
+				w.Header().Set(idempotentReplayedHeader, "true")
+				w.WriteHeader(existing.Status)
+				w.Write(response)
+			}
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+			rec.header = w.Header().Clone()
+		}
+
+		// a background context, the response is stored even when the client is gone
+		records = server.store(context.WithoutCancel(r.Context())).Idempotency()
+
+		if rec.code >= http.StatusInternalServerError {
+			if err := records.Delete(login, key); err != nil {
+				server.requestLogger(r).Warn("failed to release idempotency key", "error", err)
+			}
+			return
+		}
+
+		// the id of this request isn't the one of the retries
+		rec.header.Del("Request-ID")
+
+		record.Status = rec.code
+		record.Header = rec.header
+		record.Body = rec.body.Bytes()
+		if isNoStore(rec.header) {
+			// the retries of a login get their session, the cookies are sealed with the tokens
+			record.Header = http.Header{"Cache-Control": {"no-store"}}
+			record.Body, err = server.sealIdempotentResponse(record, body, rec.header, rec.body.Bytes())
+			if err != nil {
+				server.requestLogger(r).Warn("failed to seal idempotent response", "error", err)
+			}
+			if expiresAt := record.CreatedAt.Add(idempotentCredentialsTTL); expiresAt.Before(record.ExpiresAt) {
+				record.ExpiresAt = expiresAt
+			}
+		} else {
+			// cookies are replayed sealed only, with the credentials
+			record.Header.Del("Set-Cookie")
+		}
+		if err := records.Complete(record); err != nil {
+			server.requestLogger(r).Warn("failed to store idempotent response", "error", err)
+		}
+	})
+}
+
+func isNoStore(header http.Header) bool {
+	return strings.Contains(header.Get("Cache-Control"), "no-store")
+}
+
+// sealedResponse is the response marked no-store of a record, stored encrypted
+type sealedResponse struct {
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// idempotencyCipher encrypts the response of the record with a key derived from the secret of
+// the server and the request: the database alone doesn't open it, only the retries do
+func (server *Server) idempotencyCipher(record *models.IdempotencyRecord, request []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, []byte(jwtSecret(server.config)))
+	mac.Write([]byte("idempotency\x00" + record.UserLogin + "\x00" + record.Key + "\x00"))
+	mac.Write(request)
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (server *Server) sealIdempotentResponse(record *models.IdempotencyRecord, request []byte, header http.Header, body []byte) ([]byte, error) {
+	aead, err := server.idempotencyCipher(record, request)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := json.Marshal(sealedResponse{Header: header, Body: body})
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (server *Server) openIdempotentResponse(record *models.IdempotencyRecord, request []byte) (http.Header, []byte, error) {
+	aead, err := server.idempotencyCipher(record, request)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(record.Body) < aead.NonceSize() {
+		return nil, nil, errors.New("the response isn't sealed")
+	}
+	nonce, ciphertext := record.Body[:aead.NonceSize()], record.Body[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var response sealedResponse
+	if err := json.Unmarshal(plaintext, &response); err != nil {
+		return nil, nil, err
+	}
+	return response.Header, response.Body, nil
+}
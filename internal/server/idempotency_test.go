@@ -0,0 +1,184 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func doIdempotentRequest(s http.Handler, method, path, auth, key string, payload any) *httptest.ResponseRecorder {
+	b := &bytes.Buffer{}
+	if payload != nil {
+		json.NewEncoder(b).Encode(payload)
+	}
+
+	req := httptest.NewRequest(method, path, b)
+	req.Header.Set("Idempotency-Key", key)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotency_Replay(t *testing.T) {
+	s := newTestServer(t)
+	bearer := "Bearer " + registerUser(t, s, "alice")
+	key := createAPIKey(t, s, bearer, models.ScopeProfileRead)
+
+	first := doIdempotentRequest(s, http.MethodDelete, "/api/v1/private/keys/"+key.ID, bearer, "revoke-1", nil)
+	require.Equal(t, http.StatusNoContent, first.Code, first.Body.String())
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	retry := doIdempotentRequest(s, http.MethodDelete, "/api/v1/private/keys/"+key.ID, bearer, "revoke-1", nil)
+	require.Equal(t, http.StatusNoContent, retry.Code, "the retry doesn't fail as a revoked key")
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.NotEqual(t, first.Header().Get("Request-ID"), retry.Header().Get("Request-ID"))
+
+	rec := doIdempotentRequest(s, http.MethodDelete, "/api/v1/private/keys/other", bearer, "revoke-1", nil)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Equal(t, server.CodeIdempotencyReuse, decodeProblem(t, rec).Code)
+
+	rec = doRequest(s, http.MethodDelete, "/api/v1/private/keys/"+key.ID, bearer, nil)
+	assert.NotEqual(t, http.StatusNoContent, rec.Code, "requests without a key aren't replayed")
+	assert.Empty(t, rec.Header().Get("Idempotent-Replayed"))
+}
+
+func TestIdempotency_Credentials(t *testing.T) {
+	s := newTestServer(t)
+
+	registration := map[string]string{
+		"login":    "alice",
+		"username": "alice",
+		"email":    "alice@example.org",
+		"password": "password",
+	}
+
+	first := doIdempotentRequest(s, http.MethodPost, "/api/v1/users", "", "signup-1", registration)
+	require.Equal(t, http.StatusCreated, first.Code, first.Body.String())
+	var tokens struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	require.NoError(t, json.Unmarshal(first.Body.Bytes(), &tokens))
+	require.NotEmpty(t, tokens.AccessToken)
+	require.NotEmpty(t, first.Header().Get("Set-Cookie"))
+
+	// the retry gets the session of the registration
+	retry := doIdempotentRequest(s, http.MethodPost, "/api/v1/users", "", "signup-1", registration)
+	require.Equal(t, http.StatusCreated, retry.Code, retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, first.Header().Get("Set-Cookie"), retry.Header().Get("Set-Cookie"))
+	assert.Equal(t, 1, s.Storage().Users().Count())
+
+	bearer := "Bearer " + tokens.AccessToken
+	payload := map[string]any{"name": "ci", "scopes": []string{models.ScopeProfileRead}}
+	rec := doIdempotentRequest(s, http.MethodPost, "/private/keys", bearer, "key-1", payload)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var key apiKeyResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &key))
+
+	replayed := doIdempotentRequest(s, http.MethodPost, "/private/keys", bearer, "key-1", payload)
+	require.Equal(t, http.StatusCreated, replayed.Code)
+	assert.Equal(t, rec.Body.String(), replayed.Body.String())
+
+	// the records keep the credentials sealed, for a short while
+	signup, _ := json.Marshal(registration)
+	signupKey := models.RequestFingerprint(http.MethodPost, "/api/v1/users", append(signup, '\n')) + ":signup-1"
+	for _, record := range []struct{ login, key string }{{"", signupKey}, {"alice", "key-1"}} {
+		stored, err := s.Storage().Idempotency().Find(record.login, record.key)
+		require.NoError(t, err)
+		assert.NotEmpty(t, stored.Body)
+		assert.Empty(t, stored.Header.Get("Set-Cookie"))
+		assert.WithinDuration(t, stored.CreatedAt.Add(10*time.Minute), stored.ExpiresAt, time.Second)
+		for _, secret := range []string{tokens.AccessToken, tokens.RefreshToken, key.Key} {
+			assert.NotContains(t, string(stored.Body), secret)
+			assert.NotContains(t, fmt.Sprint(stored.Header), secret)
+		}
+	}
+
+	// case : the response can't be opened, e.g. sealed with another secret
+	stored, err := s.Storage().Idempotency().Find("alice", "key-1")
+	require.NoError(t, err)
+	stored.Body[len(stored.Body)-1] ^= 1
+	require.NoError(t, s.Storage().Idempotency().Complete(stored))
+
+	rec = doIdempotentRequest(s, http.MethodPost, "/private/keys", bearer, "key-1", payload)
+	require.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, server.CodeIdempotencyUsed, decodeProblem(t, rec).Code)
+}
+
+func TestIdempotency_AnonymousKeys(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, login := range []string{"alice", "bob"} {
+		rec := doIdempotentRequest(s, http.MethodPost, "/api/v1/users", "", "signup", map[string]string{
+			"login":    login,
+			"username": login,
+			"email":    login + "@example.org",
+			"password": "password",
+		})
+		require.Equal(t, http.StatusCreated, rec.Code, "anonymous clients don't share their keys")
+		assert.Empty(t, rec.Header().Get("Idempotent-Replayed"))
+	}
+	assert.Equal(t, 2, s.Storage().Users().Count())
+}
+
+func TestIdempotency_KeysOfUsers(t *testing.T) {
+	s := newTestServer(t)
+	alice := "Bearer " + registerUser(t, s, "alice")
+	bob := "Bearer " + registerUser(t, s, "bob")
+
+	for _, auth := range []string{alice, bob} {
+		key := createAPIKey(t, s, auth, models.ScopeProfileRead)
+
+		rec := doIdempotentRequest(s, http.MethodDelete, "/private/keys/"+key.ID, auth, "key-1", nil)
+		require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+		assert.Empty(t, rec.Header().Get("Idempotent-Replayed"), "each user has its own keys")
+	}
+
+	rec := doIdempotentRequest(s, http.MethodDelete, "/private/keys/nope", alice, "key-1", nil)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code, "the key of alice is in use")
+}
+
+func TestIdempotency_Errors(t *testing.T) {
+	s := newTestServer(t)
+	bearer := "Bearer " + registerUser(t, s, "alice")
+
+	// the first request is still in flight
+	inFlight := models.NewIdempotencyRecord("alice", "busy", models.RequestFingerprint(http.MethodDelete, "/private/keys/nope", nil), time.Hour)
+	require.NoError(t, s.Storage().Idempotency().Create(inFlight))
+
+	rec := doIdempotentRequest(s, http.MethodDelete, "/private/keys/nope", bearer, "busy", nil)
+	require.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, server.CodeIdempotencyBusy, decodeProblem(t, rec).Code)
+
+	rec = doIdempotentRequest(s, http.MethodDelete, "/private/keys/nope", bearer, strings.Repeat("k", 256), nil)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// errors of the client are replayed too
+	rec = doIdempotentRequest(s, http.MethodDelete, "/private/keys/nope", bearer, "missing", nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = doIdempotentRequest(s, http.MethodDelete, "/private/keys/nope", bearer, "missing", nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get("Idempotent-Replayed"))
+
+	// safe methods ignore the key
+	rec = doIdempotentRequest(s, http.MethodGet, "/private/whoami", bearer, "busy", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Idempotent-Replayed"))
+}
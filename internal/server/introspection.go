@@ -0,0 +1,127 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// maxIntrospectionEntries bounds the memory of the cache, answers beyond it aren't cached
+const maxIntrospectionEntries = 10000
+
+// introspection is the answer of RFC 7662 about a token, only active tokens have claims
+type introspection struct {
+	Active    bool   `json:"active"`
+	Subject   string `json:"sub,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ID        string `json:"jti,omitempty"`
+}
+
+type introspectionEntry struct {
+	answer  introspection
+	expires time.Time
+}
+
+// introspectionCache keeps the answers about active tokens for a short time, so that services
+// introspecting a token on each of their requests don't load its session each time. Ending
+// a session invalidates the answers about its tokens
+type introspectionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration // 0 disables the cache
+	entries map[string]introspectionEntry
+}
+
+func newIntrospectionCache(ttl time.Duration) *introspectionCache {
+	return &introspectionCache{ttl: ttl, entries: make(map[string]introspectionEntry)}
+}
+
+// tokens are kept hashed, a dump of the memory doesn't leak them
+func introspectionKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (cache *introspectionCache) SetTTL(ttl time.Duration) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.ttl = ttl
+	if ttl == 0 {
+		clear(cache.entries)
+	}
+}
+
+func (cache *introspectionCache) Get(token string, now time.Time) (introspection, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	key := introspectionKey(token)
+	entry, ok := cache.entries[key]
+	if !ok {
+		return introspection{}, false
+	}
+	if !now.Before(entry.expires) {
+		delete(cache.entries, key)
+		return introspection{}, false
+	}
+	return entry.answer, true
+}
+
+// Put caches an answer about an active token until the ttl passes, at most until the token expires
+func (cache *introspectionCache) Put(token string, answer introspection, now time.Time) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.ttl == 0 || !answer.Active {
+		return
+	}
+
+	if len(cache.entries) >= maxIntrospectionEntries {
+		for key, entry := range cache.entries {
+			if !now.Before(entry.expires) {
+				delete(cache.entries, key)
+			}
+		}
+		if len(cache.entries) >= maxIntrospectionEntries {
+			return
+		}
+	}
+
+	expires := now.Add(cache.ttl)
+	if tokenExpiry := time.Unix(answer.ExpiresAt, 0); tokenExpiry.Before(expires) {
+		expires = tokenExpiry
+	}
+	cache.entries[introspectionKey(token)] = introspectionEntry{answer: answer, expires: expires}
+}
+
+// InvalidateSession drops the answers about the tokens of the session
+func (cache *introspectionCache) InvalidateSession(sessionID string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for key, entry := range cache.entries {
+		if entry.answer.SessionID == sessionID {
+			delete(cache.entries, key)
+		}
+	}
+}
+
+// InvalidateSubject drops the answers about the tokens of the user, for changes ending the access
+// of the user rather than of one session
+func (cache *introspectionCache) InvalidateSubject(login string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for key, entry := range cache.entries {
+		if entry.answer.Subject == login {
+			delete(cache.entries, key)
+		}
+	}
+}
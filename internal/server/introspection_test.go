@@ -0,0 +1,127 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+	"vox-server/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOAuth_IntrospectionCache(t *testing.T) {
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.OAuth.IntrospectionCacheTTL = time.Minute
+	s, err := server.NewInMemoryServer(cfg)
+	require.NoError(t, err)
+
+	idp := httptest.NewServer(s)
+	defer idp.Close()
+
+	token := registerUser(t, s, "alice")
+	rp := newFakeRelyingParty(t)
+	rp.register(t, s, "Bearer "+token, false)
+
+	introspect := func(token string) map[string]any {
+		t.Helper()
+		status, introspection := rp.tokenRequest(t, idp.URL+"/oauth/introspect", url.Values{"token": {token}})
+		require.Equal(t, http.StatusOK, status)
+		return introspection
+	}
+
+	introspection := introspect(token)
+	require.Equal(t, true, introspection["active"])
+	assert.Equal(t, "alice", introspection["sub"])
+	assert.Equal(t, "Bearer", introspection["token_type"])
+	assert.InDelta(t, time.Now().Add(server.AccessTokenTTL).Unix(), introspection["exp"], 60)
+	sessionID, _ := introspection["sid"].(string)
+	require.NotEmpty(t, sessionID)
+
+	// revocations the server doesn't see apply once the answer expires
+	require.NoError(t, s.Storage().Sessions().Revoke(sessionID))
+	assert.Equal(t, true, introspect(token)["active"], "the answer is cached")
+
+	// a logout applies right away
+	rec := doRequest(s, http.MethodPost, "/sessions", "", map[string]string{"login_or_email": "alice", "password": "password"})
+	require.Equal(t, http.StatusOK, rec.Code)
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&tokens))
+
+	introspection = introspect(tokens.AccessToken)
+	require.Equal(t, true, introspection["active"])
+	assert.NotEqual(t, sessionID, introspection["sid"])
+
+	rec = doRequest(s, http.MethodDelete, "/private/sessions/current", "Bearer "+tokens.AccessToken, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, map[string]any{"active": false}, introspect(tokens.AccessToken))
+}
+
+func TestOAuth_IntrospectionWithoutCache(t *testing.T) {
+	s := newTestServer(t)
+	idp := httptest.NewServer(s)
+	defer idp.Close()
+
+	token := registerUser(t, s, "alice")
+	rp := newFakeRelyingParty(t)
+	rp.register(t, s, "Bearer "+token, false)
+
+	status, introspection := rp.tokenRequest(t, idp.URL+"/oauth/introspect", url.Values{"token": {token}})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, true, introspection["active"])
+
+	require.NoError(t, s.Storage().Sessions().Revoke(introspection["sid"].(string)))
+	_, introspection = rp.tokenRequest(t, idp.URL+"/oauth/introspect", url.Values{"token": {token}})
+	assert.Equal(t, map[string]any{"active": false}, introspection, "every introspection checks the session")
+}
+
+// the sessions of a deleted user are dropped by the foreign keys, which the in-memory storage lacks
+func TestOAuth_IntrospectionCacheOfDeletedUser(t *testing.T) {
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.OAuth.IntrospectionCacheTTL = time.Minute
+	cfg.Storage.Driver = "sqlite"
+	cfg.DatabaseURL = "sqlite://" + filepath.Join(t.TempDir(), "vox.db")
+	cfg.DB.Create = true
+	cfg.DB.AutoMigrate = true
+	s, err := server.StartServerWithConfig(cfg, false)
+	require.NoError(t, err)
+	defer s.Close(context.Background())
+
+	idp := httptest.NewServer(s)
+	defer idp.Close()
+
+	admin := "Bearer " + registerUser(t, s, "alice")
+	promote(t, s, "alice")
+	bob := registerUser(t, s, "bob")
+	rp := newFakeRelyingParty(t)
+	rp.register(t, s, admin, false)
+
+	introspect := func(token string) map[string]any {
+		t.Helper()
+		status, introspection := rp.tokenRequest(t, idp.URL+"/oauth/introspect", url.Values{"token": {token}})
+		require.Equal(t, http.StatusOK, status)
+		return introspection
+	}
+
+	require.Equal(t, true, introspect(bob)["active"])
+
+	// a password change keeps the sessions, but the answers about them are asked again
+	rec := doRequest(s, http.MethodPut, "/private/password", "Bearer "+bob, map[string]string{
+		"current_password": "password",
+		"new_password":     "new-password",
+	})
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	require.Equal(t, true, introspect(bob)["active"])
+
+	rec = doRequest(s, http.MethodDelete, "/admin/users/bob", admin, nil)
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	assert.Equal(t, map[string]any{"active": false}, introspect(bob), "the deletion applies right away")
+	assert.Equal(t, true, introspect(admin[len("Bearer "):])["active"], "other users are unaffected")
+}
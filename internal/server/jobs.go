@@ -0,0 +1,487 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	defaultJobWorkers      = 4
+	defaultJobMaxAttempts  = 10
+	defaultJobBackoff      = 10 * time.Second
+	defaultJobMaxBackoff   = time.Hour
+	defaultJobLease        = 5 * time.Minute
+	defaultJobPollInterval = time.Second
+	defaultJobRetention    = 7 * 24 * time.Hour
+	defaultJobPageSize     = 50
+	maxJobPageSize         = 500
+
+	jobCleanup          = "jobs.cleanup"
+	jobSweepIdempotency = "idempotency.sweep"
+)
+
+// JobHandler runs the jobs of a kind. An error fails the attempt, the job is retried with backoff
+// until it runs out of attempts. A job may run more than once, e.g. when its server stopped in the
+// middle of it, so handlers are idempotent
+type JobHandler interface {
+	RunJob(ctx context.Context, job *models.Job) error
+}
+
+// JobHandlerFunc adapts a function to a JobHandler
+type JobHandlerFunc func(ctx context.Context, job *models.Job) error
+
+func (f JobHandlerFunc) RunJob(ctx context.Context, job *models.Job) error {
+	return f(ctx, job)
+}
+
+// jobSchedule enqueues a run of a recurring job at every time of its cron spec
+type jobSchedule struct {
+	name     string
+	kind     string
+	payload  any
+	schedule cron.Schedule
+}
+
+type jobRunner struct {
+	mu        sync.RWMutex
+	handlers  map[string]JobHandler
+	schedules []jobSchedule
+
+	// wakes RunJobs up when jobs are enqueued or workers are free again
+	wake chan struct{}
+}
+
+func newJobRunner() *jobRunner {
+	return &jobRunner{
+		handlers: make(map[string]JobHandler),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// jobPolicy is the jobs section of the config, with the defaults of its unset keys
+type jobPolicy struct {
+	workers      int
+	maxAttempts  int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	lease        time.Duration
+	pollInterval time.Duration
+	retention    time.Duration
+}
+
+func (server *Server) jobPolicy() jobPolicy {
+	cfg := server.config.Jobs
+	policy := jobPolicy{
+		workers:      defaultJobWorkers,
+		maxAttempts:  defaultJobMaxAttempts,
+		backoff:      defaultJobBackoff,
+		maxBackoff:   defaultJobMaxBackoff,
+		lease:        defaultJobLease,
+		pollInterval: defaultJobPollInterval,
+		retention:    defaultJobRetention,
+	}
+
+	if cfg.Workers > 0 {
+		policy.workers = cfg.Workers
+	}
+	if cfg.MaxAttempts > 0 {
+		policy.maxAttempts = cfg.MaxAttempts
+	}
+	if cfg.Backoff > 0 {
+		policy.backoff = cfg.Backoff
+	}
+	if cfg.MaxBackoff > 0 {
+		policy.maxBackoff = cfg.MaxBackoff
+	}
+	if cfg.Lease > 0 {
+		policy.lease = cfg.Lease
+	}
+	if cfg.PollInterval > 0 {
+		policy.pollInterval = cfg.PollInterval
+	}
+	if cfg.Retention > 0 {
+		policy.retention = cfg.Retention
+	}
+
+	return policy
+}
+
+// retryAfter is the wait before the next attempt of a job that failed the given times
+func (policy jobPolicy) retryAfter(failed int) time.Duration {
+	wait := policy.backoff
+	for i := 1; i < failed && wait < policy.maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, policy.maxBackoff)
+}
+
+// HandleJob registers the handler of the jobs of the kind, replacing the previous one
+func (server *Server) HandleJob(kind string, handler JobHandler) {
+	server.jobs.mu.Lock()
+	defer server.jobs.mu.Unlock()
+
+	server.jobs.handlers[kind] = handler
+}
+
+func (server *Server) jobHandler(kind string) (JobHandler, bool) {
+	server.jobs.mu.RLock()
+	defer server.jobs.mu.RUnlock()
+
+	handler, ok := server.jobs.handlers[kind]
+	return handler, ok
+}
+
+// ScheduleJob enqueues a job of the kind at every time of the cron spec, in UTC: five fields
+// (e.g. "30 3 * * *" for 3:30 every day) or a descriptor such as "@hourly". Every server
+// schedules the run, it is enqueued once by its key. Runs missed while no server was up are
+// skipped. Schedules registered after RunJobs started are taken into account by its next call
+func (server *Server) ScheduleJob(name, spec, kind string, payload any) error {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("invalid schedule '%s' of job '%s': %w", spec, name, err)
+	}
+
+	server.jobs.mu.Lock()
+	defer server.jobs.mu.Unlock()
+
+	server.jobs.schedules = append(server.jobs.schedules, jobSchedule{
+		name:     name,
+		kind:     kind,
+		payload:  payload,
+		schedule: schedule,
+	})
+
+	return nil
+}
+
+// Enqueue stores a job of the kind, due now. The storage may be a transaction, e.g. the one of the
+// writes the job follows: the job is then run if and only if the transaction commits
+func (server *Server) Enqueue(store storage.Storage, kind string, payload any) (*models.Job, error) {
+	return server.EnqueueAt(store, kind, payload, time.Time{})
+}
+
+// EnqueueAt stores a job of the kind, due at the time, now when it is zero
+func (server *Server) EnqueueAt(store storage.Storage, kind string, payload any, at time.Time) (*models.Job, error) {
+	job, err := models.NewJob(kind, payload, server.jobPolicy().maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	if !at.IsZero() {
+		job.RunAt = at.UTC()
+	}
+
+	if _, err := store.Jobs().Enqueue(job); err != nil {
+		return nil, fmt.Errorf("failed to enqueue job '%s': %w", kind, err)
+	}
+
+	// before a commit the job isn't found yet, the next poll runs it
+	server.wakeJobs()
+
+	return job, nil
+}
+
+func (server *Server) wakeJobs() {
+	select {
+	case server.jobs.wake <- struct{}{}:
+	default:
+		// already woken up
+	}
+}
+
+// registerJobs registers the handlers and the schedules of the jobs of the server
+func (server *Server) registerJobs() {
+	server.HandleJob(jobPublishWebhooks, JobHandlerFunc(server.runPublishWebhooks))
+
+	server.HandleJob(jobSweepIdempotency, JobHandlerFunc(func(ctx context.Context, _ *models.Job) error {
+		deleted, err := server.store(ctx).Idempotency().DeleteExpired(time.Now())
+		if err != nil {
+			return err
+		}
+		server.logger.Debug("Expired idempotency keys are deleted", "count", deleted)
+		return nil
+	}))
+
+	server.HandleJob(jobCleanup, JobHandlerFunc(func(ctx context.Context, _ *models.Job) error {
+		deleted, err := server.store(ctx).Jobs().DeleteFinished(time.Now().Add(-server.jobPolicy().retention))
+		if err != nil {
+			return err
+		}
+		server.logger.Debug("Finished jobs are deleted", "count", deleted)
+		return nil
+	}))
+
+	for _, job := range []string{jobSweepIdempotency, jobCleanup} {
+		if err := server.ScheduleJob(job, "@hourly", job, nil); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RunJobs runs the due jobs on a pool of workers, and enqueues the runs of the recurring jobs,
+// until the context is done. Then it waits for the running attempts, which are canceled
+// and released to the other servers
+func (server *Server) RunJobs(ctx context.Context) {
+	policy := server.jobPolicy()
+
+	server.jobs.mu.RLock()
+	schedules := slices.Clone(server.jobs.schedules)
+	server.jobs.mu.RUnlock()
+
+	now := time.Now().UTC()
+	next := make([]time.Time, len(schedules))
+	for i, schedule := range schedules {
+		next[i] = schedule.schedule.Next(now)
+	}
+
+	workers := make(chan struct{}, policy.workers) // one token per busy worker
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ticker := time.NewTicker(policy.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now().UTC()
+		for i, schedule := range schedules {
+			if !now.Before(next[i]) {
+				server.enqueueRun(ctx, schedule, next[i])
+				next[i] = schedule.schedule.Next(now)
+			}
+		}
+
+		for _, job := range server.claimJobs(ctx, policy, policy.workers-len(workers)) {
+			workers <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				server.runJob(ctx, policy, job)
+				<-workers
+				server.wakeJobs()
+			}()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-server.jobs.wake:
+		}
+	}
+}
+
+// enqueueRun enqueues the run of the recurring job due at the time, unless another server did
+func (server *Server) enqueueRun(ctx context.Context, schedule jobSchedule, at time.Time) {
+	job, err := models.NewJob(schedule.kind, schedule.payload, server.jobPolicy().maxAttempts)
+	if err == nil {
+		job.Key = schedule.name + "@" + strconv.FormatInt(at.Unix(), 10)
+		job.RunAt = at
+		_, err = server.store(ctx).Jobs().Enqueue(job)
+	}
+	if err != nil {
+		server.logger.Error("failed to enqueue recurring job", "name", schedule.name, "kind", schedule.kind, "error", err)
+	}
+}
+
+func (server *Server) claimJobs(ctx context.Context, policy jobPolicy, limit int) []*models.Job {
+	if limit <= 0 || ctx.Err() != nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	jobs, err := server.store(ctx).Jobs().Claim(now, now.Add(policy.lease), limit)
+	if err != nil {
+		server.logger.Error("failed to claim jobs", "error", err)
+		return nil
+	}
+	return jobs
+}
+
+// RunDueJobs runs the jobs due now one after another, also the ones they enqueue, and returns
+// how many it ran. Unlike RunJobs it doesn't enqueue the recurring jobs, it lets tests run the
+// jobs right away
+func (server *Server) RunDueJobs(ctx context.Context) int {
+	policy := server.jobPolicy()
+
+	ran := 0
+	for {
+		jobs := server.claimJobs(ctx, policy, policy.workers)
+		if len(jobs) == 0 {
+			return ran
+		}
+
+		for _, job := range jobs {
+			server.runJob(ctx, policy, job)
+			ran++
+		}
+	}
+}
+
+// runJob makes an attempt of the claimed job and stores its outcome
+func (server *Server) runJob(ctx context.Context, policy jobPolicy, job *models.Job) {
+	// the outcome is stored also when the server is stopping
+	store := server.store(context.WithoutCancel(ctx))
+	logger := server.logger.With("job", job.ID, "kind", job.Kind, "attempt", job.Attempts)
+
+	var err error
+	handler, ok := server.jobHandler(job.Kind)
+	switch {
+	case !ok:
+		// e.g. enqueued by a newer version of the server, which may run it meanwhile
+		err = fmt.Errorf("no handler for jobs of kind '%s'", job.Kind)
+	case job.Attempts > job.MaxAttempts:
+		err = errors.New("the lease of the job ended too many times")
+	default:
+		attemptCtx, cancel := context.WithTimeout(ctx, policy.lease)
+		err = runJobHandler(attemptCtx, handler, job)
+		cancel()
+	}
+
+	now := time.Now().UTC()
+	lease := *job.LockedUntil
+	job.LockedUntil = nil
+
+	switch {
+	case ctx.Err() != nil:
+		// stopped in the middle of the attempt, it doesn't count
+		job.Status = models.JobPending
+		job.Attempts--
+		job.RunAt = now
+	case err == nil:
+		job.Status = models.JobSucceeded
+		job.LastError = ""
+		job.FinishedAt = &now
+		server.metrics.jobs.WithLabelValues(job.Kind, "succeeded").Inc()
+	case job.Attempts < job.MaxAttempts:
+		job.Status = models.JobPending
+		job.LastError = err.Error()
+		job.RunAt = now.Add(policy.retryAfter(job.Attempts))
+		server.metrics.jobs.WithLabelValues(job.Kind, "retried").Inc()
+		logger.Warn("job attempt failed, retrying", "run_at", job.RunAt, "error", err)
+	default:
+		job.Status = models.JobDead
+		job.LastError = err.Error()
+		job.FinishedAt = &now
+		server.metrics.jobs.WithLabelValues(job.Kind, "dead").Inc()
+		logger.Error("job failed for good", "error", err)
+	}
+
+	if err := store.Jobs().Update(job, lease); err != nil {
+		if errors.Is(err, storage.ErrLeaseLost) {
+			// the attempt outlived its lease, the job is another attempt's now
+			logger.Warn("job lease was lost, the outcome of the attempt is dropped", "status", job.Status)
+			return
+		}
+		// the attempt is made again once the lease ends
+		logger.Error("failed to store job", "error", err)
+	}
+}
+
+// runJobHandler turns a panic of the handler into a failed attempt
+func runJobHandler(ctx context.Context, handler JobHandler, job *models.Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+
+	return handler.RunJob(ctx, job)
+}
+
+func (server *Server) handleAdminJobsList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		filter := models.JobFilter{
+			Kind:   query.Get("kind"),
+			Status: query.Get("status"),
+			Limit:  defaultJobPageSize,
+		}
+
+		if filter.Status != "" && !slices.Contains(models.JobStatuses, filter.Status) {
+			server.error(w, r, http.StatusBadRequest, fmt.Errorf("unknown job status '%s'", filter.Status))
+			return
+		}
+
+		if v := query.Get("limit"); v != "" {
+			var err error
+			if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 || filter.Limit > maxJobPageSize {
+				server.error(w, r, http.StatusBadRequest, fmt.Errorf("'limit' must be between 1 and %d", maxJobPageSize))
+				return
+			}
+		}
+
+		jobs, err := server.store(r.Context()).Jobs().Find(filter)
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		server.respond(w, r, http.StatusOK, jobs)
+	}
+}
+
+// findJob answers 404 when the job of the route doesn't exist
+func (server *Server) findJob(w http.ResponseWriter, r *http.Request) (*models.Job, bool) {
+	id := mux.Vars(r)["id"]
+	job, err := server.store(r.Context()).Jobs().FindByID(id)
+	if err != nil {
+		server.error(w, r, http.StatusNotFound, fmt.Errorf("job '%s' not found", id))
+		return nil, false
+	}
+	return job, true
+}
+
+func (server *Server) handleAdminJobsGet() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, ok := server.findJob(w, r)
+		if !ok {
+			return
+		}
+
+		server.respond(w, r, http.StatusOK, job)
+	}
+}
+
+// handleAdminJobsRetry gives a dead job all its attempts again
+func (server *Server) handleAdminJobsRetry() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin := r.Context().Value(userContextKey).(*models.User)
+
+		job, ok := server.findJob(w, r)
+		if !ok {
+			return
+		}
+
+		if job.Status != models.JobDead {
+			server.error(w, r, http.StatusConflict, fmt.Errorf("job is %s, only dead jobs are retried", job.Status))
+			return
+		}
+
+		// the job may have been retried meanwhile
+		job, err := server.store(r.Context()).Jobs().Retry(job.ID, time.Now().UTC())
+		if errors.Is(err, storage.ErrNotFound) {
+			server.error(w, r, http.StatusConflict, errors.New("job isn't dead anymore, only dead jobs are retried"))
+			return
+		}
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		server.wakeJobs()
+
+		server.audit(r, models.AuditJobRetried, admin.Login, job.ID, map[string]any{"kind": job.Kind})
+
+		server.respond(w, r, http.StatusAccepted, job)
+	}
+}
@@ -0,0 +1,300 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newJobServer retries the failed jobs quickly
+func newJobServer(t *testing.T) *server.Server {
+	t.Helper()
+
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.Jobs.Workers = 2
+	cfg.Jobs.MaxAttempts = 3
+	cfg.Jobs.Backoff = time.Millisecond
+	cfg.Jobs.MaxBackoff = 2 * time.Millisecond
+	cfg.Jobs.PollInterval = 10 * time.Millisecond
+	s, err := server.NewInMemoryServer(cfg)
+	require.NoError(t, err)
+
+	return s
+}
+
+func findJob(t *testing.T, s *server.Server, id string) *models.Job {
+	t.Helper()
+
+	job, err := s.Storage().Jobs().FindByID(id)
+	require.NoError(t, err)
+	return job
+}
+
+func TestJobs_RetriesAndDeadLetter(t *testing.T) {
+	s := newJobServer(t)
+	ctx := context.Background()
+
+	var flakyRuns atomic.Int32
+	s.HandleJob("test.flaky", server.JobHandlerFunc(func(ctx context.Context, job *models.Job) error {
+		if flakyRuns.Add(1) < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	}))
+	s.HandleJob("test.broken", server.JobHandlerFunc(func(ctx context.Context, job *models.Job) error {
+		var payload struct {
+			Reason string `json:"reason"`
+		}
+		if err := job.Decode(&payload); err != nil {
+			return err
+		}
+		return errors.New(payload.Reason)
+	}))
+	s.HandleJob("test.panicking", server.JobHandlerFunc(func(ctx context.Context, job *models.Job) error {
+		panic("boom")
+	}))
+
+	flaky, err := s.Enqueue(s.Storage(), "test.flaky", nil)
+	require.NoError(t, err)
+	broken, err := s.Enqueue(s.Storage(), "test.broken", map[string]string{"reason": "broken for good"})
+	require.NoError(t, err)
+	panicking, err := s.Enqueue(s.Storage(), "test.panicking", nil)
+	require.NoError(t, err)
+	unknown, err := s.Enqueue(s.Storage(), "test.unknown", nil)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		s.RunDueJobs(ctx)
+		for _, id := range []string{flaky.ID, broken.ID, panicking.ID, unknown.ID} {
+			if !findJob(t, s, id).IsFinished() {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 5*time.Millisecond)
+
+	job := findJob(t, s, flaky.ID)
+	assert.Equal(t, models.JobSucceeded, job.Status)
+	assert.Equal(t, 3, job.Attempts)
+	assert.Empty(t, job.LastError)
+
+	job = findJob(t, s, broken.ID)
+	assert.Equal(t, models.JobDead, job.Status)
+	assert.Equal(t, 3, job.Attempts)
+	assert.Equal(t, "broken for good", job.LastError)
+	assert.NotNil(t, job.FinishedAt)
+
+	assert.Equal(t, models.JobDead, findJob(t, s, panicking.ID).Status)
+	assert.Contains(t, findJob(t, s, panicking.ID).LastError, "boom")
+	assert.Contains(t, findJob(t, s, unknown.ID).LastError, "no handler")
+
+	// dead jobs are listed and retried by the admins
+	root := "Bearer " + registerUser(t, s, "root")
+	promote(t, s, "root")
+
+	rec := doRequest(s, http.MethodGet, "/admin/jobs?status=dead", root, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var dead []models.Job
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&dead))
+	assert.Len(t, dead, 3)
+
+	rec = doRequest(s, http.MethodGet, "/admin/jobs?status=lost", root, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/admin/jobs/"+broken.ID+"/retry", root, nil)
+	require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	var retried models.Job
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&retried))
+	assert.Equal(t, models.JobPending, retried.Status)
+	assert.Zero(t, retried.Attempts)
+
+	rec = doRequest(s, http.MethodPost, "/admin/jobs/"+broken.ID+"/retry", root, nil)
+	assert.Equal(t, http.StatusConflict, rec.Code, "only dead jobs are retried")
+
+	rec = doRequest(s, http.MethodPost, "/admin/jobs/"+flaky.ID+"/retry", root, nil)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = doRequest(s, http.MethodGet, "/admin/jobs/nope", root, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = doRequest(s, http.MethodGet, "/admin/audit?action="+models.AuditJobRetried, root, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var page auditPage
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&page))
+	require.Len(t, page.Events, 1)
+	assert.Equal(t, broken.ID, page.Events[0].Target)
+
+	require.Eventually(t, func() bool {
+		s.RunDueJobs(ctx)
+		return findJob(t, s, broken.ID).IsFinished()
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, 3, findJob(t, s, broken.ID).Attempts)
+}
+
+func TestJobs_ConcurrentRetries(t *testing.T) {
+	s := newJobServer(t)
+	ctx := context.Background()
+
+	s.HandleJob("test.broken", server.JobHandlerFunc(func(context.Context, *models.Job) error {
+		return errors.New("broken")
+	}))
+	broken, err := s.Enqueue(s.Storage(), "test.broken", nil)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		s.RunDueJobs(ctx)
+		return findJob(t, s, broken.ID).IsFinished()
+	}, 5*time.Second, 5*time.Millisecond)
+
+	root := "Bearer " + registerUser(t, s, "root")
+	promote(t, s, "root")
+
+	var accepted atomic.Int32
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := doRequest(s, http.MethodPost, "/admin/jobs/"+broken.ID+"/retry", root, nil)
+			if rec.Code == http.StatusAccepted {
+				accepted.Add(1)
+			} else {
+				assert.Equal(t, http.StatusConflict, rec.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), accepted.Load(), "a dead job is retried once")
+}
+
+func TestJobs_LostLease(t *testing.T) {
+	s := newJobServer(t)
+	ctx := context.Background()
+
+	// another server claims the job once its lease ends, in the middle of the attempt
+	var reclaimed []*models.Job
+	s.HandleJob("test.slow", server.JobHandlerFunc(func(_ context.Context, job *models.Job) error {
+		later := job.LockedUntil.Add(time.Second)
+		var err error
+		reclaimed, err = s.Storage().Jobs().Claim(later, later.Add(time.Minute), 1)
+		if err != nil {
+			return err
+		}
+		return errors.New("too slow")
+	}))
+	slow, err := s.Enqueue(s.Storage(), "test.slow", nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, s.RunDueJobs(ctx))
+	require.Len(t, reclaimed, 1)
+
+	job := findJob(t, s, slow.ID)
+	assert.Equal(t, models.JobRunning, job.Status, "the outcome of the late attempt is dropped")
+	assert.Equal(t, 2, job.Attempts)
+	assert.Empty(t, job.LastError)
+	if assert.NotNil(t, job.LockedUntil) {
+		assert.True(t, reclaimed[0].LockedUntil.Equal(*job.LockedUntil))
+	}
+}
+
+func TestJobs_Delayed(t *testing.T) {
+	s := newJobServer(t)
+	ctx := context.Background()
+
+	var runs atomic.Int32
+	s.HandleJob("test.delayed", server.JobHandlerFunc(func(context.Context, *models.Job) error {
+		runs.Add(1)
+		return nil
+	}))
+
+	job, err := s.EnqueueAt(s.Storage(), "test.delayed", nil, time.Now().Add(50*time.Millisecond))
+	require.NoError(t, err)
+
+	assert.Zero(t, s.RunDueJobs(ctx), "the job isn't due yet")
+
+	require.Eventually(t, func() bool { return s.RunDueJobs(ctx) == 1 }, 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(1), runs.Load())
+	assert.Equal(t, models.JobSucceeded, findJob(t, s, job.ID).Status)
+}
+
+func TestJobs_Outbox(t *testing.T) {
+	s := newTestServer(t)
+
+	published := func() []*models.Job {
+		jobs, err := s.Storage().Jobs().Find(models.JobFilter{Kind: "webhooks.publish"})
+		require.NoError(t, err)
+		return jobs
+	}
+
+	registerUser(t, s, "alice")
+	require.Len(t, published(), 1, "the registration enqueues the publication of its event")
+
+	// case : the user isn't stored, neither is the event
+	rec := doRequest(s, http.MethodPost, "/users", "", map[string]string{
+		"login":    "alice",
+		"username": "alice",
+		"email":    "alice@example.org",
+		"password": "password",
+	})
+	require.NotEqual(t, http.StatusCreated, rec.Code)
+	assert.Len(t, published(), 1)
+}
+
+func TestJobs_RunJobs(t *testing.T) {
+	s := newJobServer(t)
+
+	require.Error(t, s.ScheduleJob("broken", "every minute", "test.tick", nil))
+
+	var ticks atomic.Int32
+	s.HandleJob("test.tick", server.JobHandlerFunc(func(context.Context, *models.Job) error {
+		ticks.Add(1)
+		return nil
+	}))
+	require.NoError(t, s.ScheduleJob("tick", "@every 1s", "test.tick", nil))
+
+	started := make(chan struct{})
+	s.HandleJob("test.blocking", server.JobHandlerFunc(func(ctx context.Context, _ *models.Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.RunJobs(ctx)
+		close(done)
+	}()
+
+	blocking, err := s.Enqueue(s.Storage(), "test.blocking", nil)
+	require.NoError(t, err)
+	<-started
+
+	// the other worker runs the recurring job meanwhile
+	require.Eventually(t, func() bool { return ticks.Load() >= 1 }, 5*time.Second, 10*time.Millisecond)
+
+	jobs, err := s.Storage().Jobs().Find(models.JobFilter{Kind: "test.tick"})
+	require.NoError(t, err)
+	require.NotEmpty(t, jobs)
+	assert.True(t, strings.HasPrefix(jobs[0].Key, "tick@"), jobs[0].Key)
+
+	// stopping releases the running job to the other servers
+	cancel()
+	<-done
+
+	job := findJob(t, s, blocking.ID)
+	assert.Equal(t, models.JobPending, job.Status)
+	assert.Zero(t, job.Attempts)
+	assert.Nil(t, job.LockedUntil)
+}
@@ -1,24 +1,69 @@
 package server
 
 import (
+	"crypto/rand"
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
+	"vox-server/internal/models"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+const (
+	AccessTokenTTL       = 24 * time.Hour
+	ClientAccessTokenTTL = time.Hour // tokens issued to OAuth clients live shorter
+	RefreshTokenTTL      = 7 * 24 * time.Hour
+
+	tokenTypeAccess  = "access"
+	tokenTypeRefresh = "refresh"
 )
 
 type Claims struct {
-	LoginOrEmail string `json:"login_or_email"`
+	LoginOrEmail string `json:"login_or_email,omitempty"`
+	SessionID    string `json:"sid"`
+	ClientID     string `json:"client_id,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	TokenType    string `json:"token_type"`
 
 	jwt.StandardClaims
 }
 
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HS256 keys shorter than the hash are weak (RFC 7518 3.2)
+const minJWTSecretLength = 32
+
 var jwtKey []byte
 
+var ephemeralJWTKey struct {
+	once sync.Once
+	key  string
+}
+
 func SetJWTKey(key string) {
 	jwtKey = []byte(key)
 }
 
+// jwtSecret is the configured secret or, without one, a secret generated once per process
+func jwtSecret(config *Config) string {
+	if config.JWT.Secret != "" {
+		return config.JWT.Secret
+	}
+
+	ephemeralJWTKey.once.Do(func() {
+		b := make([]byte, minJWTSecretLength)
+		rand.Read(b)
+		ephemeralJWTKey.key = string(b)
+	})
+	return ephemeralJWTKey.key
+}
+
 func GetJWTKey() []byte {
 	return []byte(jwtKey)
 }
@@ -27,6 +72,9 @@ func ValidateToken(tokenString string) (*Claims, error) {
 	secretKey := GetJWTKey()
 
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
 		return secretKey, nil
 	})
 	if err != nil {
@@ -40,20 +88,48 @@ func ValidateToken(tokenString string) (*Claims, error) {
 	return nil, errors.New("invalid token")
 }
 
-func GenerateToken(loginOrEmail string) (string, string, error) {
-	tokenExpiry := time.Now().Add(24 * time.Hour).Unix()
-	refreshTokenExpiry := time.Now().Add(7 * 24 * time.Hour).Unix()
+// accessTokenExpiry never outlives the session the token belongs to
+func accessTokenExpiry(session *models.Session, now time.Time) time.Time {
+	ttl := AccessTokenTTL
+	if session.IsRestricted() {
+		ttl = ClientAccessTokenTTL
+	}
+
+	expiry := now.Add(ttl)
+	if session.ExpiresAt.Before(expiry) {
+		return session.ExpiresAt
+	}
+	return expiry
+}
+
+// GenerateToken issues an access and a refresh token bound to the session
+func GenerateToken(loginOrEmail string, session *models.Session) (string, string, error) {
+	now := time.Now()
 
 	claims := &Claims{
 		LoginOrEmail: loginOrEmail,
+		SessionID:    session.ID,
+		ClientID:     session.ClientID,
+		Scope:        strings.Join(session.Scopes, " "),
+		TokenType:    tokenTypeAccess,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: tokenExpiry,
+			Id:        uuid.New().String(),
+			Subject:   session.UserLogin,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: accessTokenExpiry(session, now).Unix(),
 		},
 	}
 
 	refreshClaims := &Claims{
+		SessionID: session.ID,
+		ClientID:  session.ClientID,
+		Scope:     strings.Join(session.Scopes, " "),
+		TokenType: tokenTypeRefresh,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: refreshTokenExpiry,
+			Id:        uuid.New().String(),
+			Subject:   session.UserLogin,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: session.ExpiresAt.Unix(),
 		},
 	}
 
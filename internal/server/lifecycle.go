@@ -0,0 +1,306 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const defaultShutdownTimeout = 20 * time.Second
+
+// component is closed when the server stops, after the in-flight requests are drained
+type component struct {
+	name  string
+	close func(context.Context) error
+}
+
+type lifecycle struct {
+	mu         sync.Mutex
+	components []component
+
+	// closed when the shutdown begins
+	stopping chan struct{}
+	stopOnce sync.Once
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{stopping: make(chan struct{})}
+}
+
+// OnShutdown registers a component to close when the server stops.
+// Components are closed in reverse order of registration, so a component
+// can rely on the ones registered before it, e.g. the audit log on the database
+func (server *Server) OnShutdown(name string, close func(context.Context) error) {
+	server.lifecycle.mu.Lock()
+	defer server.lifecycle.mu.Unlock()
+
+	server.lifecycle.components = append(server.lifecycle.components, component{name: name, close: close})
+}
+
+// Stopping is closed when the shutdown begins. Long-lived handlers (e.g. streams)
+// return on it, otherwise the drain waits for them until its deadline
+func (server *Server) Stopping() <-chan struct{} {
+	return server.lifecycle.stopping
+}
+
+func (server *Server) shutdownTimeout() time.Duration {
+	if server.config.HTTP.ShutdownTimeout > 0 {
+		return server.config.HTTP.ShutdownTimeout
+	}
+	return defaultShutdownTimeout
+}
+
+func (server *Server) newHTTPServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: server.config.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       server.config.HTTP.ReadTimeout,
+		WriteTimeout:      server.config.HTTP.WriteTimeout,
+		IdleTimeout:       server.config.HTTP.IdleTimeout,
+		MaxHeaderBytes:    int(server.config.HTTP.MaxHeaderBytes),
+		ErrorLog:          slog.NewLogLogger(server.logger.Handler(), slog.LevelWarn),
+	}
+}
+
+// RunServer serves until SIGINT or SIGTERM, then shuts down gracefully.
+// A second signal kills the process. SIGHUP reloads the config
+func (server *Server) RunServer() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go server.WatchConfig(ctx, hup)
+
+	return server.Run(ctx)
+}
+
+// Run listens on the configured address and serves until the context is done
+func (server *Server) Run(ctx context.Context) error {
+	l, err := net.Listen("tcp", server.config.Port)
+	if err != nil {
+		return fmt.Errorf("failed to listen on '%s': %w", server.config.Port, err)
+	}
+
+	return server.Serve(ctx, l)
+}
+
+// Serve serves the API on the listener, the admin endpoints on the configured admin
+// address and the gRPC API on the configured gRPC address, over TLS and HTTP/2 when
+// configured, until the context is done or a listener fails. Then it reports not-ready
+// for the shutdown delay, stops accepting connections, waits for the in-flight requests
+// and calls until the shutdown timeout, stops the background workers and waits for them,
+// closes the registered components and finally the admin listener
+func (server *Server) Serve(ctx context.Context, l net.Listener) error {
+	tlsConfig, certs, err := server.newTLSConfig()
+	if err != nil {
+		l.Close()
+		return err
+	}
+
+	adminTLSConfig, err := server.newAdminTLSConfig(tlsConfig)
+	if err != nil {
+		l.Close()
+		return err
+	}
+
+	api := server.newHTTPServer(server)
+	api.TLSConfig = tlsConfig
+
+	var admin *http.Server
+	var adminListener net.Listener
+	if server.config.Admin.Address != "" {
+		if adminListener, err = net.Listen("tcp", server.config.Admin.Address); err != nil {
+			l.Close()
+			return fmt.Errorf("failed to listen on admin address '%s': %w", server.config.Admin.Address, err)
+		}
+		admin = server.newHTTPServer(server.AdminHandler())
+		admin.TLSConfig = adminTLSConfig
+	}
+
+	var rpc *grpc.Server
+	var rpcListener net.Listener
+	if server.config.GRPC.Address != "" {
+		if rpcListener, err = net.Listen("tcp", server.config.GRPC.Address); err != nil {
+			l.Close()
+			if adminListener != nil {
+				adminListener.Close()
+			}
+			return fmt.Errorf("failed to listen on grpc address '%s': %w", server.config.GRPC.Address, err)
+		}
+
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		rpc = server.NewGRPCServer(opts...)
+	}
+
+	if certs != nil {
+		watchCtx, stopWatching := context.WithCancel(ctx)
+		defer stopWatching()
+		go server.watchCertificate(watchCtx, certs)
+	}
+
+	// the background workers stop with the listeners, before the components they use are closed
+	workCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+	var workers sync.WaitGroup
+	workers.Add(2)
+	go func() {
+		defer workers.Done()
+		server.RunJobs(workCtx)
+	}()
+	go func() {
+		defer workers.Done()
+		server.DeliverWebhooks(workCtx)
+	}()
+
+	failed := make(chan error, 3)
+	go func() {
+		server.logger.Info("Server is started", "address", l.Addr().String(), "tls", tlsConfig != nil)
+		failed <- serveHTTP(api, l)
+	}()
+	if admin != nil {
+		go func() {
+			server.logger.Info("Admin server is started", "address", adminListener.Addr().String(), "tls", tlsConfig != nil)
+			failed <- serveHTTP(admin, adminListener)
+		}()
+	}
+	if rpc != nil {
+		go func() {
+			server.logger.Info("gRPC server is started", "address", rpcListener.Addr().String(), "tls", tlsConfig != nil)
+			failed <- rpc.Serve(rpcListener)
+		}()
+	}
+
+	var errs []error
+	select {
+	case <-ctx.Done():
+		server.logger.Info("Server is shutting down", "timeout", server.shutdownTimeout())
+	case err := <-failed:
+		server.logger.Error("Server failed, shutting down", "error", err)
+		errs = append(errs, err)
+	}
+
+	server.lifecycle.stopOnce.Do(func() { close(server.lifecycle.stopping) })
+
+	if delay := server.config.HTTP.ShutdownDelay; delay > 0 {
+		// /readyz reports not-ready meanwhile
+		server.logger.Info("Server is not ready anymore, waiting before draining", "delay", delay)
+		time.Sleep(delay)
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), server.shutdownTimeout())
+	defer cancel()
+
+	if err := api.Shutdown(drainCtx); err != nil {
+		// the deadline passed, cut the remaining requests off
+		api.Close()
+		errs = append(errs, fmt.Errorf("failed to drain requests: %w", err))
+	}
+	if rpc != nil {
+		if err := stopGRPC(drainCtx, rpc); err != nil {
+			errs = append(errs, fmt.Errorf("failed to drain calls: %w", err))
+		}
+	}
+
+	// the running jobs and deliveries are canceled, their outcomes stored before the database is closed
+	stopWorkers()
+	workers.Wait()
+
+	closeCtx, cancel := context.WithTimeout(context.Background(), server.shutdownTimeout())
+	defer cancel()
+
+	errs = append(errs, server.Close(closeCtx))
+
+	// the admin listener stays up to the end, so the shutdown can be observed
+	if admin != nil {
+		if err := admin.Shutdown(closeCtx); err != nil {
+			admin.Close()
+			errs = append(errs, fmt.Errorf("failed to stop admin server: %w", err))
+		}
+	}
+
+	server.logger.Info("Server is stopped")
+	return errors.Join(errs...)
+}
+
+// serveHTTP serves TLS, and HTTP/2 with it, when the server has a TLS config
+func serveHTTP(srv *http.Server, l net.Listener) error {
+	if srv.TLSConfig != nil {
+		// the certificates come from the config
+		return srv.ServeTLS(l, "", "")
+	}
+	return srv.Serve(l)
+}
+
+// stopGRPC waits for the in-flight calls until the context is done, then cuts them off
+func stopGRPC(ctx context.Context, srv *grpc.Server) error {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		srv.Stop()
+		<-stopped
+		return ctx.Err()
+	}
+}
+
+// Close closes the registered components in reverse order of registration,
+// then flushes the spans. Serve calls it, servers that don't serve close themselves
+func (server *Server) Close(ctx context.Context) error {
+	server.lifecycle.closeOnce.Do(func() {
+		server.lifecycle.mu.Lock()
+		components := slices.Clone(server.lifecycle.components)
+		server.lifecycle.mu.Unlock()
+
+		var errs []error
+		for _, c := range slices.Backward(components) {
+			if err := c.close(ctx); err != nil {
+				server.logger.Error("failed to close component", "component", c.name, "error", err)
+				errs = append(errs, fmt.Errorf("failed to close %s: %w", c.name, err))
+				continue
+			}
+			server.logger.Debug("Component is closed", "component", c.name)
+		}
+
+		// last, so that the spans of the shutdown are exported too
+		if server.shutdownTracing != nil {
+			if err := server.shutdownTracing(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("failed to flush spans: %w", err))
+			}
+		}
+
+		server.lifecycle.closeErr = errors.Join(errs...)
+	})
+
+	return server.lifecycle.closeErr
+}
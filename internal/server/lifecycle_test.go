@@ -0,0 +1,211 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSlowIssuer is an oidc provider whose discovery blocks until it is released,
+// so that the login of the provider stays in flight
+func newSlowIssuer(t *testing.T) (issuer *httptest.Server, received <-chan struct{}, release func()) {
+	t.Helper()
+
+	requests := make(chan struct{}, 1)
+	done := make(chan struct{})
+
+	issuer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		<-done
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(issuer.Close)
+
+	var once sync.Once
+	release = func() { once.Do(func() { close(done) }) }
+	t.Cleanup(release) // runs before issuer.Close
+
+	return issuer, requests, release
+}
+
+func newServingServer(t *testing.T, cfg *server.Config) (s *server.Server, url string, stop func() error) {
+	t.Helper()
+
+	s, err := server.NewInMemoryServer(cfg)
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ctx, l) }()
+
+	stop = func() error {
+		cancel()
+		select {
+		case err := <-served:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("server did not stop")
+			return nil
+		}
+	}
+
+	scheme := "http"
+	if cfg.TLS.CertFile != "" || cfg.TLS.SelfSigned {
+		scheme = "https"
+	}
+	return s, scheme + "://" + l.Addr().String(), stop
+}
+
+func slowConfig(issuer string, shutdownTimeout time.Duration) *server.Config {
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.HTTP.ShutdownTimeout = shutdownTimeout
+	cfg.OIDCProviders = []server.OIDCProvider{{Name: "slow", Issuer: issuer, ClientID: "vox"}}
+	return cfg
+}
+
+func TestServer_ShutdownDrainsRequests(t *testing.T) {
+	issuer, received, release := newSlowIssuer(t)
+	s, url, stop := newServingServer(t, slowConfig(issuer.URL, 5*time.Second))
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get(url + "/auth/oidc/slow/login")
+		if err != nil {
+			responses <- nil
+			return
+		}
+		resp.Body.Close()
+		responses <- resp
+	}()
+	<-received
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- stop() }()
+
+	select {
+	case <-s.Stopping():
+	case <-time.After(time.Second):
+		t.Fatal("shutdown did not begin")
+	}
+
+	// new connections are refused while the request is in flight
+	assert.Eventually(t, func() bool {
+		_, err := http.Get(url + "/login")
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+
+	release()
+
+	resp := <-responses
+	require.NotNil(t, resp, "the in-flight request should complete")
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.NoError(t, <-stopped)
+}
+
+func TestServer_ShutdownDeadline(t *testing.T) {
+	issuer, received, _ := newSlowIssuer(t)
+	_, url, stop := newServingServer(t, slowConfig(issuer.URL, 100*time.Millisecond))
+
+	failed := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(url + "/auth/oidc/slow/login")
+		if err == nil {
+			resp.Body.Close()
+		}
+		failed <- err
+	}()
+	<-received
+
+	start := time.Now()
+	err := stop()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	assert.Error(t, <-failed, "the request should be cut off")
+}
+
+func TestServer_ShutdownClosesComponents(t *testing.T) {
+	s, url, stop := newServingServer(t, &server.Config{Env: server.EnvLocal})
+
+	var closed []string
+	s.OnShutdown("first", func(context.Context) error {
+		closed = append(closed, "first")
+		return nil
+	})
+	s.OnShutdown("second", func(context.Context) error {
+		closed = append(closed, "second")
+		return errors.New("broken")
+	})
+
+	resp, err := http.Post(url+"/users", "application/json", strings.NewReader(
+		`{"login":"user","username":"user","email":"user@example.org","password":"password"}`,
+	))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	assert.ErrorContains(t, stop(), "failed to close second: broken")
+	assert.Equal(t, []string{"second", "first"}, closed, "components are closed in reverse order")
+
+	// the audit log stored its queue on close
+	events, err := s.Storage().Audit().Find(models.AuditFilter{Action: models.AuditUserRegistered})
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+
+	assert.ErrorContains(t, s.Close(context.Background()), "broken", "closing twice returns the first result")
+	assert.Equal(t, []string{"second", "first"}, closed)
+}
+
+func TestServer_ShutdownStopsWorkers(t *testing.T) {
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.Jobs.PollInterval = 10 * time.Millisecond
+	s, _, stop := newServingServer(t, cfg)
+
+	started := make(chan struct{})
+	var returned atomic.Bool
+	s.HandleJob("test.blocking", server.JobHandlerFunc(func(ctx context.Context, _ *models.Job) error {
+		close(started)
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+		returned.Store(true)
+		return ctx.Err()
+	}))
+
+	var workersStopped bool
+	s.OnShutdown("database", func(context.Context) error {
+		workersStopped = returned.Load()
+		return nil
+	})
+
+	job, err := s.Enqueue(s.Storage(), "test.blocking", nil)
+	require.NoError(t, err)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not start")
+	}
+
+	require.NoError(t, stop())
+	assert.True(t, workersStopped, "the workers stop before the components are closed")
+
+	// the running attempt released the job before the server stopped
+	stored, err := s.Storage().Jobs().FindByID(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobPending, stored.Status)
+	assert.Nil(t, stored.LockedUntil)
+}
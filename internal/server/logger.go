@@ -1,22 +1,229 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
-	"os"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
 )
 
-func SetupLogger(env string) (*slog.Logger, error) {
-	var logger *slog.Logger
+const redacted = "[REDACTED]"
+
+// keys whose values never reach the logs, keys ending with
+// "password", "secret" or "_token" are redacted too
+var sensitiveLogKeys = []string{
+	"authorization",
+	"cookie",
+	"set-cookie",
+	"token",
+	"api_key",
+	"code",
+	"code_verifier",
+}
+
+func isSensitiveLogKey(key string) bool {
+	key = strings.ToLower(key)
+	if slices.Contains(sensitiveLogKeys, key) {
+		return true
+	}
+
+	return strings.HasSuffix(key, "password") ||
+		strings.HasSuffix(key, "secret") ||
+		strings.HasSuffix(key, "_token")
+}
+
+func redactAttr(_ []string, attr slog.Attr) slog.Attr {
+	if attr.Value.Kind() != slog.KindGroup && isSensitiveLogKey(attr.Key) {
+		return slog.String(attr.Key, redacted)
+	}
+	return attr
+}
+
+// defaultLogLevel is used when the config sets no level
+func defaultLogLevel(env string) (slog.Level, error) {
 	switch env {
-	case EnvLocal:
-		logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
-	case EnvDev:
-		logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	case EnvLocal, EnvDev:
+		return slog.LevelDebug, nil
 	case EnvProd:
-		logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+		return slog.LevelInfo, nil
 	default:
-		return nil, errors.New("invalid env variable")
+		return 0, errors.New("invalid env variable")
+	}
+}
+
+// SetupLogger builds a text or json logger writing to w.
+// The level is read from the variable, so it can be changed at runtime
+func SetupLogger(w io.Writer, env, format string, level *slog.LevelVar) (*slog.Logger, error) {
+	if _, err := defaultLogLevel(env); err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+
+	switch format {
+	case LogFormatText, "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format '%s'", format)
+	}
+}
+
+// newLogLevel parses the configured level, falling back to the default of the environment
+func newLogLevel(env, name string) (*slog.LevelVar, error) {
+	level := &slog.LevelVar{}
+
+	if name == "" {
+		l, err := defaultLogLevel(env)
+		if err != nil {
+			return nil, err
+		}
+		level.Set(l)
+		return level, nil
+	}
+
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return nil, fmt.Errorf("invalid log level: %w", err)
+	}
+
+	return level, nil
+}
+
+// requestLog is shared by the middlewares of a request,
+// so that authentification can add the user to the request logger
+type requestLog struct {
+	logger *slog.Logger
+}
+
+// requestLogger returns the logger of the request, it carries the request id,
+// the route template and the authentificated user
+func (server *Server) requestLogger(r *http.Request) *slog.Logger {
+	if entry, ok := r.Context().Value(loggerContextKey).(*requestLog); ok {
+		return entry.logger
+	}
+	return server.logger
+}
+
+func setRequestLogUser(r *http.Request, login string) {
+	if entry, ok := r.Context().Value(loggerContextKey).(*requestLog); ok {
+		entry.logger = entry.logger.With("user", login)
+	}
+}
+
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
+
+// logSampler logs one of every n requests of noisy routes
+type logSampler struct {
+	rates    map[string]uint64 // route template -> n
+	counters map[string]*atomic.Uint64
+}
+
+func newLogSampler(rates map[string]int) (*logSampler, error) {
+	sampler := &logSampler{
+		rates:    make(map[string]uint64),
+		counters: make(map[string]*atomic.Uint64),
+	}
+
+	for route, n := range rates {
+		if n < 1 {
+			return nil, fmt.Errorf("invalid log sampling of '%s': must be at least 1", route)
+		}
+		sampler.rates[route] = uint64(n)
+		sampler.counters[route] = &atomic.Uint64{}
+	}
+
+	return sampler, nil
+}
+
+func (sampler *logSampler) sample(route string, code int) bool {
+	n, ok := sampler.rates[route]
+	if !ok || n == 1 || code >= http.StatusInternalServerError {
+		return true
+	}
+
+	return (sampler.counters[route].Add(1)-1)%n == 0
+}
+
+func (server *Server) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := routeTemplate(r)
+
+		entry := &requestLog{
+			logger: server.logger.With(
+				"request_id", r.Context().Value(requestIDContextKey),
+				"route", route,
+			),
+		}
+
+		rw := &responseWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), loggerContextKey, entry)))
+
+		if !server.logSampler.Load().sample(route, rw.code) {
+			return
+		}
+
+		level := slog.LevelInfo
+		if rw.code >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		entry.logger.LogAttrs(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rw.code),
+			slog.Int("bytes", rw.size),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("user_agent", r.UserAgent()),
+			slog.Duration("took", time.Since(start)),
+		)
+	})
+}
+
+func (server *Server) handleAdminLogLevel() http.HandlerFunc {
+	type request struct {
+		Level string `json:"level"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			req := &request{}
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				server.error(w, r, http.StatusBadRequest, err)
+				return
+			}
+
+			if err := server.logLevel.UnmarshalText([]byte(req.Level)); err != nil {
+				server.error(w, r, http.StatusBadRequest, fmt.Errorf("invalid log level: %w", err))
+				return
+			}
+
+			server.requestLogger(r).Info("log level changed", "level", server.logLevel.Level().String())
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		server.respond(w, r, http.StatusOK, map[string]string{"level": server.logLevel.Level().String()})
 	}
-	return logger, nil
 }
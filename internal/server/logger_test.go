@@ -0,0 +1,76 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"vox-server/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	level := &slog.LevelVar{}
+
+	logger, err := server.SetupLogger(buf, server.EnvProd, server.LogFormatJSON, level)
+	require.NoError(t, err)
+
+	logger.Info("login",
+		"login", "alice",
+		"password", "hunter22",
+		"new_password", "hunter23",
+		"client_secret", "s3cret",
+		"refresh_token", "eyJ...",
+		"Authorization", "Bearer eyJ...",
+		slog.Group("form", "code", "abc", "state", "xyz"),
+		"error_code", "internal_error",
+		"grpc_code", "Internal",
+	)
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "alice", entry["login"])
+	assert.Equal(t, "xyz", entry["form"].(map[string]any)["state"])
+	assert.Equal(t, "internal_error", entry["error_code"], "the codes of the problems aren't redacted")
+	assert.Equal(t, "Internal", entry["grpc_code"])
+	for _, secret := range []string{"hunter22", "hunter23", "s3cret", "eyJ", "abc"} {
+		assert.NotContains(t, buf.String(), secret)
+	}
+
+	// case : the level is changed at runtime
+	buf.Reset()
+	logger.Debug("hidden")
+	assert.Empty(t, buf.String())
+	level.Set(slog.LevelDebug)
+	logger.Debug("shown")
+	assert.Contains(t, buf.String(), "shown")
+
+	_, err = server.SetupLogger(buf, server.EnvProd, "xml", level)
+	assert.Error(t, err)
+	_, err = server.SetupLogger(buf, "staging", server.LogFormatText, level)
+	assert.Error(t, err)
+}
+
+func TestAdmin_LogLevel(t *testing.T) {
+	s := newTestServer(t)
+
+	admin := "Bearer " + registerUser(t, s, "alice")
+	promote(t, s, "alice")
+
+	rec := doRequest(s, http.MethodGet, "/admin/log/level", admin, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"level":"DEBUG"}`, rec.Body.String())
+
+	rec = doRequest(s, http.MethodPut, "/admin/log/level", admin, map[string]string{"level": "warn"})
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"level":"WARN"}`, rec.Body.String())
+
+	rec = doRequest(s, http.MethodPut, "/admin/log/level", admin, map[string]string{"level": "loud"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.True(t, strings.Contains(rec.Body.String(), "invalid log level"))
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "vox"
+
+// metrics of a server live in its own registry, so that servers don't share them (e.g. in tests)
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	logins          *prometheus.CounterVec
+	authFailures    *prometheus.CounterVec
+	introspections  *prometheus.CounterVec
+	userLookups     *prometheus.CounterVec
+	webhooks        *prometheus.CounterVec
+	jobs            *prometheus.CounterVec
+
+	configReloads       *prometheus.CounterVec
+	configReloadSuccess prometheus.Gauge
+	configPendingKeys   prometheus.Gauge
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Handled HTTP requests by route template and status code.",
+		}, []string{"method", "route", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of HTTP requests by route template.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "auth",
+			Name:      "logins_total",
+			Help:      "Interactive logins by method (password, oidc) and result (success, failure).",
+		}, []string{"method", "result"}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "auth",
+			Name:      "failures_total",
+			Help:      "Rejected credentials of API requests by authorization scheme.",
+		}, []string{"scheme"}),
+		introspections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "oauth",
+			Name:      "introspections_total",
+			Help:      "Token introspections by cache result (hit, miss).",
+		}, []string{"cache"}),
+		userLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "storage",
+			Name:      "user_lookups_total",
+			Help:      "Lookups of users by login or email by cache result (hit, miss).",
+		}, []string{"cache"}),
+		webhooks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "webhooks",
+			Name:      "attempts_total",
+			Help:      "Webhook delivery attempts by result (succeeded, retried, failed).",
+		}, []string{"result"}),
+		jobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "jobs",
+			Name:      "attempts_total",
+			Help:      "Job attempts by kind and result (succeeded, retried, dead).",
+		}, []string{"kind", "result"}),
+		configReloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "config",
+			Name:      "reloads_total",
+			Help:      "Config reloads by result (applied, unchanged, failed).",
+		}, []string{"result"}),
+		configReloadSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "config",
+			Name:      "last_reload_success_timestamp_seconds",
+			Help:      "Time of the last config reload that didn't fail.",
+		}),
+		configPendingKeys: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "config",
+			Name:      "pending_restart_keys",
+			Help:      "Changed config keys that only apply after a restart.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.logins,
+		m.authFailures,
+		m.introspections,
+		m.userLookups,
+		m.webhooks,
+		m.jobs,
+		m.configReloads,
+		m.configReloadSuccess,
+		m.configPendingKeys,
+	)
+
+	return m
+}
+
+// registerDB exports the connection pool stats of the database
+func (m *metrics) registerDB(db *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, metricsNamespace))
+}
+
+func (m *metrics) login(method string, ok bool) {
+	result := "success"
+	if !ok {
+		result = "failure"
+	}
+	m.logins.WithLabelValues(method, result).Inc()
+}
+
+// Metrics lets subsystems register their own collectors, e.g. a gauge of open connections
+func (server *Server) Metrics() prometheus.Registerer {
+	return server.metrics.registry
+}
+
+// AdminHandler serves the endpoints of the admin listener, i.e. /metrics and the probes
+func (server *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /healthz", server.handleHealthz())
+	mux.Handle("GET /readyz", server.handleReadyz())
+	mux.Handle("GET /metrics", promhttp.HandlerFor(server.metrics.registry, promhttp.HandlerOpts{
+		ErrorLog: slogErrorLogger{server.logger},
+	}))
+	return mux
+}
+
+func (server *Server) measureRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := routeTemplate(r)
+
+		rw := &responseWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		server.metrics.requests.WithLabelValues(r.Method, route, strconv.Itoa(rw.code)).Inc()
+		server.metrics.requestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// slogErrorLogger reports errors of the metrics handler through the server logger
+type slogErrorLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogErrorLogger) Println(v ...any) {
+	l.logger.Error(fmt.Sprint(v...))
+}
@@ -0,0 +1,50 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Metrics(t *testing.T) {
+	s := newTestServer(t)
+
+	auth := "Bearer " + registerUser(t, s, "alice")
+	doRequest(s, http.MethodGet, "/private/whoami", auth, nil)
+	doRequest(s, http.MethodGet, "/private/whoami", "Bearer forged", nil)
+	doRequest(s, http.MethodPost, "/sessions", "", map[string]string{"login_or_email": "alice", "password": "password"})
+	doRequest(s, http.MethodPost, "/sessions", "", map[string]string{"login_or_email": "alice", "password": "wrong password"})
+
+	// subsystems register their own metrics
+	require.NoError(t, s.Metrics().Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "vox_test_connections",
+		Help: "Open test connections.",
+	}, func() float64 { return 3 })))
+
+	// case : metrics are served by the admin listener only
+	rec := doRequest(s, http.MethodGet, "/metrics", "", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		`vox_http_requests_total{code="201",method="POST",route="/users"} 1`,
+		`vox_http_requests_total{code="200",method="GET",route="/private/whoami"} 1`,
+		`vox_http_requests_total{code="401",method="GET",route="/private/whoami"} 1`,
+		`vox_http_request_duration_seconds_count{method="POST",route="/sessions"} 2`,
+		`vox_auth_logins_total{method="password",result="success"} 1`,
+		`vox_auth_logins_total{method="password",result="failure"} 1`,
+		`vox_auth_failures_total{scheme="bearer"} 1`,
+		`vox_test_connections 3`,
+		`go_goroutines`,
+	} {
+		assert.Contains(t, body, line)
+	}
+}
@@ -0,0 +1,607 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"vox-server/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// oauthError is an error response defined by RFC 6749
+type oauthError struct {
+	code        string
+	description string
+}
+
+func (e *oauthError) Error() string {
+	return e.code + ": " + e.description
+}
+
+func newOAuthError(code, description string) *oauthError {
+	return &oauthError{code: code, description: description}
+}
+
+func (server *Server) oauthError(w http.ResponseWriter, r *http.Request, code int, err *oauthError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	server.respond(w, r, code, map[string]string{
+		"error":             err.code,
+		"error_description": err.description,
+	})
+}
+
+type authorizeRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Prompt              string
+}
+
+func parseAuthorizeRequest(form url.Values) *authorizeRequest {
+	return &authorizeRequest{
+		ClientID:            form.Get("client_id"),
+		RedirectURI:         form.Get("redirect_uri"),
+		ResponseType:        form.Get("response_type"),
+		Scope:               form.Get("scope"),
+		State:               form.Get("state"),
+		Nonce:               form.Get("nonce"),
+		CodeChallenge:       form.Get("code_challenge"),
+		CodeChallengeMethod: form.Get("code_challenge_method"),
+		Prompt:              form.Get("prompt"),
+	}
+}
+
+// params are passed through the consent form as hidden fields
+func (req *authorizeRequest) params() map[string]string {
+	return map[string]string{
+		"client_id":             req.ClientID,
+		"redirect_uri":          req.RedirectURI,
+		"response_type":         req.ResponseType,
+		"scope":                 req.Scope,
+		"state":                 req.State,
+		"nonce":                 req.Nonce,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": req.CodeChallengeMethod,
+	}
+}
+
+func (req *authorizeRequest) scopes() []string {
+	return strings.Fields(req.Scope)
+}
+
+// resolveClient checks the client and the redirect uri. Until they are trusted
+// errors are shown to the user instead of being redirected
+func (server *Server) resolveClient(ctx context.Context, req *authorizeRequest) (*models.OAuthClient, error) {
+	client, err := server.store(ctx).OAuthClients().FindByID(req.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("unknown client '%s'", req.ClientID)
+	}
+
+	if !client.HasRedirectURI(req.RedirectURI) {
+		return nil, fmt.Errorf("redirect uri '%s' is not registered for the client", req.RedirectURI)
+	}
+
+	return client, nil
+}
+
+func (req *authorizeRequest) validate(client *models.OAuthClient) *oauthError {
+	if req.ResponseType != "code" {
+		return newOAuthError("unsupported_response_type", "only the authorization code flow is supported")
+	}
+
+	scopes := req.scopes()
+	if len(scopes) == 0 {
+		return newOAuthError("invalid_scope", "scope is required")
+	}
+	for _, scope := range scopes {
+		if !models.IsKnownOAuthScope(scope) {
+			return newOAuthError("invalid_scope", fmt.Sprintf("unknown scope '%s'", scope))
+		}
+	}
+
+	if req.CodeChallenge != "" && req.CodeChallengeMethod != models.CodeChallengeS256 {
+		return newOAuthError("invalid_request", "code_challenge_method must be S256")
+	}
+
+	if client.Public && req.CodeChallenge == "" {
+		return newOAuthError("invalid_request", "public clients must use PKCE")
+	}
+
+	return nil
+}
+
+func redirectWithParams(w http.ResponseWriter, r *http.Request, redirectURI string, params map[string]string) {
+	u, _ := url.Parse(redirectURI)
+
+	q := u.Query()
+	for k, v := range params {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func redirectWithError(w http.ResponseWriter, r *http.Request, req *authorizeRequest, err *oauthError) {
+	redirectWithParams(w, r, req.RedirectURI, map[string]string{
+		"error":             err.code,
+		"error_description": err.description,
+		"state":             req.State,
+	})
+}
+
+func (server *Server) issueAuthorizationCode(w http.ResponseWriter, r *http.Request, req *authorizeRequest, user *models.User) {
+	code, err := models.NewAuthorizationCode(req.ClientID, user.Login, req.RedirectURI, req.scopes())
+	if err != nil {
+		redirectWithError(w, r, req, newOAuthError("server_error", "failed to generate code"))
+		return
+	}
+
+	code.Nonce = req.Nonce
+	code.CodeChallenge = req.CodeChallenge
+	code.CodeChallengeMethod = req.CodeChallengeMethod
+
+	if err := server.store(r.Context()).AuthorizationCodes().Create(code); err != nil {
+		server.requestLogger(r).Error("failed to store authorization code", "client_id", req.ClientID, "error", err)
+		redirectWithError(w, r, req, newOAuthError("server_error", "failed to store code"))
+		return
+	}
+
+	redirectWithParams(w, r, req.RedirectURI, map[string]string{
+		"code":  code.Code,
+		"state": req.State,
+	})
+}
+
+// handleOAuthAuthorize starts the authorization code flow. Users without a session
+// are sent to the login page, then asked for consent unless it was already given
+func (server *Server) handleOAuthAuthorize() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := parseAuthorizeRequest(r.URL.Query())
+
+		client, err := server.resolveClient(r.Context(), req)
+		if err != nil {
+			server.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if oerr := req.validate(client); oerr != nil {
+			redirectWithError(w, r, req, oerr)
+			return
+		}
+
+		user, session, err := server.userFromSessionCookie(r)
+		if err != nil || req.Prompt == "login" {
+			http.Redirect(w, r, "/login?return_to="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+
+		consent, err := server.store(r.Context()).Consents().Find(user.Login, client.ID)
+		if err == nil && consent.Covers(req.scopes()) && req.Prompt != "consent" {
+			server.issueAuthorizationCode(w, r, req, user)
+			return
+		}
+
+		if req.Prompt == "none" {
+			redirectWithError(w, r, req, newOAuthError("consent_required", "user has not granted the requested scopes"))
+			return
+		}
+
+		server.render(w, r, map[string]any{
+			"title":     "Authorize",
+			"formType":  "consent",
+			"client":    client,
+			"user":      user,
+			"scopes":    req.scopes(),
+			"params":    req.params(),
+			"csrfToken": server.csrfToken(session),
+		})
+	}
+}
+
+// handleOAuthConsent receives the decision made on the consent page
+func (server *Server) handleOAuthConsent() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			server.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		req := parseAuthorizeRequest(r.PostForm)
+
+		client, err := server.resolveClient(r.Context(), req)
+		if err != nil {
+			server.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if oerr := req.validate(client); oerr != nil {
+			redirectWithError(w, r, req, oerr)
+			return
+		}
+
+		user, _, err := server.userFromSessionCookie(r)
+		if err != nil {
+			server.error(w, r, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		if r.PostForm.Get("decision") != "allow" {
+			redirectWithError(w, r, req, newOAuthError("access_denied", "user denied the request"))
+			return
+		}
+
+		err = server.store(r.Context()).Consents().Grant(&models.Consent{
+			UserLogin: user.Login,
+			ClientID:  client.ID,
+			Scopes:    req.scopes(),
+			GrantedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			server.requestLogger(r).Error("failed to store consent", "client_id", client.ID, "error", err)
+			redirectWithError(w, r, req, newOAuthError("server_error", "failed to store consent"))
+			return
+		}
+
+		server.issueAuthorizationCode(w, r, req, user)
+	}
+}
+
+// authentificateClient supports client_secret_basic, client_secret_post and,
+// for public clients, none
+func (server *Server) authentificateClient(r *http.Request) (*models.OAuthClient, *oauthError) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 2.3.1: credentials are form-urlencoded before being put in the header
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := server.store(r.Context()).OAuthClients().FindByID(clientID)
+	if err != nil {
+		return nil, newOAuthError("invalid_client", "unknown client")
+	}
+
+	if !client.Public && !client.CompareSecret(secret) {
+		return nil, newOAuthError("invalid_client", "client authentication failed")
+	}
+
+	return client, nil
+}
+
+func (server *Server) handleOAuthToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			server.oauthError(w, r, http.StatusBadRequest, newOAuthError("invalid_request", err.Error()))
+			return
+		}
+
+		client, oerr := server.authentificateClient(r)
+		if oerr != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="vox"`)
+			server.oauthError(w, r, http.StatusUnauthorized, oerr)
+			return
+		}
+
+		switch r.PostForm.Get("grant_type") {
+		case "authorization_code":
+			server.exchangeAuthorizationCode(w, r, client)
+		case "refresh_token":
+			server.exchangeRefreshToken(w, r, client)
+		default:
+			server.oauthError(w, r, http.StatusBadRequest, newOAuthError("unsupported_grant_type", "only authorization_code and refresh_token grants are supported"))
+		}
+	}
+}
+
+func (server *Server) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client *models.OAuthClient) {
+	invalidGrant := func(description string) {
+		server.oauthError(w, r, http.StatusBadRequest, newOAuthError("invalid_grant", description))
+	}
+
+	// a code presented by another client or with another redirect_uri is not consumed,
+	// so that it can't be burnt before its client exchanges it
+	code, err := server.store(r.Context()).AuthorizationCodes().Consume(
+		models.HashSecret(r.PostForm.Get("code")), client.ID, r.PostForm.Get("redirect_uri"))
+	if err != nil {
+		invalidGrant("unknown or already used code, or the client or redirect_uri does not match the authorization request")
+		return
+	}
+
+	if code.IsExpired(time.Now()) {
+		invalidGrant("unknown or expired code")
+		return
+	}
+
+	if !code.VerifyChallenge(r.PostForm.Get("code_verifier")) {
+		invalidGrant("PKCE verification failed")
+		return
+	}
+
+	user, err := server.store(r.Context()).Users().FindByLogin(code.UserLogin)
+	if err != nil {
+		invalidGrant("user no longer exists")
+		return
+	}
+
+	session := models.NewSession(user.Login, client.ID, code.Scopes, RefreshTokenTTL)
+	if err := server.store(r.Context()).Sessions().Create(session); err != nil {
+		server.requestLogger(r).Error("failed to create session", "client_id", client.ID, "error", err)
+		server.oauthError(w, r, http.StatusInternalServerError, newOAuthError("server_error", "failed to create session"))
+		return
+	}
+
+	server.respondTokens(w, r, user, session, code)
+}
+
+func (server *Server) exchangeRefreshToken(w http.ResponseWriter, r *http.Request, client *models.OAuthClient) {
+	invalidGrant := func(description string) {
+		server.oauthError(w, r, http.StatusBadRequest, newOAuthError("invalid_grant", description))
+	}
+
+	claims, err := ValidateToken(r.PostForm.Get("refresh_token"))
+	if err != nil || claims.TokenType != tokenTypeRefresh {
+		invalidGrant("invalid refresh token")
+		return
+	}
+
+	session, err := server.store(r.Context()).Sessions().FindByID(claims.SessionID)
+	if err != nil || !session.IsActive(time.Now()) || session.ClientID != client.ID {
+		invalidGrant("session is revoked or expired")
+		return
+	}
+
+	user, err := server.store(r.Context()).Users().FindByLogin(session.UserLogin)
+	if err != nil {
+		invalidGrant("user no longer exists")
+		return
+	}
+
+	// refreshed ID tokens carry no nonce (OpenID Connect Core 12.2)
+	server.respondTokens(w, r, user, session, &models.AuthorizationCode{
+		ClientID: client.ID,
+		Scopes:   session.Scopes,
+		AuthTime: session.CreatedAt,
+	})
+}
+
+func (server *Server) respondTokens(w http.ResponseWriter, r *http.Request, user *models.User, session *models.Session, code *models.AuthorizationCode) {
+	accessToken, refreshToken, err := GenerateToken(user.Login, session)
+	if err != nil {
+		server.oauthError(w, r, http.StatusInternalServerError, newOAuthError("server_error", "failed to generate token"))
+		return
+	}
+
+	response := map[string]any{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(time.Until(accessTokenExpiry(session, time.Now())).Seconds()),
+		"refresh_token": refreshToken,
+		"scope":         strings.Join(session.Scopes, " "),
+	}
+
+	if slices.Contains(session.Scopes, models.ScopeOpenID) {
+		idToken, err := server.signIDToken(r, user, code, accessToken)
+		if err != nil {
+			server.oauthError(w, r, http.StatusInternalServerError, newOAuthError("server_error", "failed to sign id token"))
+			return
+		}
+		response["id_token"] = idToken
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	server.respond(w, r, http.StatusOK, response)
+}
+
+func (server *Server) handleOAuthUserInfo() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(userContextKey).(*models.User)
+		if !ok || user == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			server.error(w, r, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		var scopes []string
+		if session, ok := r.Context().Value(sessionContextKey).(*models.Session); ok && session.IsRestricted() {
+			scopes = session.Scopes
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		server.respond(w, r, http.StatusOK, userClaims(user, scopes))
+	}
+}
+
+// handleOAuthIntrospect implements RFC 7662 for confidential clients
+func (server *Server) handleOAuthIntrospect() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			server.oauthError(w, r, http.StatusBadRequest, newOAuthError("invalid_request", err.Error()))
+			return
+		}
+
+		client, oerr := server.authentificateClient(r)
+		if oerr == nil && client.Public {
+			oerr = newOAuthError("invalid_client", "public clients can't introspect tokens")
+		}
+		if oerr != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="vox"`)
+			server.oauthError(w, r, http.StatusUnauthorized, oerr)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+
+		server.respond(w, r, http.StatusOK, server.introspect(r.Context(), r.PostForm.Get("token")))
+	}
+}
+
+// introspect answers whether the token is valid and its session active, from the cache
+// when it was asked lately
+func (server *Server) introspect(ctx context.Context, token string) introspection {
+	now := time.Now()
+	if answer, ok := server.introspections.Get(token, now); ok {
+		server.metrics.introspections.WithLabelValues("hit").Inc()
+		return answer
+	}
+	server.metrics.introspections.WithLabelValues("miss").Inc()
+
+	claims, session, err := server.activeToken(ctx, token)
+	if err != nil {
+		return introspection{Active: false}
+	}
+
+	tokenType := "Bearer"
+	if claims.TokenType == tokenTypeRefresh {
+		tokenType = "refresh_token"
+	}
+
+	answer := introspection{
+		Active:    true,
+		Subject:   session.UserLogin,
+		Username:  session.UserLogin,
+		Scope:     claims.Scope,
+		TokenType: tokenType,
+		ClientID:  session.ClientID,
+		SessionID: session.ID,
+		ExpiresAt: claims.ExpiresAt,
+		IssuedAt:  claims.IssuedAt,
+		ID:        claims.Id,
+	}
+	server.introspections.Put(token, answer, now)
+	return answer
+}
+
+// handleOAuthRevoke implements RFC 7009. Revoking any token of a session revokes the whole session
+func (server *Server) handleOAuthRevoke() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			server.oauthError(w, r, http.StatusBadRequest, newOAuthError("invalid_request", err.Error()))
+			return
+		}
+
+		client, oerr := server.authentificateClient(r)
+		if oerr != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="vox"`)
+			server.oauthError(w, r, http.StatusUnauthorized, oerr)
+			return
+		}
+
+		// invalid tokens and tokens of other clients are ignored, as the RFC requires
+		_, session, err := server.activeToken(r.Context(), r.PostForm.Get("token"))
+		if err == nil && session.ClientID == client.ID {
+			if err := server.store(r.Context()).Sessions().Revoke(session.ID); err != nil {
+				server.requestLogger(r).Error("failed to revoke session", "session_id", session.ID, "error", err)
+			}
+			server.introspections.InvalidateSession(session.ID)
+		}
+
+		server.respond(w, r, http.StatusOK, nil)
+	}
+}
+
+func (server *Server) handleOAuthClientsList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(userContextKey).(*models.User)
+		if !ok || user == nil {
+			server.error(w, r, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		clients, err := server.store(r.Context()).OAuthClients().FindByOwner(user.Login)
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		server.respond(w, r, http.StatusOK, clients)
+	}
+}
+
+func (server *Server) handleOAuthClientsCreate() http.HandlerFunc {
+	type request struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Public       bool     `json:"public"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(userContextKey).(*models.User)
+		if !ok || user == nil {
+			server.error(w, r, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			server.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		client, err := models.NewOAuthClient(user.Login, req.Name, req.RedirectURIs, req.Public)
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to generate client: %w", err))
+			return
+		}
+
+		if err := server.store(r.Context()).OAuthClients().Create(client); err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		server.audit(r, models.AuditOAuthClientCreated, user.Login, client.ID, map[string]any{
+			"name":          client.Name,
+			"redirect_uris": client.RedirectURIs,
+			"public":        client.Public,
+		})
+
+		// the plain secret is shown only once, in this response
+		w.Header().Set("Cache-Control", "no-store")
+		server.respond(w, r, http.StatusCreated, client)
+	}
+}
+
+func (server *Server) handleOAuthClientsDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(userContextKey).(*models.User)
+		if !ok || user == nil {
+			server.error(w, r, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		client, err := server.store(r.Context()).OAuthClients().FindByID(id)
+		if err != nil || client.OwnerLogin != user.Login {
+			server.error(w, r, http.StatusNotFound, fmt.Errorf("oauth client '%s' not found", id))
+			return
+		}
+
+		if err := server.store(r.Context()).OAuthClients().Delete(client.ID); err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		server.audit(r, models.AuditOAuthClientDeleted, user.Login, client.ID, nil)
+
+		server.respond(w, r, http.StatusNoContent, nil)
+	}
+}
@@ -0,0 +1,469 @@
+package server_test
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRelyingParty is an OAuth client application, it records what arrives to its callback
+type fakeRelyingParty struct {
+	*httptest.Server
+	callbacks chan url.Values
+
+	clientID     string
+	clientSecret string
+}
+
+func newFakeRelyingParty(t *testing.T) *fakeRelyingParty {
+	t.Helper()
+
+	rp := &fakeRelyingParty{callbacks: make(chan url.Values, 1)}
+	rp.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rp.callbacks <- r.URL.Query()
+		io.WriteString(w, "signed in")
+	}))
+	t.Cleanup(rp.Close)
+
+	return rp
+}
+
+func (rp *fakeRelyingParty) redirectURI() string {
+	return rp.URL + "/callback"
+}
+
+func (rp *fakeRelyingParty) register(t *testing.T, s http.Handler, auth string, public bool) {
+	t.Helper()
+
+	rec := doRequest(s, http.MethodPost, "/private/oauth/clients", auth, map[string]any{
+		"name":          "wiki",
+		"redirect_uris": []string{rp.redirectURI()},
+		"public":        public,
+	})
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var client struct {
+		ID     string `json:"client_id"`
+		Secret string `json:"client_secret"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&client))
+
+	rp.clientID = client.ID
+	rp.clientSecret = client.Secret
+}
+
+// tokenRequest calls an endpoint of the provider authentificated as the client
+func (rp *fakeRelyingParty) tokenRequest(t *testing.T, endpoint string, form url.Values) (int, map[string]any) {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if rp.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(rp.clientID), url.QueryEscape(rp.clientSecret))
+	} else {
+		form.Set("client_id", rp.clientID)
+		req.Body = io.NopCloser(strings.NewReader(form.Encode()))
+		req.ContentLength = int64(len(form.Encode()))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body := map[string]any{}
+	json.NewDecoder(resp.Body).Decode(&body)
+
+	return resp.StatusCode, body
+}
+
+func (rp *fakeRelyingParty) exchange(t *testing.T, issuer, code, verifier string) (int, map[string]any) {
+	return rp.tokenRequest(t, issuer+"/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {rp.redirectURI()},
+		"code_verifier": {verifier},
+	})
+}
+
+// verifyIDToken checks the signature against the published JWKS
+func (rp *fakeRelyingParty) verifyIDToken(t *testing.T, issuer, raw string) jwt.MapClaims {
+	t.Helper()
+
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	getJSON(t, issuer+"/.well-known/openid-configuration", &discovery)
+	require.Equal(t, issuer, discovery.Issuer)
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	getJSON(t, discovery.JWKSURI, &jwks)
+	require.Len(t, jwks.Keys, 1)
+
+	n, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].N)
+	e, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].E)
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		assert.Equal(t, jwks.Keys[0].Kid, token.Header["kid"])
+		return key, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "RS256", token.Method.Alg())
+
+	assert.Equal(t, issuer, claims["iss"])
+	assert.Equal(t, rp.clientID, claims["aud"])
+
+	return claims
+}
+
+func getJSON(t *testing.T, url string, v any) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+}
+
+// newBrowser keeps cookies and doesn't follow redirects, so every step can be checked
+func newBrowser(t *testing.T) *http.Client {
+	t.Helper()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+
+	return &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+var csrfInput = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// csrfFromPage is the CSRF token of the form of a page
+func csrfFromPage(t *testing.T, page []byte) string {
+	t.Helper()
+
+	match := csrfInput.FindSubmatch(page)
+	require.Len(t, match, 2, "the form carries a CSRF token")
+	return string(match[1])
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestOAuth_AuthorizationCodeFlow(t *testing.T) {
+	s := newTestServer(t)
+	idp := httptest.NewServer(s)
+	defer idp.Close()
+
+	bearer := "Bearer " + registerUser(t, s, "alice")
+	rp := newFakeRelyingParty(t)
+	rp.register(t, s, bearer, false)
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {rp.clientID},
+		"redirect_uri":          {rp.redirectURI()},
+		"scope":                 {"openid email"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6_WzA2Mj"},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	authorizeURL := idp.URL + "/oauth/authorize?" + params.Encode()
+	browser := newBrowser(t)
+
+	// an anonymous user is sent to the login page first
+	resp, err := browser.Get(authorizeURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	returnTo, _ := url.Parse(resp.Header.Get("Location"))
+	assert.Equal(t, "/login", returnTo.Path)
+	assert.Equal(t, idp.URL+returnTo.Query().Get("return_to"), authorizeURL)
+
+	resp, err = browser.Post(idp.URL+"/sessions", "application/json",
+		strings.NewReader(`{"login_or_email": "alice", "password": "password"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the logged in user is asked for consent
+	resp, err = browser.Get(authorizeURL)
+	require.NoError(t, err)
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(page), "Authorize wiki")
+	assert.Contains(t, string(page), "email")
+
+	consent := url.Values{"decision": {"allow"}, "csrf_token": {csrfFromPage(t, page)}}
+	for k, v := range params {
+		consent[k] = v
+	}
+	resp, err = browser.PostForm(idp.URL+"/oauth/authorize", consent)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	resp, err = browser.Get(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	callback := <-rp.callbacks
+	assert.Equal(t, "xyz", callback.Get("state"))
+	code := callback.Get("code")
+	require.NotEmpty(t, code)
+
+	// another client can't exchange the code, nor burn it
+	other := newFakeRelyingParty(t)
+	other.register(t, s, bearer, false)
+	status, body := other.exchange(t, idp.URL, code, verifier)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_grant", body["error"])
+
+	// the relying party exchanges the code
+	status, tokens := rp.exchange(t, idp.URL, code, verifier)
+	require.Equal(t, http.StatusOK, status, tokens)
+	assert.Equal(t, "Bearer", tokens["token_type"])
+	assert.Equal(t, "openid email", tokens["scope"])
+
+	idToken := rp.verifyIDToken(t, idp.URL, tokens["id_token"].(string))
+	assert.Equal(t, "alice", idToken["sub"])
+	assert.Equal(t, "n-0S6_WzA2Mj", idToken["nonce"])
+	assert.Equal(t, "alice@example.org", idToken["email"])
+	assert.NotContains(t, idToken, "preferred_username", "profile scope was not granted")
+
+	// codes are single use
+	status, body = rp.exchange(t, idp.URL, code, verifier)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_grant", body["error"])
+
+	// userinfo releases the claims of granted scopes only
+	accessToken := tokens["access_token"].(string)
+	rec := doRequest(s, http.MethodGet, "/oauth/userinfo", "Bearer "+accessToken, nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var userinfo map[string]any
+	json.NewDecoder(rec.Body).Decode(&userinfo)
+	assert.Equal(t, "alice", userinfo["sub"])
+	assert.Equal(t, "alice@example.org", userinfo["email"])
+	assert.NotContains(t, userinfo, "name")
+
+	// client tokens are restricted to their scopes on the API
+	rec = doRequest(s, http.MethodGet, "/private/whoami", "Bearer "+accessToken, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	status, introspection := rp.tokenRequest(t, idp.URL+"/oauth/introspect", url.Values{"token": {accessToken}})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, true, introspection["active"])
+	assert.Equal(t, "alice", introspection["sub"])
+	assert.Equal(t, rp.clientID, introspection["client_id"])
+	assert.Equal(t, "openid email", introspection["scope"])
+	assert.NotEmpty(t, introspection["sid"])
+
+	// refresh keeps the session
+	status, refreshed := rp.tokenRequest(t, idp.URL+"/oauth/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tokens["refresh_token"].(string)},
+	})
+	require.Equal(t, http.StatusOK, status, refreshed)
+	refreshedIDToken := rp.verifyIDToken(t, idp.URL, refreshed["id_token"].(string))
+	assert.NotContains(t, refreshedIDToken, "nonce")
+
+	// revoking any token ends the session
+	status, _ = rp.tokenRequest(t, idp.URL+"/oauth/revoke", url.Values{"token": {tokens["refresh_token"].(string)}})
+	assert.Equal(t, http.StatusOK, status)
+
+	for _, token := range []string{accessToken, refreshed["access_token"].(string)} {
+		status, introspection = rp.tokenRequest(t, idp.URL+"/oauth/introspect", url.Values{"token": {token}})
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, map[string]any{"active": false}, introspection)
+
+		rec = doRequest(s, http.MethodGet, "/oauth/userinfo", "Bearer "+token, nil)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+
+	// consent is remembered, the next authorization redirects right away
+	resp, err = browser.Get(authorizeURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	assert.True(t, strings.HasPrefix(location.String(), rp.redirectURI()))
+
+	// PKCE binds the code to the verifier
+	status, body = rp.exchange(t, idp.URL, location.Query().Get("code"), "another-verifier-another-verifier-another")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_grant", body["error"])
+}
+
+func TestOAuth_AuthorizeErrors(t *testing.T) {
+	s := newTestServer(t)
+	bearer := "Bearer " + registerUser(t, s, "bob")
+
+	confidential := newFakeRelyingParty(t)
+	confidential.register(t, s, bearer, false)
+	public := newFakeRelyingParty(t)
+	public.register(t, s, bearer, true)
+
+	testCases := []struct {
+		name          string
+		rp            *fakeRelyingParty
+		params        url.Values
+		expectedCode  int
+		expectedError string
+	}{
+		{
+			name: "unregistered redirect uri",
+			rp:   confidential,
+			params: url.Values{
+				"response_type": {"code"},
+				"redirect_uri":  {"https://evil.example/callback"},
+				"scope":         {"openid"},
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name: "unsupported response type",
+			rp:   confidential,
+			params: url.Values{
+				"response_type": {"token"},
+				"scope":         {"openid"},
+			},
+			expectedCode:  http.StatusFound,
+			expectedError: "unsupported_response_type",
+		},
+		{
+			name: "unknown scope",
+			rp:   confidential,
+			params: url.Values{
+				"response_type": {"code"},
+				"scope":         {"openid admin"},
+			},
+			expectedCode:  http.StatusFound,
+			expectedError: "invalid_scope",
+		},
+		{
+			name: "public client without PKCE",
+			rp:   public,
+			params: url.Values{
+				"response_type": {"code"},
+				"scope":         {"openid"},
+			},
+			expectedCode:  http.StatusFound,
+			expectedError: "invalid_request",
+		},
+		{
+			name: "plain PKCE method",
+			rp:   public,
+			params: url.Values{
+				"response_type":         {"code"},
+				"scope":                 {"openid"},
+				"code_challenge":        {"challenge"},
+				"code_challenge_method": {"plain"},
+			},
+			expectedCode:  http.StatusFound,
+			expectedError: "invalid_request",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.params.Set("client_id", tc.rp.clientID)
+			if tc.params.Get("redirect_uri") == "" {
+				tc.params.Set("redirect_uri", tc.rp.redirectURI())
+			}
+
+			rec := doRequest(s, http.MethodGet, "/oauth/authorize?"+tc.params.Encode(), "", nil)
+			assert.Equal(t, tc.expectedCode, rec.Code)
+
+			if tc.expectedError != "" {
+				location, _ := url.Parse(rec.Header().Get("Location"))
+				assert.Equal(t, tc.expectedError, location.Query().Get("error"))
+			}
+		})
+	}
+}
+
+func TestOAuth_ConsentDenied(t *testing.T) {
+	s := newTestServer(t)
+	bearer := "Bearer " + registerUser(t, s, "carol")
+	rp := newFakeRelyingParty(t)
+	rp.register(t, s, bearer, false)
+
+	// the session cookie set on login authentificates the consent form
+	rec := doRequest(s, http.MethodPost, "/sessions", "", map[string]string{
+		"login_or_email": "carol",
+		"password":       "password",
+	})
+	require.Equal(t, http.StatusOK, rec.Code)
+	cookies := rec.Result().Cookies()
+	require.NotEmpty(t, cookies)
+
+	form := url.Values{
+		"response_type": {"code"},
+		"client_id":     {rp.clientID},
+		"redirect_uri":  {rp.redirectURI()},
+		"scope":         {"openid"},
+		"state":         {"abc"},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+form.Encode(), nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	form.Set("decision", "deny")
+	form.Set("csrf_token", csrfFromPage(t, rec.Body.Bytes()))
+	req = httptest.NewRequest(http.MethodPost, "/oauth/authorize", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusFound, rec.Code)
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	assert.Equal(t, "access_denied", location.Query().Get("error"))
+	assert.Equal(t, "abc", location.Query().Get("state"))
+}
+
+func TestServer_Logout(t *testing.T) {
+	s := newTestServer(t)
+	bearer := "Bearer " + registerUser(t, s, "dave")
+
+	rec := doRequest(s, http.MethodDelete, "/private/sessions/current", bearer, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = doRequest(s, http.MethodGet, "/private/whoami", bearer, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
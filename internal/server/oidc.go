@@ -0,0 +1,280 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+	"vox-server/internal/models"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const idTokenTTL = time.Hour
+
+// signingKey signs ID tokens (RS256) and is published in the JWKS document
+type signingKey struct {
+	id  string
+	key *rsa.PrivateKey
+}
+
+var ephemeralKey struct {
+	once sync.Once
+	key  *signingKey
+	err  error
+}
+
+// loadSigningKey reads a PEM encoded RSA key (PKCS#1 or PKCS#8).
+// Without a path a key is generated once per process, so tokens don't survive restarts
+func loadSigningKey(path string) (*signingKey, error) {
+	if path == "" {
+		ephemeralKey.once.Do(func() {
+			var key *rsa.PrivateKey
+			key, ephemeralKey.err = rsa.GenerateKey(rand.Reader, 2048)
+			if ephemeralKey.err == nil {
+				ephemeralKey.key, ephemeralKey.err = newSigningKey(key)
+			}
+		})
+		return ephemeralKey.key, ephemeralKey.err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing key is not PEM encoded")
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported signing key type '%s'", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("signing key is not an RSA key")
+	}
+
+	return newSigningKey(rsaKey)
+}
+
+// previousSigningKeyPath keeps the key replaced by the last rotation,
+// it stays in the JWKS so that the tokens it signed can still be verified
+func previousSigningKeyPath(path string) string {
+	return path + ".previous"
+}
+
+// loadPreviousSigningKey returns nil when there was no rotation
+func loadPreviousSigningKey(path string) (*signingKey, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	previous := previousSigningKeyPath(path)
+	if _, err := os.Stat(previous); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	return loadSigningKey(previous)
+}
+
+// RotateSigningKey writes a new key to the path and keeps the current one as the previous key.
+// Servers use the new key after a restart. It returns the ids of the new and the previous key
+func RotateSigningKey(path string) (string, string, error) {
+	if path == "" {
+		return "", "", errors.New("no signing key path is configured, the key is ephemeral")
+	}
+
+	current, err := loadSigningKey(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", "", err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", err
+	}
+
+	next, err := newSigningKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	// written aside first, so that a failure leaves the current key in place
+	tmp := path + ".next"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return "", "", fmt.Errorf("failed to write signing key: %w", err)
+	}
+
+	var previousID string
+	if current != nil {
+		if err := os.Rename(path, previousSigningKeyPath(path)); err != nil {
+			os.Remove(tmp)
+			return "", "", fmt.Errorf("failed to keep the previous signing key: %w", err)
+		}
+		previousID = current.id
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return "", "", fmt.Errorf("failed to replace signing key: %w", err)
+	}
+
+	return next.id, previousID, nil
+}
+
+func newSigningKey(key *rsa.PrivateKey) (*signingKey, error) {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(der)
+
+	return &signingKey{
+		id:  base64.RawURLEncoding.EncodeToString(sum[:12]),
+		key: key,
+	}, nil
+}
+
+func (k *signingKey) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = k.id
+	return token.SignedString(k.key)
+}
+
+func (k *signingKey) jwk() map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": k.id,
+		"n":   base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+	}
+}
+
+// issuer is the configured public URL or, for local setups, the URL the request came to
+func (server *Server) issuer(r *http.Request) string {
+	if server.config.OAuth.Issuer != "" {
+		return server.config.OAuth.Issuer
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func (server *Server) signIDToken(r *http.Request, user *models.User, code *models.AuthorizationCode, accessToken string) (string, error) {
+	now := time.Now()
+
+	// at_hash is the left half of the access token hash (OpenID Connect Core 3.1.3.6)
+	sum := sha256.Sum256([]byte(accessToken))
+
+	claims := jwt.MapClaims{
+		"iss":       server.issuer(r),
+		"sub":       user.Login,
+		"aud":       code.ClientID,
+		"azp":       code.ClientID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(idTokenTTL).Unix(),
+		"auth_time": code.AuthTime.Unix(),
+		"at_hash":   base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]),
+	}
+
+	if code.Nonce != "" {
+		claims["nonce"] = code.Nonce
+	}
+
+	for k, v := range userClaims(user, code.Scopes) {
+		claims[k] = v
+	}
+
+	return server.signingKey.sign(claims)
+}
+
+// userClaims are the standard claims released for the granted scopes
+func userClaims(user *models.User, scopes []string) map[string]any {
+	claims := map[string]any{
+		"sub": user.Login,
+	}
+
+	if scopes == nil || slices.Contains(scopes, models.ScopeProfile) {
+		claims["preferred_username"] = user.Login
+		claims["name"] = user.Username
+	}
+
+	if scopes == nil || slices.Contains(scopes, models.ScopeEmail) {
+		claims["email"] = user.Email
+		claims["email_verified"] = false
+	}
+
+	return claims
+}
+
+func (server *Server) handleOpenIDConfiguration() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		issuer := server.issuer(r)
+
+		w.Header().Set("Content-Type", "application/json")
+		server.respond(w, r, http.StatusOK, map[string]any{
+			"issuer":                                issuer,
+			"authorization_endpoint":                issuer + "/oauth/authorize",
+			"token_endpoint":                        issuer + "/oauth/token",
+			"userinfo_endpoint":                     issuer + "/oauth/userinfo",
+			"jwks_uri":                              issuer + "/.well-known/jwks.json",
+			"introspection_endpoint":                issuer + "/oauth/introspect",
+			"revocation_endpoint":                   issuer + "/oauth/revoke",
+			"response_types_supported":              []string{"code"},
+			"grant_types_supported":                 []string{"authorization_code", "refresh_token"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"scopes_supported":                      models.OAuthScopes,
+			"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+			"code_challenge_methods_supported":      []string{models.CodeChallengeS256},
+			"claims_supported": []string{
+				"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash",
+				"preferred_username", "name", "email", "email_verified",
+			},
+		})
+	}
+}
+
+func (server *Server) handleJWKS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		keys := []map[string]string{server.signingKey.jwk()}
+		if server.previousKey != nil {
+			keys = append(keys, server.previousKey.jwk())
+		}
+
+		server.respond(w, r, http.StatusOK, map[string]any{"keys": keys})
+	}
+}
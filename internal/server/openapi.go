@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"vox-server/api"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+const (
+	apiV1Prefix = "/api/v1"
+	// the unversioned paths are deprecated since the API is served under /api/v1, RFC 9745
+	legacyAPIDeprecation = "@1792368000" // 2026-10-19
+)
+
+// deprecated marks the responses of the unversioned API paths, pointing to their successor
+func (server *Server) deprecated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", legacyAPIDeprecation)
+		w.Header().Set("Link", "<"+apiV1Prefix+r.URL.Path+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// openAPISpec is the embedded description of the API, loaded on first use
+var openAPISpec = sync.OnceValues(func() (*openapi3.T, error) {
+	return openapi3.NewLoader().LoadFromData(api.OpenAPI)
+})
+
+func (server *Server) handleOpenAPI() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(api.OpenAPI)
+	}
+}
+
+// apiOperation is an operation of the spec, as the docs page lists it
+type apiOperation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Public      bool
+	Parameters  []*openapi3.Parameter
+	Request     string
+	Responses   []apiResponse
+}
+
+type apiResponse struct {
+	Status      string
+	Description string
+	Schema      string
+}
+
+// schemaText shows a schema as the JSON of the spec, a reference to a component stays one
+func schemaText(ref *openapi3.SchemaRef) string {
+	if ref == nil {
+		return ""
+	}
+	b, err := json.MarshalIndent(ref, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// apiOperations lists the operations of the spec in the order of their paths
+func apiOperations(spec *openapi3.T) []apiOperation {
+	var operations []apiOperation
+
+	paths := spec.Paths.Map()
+	for _, path := range slices.Sorted(maps.Keys(paths)) {
+		item := paths[path]
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			op := item.GetOperation(method)
+			if op == nil {
+				continue
+			}
+
+			operation := apiOperation{
+				Method:      method,
+				Path:        apiV1Prefix + path,
+				Summary:     op.Summary,
+				Description: op.Description,
+				Public:      op.Security != nil && len(*op.Security) == 0,
+			}
+			for _, param := range slices.Concat(item.Parameters, op.Parameters) {
+				operation.Parameters = append(operation.Parameters, param.Value)
+			}
+			if op.RequestBody != nil {
+				if media := op.RequestBody.Value.Content.Get("application/json"); media != nil {
+					operation.Request = schemaText(media.Schema)
+				}
+			}
+
+			responses := op.Responses.Map()
+			for _, status := range slices.Sorted(maps.Keys(responses)) {
+				response := responses[status]
+				if response.Ref != "" {
+					// e.g. every problem shares the one of the components
+					name := response.Ref[strings.LastIndex(response.Ref, "/")+1:]
+					operation.Responses = append(operation.Responses, apiResponse{Status: status, Description: name})
+					continue
+				}
+
+				res := apiResponse{Status: status}
+				if response.Value.Description != nil {
+					res.Description = *response.Value.Description
+				}
+				for _, media := range response.Value.Content {
+					res.Schema = schemaText(media.Schema)
+				}
+				operation.Responses = append(operation.Responses, res)
+			}
+
+			operations = append(operations, operation)
+		}
+	}
+
+	return operations
+}
+
+// handleAPIDocs renders the spec, without scripts of a third party
+func (server *Server) handleAPIDocs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		spec, err := openAPISpec()
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		schemas := map[string]string{}
+		for name, schema := range spec.Components.Schemas {
+			schemas[name] = schemaText(&openapi3.SchemaRef{Value: schema.Value})
+		}
+
+		server.render(w, r, map[string]any{
+			"title":      "API",
+			"formType":   "docs",
+			"info":       spec.Info,
+			"specURL":    apiV1Prefix + "/openapi.json",
+			"operations": apiOperations(spec),
+			"schemas":    schemas,
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"vox-server/api"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	openapi3filter.RegisterBodyDecoder("application/x-ndjson", openapi3filter.FileBodyDecoder)
+}
+
+// contract checks the requests of a test and the responses of the server against the spec,
+// and which operations of the spec the test exercised
+type contract struct {
+	t       *testing.T
+	s       http.Handler
+	spec    *openapi3.T
+	router  routers.Router
+	covered map[string]bool
+}
+
+func newContract(t *testing.T, s http.Handler) *contract {
+	t.Helper()
+
+	spec, err := openapi3.NewLoader().LoadFromData(api.OpenAPI)
+	require.NoError(t, err)
+	require.NoError(t, spec.Validate(t.Context()))
+
+	router, err := gorillamux.NewRouter(spec)
+	require.NoError(t, err)
+
+	return &contract{t: t, s: s, spec: spec, router: router, covered: map[string]bool{}}
+}
+
+// do sends a request the spec allows, and checks the response
+func (c *contract) do(method, path, auth string, payload any) *httptest.ResponseRecorder {
+	c.t.Helper()
+	return c.send(true, method, path, auth, payload)
+}
+
+// doInvalid sends a request the spec rejects, and checks the response
+func (c *contract) doInvalid(method, path, auth string, payload any) *httptest.ResponseRecorder {
+	c.t.Helper()
+	return c.send(false, method, path, auth, payload)
+}
+
+func (c *contract) send(valid bool, method, path, auth string, payload any) *httptest.ResponseRecorder {
+	c.t.Helper()
+
+	var body []byte
+	if payload != nil {
+		var err error
+		body, err = json.Marshal(payload)
+		require.NoError(c.t, err)
+	}
+
+	req := httptest.NewRequest(method, "/api/v1"+path, bytes.NewReader(body))
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	route, params, err := c.router.FindRoute(req)
+	require.NoError(c.t, err, "%s %s isn't in the spec", method, path)
+
+	input := &openapi3filter.RequestValidationInput{
+		Request:    req,
+		PathParams: params,
+		Route:      route,
+		Options:    &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+	}
+	err = openapi3filter.ValidateRequest(c.t.Context(), input)
+	if valid {
+		require.NoError(c.t, err, "the request of the test doesn't match the spec")
+	} else {
+		require.Error(c.t, err, "the spec allows the invalid request")
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	rec := httptest.NewRecorder()
+	c.s.ServeHTTP(rec, req)
+
+	err = openapi3filter.ValidateResponse(c.t.Context(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 rec.Code,
+		Header:                 rec.Header(),
+		Body:                   io.NopCloser(bytes.NewReader(rec.Body.Bytes())),
+		Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+	})
+	assert.NoError(c.t, err, "%s %s answered %d, not as specified: %s", method, path, rec.Code, rec.Body.String())
+
+	c.covered[route.Operation.OperationID] = true
+	return rec
+}
+
+// uncovered lists the operations of the spec no request exercised
+func (c *contract) uncovered() []string {
+	var ids []string
+	for _, item := range c.spec.Paths.Map() {
+		for _, op := range item.Operations() {
+			if !c.covered[op.OperationID] {
+				ids = append(ids, op.OperationID)
+			}
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestOpenAPI_Contract(t *testing.T) {
+	s := newTestServer(t)
+	c := newContract(t, s)
+
+	// accounts
+	rec := c.do(http.MethodPost, "/users", "", map[string]string{
+		"login":    "alice",
+		"username": "alice",
+		"email":    "alice@example.org",
+		"password": "password",
+	})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&tokens))
+	bearer := "Bearer " + tokens.AccessToken
+
+	c.doInvalid(http.MethodPost, "/users", "", map[string]string{"login": "bob", "email": "not an email"})
+	c.do(http.MethodPost, "/users", "", map[string]string{
+		"login":    "alice",
+		"username": "alice",
+		"email":    "alice@example.org",
+		"password": "password",
+	})
+
+	rec = c.do(http.MethodPost, "/sessions", "", map[string]string{"login_or_email": "alice", "password": "password"})
+	require.Equal(t, http.StatusOK, rec.Code)
+	c.do(http.MethodPost, "/sessions", "", map[string]string{"login_or_email": "alice", "password": "wrong"})
+	c.doInvalid(http.MethodPost, "/sessions", "", map[string]string{"login_or_email": "alice"})
+
+	// profile
+	require.Equal(t, http.StatusOK, c.do(http.MethodGet, "/private/whoami", bearer, nil).Code)
+	c.do(http.MethodGet, "/private/whoami", "", nil)
+	c.do(http.MethodGet, "/private/whoami", "Bearer nope", nil)
+	require.Equal(t, http.StatusOK, c.do(http.MethodGet, "/private/identities", bearer, nil).Code)
+	require.Equal(t, http.StatusNoContent, c.do(http.MethodPut, "/private/password", bearer, map[string]string{
+		"current_password": "password",
+		"new_password":     "password2",
+	}).Code)
+	c.do(http.MethodPut, "/private/password", bearer, map[string]string{
+		"current_password": "wrong",
+		"new_password":     "password3",
+	})
+
+	// keys
+	require.Equal(t, http.StatusOK, c.do(http.MethodGet, "/private/keys", bearer, nil).Code)
+	rec = c.do(http.MethodPost, "/private/keys", bearer, map[string]any{"name": "ci", "scopes": []string{"profile:read"}})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var key apiKeyResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&key))
+	c.do(http.MethodGet, "/private/whoami", "Bot "+key.Key, nil)
+	c.do(http.MethodGet, "/private/keys", "Bot "+key.Key, nil)
+	c.do(http.MethodGet, "/private/keys", bearer, nil)
+	require.Equal(t, http.StatusNoContent, c.do(http.MethodDelete, "/private/keys/"+key.ID, bearer, nil).Code)
+	c.do(http.MethodDelete, "/private/keys/"+key.ID, bearer, nil)
+	c.do(http.MethodDelete, "/private/keys/nope", bearer, nil)
+
+	// clients
+	require.Equal(t, http.StatusOK, c.do(http.MethodGet, "/private/oauth/clients", bearer, nil).Code)
+	rec = c.do(http.MethodPost, "/private/oauth/clients", bearer, map[string]any{
+		"name":          "app",
+		"redirect_uris": []string{"https://app.example.org/callback"},
+	})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var client struct {
+		ID string `json:"client_id"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&client))
+	c.do(http.MethodGet, "/private/oauth/clients", bearer, nil)
+	c.do(http.MethodPost, "/private/oauth/clients", bearer, map[string]any{
+		"name":          "app",
+		"redirect_uris": []string{"https://app.example.org/callback#fragment"},
+	})
+	require.Equal(t, http.StatusNoContent, c.do(http.MethodDelete, "/private/oauth/clients/"+client.ID, bearer, nil).Code)
+	c.do(http.MethodDelete, "/private/oauth/clients/"+client.ID, bearer, nil)
+
+	// admin
+	c.do(http.MethodGet, "/admin/audit", bearer, nil)
+	registerUser(t, s, "root")
+	promote(t, s, "root")
+	rec = c.do(http.MethodPost, "/sessions", "", map[string]string{"login_or_email": "root", "password": "password"})
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&tokens))
+	root := "Bearer " + tokens.AccessToken
+
+	require.Equal(t, http.StatusOK, c.do(http.MethodGet, "/admin/audit?limit=2&action=user.registered", root, nil).Code)
+	c.doInvalid(http.MethodGet, "/admin/audit?since=yesterday", root, nil)
+	require.Equal(t, http.StatusOK, c.do(http.MethodGet, "/admin/audit/export", root, nil).Code)
+	require.Equal(t, http.StatusOK, c.do(http.MethodPut, "/admin/users/alice/role", root, map[string]string{"role": "admin"}).Code)
+	c.do(http.MethodPut, "/admin/users/root/role", root, map[string]string{"role": "user"})
+	c.doInvalid(http.MethodPut, "/admin/users/alice/role", root, map[string]string{"role": "owner"})
+	require.Equal(t, http.StatusOK, c.do(http.MethodGet, "/admin/log/level", root, nil).Code)
+	require.Equal(t, http.StatusOK, c.do(http.MethodPut, "/admin/log/level", root, map[string]string{"level": "debug"}).Code)
+	c.do(http.MethodPut, "/admin/log/level", root, map[string]string{"level": "loud"})
+	require.Equal(t, http.StatusOK, c.do(http.MethodGet, "/admin/config/reload", root, nil).Code)
+	c.do(http.MethodPost, "/admin/config/reload", root, nil)
+	require.Equal(t, http.StatusOK, c.do(http.MethodGet, "/admin/webhooks", root, nil).Code)
+	rec = c.do(http.MethodPost, "/admin/webhooks", root, map[string]any{
+		"url":    "https://hooks.example.org/vox",
+		"events": []string{"user.deleted"},
+	})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var webhook struct {
+		ID string `json:"id"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&webhook))
+	c.doInvalid(http.MethodPost, "/admin/webhooks", root, map[string]any{"url": "https://hooks.example.org/vox"})
+	require.Equal(t, http.StatusOK, c.do(http.MethodGet, "/admin/webhooks/"+webhook.ID, root, nil).Code)
+	c.do(http.MethodGet, "/admin/webhooks/nope", root, nil)
+	require.Equal(t, http.StatusOK, c.do(http.MethodPatch, "/admin/webhooks/"+webhook.ID, root, map[string]any{"enabled": true}).Code)
+	c.doInvalid(http.MethodPatch, "/admin/webhooks/"+webhook.ID, root, map[string]any{"events": []string{"session.login"}})
+	require.Equal(t, http.StatusNoContent, c.do(http.MethodDelete, "/admin/users/alice", root, nil).Code)
+	require.NotZero(t, s.RunDueJobs(t.Context()))
+	rec = c.do(http.MethodGet, "/admin/webhooks/"+webhook.ID+"/deliveries", root, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var deliveries []struct {
+		ID string `json:"id"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&deliveries))
+	require.Len(t, deliveries, 1)
+	require.Equal(t, http.StatusAccepted, c.do(http.MethodPost, "/admin/webhooks/"+webhook.ID+"/deliveries/"+deliveries[0].ID+"/replay", root, nil).Code)
+	c.do(http.MethodPost, "/admin/webhooks/"+webhook.ID+"/deliveries/nope/replay", root, nil)
+	require.Equal(t, http.StatusNoContent, c.do(http.MethodDelete, "/admin/webhooks/"+webhook.ID, root, nil).Code)
+
+	// jobs
+	rec = c.do(http.MethodGet, "/admin/jobs?status=succeeded&limit=10", root, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var jobs []struct {
+		ID string `json:"id"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&jobs))
+	require.NotEmpty(t, jobs)
+	c.doInvalid(http.MethodGet, "/admin/jobs?status=lost", root, nil)
+	require.Equal(t, http.StatusOK, c.do(http.MethodGet, "/admin/jobs/"+jobs[0].ID, root, nil).Code)
+	c.do(http.MethodGet, "/admin/jobs/nope", root, nil)
+	require.Equal(t, http.StatusConflict, c.do(http.MethodPost, "/admin/jobs/"+jobs[0].ID+"/retry", root, nil).Code)
+	c.do(http.MethodDelete, "/admin/webhooks/"+webhook.ID, root, nil)
+	c.do(http.MethodDelete, "/admin/users/alice", root, nil)
+	c.do(http.MethodDelete, "/admin/users/root", root, nil)
+
+	require.Equal(t, http.StatusNoContent, c.do(http.MethodDelete, "/private/sessions/current", root, nil).Code)
+	c.do(http.MethodDelete, "/private/sessions/current", root, nil)
+
+	assert.Empty(t, c.uncovered(), "every operation of the spec is checked against its handler")
+}
+
+func TestOpenAPI_Served(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doRequest(s, http.MethodGet, "/api/v1/openapi.json", "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, string(api.OpenAPI), rec.Body.String())
+
+	rec = doRequest(s, http.MethodGet, "/api/v1/docs", "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	assert.Contains(t, body, "<code>/api/v1/private/keys/{id}</code>")
+	assert.Contains(t, body, "Register a user and start a session")
+	assert.Contains(t, body, `href="/api/v1/openapi.json"`)
+}
+
+func TestAPI_LegacyPaths(t *testing.T) {
+	s := newTestServer(t)
+	bearer := "Bearer " + registerUser(t, s, "alice")
+
+	rec := doRequest(s, http.MethodGet, "/private/whoami", bearer, nil)
+	require.Equal(t, http.StatusOK, rec.Code, "the unversioned paths still work")
+	assert.Equal(t, "@1792368000", rec.Header().Get("Deprecation"))
+	assert.Equal(t, `</api/v1/private/whoami>; rel="successor-version"`, rec.Header().Get("Link"))
+
+	rec = doRequest(s, http.MethodGet, "/api/v1/private/whoami", bearer, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Deprecation"))
+
+	rec = doRequest(s, http.MethodGet, "/.well-known/openid-configuration", "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Deprecation"), "the OAuth endpoints aren't versioned")
+
+	rec = doRequest(s, http.MethodGet, "/api/v1/nope", "", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Empty(t, rec.Header().Get("Deprecation"))
+}
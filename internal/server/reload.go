@@ -0,0 +1,353 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"vox-server/internal/models"
+)
+
+const (
+	ReloadResultApplied   = "applied"
+	ReloadResultUnchanged = "unchanged"
+	ReloadResultFailed    = "failed"
+)
+
+// ConfigSubscriber is a subsystem applying a reloaded config. PrepareConfig checks the
+// config and builds the new state without applying it, the returned commit applies it.
+// A reload commits only when every concerned subscriber prepared, so that a bad value
+// doesn't leave the subsystems disagreeing
+type ConfigSubscriber interface {
+	PrepareConfig(cfg *Config) (commit func(), err error)
+}
+
+// ConfigSubscriberFunc adapts a function to a ConfigSubscriber
+type ConfigSubscriberFunc func(cfg *Config) (func(), error)
+
+func (f ConfigSubscriberFunc) PrepareConfig(cfg *Config) (func(), error) {
+	return f(cfg)
+}
+
+// ReloadStatus is the outcome of a reload. Changes of keys no subscriber
+// applies are ignored until the next restart
+type ReloadStatus struct {
+	Time            time.Time `json:"time"`
+	Result          string    `json:"result"`
+	Error           string    `json:"error,omitempty"`
+	Applied         []string  `json:"applied,omitempty"`
+	RestartRequired []string  `json:"restart_required,omitempty"`
+}
+
+type configSubscription struct {
+	name       string
+	keys       []string
+	subscriber ConfigSubscriber
+}
+
+// handles reports whether the subscription applies the key, a key covers the keys nested in it
+func (subscription configSubscription) handles(key string) bool {
+	for _, k := range subscription.keys {
+		if key == k || strings.HasPrefix(key, k+".") {
+			return true
+		}
+	}
+	return false
+}
+
+type configReloader struct {
+	// serializes the reloads
+	mu sync.Mutex
+
+	load          func() (*Config, error)
+	applied       *Config
+	subscriptions []configSubscription
+	last          *ReloadStatus
+
+	// the version of the config file when the server started, WatchConfig looks for changes of it
+	modTime time.Time
+	size    int64
+}
+
+func newConfigReloader(config *Config) *configReloader {
+	reloader := &configReloader{
+		load:    func() (*Config, error) { return LoadConfig(config.File()) },
+		applied: config,
+	}
+	reloader.modTime, reloader.size = statConfigFile(config.File())
+
+	return reloader
+}
+
+func statConfigFile(path string) (time.Time, int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, -1
+	}
+	return info.ModTime(), info.Size()
+}
+
+// SubscribeConfig lets a subsystem apply changes of the keys (e.g. "log.level") without
+// a restart. The subscriber is prepared when one of its keys changed
+func (server *Server) SubscribeConfig(name string, keys []string, subscriber ConfigSubscriber) {
+	server.reloader.mu.Lock()
+	defer server.reloader.mu.Unlock()
+
+	server.reloader.subscriptions = append(server.reloader.subscriptions, configSubscription{
+		name:       name,
+		keys:       keys,
+		subscriber: subscriber,
+	})
+}
+
+// subscribeConfig subscribes the subsystems of the server itself
+func (server *Server) subscribeConfig() {
+	server.SubscribeConfig("log", []string{"log.level", "log.sampling"}, ConfigSubscriberFunc(func(cfg *Config) (func(), error) {
+		level, err := newLogLevel(cfg.Env, cfg.Log.Level)
+		if err != nil {
+			return nil, err
+		}
+
+		sampler, err := newLogSampler(cfg.Log.Sampling)
+		if err != nil {
+			return nil, err
+		}
+
+		return func() {
+			server.logLevel.Set(level.Level())
+			server.logSampler.Store(sampler)
+		}, nil
+	}))
+
+	server.SubscribeConfig("cors", []string{"cors"}, ConfigSubscriberFunc(func(cfg *Config) (func(), error) {
+		policy, err := newCORSPolicy(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return func() { server.corsPolicy.Store(policy) }, nil
+	}))
+
+	server.SubscribeConfig("health", []string{"health.timeout"}, ConfigSubscriberFunc(func(cfg *Config) (func(), error) {
+		return func() { server.health.SetTimeout(cfg.Health.Timeout) }, nil
+	}))
+
+	server.SubscribeConfig("introspection", []string{"oauth.introspection_cache_ttl"}, ConfigSubscriberFunc(func(cfg *Config) (func(), error) {
+		return func() { server.introspections.SetTTL(cfg.OAuth.IntrospectionCacheTTL) }, nil
+	}))
+}
+
+// ReloadConfig reads the config file again and applies the changed keys of the subscribers.
+// Changes of the other keys are logged and ignored, the config the server started with stays
+// in effect for them. Nothing is applied when the config is invalid or a subscriber fails
+func (server *Server) ReloadConfig() (*ReloadStatus, error) {
+	reloader := server.reloader
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+
+	status, err := server.reload(reloader)
+	reloader.last = status
+
+	server.metrics.configReloads.WithLabelValues(status.Result).Inc()
+	if err != nil {
+		server.logger.Error("Config reload failed, keeping the current config", "error", err)
+		return status, err
+	}
+
+	server.metrics.configReloadSuccess.Set(float64(status.Time.Unix()))
+	server.metrics.configPendingKeys.Set(float64(len(status.RestartRequired)))
+
+	if len(status.Applied) > 0 {
+		server.logger.Info("Config is reloaded", "applied", status.Applied)
+	}
+	return status, nil
+}
+
+func (server *Server) reload(reloader *configReloader) (*ReloadStatus, error) {
+	status := &ReloadStatus{Time: time.Now(), Result: ReloadResultFailed}
+
+	cfg, err := reloader.load()
+	if err != nil {
+		status.Error = err.Error()
+		return status, err
+	}
+
+	// compared to the startup config, so that they are reported until the restart
+	var pending []configChange
+	for _, change := range diffConfig(server.config, cfg) {
+		if !slices.ContainsFunc(reloader.subscriptions, func(s configSubscription) bool { return s.handles(change.Key) }) {
+			pending = append(pending, change)
+			status.RestartRequired = append(status.RestartRequired, change.Key)
+		}
+	}
+	if len(pending) > 0 {
+		diff := make([]string, len(pending))
+		for i, change := range pending {
+			diff[i] = change.String()
+		}
+		server.logger.Warn("Config changes need a restart, they are ignored", "diff", diff)
+	}
+
+	var changed []string
+	for _, change := range diffConfig(reloader.applied, cfg) {
+		if !slices.Contains(status.RestartRequired, change.Key) {
+			changed = append(changed, change.Key)
+		}
+	}
+
+	var commits []func()
+	var errs []error
+	for _, subscription := range reloader.subscriptions {
+		if !slices.ContainsFunc(changed, subscription.handles) {
+			continue
+		}
+
+		commit, err := subscription.subscriber.PrepareConfig(cfg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", subscription.name, err))
+			continue
+		}
+		commits = append(commits, commit)
+	}
+	if err := errors.Join(errs...); err != nil {
+		status.Error = err.Error()
+		return status, err
+	}
+
+	for _, commit := range commits {
+		commit()
+	}
+	reloader.applied = cfg
+
+	status.Result = ReloadResultUnchanged
+	if len(changed) > 0 {
+		status.Result = ReloadResultApplied
+		status.Applied = changed
+	}
+	return status, nil
+}
+
+// configChange is a changed key, with the values of the redacted configs so that it can be logged
+type configChange struct {
+	Key      string
+	Old, New any
+}
+
+func (change configChange) String() string {
+	return fmt.Sprintf("%s: %v -> %v", change.Key, change.Old, change.New)
+}
+
+// diffConfig lists the changed keys of the config file, the leaves of nested sections
+func diffConfig(old, new *Config) []configChange {
+	var changes []configChange
+	walkConfig("", reflect.ValueOf(*old), reflect.ValueOf(*new), func(key string, _, _ reflect.Value) {
+		changes = append(changes, configChange{Key: key, Old: redacted, New: redacted})
+	})
+
+	// a changed secret has the same redacted value, it keeps the placeholder
+	values := map[string]configChange{}
+	walkConfig("", reflect.ValueOf(*old.Redacted()), reflect.ValueOf(*new.Redacted()), func(key string, a, b reflect.Value) {
+		values[key] = configChange{Key: key, Old: a.Interface(), New: b.Interface()}
+	})
+	for i, change := range changes {
+		if value, ok := values[change.Key]; ok {
+			changes[i] = value
+		}
+	}
+
+	return changes
+}
+
+func walkConfig(prefix string, a, b reflect.Value, changed func(key string, a, b reflect.Value)) {
+	if a.Kind() != reflect.Struct {
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			changed(prefix, a, b)
+		}
+		return
+	}
+
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || name == "" || name == "-" {
+			continue
+		}
+
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		walkConfig(key, a.Field(i), b.Field(i), changed)
+	}
+}
+
+// WatchConfig reloads the config on the signals and, with a watch interval, when the
+// config file changes, until the context is done. RunServer watches SIGHUP
+func (server *Server) WatchConfig(ctx context.Context, signals <-chan os.Signal) {
+	var changes <-chan time.Time
+	file := server.config.File()
+	if interval := server.config.Reload.WatchInterval; interval > 0 && file != "" {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		changes = ticker.C
+	}
+
+	modTime, size := server.reloader.modTime, server.reloader.size
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			server.logger.Info("Reloading the config on signal", "file", file)
+		case <-changes:
+			// editors and mounted volumes replace the file, so its identity isn't compared
+			t, n := statConfigFile(file)
+			if t.Equal(modTime) && n == size {
+				continue
+			}
+			modTime, size = t, n
+			server.logger.Info("Reloading the changed config", "file", file)
+		}
+
+		if status, err := server.ReloadConfig(); err == nil && len(status.Applied) > 0 {
+			server.AuditOperator(models.AuditConfigReloaded, "system", file, map[string]any{"applied": status.Applied})
+		}
+	}
+}
+
+// handleAdminConfigReload reports the last reload, POST reloads the config
+func (server *Server) handleAdminConfigReload() http.HandlerFunc {
+	type response struct {
+		File       string        `json:"file,omitempty"`
+		LastReload *ReloadStatus `json:"last_reload"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			admin := r.Context().Value(userContextKey).(*models.User)
+
+			status, err := server.ReloadConfig()
+			if err != nil {
+				server.error(w, r, http.StatusUnprocessableEntity, err)
+				return
+			}
+
+			if len(status.Applied) > 0 {
+				server.audit(r, models.AuditConfigReloaded, admin.Login, server.config.File(), map[string]any{"applied": status.Applied})
+			}
+		}
+
+		server.reloader.mu.Lock()
+		resp := response{File: server.config.File(), LastReload: server.reloader.last}
+		server.reloader.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		server.respond(w, r, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,166 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const reloadConfig = `
+env: local
+address: :8085
+log:
+  level: info
+health:
+  timeout: 2s
+`
+
+// newReloadableServer serves the config written to a file, and an admin token
+func newReloadableServer(t *testing.T, content string) (*server.Server, string, string) {
+	t.Helper()
+	t.Setenv(server.ConfigEnvVar, "")
+
+	path := writeFile(t, "config.yaml", content)
+	cfg, err := server.LoadConfig(path)
+	require.NoError(t, err)
+
+	s, err := server.NewInMemoryServer(cfg)
+	require.NoError(t, err)
+
+	token := registerUser(t, s, "admin")
+	promote(t, s, "admin")
+
+	return s, path, "Bearer " + token
+}
+
+func logLevel(t *testing.T, s http.Handler, auth string) string {
+	t.Helper()
+
+	rec := doRequest(s, http.MethodGet, "/admin/log/level", auth, nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var resp struct {
+		Level string `json:"level"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return resp.Level
+}
+
+func TestConfigReload(t *testing.T) {
+	s, path, auth := newReloadableServer(t, reloadConfig)
+	assert.Equal(t, "INFO", logLevel(t, s, auth))
+
+	status, err := s.ReloadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, server.ReloadResultUnchanged, status.Result)
+
+	// case : reloadable keys are applied, the others wait for a restart
+	require.NoError(t, os.WriteFile(path, []byte(`
+env: local
+address: :9999
+db:
+  password: changed
+log:
+  level: warn
+  sampling:
+    /healthz: 10
+health:
+  timeout: 2s
+`), 0o600))
+
+	status, err = s.ReloadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, server.ReloadResultApplied, status.Result)
+	assert.Equal(t, []string{"log.level", "log.sampling"}, status.Applied)
+	assert.Equal(t, []string{"address", "db.password", "database_url", "test_database_url"}, status.RestartRequired)
+	assert.Equal(t, "WARN", logLevel(t, s, auth))
+
+	// case : an invalid config changes nothing
+	require.NoError(t, os.WriteFile(path, []byte("log:\n  level: loud\n"), 0o600))
+	status, err = s.ReloadConfig()
+	require.Error(t, err)
+	assert.Equal(t, server.ReloadResultFailed, status.Result)
+	assert.Contains(t, status.Error, "log.level")
+	assert.Equal(t, "WARN", logLevel(t, s, auth))
+
+	// case : a failing subscriber keeps the others from applying the config
+	s.SubscribeConfig("audit", []string{"audit.buffer_size"}, server.ConfigSubscriberFunc(func(*server.Config) (func(), error) {
+		return nil, errors.New("can't resize")
+	}))
+	require.NoError(t, os.WriteFile(path, []byte(reloadConfig+"audit:\n  buffer_size: 16\n"), 0o600))
+	_, err = s.ReloadConfig()
+	assert.EqualError(t, err, "audit: can't resize")
+	assert.Equal(t, "WARN", logLevel(t, s, auth))
+
+	rec := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `vox_config_reloads_total{result="applied"} 1`)
+	assert.Contains(t, rec.Body.String(), `vox_config_reloads_total{result="failed"} 2`)
+	assert.Contains(t, rec.Body.String(), "vox_config_pending_restart_keys 4")
+}
+
+func TestConfigReload_Admin(t *testing.T) {
+	s, path, auth := newReloadableServer(t, reloadConfig)
+
+	rec := doRequest(s, http.MethodGet, "/admin/config/reload", auth, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"file": "`+path+`", "last_reload": null}`, rec.Body.String())
+
+	require.NoError(t, os.WriteFile(path, []byte("log:\n  level: error\n"), 0o600))
+
+	rec = doRequest(s, http.MethodPost, "/admin/config/reload", auth, nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var resp struct {
+		LastReload server.ReloadStatus `json:"last_reload"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, server.ReloadResultApplied, resp.LastReload.Result)
+	assert.Equal(t, []string{"log.level"}, resp.LastReload.Applied)
+	assert.Equal(t, "ERROR", logLevel(t, s, auth))
+
+	require.NoError(t, os.WriteFile(path, []byte("log:\n  level: loud\n"), 0o600))
+	rec = doRequest(s, http.MethodPost, "/admin/config/reload", auth, nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/admin/config/reload", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = doRequest(s, http.MethodGet, "/admin/audit?action="+models.AuditConfigReloaded, auth, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	page := auditPage{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	require.Len(t, page.Events, 1, "only the applied reload is audited")
+	assert.Equal(t, "admin", page.Events[0].Actor)
+}
+
+func TestConfigReload_Watch(t *testing.T) {
+	watched := func(level string) string {
+		return "env: local\nlog:\n  level: " + level + "\nreload:\n  watch_interval: 10ms\n"
+	}
+	s, path, auth := newReloadableServer(t, watched("info"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	go s.WatchConfig(ctx, signals)
+
+	// case : the changed file is reloaded
+	require.NoError(t, os.WriteFile(path, []byte(watched("error")), 0o600))
+	assert.Eventually(t, func() bool { return logLevel(t, s, auth) == "ERROR" }, time.Second, 10*time.Millisecond)
+
+	// case : a signal reloads the file
+	require.NoError(t, os.WriteFile(path, []byte(watched("debug")), 0o600))
+	signals <- os.Interrupt
+	assert.Eventually(t, func() bool { return logLevel(t, s, auth) == "DEBUG" }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"vox-server/internal/models"
+)
+
+// apiCSP is the policy of every response but the pages, which have nothing to run or embed
+const apiCSP = "default-src 'none'; frame-ancestors 'none'"
+
+// pageCSP lets a page run its own inline scripts and styles, the ones carrying the nonce,
+// and load Bootstrap from its CDN
+func pageCSP(nonce string) string {
+	return "default-src 'self'; " +
+		"script-src 'nonce-" + nonce + "' https://cdn.jsdelivr.net; " +
+		"style-src 'self' 'nonce-" + nonce + "' https://cdn.jsdelivr.net; " +
+		"img-src 'self' data:; " +
+		"object-src 'none'; " +
+		"base-uri 'none'; " +
+		"frame-ancestors 'none'"
+}
+
+// securityHeaders sets the headers hardening browsers against sniffing, framing and downgrades.
+// Pages replace the Content-Security-Policy with their own, see render
+func (server *Server) securityHeaders(next http.Handler) http.Handler {
+	hsts := ""
+	if server.config.Env != EnvLocal && server.config.Security.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(server.config.Security.HSTSMaxAge.Seconds())) + "; includeSubDomains"
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("Content-Security-Policy", apiCSP)
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		if hsts != "" {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// render writes a page of base.html. The inline scripts and styles of the templates
+// carry the nonce given in data, a new one for every response
+func (server *Server) render(w http.ResponseWriter, r *http.Request, data map[string]any) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		server.error(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	data["nonce"] = nonce
+
+	w.Header().Set("Content-Security-Policy", pageCSP(nonce))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := server.templates.ExecuteTemplate(w, "base.html", data); err != nil {
+		server.requestLogger(r).Error("failed to render page", "error", err)
+	}
+}
+
+// csrfField names the form field with the CSRF token, scripts can send it as the X-CSRF-Token header
+const csrfField = "csrf_token"
+
+// csrfToken is bound to the session, so a page of another site can't know it
+func (server *Server) csrfToken(session *models.Session) string {
+	mac := hmac.New(sha256.New, GetJWTKey())
+	mac.Write([]byte("csrf:" + session.ID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// protectCSRF rejects the forms posted with the session cookie but without the CSRF token
+// of the session. Requests without a valid session cookie pass, their handler rejects them
+func (server *Server) protectCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		_, session, err := server.userFromSessionCookie(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			server.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		token := r.PostForm.Get(csrfField)
+		if token == "" {
+			token = r.Header.Get("X-CSRF-Token")
+		}
+		if token == "" {
+			server.error(w, r, http.StatusForbidden, apiError(CodeCSRFFailed, errors.New("missing CSRF token")))
+			return
+		}
+		if !hmac.Equal([]byte(token), []byte(server.csrfToken(session))) {
+			server.error(w, r, http.StatusForbidden, apiError(CodeCSRFFailed, errors.New("invalid CSRF token")))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,206 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"vox-server/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func preflight(s http.Handler, path, origin, method, headers string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodOptions, path, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		req.Header.Set("Access-Control-Request-Headers", headers)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCORS(t *testing.T) {
+	cfg := &server.Config{Env: server.EnvDev}
+	cfg.CORS.AllowedOrigins = []string{"https://app.example.org"}
+	cfg.CORS.AllowedMethods = []string{"GET", "POST"}
+	cfg.CORS.AllowedHeaders = []string{"Authorization", "Content-Type"}
+	cfg.CORS.ExposedHeaders = []string{"Request-ID"}
+	cfg.CORS.AllowCredentials = true
+	cfg.CORS.MaxAge = 10 * time.Minute
+	s, err := server.NewInMemoryServer(cfg)
+	require.NoError(t, err)
+
+	// case : the preflight of an allowed origin, even to a POST-only route
+	rec := preflight(s, "/users", "https://app.example.org", "POST", "content-type, authorization")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://app.example.org", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "GET, POST", rec.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "600", rec.Header().Get("Access-Control-Max-Age"))
+
+	// case : methods, headers and origins that aren't allowed
+	rec = preflight(s, "/users", "https://app.example.org", "DELETE", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = preflight(s, "/users", "https://app.example.org", "POST", "X-Secret")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = preflight(s, "/users", "https://evil.example.org", "POST", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+
+	// case : actual requests
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set("Origin", "https://app.example.org")
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, "https://app.example.org", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Request-ID", rec.Header().Get("Access-Control-Expose-Headers"))
+	assert.Contains(t, rec.Header().Values("Vary"), "Origin")
+
+	req.Header.Set("Origin", "https://evil.example.org")
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, "the browser, not the server, blocks the response")
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORS_Defaults(t *testing.T) {
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.CORS.AllowedMethods = []string{"POST"}
+	s, err := server.NewInMemoryServer(cfg)
+	require.NoError(t, err)
+
+	rec := preflight(s, "/sessions", "http://localhost:5173", "POST", "")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"), "local allows any origin")
+
+	cfg = &server.Config{Env: server.EnvDev}
+	cfg.CORS.AllowedMethods = []string{"POST"}
+	s, err = server.NewInMemoryServer(cfg)
+	require.NoError(t, err)
+
+	rec = preflight(s, "/sessions", "http://localhost:5173", "POST", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code, "dev and prod allow no origin unless configured")
+
+	// case : configured origins match whatever their case
+	cfg.CORS.AllowedOrigins = []string{"HTTPS://App.Example.ORG"}
+	s, err = server.NewInMemoryServer(cfg)
+	require.NoError(t, err)
+	rec = preflight(s, "/sessions", "https://app.example.org", "POST", "")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://app.example.org", rec.Header().Get("Access-Control-Allow-Origin"))
+
+	cfg.CORS.AllowedOrigins = []string{"*", "ftp://files.example.org"}
+	cfg.CORS.AllowCredentials = true
+	assert.EqualError(t, cfg.Validate(), "cors: credentials can't be allowed for any origin\n"+
+		"cors: invalid origin 'ftp://files.example.org', expected e.g. https://app.example.org")
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doRequest(s, http.MethodGet, "/healthz", "", nil)
+	assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "DENY", rec.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "default-src 'none'; frame-ancestors 'none'", rec.Header().Get("Content-Security-Policy"))
+	assert.Empty(t, rec.Header().Get("Strict-Transport-Security"), "local is served over plain HTTP")
+
+	cfg := &server.Config{Env: server.EnvDev}
+	cfg.Security.HSTSMaxAge = 24 * time.Hour
+	dev, err := server.NewInMemoryServer(cfg)
+	require.NoError(t, err)
+	rec = doRequest(dev, http.MethodGet, "/healthz", "", nil)
+	assert.Equal(t, "max-age=86400; includeSubDomains", rec.Header().Get("Strict-Transport-Security"))
+}
+
+var cspNonce = regexp.MustCompile(`script-src 'nonce-([^']+)'`)
+
+func TestSecurityHeaders_PageNonce(t *testing.T) {
+	s := newTestServer(t)
+
+	nonces := map[string]bool{}
+	for _, page := range []string{"/login", "/register", "/login"} {
+		rec := doRequest(s, http.MethodGet, page, "", nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+
+		match := cspNonce.FindStringSubmatch(rec.Header().Get("Content-Security-Policy"))
+		require.Len(t, match, 2, page)
+		nonce := match[1]
+		nonces[nonce] = true
+
+		body := rec.Body.String()
+		assert.Contains(t, body, `<script nonce="`+nonce+`">`, "the inline script of %s runs", page)
+		assert.Contains(t, body, `<style nonce="`+nonce+`">`)
+		assert.NotContains(t, body, "<script>", "no inline script is blocked")
+	}
+	assert.Len(t, nonces, 3, "every response has its own nonce")
+}
+
+func TestCSRF_ConsentForm(t *testing.T) {
+	s := newTestServer(t)
+	bearer := "Bearer " + registerUser(t, s, "erin")
+	rp := newFakeRelyingParty(t)
+	rp.register(t, s, bearer, false)
+
+	login := func(login string) *http.Cookie {
+		rec := doRequest(s, http.MethodPost, "/sessions", "", map[string]string{
+			"login_or_email": login,
+			"password":       "password",
+		})
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec.Result().Cookies()[0]
+	}
+	erin := login("erin")
+	registerUser(t, s, "frank")
+	frank := login("frank")
+
+	form := url.Values{
+		"response_type": {"code"},
+		"client_id":     {rp.clientID},
+		"redirect_uri":  {rp.redirectURI()},
+		"scope":         {"openid"},
+		"state":         {"abc"},
+	}
+	consentPage := func(cookie *http.Cookie) string {
+		req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+form.Encode(), nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		return csrfFromPage(t, rec.Body.Bytes())
+	}
+	post := func(cookie *http.Cookie, token, header string) int {
+		values := url.Values{"decision": {"allow"}, "csrf_token": {token}}
+		for k, v := range form {
+			values[k] = v
+		}
+		req := httptest.NewRequest(http.MethodPost, "/oauth/authorize", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header != "" {
+			req.Header.Set("X-CSRF-Token", header)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	erinToken := consentPage(erin)
+	assert.NotEqual(t, erinToken, consentPage(frank), "tokens are bound to the session")
+
+	assert.Equal(t, http.StatusForbidden, post(erin, "", ""), "a cross-site form has no token")
+	assert.Equal(t, http.StatusForbidden, post(frank, erinToken, ""), "the token of another session")
+	assert.Equal(t, http.StatusUnauthorized, post(nil, erinToken, ""), "without the cookie the form isn't authentificated")
+	assert.Equal(t, http.StatusFound, post(erin, "", erinToken), "scripts send the header")
+	assert.Equal(t, http.StatusFound, post(erin, erinToken, ""))
+}
@@ -7,19 +7,23 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
-	"log"
 	"log/slog"
 	"net/http"
+	"os"
 	"strings"
-	"time"
+	"sync/atomic"
+	"vox-server/internal/migrator"
 	"vox-server/internal/models"
 	"vox-server/internal/storage"
+	"vox-server/internal/storage/cached_storage"
 	"vox-server/internal/storage/postgres_storage"
+	"vox-server/internal/storage/sqlite_storage"
 	"vox-server/internal/storage/test_storage"
+	"vox-server/templates"
 
 	"github.com/google/uuid"
-	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type contextKey int16
@@ -27,18 +31,56 @@ type contextKey int16
 const (
 	userContextKey contextKey = iota
 	requestIDContextKey
+	apiKeyContextKey
+	sessionContextKey
+	loggerContextKey
 )
 
 type Server struct {
-	config    *Config
-	logger    *slog.Logger
-	router    *mux.Router
-	storage   storage.Storage
-	templates *template.Template
+	config     *Config
+	logger     *slog.Logger
+	logLevel   *slog.LevelVar
+	logSampler atomic.Pointer[logSampler]
+	router     *mux.Router
+	handler    http.Handler // the router behind the middlewares it can't run
+	corsPolicy atomic.Pointer[corsPolicy]
+	storage    storage.Storage
+	templates  *template.Template
+	signingKey *signingKey
+	auditLog   *auditLog
+	metrics    *metrics
+	lifecycle  *lifecycle
+	health     *HealthRegistry
+	reloader   *configReloader
+
+	oidcProviders map[string]*oidcProvider
+	// replaced by the last signing key rotation, published in the JWKS until the next one
+	previousKey *signingKey
+
+	// answers of the token introspection, invalidated when sessions end
+	introspections *introspectionCache
+
+	// users looked up by login or email, nil when storage.user_cache is disabled
+	userCache *cached_storage.UserCache
+
+	// wakes DeliverWebhooks up when deliveries are stored
+	webhooksPublished chan struct{}
+
+	// the handlers and the schedules of the background jobs
+	jobs *jobRunner
+
+	// flushes the spans of the global tracer provider
+	shutdownTracing func(context.Context) error
 }
 
-func initDB(database_url string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", database_url)
+func initDB(driver, database_url string) (*sql.DB, error) {
+	var db *sql.DB
+	var err error
+	if driver == migrator.DriverSQLite {
+		db, err = sqlite_storage.Open(database_url)
+	} else {
+		db, err = sql.Open("postgres", database_url)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -50,79 +92,307 @@ func initDB(database_url string) (*sql.DB, error) {
 	return db, nil
 }
 
+// newDBStorage is the storage of the driver on top of the database
+func newDBStorage(driver string, db *sql.DB) storage.Storage {
+	if driver == migrator.DriverSQLite {
+		return sqlite_storage.NewDBStorage(db)
+	}
+	return postgres_storage.NewDBStorage(db)
+}
+
 func NewServerWithDB(config *Config, useTestDB bool) (*Server, error) {
-	log, err := SetupLogger(config.Env)
+	level, err := newLogLevel(config.Env, config.Log.Level)
 	if err != nil {
 		return nil, err
 	}
 
-	var db *sql.DB
-	if useTestDB {
-		db, err = initDB(config.TestDatabaseURL)
-	} else {
-		db, err = initDB(config.DatabaseURL)
+	log, err := SetupLogger(os.Stdout, config.Env, config.Log.Format, level)
+	if err != nil {
+		return nil, err
 	}
 
+	sampler, err := newLogSampler(config.Log.Sampling)
 	if err != nil {
 		return nil, err
 	}
 
-	templates := template.Must(template.ParseGlob("templates/*.html"))
+	cors, err := newCORSPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	driver := config.StorageDriver()
+	db, err := initDB(driver, config.DatabaseURLFor(useTestDB))
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := loadSigningKey(config.OAuth.SigningKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	previousKey, err := loadPreviousSigningKey(config.OAuth.SigningKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	providers, err := newOIDCProviders(config.OIDCProviders)
+	if err != nil {
+		return nil, err
+	}
+
+	store := newDBStorage(driver, db)
+
 	s := Server{
-		config:    config,
-		logger:    log,
-		router:    mux.NewRouter(),
-		storage:   postgres_storage.NewDBStorage(db),
-		templates: templates,
+		config:     config,
+		logger:     log,
+		logLevel:   level,
+		router:     mux.NewRouter(),
+		storage:    store,
+		templates:  template.Must(template.ParseFS(templates.FS, "*.html")),
+		signingKey: key,
+		auditLog:   newAuditLog(store.Audit(), log, config.Audit.BufferSize),
+		metrics:    newMetrics(),
+		lifecycle:  newLifecycle(),
+		health:     NewHealthRegistry(config.Health.Timeout),
+		reloader:   newConfigReloader(config),
+
+		oidcProviders: providers,
+		previousKey:   previousKey,
+
+		introspections: newIntrospectionCache(config.OAuth.IntrospectionCacheTTL),
+
+		webhooksPublished: make(chan struct{}, 1),
+
+		jobs: newJobRunner(),
 	}
 
+	s.cacheUsers()
+	s.logSampler.Store(sampler)
+	s.corsPolicy.Store(cors)
+	s.metrics.registerDB(db)
+	s.RegisterHealthChecker("database", HealthCheckerFunc(db.PingContext))
+	s.RegisterHealthChecker("migrations", migrationsChecker(db, driver))
+	s.OnShutdown("database", func(context.Context) error { return db.Close() })
+	s.OnShutdown("audit log", s.auditLog.Close)
+	SetJWTKey(jwtSecret(config))
+	s.subscribeConfig()
+	s.registerJobs()
 	s.configureRouter()
 
 	return &s, nil
 }
 
 func NewInMemoryServer(config *Config) (*Server, error) {
-	log, err := SetupLogger(config.Env)
+	level, err := newLogLevel(config.Env, config.Log.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	log, err := SetupLogger(os.Stdout, config.Env, config.Log.Format, level)
+	if err != nil {
+		return nil, err
+	}
+
+	sampler, err := newLogSampler(config.Log.Sampling)
+	if err != nil {
+		return nil, err
+	}
+
+	cors, err := newCORSPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := loadSigningKey(config.OAuth.SigningKeyPath)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Server{
-		config:  config,
-		logger:  log,
-		router:  mux.NewRouter(),
-		storage: test_storage.NewInMemoryStorage(),
-	}, nil
+	previousKey, err := loadPreviousSigningKey(config.OAuth.SigningKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	providers, err := newOIDCProviders(config.OIDCProviders)
+	if err != nil {
+		return nil, err
+	}
+
+	store := test_storage.NewInMemoryStorage()
+
+	s := Server{
+		config:     config,
+		logger:     log,
+		logLevel:   level,
+		router:     mux.NewRouter(),
+		storage:    store,
+		templates:  template.Must(template.ParseFS(templates.FS, "*.html")),
+		signingKey: key,
+		auditLog:   newAuditLog(store.Audit(), log, config.Audit.BufferSize),
+		metrics:    newMetrics(),
+		lifecycle:  newLifecycle(),
+		health:     NewHealthRegistry(config.Health.Timeout),
+		reloader:   newConfigReloader(config),
+
+		oidcProviders: providers,
+		previousKey:   previousKey,
+
+		introspections: newIntrospectionCache(config.OAuth.IntrospectionCacheTTL),
+
+		webhooksPublished: make(chan struct{}, 1),
+
+		jobs: newJobRunner(),
+	}
+
+	s.cacheUsers()
+	s.logSampler.Store(sampler)
+	s.corsPolicy.Store(cors)
+	s.OnShutdown("audit log", s.auditLog.Close)
+	SetJWTKey(jwtSecret(config))
+	s.subscribeConfig()
+	s.registerJobs()
+	s.configureRouter()
+
+	return &s, nil
+}
+
+// Storage gives management tasks (e.g. promoting the first admin) access to the store
+func (server *Server) Storage() storage.Storage {
+	return server.storage
+}
+
+// cacheUsers puts the user cache in front of the storage when it's enabled, handlers see no difference
+func (server *Server) cacheUsers() {
+	cfg := server.config.Storage.UserCache
+	if !cfg.Enabled {
+		return
+	}
+
+	server.userCache = cached_storage.NewUserCache(cached_storage.UserCacheOptions{
+		Size:        cfg.Size,
+		TTL:         cfg.TTL,
+		NegativeTTL: cfg.NegativeTTL,
+		Observe: func(result string) {
+			server.metrics.userLookups.WithLabelValues(result).Inc()
+		},
+	})
+	server.storage = cached_storage.NewStorage(server.storage, server.userCache)
+}
+
+// SetUserCacheNotifier shares the invalidations of the user cache with the other replicas,
+// it does nothing when the cache is disabled
+func (server *Server) SetUserCacheNotifier(notifier cached_storage.Notifier) {
+	if server.userCache != nil {
+		server.userCache.SetNotifier(notifier)
+	}
+}
+
+// store returns the storage running its queries in the context, e.g. of a request
+func (server *Server) store(ctx context.Context) storage.Storage {
+	return server.storage.WithContext(ctx)
 }
 
 func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	server.router.ServeHTTP(w, r)
+	server.handler.ServeHTTP(w, r)
 }
 
 func (server *Server) configureRouter() {
+	server.router.Use(server.traceRequest)
 	server.router.Use(server.setRequestID)
+	server.router.Use(server.measureRequest)
 	server.router.Use(server.logRequest)
-	server.router.Use(handlers.CORS(handlers.AllowedOrigins([]string{"*"}))) // any domain can make requests to your server
+	server.router.Use(server.limitBody)
+	server.handler = server.securityHeaders(server.cors(server.router))
+
+	// the middlewares don't run for the requests no route matches
+	server.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.error(w, r, http.StatusNotFound, fmt.Errorf("no route for '%s'", r.URL.Path))
+	})
+	server.router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.error(w, r, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed on '%s'", r.Method, r.URL.Path))
+	})
 
 	server.router.PathPrefix("/static/").Handler(http.StripPrefix("/static/",
 		http.FileServer(http.Dir("./static"))))
 
+	// probes of the orchestrator, also served by the admin listener
+	server.router.HandleFunc("/healthz", server.handleHealthz()).Methods("GET")
+	server.router.HandleFunc("/readyz", server.handleReadyz()).Methods("GET")
+
 	// HTML routes
 	server.router.HandleFunc("/login", server.handleLoginPage()).Methods("GET")
 	server.router.HandleFunc("/register", server.handleRegisterPage()).Methods("GET")
 
-	// API routes
-	server.router.HandleFunc("/users", server.handleUsersCreate()).Methods("POST")
-	server.router.HandleFunc("/sessions", server.handleSessionsCreate()).Methods("POST")
-
-	private := server.router.PathPrefix("/private").Subrouter()
-	private.Use(server.authentificateUser)
-	private.HandleFunc("/whoami", server.handleWhoAmI()).Methods("GET")
+	// OAuth 2.0 / OpenID Connect provider
+	server.router.HandleFunc("/.well-known/openid-configuration", server.handleOpenIDConfiguration()).Methods("GET")
+	server.router.HandleFunc("/.well-known/jwks.json", server.handleJWKS()).Methods("GET")
+	server.router.HandleFunc("/oauth/authorize", server.handleOAuthAuthorize()).Methods("GET")
+	server.router.Handle("/oauth/authorize", server.protectCSRF(server.handleOAuthConsent())).Methods("POST")
+	server.router.HandleFunc("/oauth/token", server.handleOAuthToken()).Methods("POST")
+	server.router.HandleFunc("/oauth/introspect", server.handleOAuthIntrospect()).Methods("POST")
+	server.router.HandleFunc("/oauth/revoke", server.handleOAuthRevoke()).Methods("POST")
+
+	// sign in with external OpenID Connect providers
+	server.router.HandleFunc("/auth/oidc/{provider}/login", server.handleOIDCLogin()).Methods("GET")
+	server.router.HandleFunc("/auth/oidc/{provider}/callback", server.handleOIDCCallback()).Methods("GET")
+
+	userinfo := server.router.PathPrefix("/oauth/userinfo").Subrouter()
+	userinfo.Use(server.authentificateUser)
+	userinfo.Handle("", server.requireScope(models.ScopeOpenID)(server.handleOAuthUserInfo())).Methods("GET", "POST")
+
+	// the API, also on its unversioned paths until the clients move to /api/v1
+	v1 := server.router.PathPrefix(apiV1Prefix).Subrouter()
+	v1.HandleFunc("/openapi.json", server.handleOpenAPI()).Methods("GET")
+	v1.HandleFunc("/docs", server.handleAPIDocs()).Methods("GET")
+	server.routeAPI(v1)
+
+	legacy := server.router.NewRoute().Subrouter()
+	legacy.Use(server.deprecated)
+	server.routeAPI(legacy)
 }
 
-func (server *Server) RunServer() error {
-	server.logger.Debug("Server is started")
-	return http.ListenAndServe(server.config.Port, server.router)
+// routeAPI registers the routes of the API, described by api/openapi.json
+func (server *Server) routeAPI(router *mux.Router) {
+	router.Handle("/users", server.idempotent(server.handleUsersCreate())).Methods("POST")
+	router.Handle("/sessions", server.idempotent(server.handleSessionsCreate())).Methods("POST")
+
+	private := router.PathPrefix("/private").Subrouter()
+	private.Use(server.authentificateUser)
+	private.Use(server.idempotent)
+	private.HandleFunc("/sessions/current", server.handleSessionsDelete()).Methods("DELETE")
+	private.Handle("/password", server.requireScope(models.ScopeProfileWrite)(server.handleUsersPasswordChange())).Methods("PUT")
+	private.Handle("/whoami", server.requireScope(models.ScopeProfileRead)(server.handleWhoAmI())).Methods("GET")
+	private.Handle("/identities", server.requireScope(models.ScopeProfileRead)(server.handleIdentitiesList())).Methods("GET")
+	private.Handle("/keys", server.requireScope(models.ScopeKeysRead)(server.handleAPIKeysList())).Methods("GET")
+	private.Handle("/keys", server.requireScope(models.ScopeKeysWrite)(server.handleAPIKeysCreate())).Methods("POST")
+	private.Handle("/keys/{id}", server.requireScope(models.ScopeKeysWrite)(server.handleAPIKeysRevoke())).Methods("DELETE")
+	private.Handle("/oauth/clients", server.requireScope(models.ScopeClientsRead)(server.handleOAuthClientsList())).Methods("GET")
+	private.Handle("/oauth/clients", server.requireScope(models.ScopeClientsWrite)(server.handleOAuthClientsCreate())).Methods("POST")
+	private.Handle("/oauth/clients/{id}", server.requireScope(models.ScopeClientsWrite)(server.handleOAuthClientsDelete())).Methods("DELETE")
+
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.Use(server.authentificateUser)
+	admin.Use(server.requireAdmin)
+	admin.Use(server.requireScope(models.ScopeAdmin))
+	admin.Use(server.idempotent)
+	admin.HandleFunc("/audit", server.handleAdminAudit()).Methods("GET")
+	admin.HandleFunc("/audit/export", server.handleAdminAuditExport()).Methods("GET")
+	admin.HandleFunc("/users/{login}/role", server.handleAdminUsersRole()).Methods("PUT")
+	admin.HandleFunc("/users/{login}", server.handleAdminUsersDelete()).Methods("DELETE")
+	admin.HandleFunc("/webhooks", server.handleAdminWebhooksList()).Methods("GET")
+	admin.HandleFunc("/webhooks", server.handleAdminWebhooksCreate()).Methods("POST")
+	admin.HandleFunc("/webhooks/{id}", server.handleAdminWebhooksGet()).Methods("GET")
+	admin.HandleFunc("/webhooks/{id}", server.handleAdminWebhooksUpdate()).Methods("PATCH")
+	admin.HandleFunc("/webhooks/{id}", server.handleAdminWebhooksDelete()).Methods("DELETE")
+	admin.HandleFunc("/webhooks/{id}/deliveries", server.handleAdminWebhookDeliveries()).Methods("GET")
+	admin.HandleFunc("/webhooks/{id}/deliveries/{delivery}/replay", server.handleAdminWebhookReplay()).Methods("POST")
+	admin.HandleFunc("/jobs", server.handleAdminJobsList()).Methods("GET")
+	admin.HandleFunc("/jobs/{id}", server.handleAdminJobsGet()).Methods("GET")
+	admin.HandleFunc("/jobs/{id}/retry", server.handleAdminJobsRetry()).Methods("POST")
+	admin.HandleFunc("/log/level", server.handleAdminLogLevel()).Methods("GET", "PUT")
+	admin.HandleFunc("/config/reload", server.handleAdminConfigReload()).Methods("GET", "POST")
 }
 
 func StartServer(useTestDB bool) (*Server, error) {
@@ -131,7 +401,18 @@ func StartServer(useTestDB bool) (*Server, error) {
 		return nil, err
 	}
 
-	if err = ConfigurationDBs(cfg); err != nil {
+	return StartServerWithConfig(cfg, useTestDB)
+}
+
+// StartServerWithConfig prepares the database as the config allows and builds the server
+func StartServerWithConfig(cfg *Config, useTestDB bool) (*Server, error) {
+	databaseURL := cfg.DatabaseURLFor(useTestDB)
+	if err := createDatabase(context.Background(), cfg, databaseURL); err != nil {
+		return nil, err
+	}
+
+	shutdownTracing, err := SetupTracing(context.Background(), cfg, nil)
+	if err != nil {
 		return nil, err
 	}
 
@@ -139,38 +420,85 @@ func StartServer(useTestDB bool) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
+	s.shutdownTracing = shutdownTracing
+
+	if err := s.migrateDatabase(databaseURL); err != nil {
+		return nil, errors.Join(err, s.Close(context.Background()))
+	}
 
 	return s, nil
 }
 
+// limitBody bounds the bodies of requests, reading beyond the limit fails with *http.MaxBytesError
+func (server *Server) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limit := int64(server.config.HTTP.MaxBodyBytes); limit > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (server *Server) setRequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := uuid.New().String()
+		// the id of the trace links the request to its spans
+		if span := trace.SpanContextFromContext(r.Context()); span.IsValid() {
+			id = span.TraceID().String()
+		}
+
 		w.Header().Set("Request-ID", id)
 		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey, id)))
 	})
 }
 
-func (server *Server) logRequest(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		rw := &responseWriter{w, http.StatusOK}
-		next.ServeHTTP(rw, r)
-		end := time.Now()
-
-		log.Printf(
-			"\nREQUEST:\n    req_id=%s\n    method=%s\n    status_code=%d\n    status_msg=%s\n    path=%s\n    remote_addr=%s\n    start=%s\n    end=%s\n    took=%v\n",
-			r.Context().Value(requestIDContextKey),
-			r.Method,
-			rw.code,
-			http.StatusText(rw.code),
-			r.URL.Path,
-			r.RemoteAddr,
-			start,
-			end,
-			end.Sub(start),
-		)
-	})
+// authentificate resolves the user of an authorization header, 'Bearer <token>' or 'Bot <key>',
+// and puts them in the context. The HTTP middleware and the gRPC interceptor share it
+func (server *Server) authentificate(ctx context.Context, header string) (context.Context, error) {
+	parts := strings.Split(header, " ")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid authorization header format")
+	}
+
+	var u *models.User
+
+	switch strings.ToLower(parts[0]) {
+	case "bearer":
+		user, session, err := server.authentificateToken(ctx, parts[1])
+		if err != nil {
+			return nil, server.authFailure("bearer", "invalid or expired token", err)
+		}
+
+		u = user
+		ctx = context.WithValue(ctx, sessionContextKey, session)
+	case "bot":
+		key, err := server.authentificateAPIKey(ctx, parts[1])
+		if err != nil {
+			return nil, server.authFailure("bot", "invalid or revoked api key", err)
+		}
+
+		u, err = server.store(ctx).Users().FindByLogin(key.UserLogin)
+		if err != nil {
+			return nil, server.authFailure("bot", "invalid or revoked api key", lookupError("user", err))
+		}
+
+		ctx = context.WithValue(ctx, apiKeyContextKey, key)
+	default:
+		return nil, fmt.Errorf("invalid authorization header format")
+	}
+
+	return context.WithValue(ctx, userContextKey, u), nil
+}
+
+// authFailure is the error of a rejected credential, its detail is fixed whatever the cause.
+// A failed lookup doesn't reject the credential, it is an internal error
+func (server *Server) authFailure(scheme, detail string, err error) *APIError {
+	if errors.Is(err, errLookupFailed) {
+		return &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: "internal error", Err: err}
+	}
+
+	server.metrics.authFailures.WithLabelValues(scheme).Inc()
+	return &APIError{Code: CodeInvalidToken, Detail: detail, Err: err}
 }
 
 func (server *Server) authentificateUser(next http.Handler) http.Handler {
@@ -183,33 +511,14 @@ func (server *Server) authentificateUser(next http.Handler) http.Handler {
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			server.error(w, r, http.StatusUnauthorized, fmt.Errorf("invalid authorization header format"))
-			return
-		}
-
-		tokenString := parts[1]
-
-		claims, err := ValidateToken(tokenString)
-		if err != nil {
-			server.error(w, r, http.StatusUnauthorized, fmt.Errorf("invalid token: %w", err))
-			return
-		}
-
-		var u *models.User
-		if strings.Contains(claims.LoginOrEmail, "@") {
-			u, err = server.storage.Users().FindByEmail(claims.LoginOrEmail)
-		} else {
-			u, err = server.storage.Users().FindByLogin(claims.LoginOrEmail)
-		}
-
+		ctx, err := server.authentificate(r.Context(), authHeader)
 		if err != nil {
-			server.error(w, r, http.StatusUnauthorized, fmt.Errorf("invalid token: %w", err))
+			server.error(w, r, http.StatusUnauthorized, err)
 			return
 		}
 
-		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, u)))
+		setRequestLogUser(r, ctx.Value(userContextKey).(*models.User).Login)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
@@ -248,15 +557,25 @@ func (server *Server) handleUsersCreate() http.HandlerFunc {
 			Password: req.Password,
 		}
 
-		if err := server.storage.Users().Create(u); err != nil {
-			server.error(w, r, http.StatusUnprocessableEntity, err)
+		// the user and the publication of the event are committed together. Invalid fields
+		// and a taken login or email are told apart by server.error, other failures are internal
+		err := server.store(r.Context()).WithTx(r.Context(), func(tx storage.Storage) error {
+			if err := tx.Users().Create(u); err != nil {
+				return err
+			}
+			return server.PublishWebhooks(tx, models.AuditUserRegistered, u.Login, u.Login)
+		})
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
 		}
 
 		u.Sanitize()
+		server.audit(r, models.AuditUserRegistered, u.Login, u.Login, nil)
 
-		accessToken, refreshToken, err := GenerateToken(req.Login)
+		accessToken, refreshToken, err := server.createSession(w, r, u, req.Login)
 		if err != nil {
-			server.error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to generate token: %w", err))
+			server.error(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
@@ -285,7 +604,14 @@ func (server *Server) handleSessionsCreate() http.HandlerFunc {
 		}
 
 		if req.LoginOrEmail == "" || req.Password == "" {
-			server.error(w, r, http.StatusBadRequest, errors.New("login/email and password are required"))
+			problem := &APIError{Code: CodeValidationFailed, Detail: "login/email and password are required"}
+			if req.LoginOrEmail == "" {
+				problem.Fields = append(problem.Fields, FieldError{Field: "login_or_email", Code: "required", Message: "is required"})
+			}
+			if req.Password == "" {
+				problem.Fields = append(problem.Fields, FieldError{Field: "password", Code: "required", Message: "is required"})
+			}
+			server.error(w, r, http.StatusBadRequest, problem)
 			return
 		}
 
@@ -293,27 +619,40 @@ func (server *Server) handleSessionsCreate() http.HandlerFunc {
 		var err error
 
 		if strings.Contains(req.LoginOrEmail, "@") {
-			u, err = server.storage.Users().FindByEmail(req.LoginOrEmail)
+			u, err = server.store(r.Context()).Users().FindByEmail(req.LoginOrEmail)
 		} else {
-			u, err = server.storage.Users().FindByLogin(req.LoginOrEmail)
+			u, err = server.store(r.Context()).Users().FindByLogin(req.LoginOrEmail)
 		}
 
 		if err != nil {
-			server.error(w, r, http.StatusUnauthorized, errors.New("incorrect login/email or password"))
+			server.metrics.login("password", false)
+			server.audit(r, models.AuditLoginFailed, "", "", map[string]any{
+				"login_or_email": req.LoginOrEmail,
+				"reason":         "unknown user",
+			})
+			server.error(w, r, http.StatusUnauthorized, apiError(CodeInvalidCredentials, errors.New("incorrect login/email or password")))
 			return
 		}
 
-		if !u.ComparePassword(req.Password) {
-			server.error(w, r, http.StatusUnauthorized, errors.New("incorrect login/email or password"))
+		if !u.ComparePasswordContext(r.Context(), req.Password) {
+			server.metrics.login("password", false)
+			server.audit(r, models.AuditLoginFailed, "", u.Login, map[string]any{
+				"login_or_email": req.LoginOrEmail,
+				"reason":         "wrong password",
+			})
+			server.error(w, r, http.StatusUnauthorized, apiError(CodeInvalidCredentials, errors.New("incorrect login/email or password")))
 			return
 		}
 
-		accessToken, refreshToken, err := GenerateToken(req.LoginOrEmail)
+		accessToken, refreshToken, err := server.createSession(w, r, u, req.LoginOrEmail)
 		if err != nil {
-			server.error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to generate token: %w", err))
+			server.error(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
+		server.metrics.login("password", true)
+		server.audit(r, models.AuditLoginSucceeded, u.Login, u.Login, map[string]any{"method": "password"})
+
 		response := map[string]any{
 			"access_token":  accessToken,
 			"refresh_token": refreshToken,
@@ -323,31 +662,73 @@ func (server *Server) handleSessionsCreate() http.HandlerFunc {
 	}
 }
 
+func (server *Server) handleUsersPasswordChange() http.HandlerFunc {
+	type request struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(userContextKey).(*models.User)
+		if !ok || user == nil {
+			server.error(w, r, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			server.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if !user.ComparePasswordContext(r.Context(), req.CurrentPassword) {
+			server.error(w, r, http.StatusForbidden, apiError(CodeInvalidCredentials, errors.New("incorrect password")))
+			return
+		}
+
+		err := server.store(r.Context()).WithTx(r.Context(), func(tx storage.Storage) error {
+			if err := tx.Users().UpdatePassword(user.Login, req.NewPassword); err != nil {
+				return err
+			}
+			return server.PublishWebhooks(tx, models.AuditPasswordChanged, user.Login, user.Login)
+		})
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		server.introspections.InvalidateSubject(user.Login)
+
+		server.audit(r, models.AuditPasswordChanged, user.Login, user.Login, nil)
+
+		server.respond(w, r, http.StatusNoContent, nil)
+	}
+}
+
 func (s *Server) handleLoginPage() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		s.templates.ExecuteTemplate(w, "base.html", map[string]interface{}{
-			"title":    "Login",
-			"formType": "login",
+		s.render(w, r, map[string]any{
+			"title":     "Login",
+			"formType":  "login",
+			"providers": s.oidcProviderNames(),
+			"returnTo":  r.URL.Query().Get("return_to"),
 		})
 	}
 }
 
 func (s *Server) handleRegisterPage() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		s.templates.ExecuteTemplate(w, "base.html", map[string]interface{}{
+		s.render(w, r, map[string]any{
 			"title":    "Register",
 			"formType": "register",
 		})
 	}
 }
 
-// Render error
-func (server *Server) error(w http.ResponseWriter, r *http.Request, code int, err error) {
-	server.respond(w, r, code, map[string]string{"error": err.Error()})
-}
-
 // Render all types feedback
 func (server *Server) respond(w http.ResponseWriter, _ *http.Request, code int, data any) {
+	if data != nil && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 	w.WriteHeader(code)
 	if data != nil {
 		json.NewEncoder(w).Encode(data)
@@ -2,16 +2,95 @@ package server_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
 	"vox-server/internal/server"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func TestServerWithSQLite(t *testing.T) {
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.Storage.Driver = "sqlite"
+	cfg.DatabaseURL = "sqlite://" + filepath.Join(t.TempDir(), "vox.db")
+	cfg.DB.Create = true
+	cfg.DB.AutoMigrate = true
+	require.NoError(t, cfg.Validate())
+
+	s, err := server.StartServerWithConfig(cfg, false)
+	require.NoError(t, err)
+	defer s.Close(context.Background())
+
+	code, report := readyz(t, s)
+	assert.Equal(t, http.StatusOK, code, report.Err())
+	assert.Contains(t, report.Checks, "migrations")
+
+	registerUser(t, s, "alice")
+	user, err := s.Storage().Users().FindByLogin("alice")
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.org", user.Email)
+}
+
+func TestServerWithSQLite_Conflicts(t *testing.T) {
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.Storage.Driver = "sqlite"
+	cfg.DatabaseURL = "sqlite://" + filepath.Join(t.TempDir(), "vox.db")
+	cfg.DB.Create = true
+	cfg.DB.AutoMigrate = true
+
+	s, err := server.StartServerWithConfig(cfg, false)
+	require.NoError(t, err)
+	defer s.Close(context.Background())
+
+	registerUser(t, s, "alice")
+
+	// the unique constraints of sqlite are told apart from its failures, as those of postgres are
+	for name, registration := range map[string]map[string]string{
+		"taken login": {"login": "alice", "username": "alice", "email": "other@example.org", "password": "password"},
+		"taken email": {"login": "other", "username": "other", "email": "alice@example.org", "password": "password"},
+	} {
+		rec := doRequest(s, http.MethodPost, "/users", "", registration)
+		assert.Equal(t, http.StatusConflict, rec.Code, name)
+		assert.Contains(t, rec.Body.String(), `"code":"conflict"`, name)
+		assert.NotContains(t, rec.Body.String(), "UNIQUE", name)
+	}
+}
+
+func TestServerWithSQLite_StorageFailure(t *testing.T) {
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.Storage.Driver = "sqlite"
+	cfg.DatabaseURL = "sqlite://" + filepath.Join(t.TempDir(), "vox.db")
+	cfg.DB.Create = true
+	cfg.DB.AutoMigrate = true
+
+	s, err := server.StartServerWithConfig(cfg, false)
+	require.NoError(t, err)
+
+	bearer := "Bearer " + registerUser(t, s, "alice")
+	require.NoError(t, s.Close(context.Background()))
+
+	// the token may be valid, the failed lookup is not a rejection and its cause isn't written
+	rec := doRequest(s, http.MethodGet, "/private/whoami", bearer, nil)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"internal_error"`)
+	assert.NotContains(t, rec.Body.String(), "sql")
+
+	rec = doRequest(s, http.MethodPost, "/users", "", map[string]string{
+		"login":    "bob",
+		"username": "bob",
+		"email":    "bob@example.org",
+		"password": "password",
+	})
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "sql")
+}
+
 // TODO : after TestServerWithDB_* testdb should be cleared
 func /*Test*/ ServerWithDB_HandleUsersCreate(t *testing.T) {
 	s, err := server.StartServer(true)
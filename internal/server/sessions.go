@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+)
+
+// the cookie carries the access token for browser flows (e.g. /oauth/authorize)
+const sessionCookieName = "vox_session"
+
+// errLookupFailed is wrapped by the errors of the storage looking the credentials up,
+// unlike the other errors of the authentification they don't mean the credentials are wrong
+var errLookupFailed = errors.New("failed to look up the credentials")
+
+// lookupError tells an unknown record, which rejects the credentials, from a failure of the storage
+func lookupError(what string, err error) error {
+	if errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("unknown %s: %w", what, err)
+	}
+	return fmt.Errorf("%w: %w", errLookupFailed, err)
+}
+
+// activeToken validates a token issued by GenerateToken and its session
+func (server *Server) activeToken(ctx context.Context, token string) (*Claims, *models.Session, error) {
+	claims, err := ValidateToken(token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session, err := server.store(ctx).Sessions().FindByID(claims.SessionID)
+	if err != nil {
+		return nil, nil, lookupError("session", err)
+	}
+
+	if !session.IsActive(time.Now()) {
+		return nil, nil, errors.New("session is revoked or expired")
+	}
+
+	return claims, session, nil
+}
+
+// authentificateToken resolves the user behind an access token
+func (server *Server) authentificateToken(ctx context.Context, token string) (*models.User, *models.Session, error) {
+	claims, session, err := server.activeToken(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if claims.TokenType != tokenTypeAccess {
+		return nil, nil, errors.New("not an access token")
+	}
+
+	u, err := server.store(ctx).Users().FindByLogin(session.UserLogin)
+	if err != nil {
+		return nil, nil, lookupError("user", err)
+	}
+
+	return u, session, nil
+}
+
+func (server *Server) userFromSessionCookie(r *http.Request) (*models.User, *models.Session, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return server.authentificateToken(r.Context(), cookie.Value)
+}
+
+// createSession starts a first-party session, its tokens are not restricted by scopes
+func (server *Server) createSession(w http.ResponseWriter, r *http.Request, user *models.User, loginOrEmail string) (string, string, error) {
+	session := models.NewSession(user.Login, "", nil, RefreshTokenTTL)
+	if err := server.store(r.Context()).Sessions().Create(session); err != nil {
+		return "", "", fmt.Errorf("failed to create session: %w", err)
+	}
+
+	accessToken, refreshToken, err := GenerateToken(loginOrEmail, session)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	// the tokens are shown only once
+	w.Header().Set("Cache-Control", "no-store")
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    accessToken,
+		Path:     "/",
+		Expires:  accessTokenExpiry(session, time.Now()),
+		HttpOnly: true,
+		Secure:   server.config.Env != EnvLocal,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return accessToken, refreshToken, nil
+}
+
+func (server *Server) handleSessionsDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := r.Context().Value(sessionContextKey).(*models.Session)
+		if !ok || session == nil {
+			server.error(w, r, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		if err := server.store(r.Context()).Sessions().Revoke(session.ID); err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		server.introspections.InvalidateSession(session.ID)
+
+		server.audit(r, models.AuditLogout, session.UserLogin, session.UserLogin, map[string]any{"session_id": session.ID})
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookieName,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   server.config.Env != EnvLocal,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		server.respond(w, r, http.StatusNoContent, nil)
+	}
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultCertReloadInterval = time.Minute
+	selfSignedValidity        = 30 * 24 * time.Hour
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsEnabled reports whether the listeners serve TLS
+func (cfg *Config) tlsEnabled() bool {
+	return cfg.TLS.CertFile != "" || cfg.TLS.SelfSigned
+}
+
+// tlsCipherSuites resolves the names of TLS 1.2 suites, the insecure ones are refused
+func tlsCipherSuites(names []string) ([]uint16, error) {
+	var ids []uint16
+	var errs []error
+	for _, name := range names {
+		i := 0
+		suites := tls.CipherSuites()
+		for i < len(suites) && suites[i].Name != name {
+			i++
+		}
+		if i == len(suites) {
+			errs = append(errs, fmt.Errorf("cipher suite '%s' is unknown or insecure", name))
+			continue
+		}
+		ids = append(ids, suites[i].ID)
+	}
+	return ids, errors.Join(errs...)
+}
+
+// validateTLS checks the tls section and the client CA of the admin listener
+func (cfg *Config) validateTLS(check func(key string, err error)) {
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		check("tls", errors.New("cert_file and key_file are set together"))
+	} else if cfg.TLS.CertFile != "" {
+		_, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		check("tls.cert_file", err)
+	}
+
+	if cfg.TLS.SelfSigned {
+		if cfg.Env != EnvLocal {
+			check("tls.self_signed", errors.New("is for the local env only"))
+		}
+		if cfg.TLS.CertFile != "" {
+			check("tls.self_signed", errors.New("can't be combined with cert_file"))
+		}
+	}
+
+	if _, ok := tlsVersions[cfg.TLS.MinVersion]; !ok && cfg.TLS.MinVersion != "" {
+		check("tls.min_version", fmt.Errorf("must be 1.2 or 1.3, not '%s'", cfg.TLS.MinVersion))
+	}
+
+	_, err := tlsCipherSuites(cfg.TLS.CipherSuites)
+	check("tls.cipher_suites", err)
+
+	if cfg.Admin.ClientCAFile != "" {
+		if !cfg.tlsEnabled() {
+			check("admin.client_ca_file", errors.New("needs TLS, see the tls section"))
+		}
+		_, err := loadCertPool(cfg.Admin.ClientCAFile)
+		check("admin.client_ca_file", err)
+	}
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in '%s'", path)
+	}
+	return pool, nil
+}
+
+// certReloader serves the certificate of the files, and the new one once they change on disk
+type certReloader struct {
+	certFile, keyFile string
+
+	cert atomic.Pointer[tls.Certificate]
+	// the latest modification of the files when the certificate was loaded
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (reloader *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return reloader.cert.Load(), nil
+}
+
+func (reloader *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{reloader.certFile, reloader.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reload loads the files when they changed since the last load. A pair that doesn't
+// match, e.g. while the files are replaced one after the other, is retried by the next call
+func (reloader *certReloader) reload() (bool, error) {
+	modTime, err := reloader.filesModTime()
+	if err != nil {
+		return false, err
+	}
+	if reloader.cert.Load() != nil && modTime.Equal(reloader.modTime) {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	reloader.cert.Store(&cert)
+	reloader.modTime = modTime
+	return true, nil
+}
+
+// watchCertificate reloads the certificate files when they change, until the context is done
+func (server *Server) watchCertificate(ctx context.Context, reloader *certReloader) {
+	interval := server.config.TLS.ReloadInterval
+	if interval <= 0 {
+		interval = defaultCertReloadInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		reloaded, err := reloader.reload()
+		if err != nil {
+			server.logger.Warn("Failed to reload the TLS certificate, keeping the current one", "cert_file", reloader.certFile, "error", err)
+			continue
+		}
+		if reloaded {
+			leaf := reloader.cert.Load().Leaf
+			server.logger.Info("TLS certificate is reloaded", "cert_file", reloader.certFile, "subject", leaf.Subject.String(), "not_after", leaf.NotAfter)
+		}
+	}
+}
+
+// selfSignedCertificate is a certificate for localhost, generated for the local env
+func selfSignedCertificate(address string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "vox-server (self-signed)"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(selfSignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil && host != "" {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// newTLSConfig builds the TLS config of the listeners, nil when TLS is off. The reloader
+// is nil for a self-signed certificate, which doesn't change
+func (server *Server) newTLSConfig() (*tls.Config, *certReloader, error) {
+	cfg := server.config
+	if !cfg.tlsEnabled() {
+		return nil, nil, nil
+	}
+
+	suites, err := tlsCipherSuites(cfg.TLS.CipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	minVersion, ok := tlsVersions[cfg.TLS.MinVersion]
+	if !ok {
+		minVersion = tls.VersionTLS12
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: suites,
+	}
+
+	if cfg.TLS.SelfSigned {
+		cert, err := selfSignedCertificate(cfg.Port)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate a self-signed certificate: %w", err)
+		}
+
+		fingerprint := sha256.Sum256(cert.Certificate[0])
+		server.logger.Warn("Serving a self-signed certificate, for local use only",
+			"sha256", hex.EncodeToString(fingerprint[:]), "not_after", cert.Leaf.NotAfter)
+
+		tlsConfig.Certificates = []tls.Certificate{*cert}
+		return tlsConfig, nil, nil
+	}
+
+	reloader, err := newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load the TLS certificate: %w", err)
+	}
+	tlsConfig.GetCertificate = reloader.getCertificate
+
+	return tlsConfig, reloader, nil
+}
+
+// newAdminTLSConfig requires client certificates signed by the configured CA, if any
+func (server *Server) newAdminTLSConfig(tlsConfig *tls.Config) (*tls.Config, error) {
+	if tlsConfig == nil || server.config.Admin.ClientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	pool, err := loadCertPool(server.config.Admin.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the client CA of the admin listener: %w", err)
+	}
+
+	adminConfig := tlsConfig.Clone()
+	adminConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	adminConfig.ClientCAs = pool
+	return adminConfig, nil
+}
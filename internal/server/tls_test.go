@@ -0,0 +1,248 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"vox-server/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "vox test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &testCA{cert: cert, key: key, pool: pool, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue signs a certificate for 127.0.0.1, a client certificate when usage says so
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeServerCert writes a server certificate to the files, dated so that a reload notices it
+func (ca *testCA) writeServerCert(t *testing.T, certFile, keyFile, name string, modTime time.Time) {
+	t.Helper()
+
+	certPEM, keyPEM := ca.issue(t, name, x509.ExtKeyUsageServerAuth)
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+// peerName connects anew and returns the common name of the served certificate
+func peerName(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+
+	resp, err := client.Get(url + "/healthz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	return resp.TLS.PeerCertificates[0].Subject.CommonName
+}
+
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestTLS_ServeAndReload(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	ca.writeServerCert(t, certFile, keyFile, "first", time.Now().Add(-time.Minute))
+
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.TLS.CertFile = certFile
+	cfg.TLS.KeyFile = keyFile
+	cfg.TLS.MinVersion = "1.3"
+	cfg.TLS.ReloadInterval = 10 * time.Millisecond
+	_, url, stop := newServingServer(t, cfg)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: ca.pool},
+		ForceAttemptHTTP2: true,
+		DisableKeepAlives: true,
+	}}
+
+	resp, err := client.Get(url + "/healthz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "HTTP/2.0", resp.Proto)
+	assert.Equal(t, uint16(tls.VersionTLS13), resp.TLS.Version)
+	assert.Equal(t, "first", resp.TLS.PeerCertificates[0].Subject.CommonName)
+
+	// case : TLS 1.2 is refused below the minimum version
+	old := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.pool, MaxVersion: tls.VersionTLS12}}}
+	_, err = old.Get(url + "/healthz")
+	assert.Error(t, err)
+
+	// case : a key that doesn't match yet keeps the current certificate
+	second, _ := ca.issue(t, "second", x509.ExtKeyUsageServerAuth)
+	require.NoError(t, os.WriteFile(certFile, second, 0o600))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "first", peerName(t, client, url))
+
+	// case : replaced files are served without a restart
+	ca.writeServerCert(t, certFile, keyFile, "third", time.Now())
+	assert.Eventually(t, func() bool { return peerName(t, client, url) == "third" }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, stop())
+}
+
+func TestTLS_AdminMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	ca.writeServerCert(t, certFile, keyFile, "vox", time.Now())
+	caFile := writeFile(t, "ca.pem", string(ca.pem))
+
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.TLS.CertFile = certFile
+	cfg.TLS.KeyFile = keyFile
+	cfg.Admin.Address = freeAddress(t)
+	cfg.Admin.ClientCAFile = caFile
+	_, url, stop := newServingServer(t, cfg)
+	adminURL := "https://" + cfg.Admin.Address + "/metrics"
+
+	// the API needs no client certificate
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.pool}}}
+	assert.Equal(t, "vox", peerName(t, client, url))
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", cfg.Admin.Address)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, time.Second, 10*time.Millisecond, "the admin listener is up")
+
+	_, err := client.Get(adminURL)
+	assert.Error(t, err, "the admin listener requires a client certificate")
+
+	// a certificate of another CA is refused too
+	other := newTestCA(t)
+	otherCert, otherKey := other.issue(t, "intruder", x509.ExtKeyUsageClientAuth)
+	intruder, err := tls.X509KeyPair(otherCert, otherKey)
+	require.NoError(t, err)
+	rogue := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{intruder}}}}
+	_, err = rogue.Get(adminURL)
+	assert.Error(t, err)
+
+	operatorCert, operatorKey := ca.issue(t, "operator", x509.ExtKeyUsageClientAuth)
+	operator, err := tls.X509KeyPair(operatorCert, operatorKey)
+	require.NoError(t, err)
+	admin := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{operator}}}}
+	resp, err := admin.Get(adminURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.NoError(t, stop())
+}
+
+func TestTLS_SelfSigned(t *testing.T) {
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.TLS.SelfSigned = true
+	_, url, stop := newServingServer(t, cfg)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get(url + "/healthz")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	cert := resp.TLS.PeerCertificates[0]
+	assert.Contains(t, cert.DNSNames, "localhost")
+	assert.NoError(t, cert.VerifyHostname("127.0.0.1"))
+
+	require.NoError(t, stop())
+}
+
+func TestTLS_Validate(t *testing.T) {
+	cfg := &server.Config{Env: server.EnvDev}
+	cfg.TLS.CertFile = "tls.crt"
+	cfg.TLS.MinVersion = "1.0"
+	cfg.TLS.CipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+	cfg.TLS.SelfSigned = true
+	cfg.Admin.ClientCAFile = writeFile(t, "ca.pem", "not a certificate")
+
+	assert.EqualError(t, cfg.Validate(), "admin.client_ca_file: no certificate found in '"+cfg.Admin.ClientCAFile+"'\n"+
+		"tls.cipher_suites: cipher suite 'TLS_RSA_WITH_RC4_128_SHA' is unknown or insecure\n"+
+		"tls.min_version: must be 1.2 or 1.3, not '1.0'\n"+
+		"tls.self_signed: can't be combined with cert_file\n"+
+		"tls.self_signed: is for the local env only\n"+
+		"tls: cert_file and key_file are set together")
+
+	cfg = &server.Config{Env: server.EnvLocal}
+	cfg.Admin.ClientCAFile = writeFile(t, "ca.pem", string(newTestCA(t).pem))
+	assert.EqualError(t, cfg.Validate(), "admin.client_ca_file: needs TLS, see the tls section")
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TraceExporterNone = "none"
+	TraceExporterOTLP = "otlp" // OTLP over HTTP
+)
+
+// tracer is looked up on every use, so that it follows the current global provider
+func tracer() trace.Tracer {
+	return otel.Tracer("vox-server/internal/server")
+}
+
+// W3C trace context, i.e. the 'traceparent' and 'tracestate' headers
+var tracePropagator = propagation.TraceContext{}
+
+// SetupTracing installs the global tracer provider exporting spans to the exporter of the config.
+// A given exporter replaces it and gets every span as soon as it ends (e.g. tracetest.InMemoryExporter).
+// The returned function flushes and stops the export
+func SetupTracing(ctx context.Context, config *Config, exporter sdktrace.SpanExporter) (func(context.Context) error, error) {
+	var processor sdktrace.SpanProcessor
+
+	if exporter != nil {
+		processor = sdktrace.NewSimpleSpanProcessor(exporter)
+	} else {
+		switch config.Tracing.Exporter {
+		case TraceExporterNone, "":
+			return func(context.Context) error { return nil }, nil
+		case TraceExporterOTLP:
+			opts := []otlptracehttp.Option{}
+			if config.Tracing.Endpoint != "" {
+				opts = append(opts, otlptracehttp.WithEndpointURL(config.Tracing.Endpoint))
+			}
+
+			otlp, err := otlptracehttp.New(ctx, opts...)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create otlp exporter: %w", err)
+			}
+			processor = sdktrace.NewBatchSpanProcessor(otlp)
+		default:
+			return nil, fmt.Errorf("unknown trace exporter '%s'", config.Tracing.Exporter)
+		}
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("vox-server"),
+		semconv.DeploymentEnvironmentName(config.Env),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	ratio := config.Tracing.SampleRatio
+	if exporter != nil {
+		ratio = 1
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(res),
+		// a sampled caller keeps the trace complete
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// traceRequest continues the trace of the caller, if any, in a span of the handler
+func (server *Server) traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+
+		ctx := tracePropagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(remoteIP(r)),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		rw := &responseWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rw.code))
+		if rw.code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rw.code))
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"vox-server/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func findSpan(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+
+	t.Fatalf("span '%s' not found", name)
+	return tracetest.SpanStub{}
+}
+
+func TestServer_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown, err := server.SetupTracing(context.Background(), &server.Config{Env: server.EnvLocal}, exporter)
+	require.NoError(t, err)
+	defer func() {
+		shutdown(context.Background())
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	}()
+
+	s := newTestServer(t)
+
+	// case : the trace of the caller is continued
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(
+		`{"login": "alice", "username": "alice", "email": "alice@example.org", "password": "password"}`))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	// the request id is the trace id
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", rec.Header().Get("Request-ID"))
+
+	spans := exporter.GetSpans()
+	handler := findSpan(t, spans, "POST /users")
+	assert.Equal(t, trace.SpanKindServer, handler.SpanKind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", handler.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", handler.Parent.SpanID().String())
+	assert.True(t, handler.Parent.IsRemote())
+
+	hashing := findSpan(t, spans, "bcrypt.GenerateFromPassword")
+	assert.Equal(t, handler.SpanContext.SpanID(), hashing.Parent.SpanID())
+
+	// case : a new trace is started without a caller
+	exporter.Reset()
+	rec = doRequest(s, http.MethodPost, "/sessions", "", map[string]string{"login_or_email": "alice", "password": "password"})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	spans = exporter.GetSpans()
+	handler = findSpan(t, spans, "POST /sessions")
+	assert.False(t, handler.Parent.IsValid())
+	assert.Equal(t, handler.SpanContext.TraceID().String(), rec.Header().Get("Request-ID"))
+
+	attributes := map[string]string{}
+	for _, attr := range handler.Attributes {
+		attributes[string(attr.Key)] = attr.Value.Emit()
+	}
+	assert.Equal(t, "/sessions", attributes["http.route"])
+	assert.Equal(t, "200", attributes["http.response.status_code"])
+
+	comparing := findSpan(t, spans, "bcrypt.CompareHashAndPassword")
+	assert.Equal(t, handler.SpanContext.SpanID(), comparing.Parent.SpanID())
+}
@@ -0,0 +1,567 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultWebhookTimeout      = 10 * time.Second
+	defaultWebhookMaxAttempts  = 8
+	defaultWebhookBackoff      = 30 * time.Second
+	defaultWebhookMaxBackoff   = time.Hour
+	defaultWebhookDisableAfter = 5
+	defaultWebhookPollInterval = 5 * time.Second
+	defaultWebhookPageSize     = 50
+	maxWebhookPageSize         = 500
+	// deliveries claimed at once, they are sent one after another
+	webhookBatchSize = 10
+	// the answer of a receiver is only read to reuse the connection
+	maxWebhookResponseBytes = 64 << 10
+	webhookUserAgent        = "vox-webhooks/1"
+
+	jobPublishWebhooks = "webhooks.publish"
+)
+
+// webhookPolicy is the webhooks section of the config, with the defaults of its unset keys
+type webhookPolicy struct {
+	timeout      time.Duration
+	maxAttempts  int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	disableAfter int
+	pollInterval time.Duration
+}
+
+func (server *Server) webhookPolicy() webhookPolicy {
+	cfg := server.config.Webhooks
+	policy := webhookPolicy{
+		timeout:      defaultWebhookTimeout,
+		maxAttempts:  defaultWebhookMaxAttempts,
+		backoff:      defaultWebhookBackoff,
+		maxBackoff:   defaultWebhookMaxBackoff,
+		disableAfter: defaultWebhookDisableAfter,
+		pollInterval: defaultWebhookPollInterval,
+	}
+
+	if cfg.Timeout > 0 {
+		policy.timeout = cfg.Timeout
+	}
+	if cfg.MaxAttempts > 0 {
+		policy.maxAttempts = cfg.MaxAttempts
+	}
+	if cfg.Backoff > 0 {
+		policy.backoff = cfg.Backoff
+	}
+	if cfg.MaxBackoff > 0 {
+		policy.maxBackoff = cfg.MaxBackoff
+	}
+	if cfg.DisableAfter > 0 {
+		policy.disableAfter = cfg.DisableAfter
+	}
+	if cfg.PollInterval > 0 {
+		policy.pollInterval = cfg.PollInterval
+	}
+
+	return policy
+}
+
+// retryAfter is the wait before the next attempt of a delivery that failed the given times
+func (policy webhookPolicy) retryAfter(failed int) time.Duration {
+	wait := policy.backoff
+	for i := 1; i < failed && wait < policy.maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, policy.maxBackoff)
+}
+
+// webhookUserData is the data of the events about users
+type webhookUserData struct {
+	Login string `json:"login"`
+	Actor string `json:"actor,omitempty"` // empty when the user did it
+}
+
+// webhookEvent is the payload of the jobs publishing an event to the webhooks
+type webhookEvent struct {
+	Action string    `json:"action"`
+	Actor  string    `json:"actor"`
+	Target string    `json:"target"`
+	Time   time.Time `json:"time"`
+}
+
+// PublishWebhooks enqueues the publication of the event to the webhooks subscribed to it. The storage
+// is the transaction of the write the event is about, so that the event is published if and only if
+// the write is committed, also when the write is made by another process, e.g. a command of an operator
+func (server *Server) PublishWebhooks(store storage.Storage, action, actor, target string) error {
+	if !models.IsWebhookEvent(action) {
+		return nil
+	}
+
+	_, err := server.Enqueue(store, jobPublishWebhooks, webhookEvent{
+		Action: action,
+		Actor:  actor,
+		Target: target,
+		Time:   time.Now().UTC(),
+	})
+	return err
+}
+
+// runPublishWebhooks stores a delivery of the event of the job for each endpoint subscribed to it,
+// DeliverWebhooks sends them. The event is identified by the job, so that the deliveries of a job
+// run twice are deduped by the receivers. Jobs run concurrently, receivers order events by their time
+func (server *Server) runPublishWebhooks(ctx context.Context, job *models.Job) error {
+	event := webhookEvent{}
+	if err := job.Decode(&event); err != nil {
+		return err
+	}
+
+	endpoints, err := server.store(ctx).Webhooks().FindSubscribed(event.Action)
+	if err != nil {
+		return fmt.Errorf("failed to find webhooks: %w", err)
+	}
+	// endpoints receive the events that happened after they were registered
+	endpoints = slices.DeleteFunc(endpoints, func(endpoint *models.WebhookEndpoint) bool {
+		return endpoint.CreatedAt.After(event.Time)
+	})
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	data := webhookUserData{Login: event.Target}
+	if event.Actor != event.Target {
+		data.Actor = event.Actor
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook data: %w", err)
+	}
+
+	payload, err := json.Marshal(models.WebhookPayload{ID: job.ID, Type: event.Action, Time: event.Time, Data: encoded})
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	err = server.store(ctx).WithTx(ctx, func(tx storage.Storage) error {
+		for _, endpoint := range endpoints {
+			delivery := models.NewWebhookDelivery(endpoint.ID, job.ID, event.Action, payload)
+			if err := tx.WebhookDeliveries().Create(delivery); err != nil {
+				return fmt.Errorf("failed to store webhook delivery: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	server.wakeWebhooks()
+
+	return nil
+}
+
+func (server *Server) wakeWebhooks() {
+	select {
+	case server.webhooksPublished <- struct{}{}:
+	default:
+		// already woken up
+	}
+}
+
+// DeliverWebhooks sends the pending deliveries until the context is done. Failed attempts are
+// retried with exponential backoff, endpoints whose deliveries keep failing for good are disabled
+func (server *Server) DeliverWebhooks(ctx context.Context) {
+	policy := server.webhookPolicy()
+	client := &http.Client{
+		Timeout: policy.timeout,
+		// a redirect is the answer of the receiver, it isn't followed
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	ticker := time.NewTicker(policy.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		server.deliverDueWebhooks(ctx, client, policy)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-server.webhooksPublished:
+		}
+	}
+}
+
+func (server *Server) deliverDueWebhooks(ctx context.Context, client *http.Client, policy webhookPolicy) {
+	for ctx.Err() == nil {
+		// a server stopped in the middle of the batch leaves the rest to the others once the lease ends
+		now := time.Now()
+		deliveries, err := server.store(ctx).WebhookDeliveries().Claim(now, now.Add(policy.timeout*(webhookBatchSize+1)), webhookBatchSize)
+		if err != nil {
+			server.logger.Error("failed to claim webhook deliveries", "error", err)
+			return
+		}
+
+		for _, delivery := range deliveries {
+			server.deliverWebhook(ctx, client, policy, delivery)
+		}
+
+		if len(deliveries) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+// deliverWebhook makes an attempt of the delivery and stores its result
+func (server *Server) deliverWebhook(ctx context.Context, client *http.Client, policy webhookPolicy, delivery *models.WebhookDelivery) {
+	store := server.store(ctx)
+	logger := server.logger.With("delivery", delivery.ID, "event", delivery.Event, "webhook", delivery.EndpointID)
+
+	// claimed deliveries wait for the end of their lease
+	lease := *delivery.NextAttemptAt
+
+	endpoint, err := store.Webhooks().FindByID(delivery.EndpointID)
+	if err != nil {
+		// the next attempt is made once the lease ends
+		logger.Error("failed to find webhook of delivery", "error", err)
+		return
+	}
+
+	if !endpoint.IsEnabled() {
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.Error = "webhook is disabled"
+		delivery.NextAttemptAt = nil
+		storeWebhookDelivery(logger, store, delivery, lease)
+		return
+	}
+
+	code, err := sendWebhook(ctx, client, endpoint, delivery)
+	if ctx.Err() != nil {
+		// stopped in the middle of the attempt, it doesn't count
+		return
+	}
+
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.ResponseStatus = code
+	delivery.Error = ""
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	outcome := "retried"
+	switch {
+	case err == nil:
+		outcome = "succeeded"
+		delivery.Status = models.WebhookDeliverySucceeded
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+	case delivery.Attempts < policy.maxAttempts:
+		next := now.Add(policy.retryAfter(delivery.Attempts))
+		delivery.NextAttemptAt = &next
+		logger.Warn("webhook attempt failed, retrying", "attempts", delivery.Attempts, "next_attempt_at", next, "error", err)
+	default:
+		outcome = "failed"
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.NextAttemptAt = nil
+		logger.Warn("webhook delivery failed", "attempts", delivery.Attempts, "error", err)
+	}
+
+	if !storeWebhookDelivery(logger, store, delivery, lease) {
+		return
+	}
+	server.metrics.webhooks.WithLabelValues(outcome).Inc()
+
+	// the failures in a row are counted by the storage, the servers deliver concurrently
+	switch outcome {
+	case "succeeded":
+		if err := store.Webhooks().ResetFailures(endpoint.ID); err != nil {
+			logger.Error("failed to reset webhook failures", "error", err)
+		}
+	case "failed":
+		failures, err := store.Webhooks().RecordFailure(endpoint.ID)
+		if err != nil {
+			logger.Error("failed to store webhook failures", "error", err)
+			return
+		}
+		if failures < policy.disableAfter {
+			return
+		}
+
+		disabled, err := store.Webhooks().Disable(endpoint.ID, now)
+		if err != nil {
+			logger.Error("failed to disable webhook", "error", err)
+			return
+		}
+		if disabled {
+			logger.Warn("webhook is disabled", "url", endpoint.URL, "failures", failures)
+			server.AuditOperator(models.AuditWebhookDisabled, "system", endpoint.ID, map[string]any{
+				"url":      endpoint.URL,
+				"failures": failures,
+			})
+		}
+	}
+}
+
+// storeWebhookDelivery stores the result of the attempt holding the lease, reporting whether it was stored
+func storeWebhookDelivery(logger *slog.Logger, store storage.Storage, delivery *models.WebhookDelivery, lease time.Time) bool {
+	err := store.WebhookDeliveries().Update(delivery, lease)
+	switch {
+	case errors.Is(err, storage.ErrLeaseLost):
+		// the attempt outlived its lease, the delivery is another attempt's now
+		logger.Warn("webhook delivery lease was lost, the result of the attempt is dropped", "status", delivery.Status)
+		return false
+	case err != nil:
+		// the attempt is made again once the lease ends
+		logger.Error("failed to store webhook delivery", "error", err)
+		return false
+	}
+	return true
+}
+
+// sendWebhook posts the payload of the delivery signed with the secret of the endpoint.
+// Any answer but 2xx is an error
+func sendWebhook(ctx context.Context, client *http.Client, endpoint *models.WebhookEndpoint, delivery *models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", webhookUserAgent)
+	req.Header.Set("Vox-Event", delivery.Event)
+	req.Header.Set("Vox-Delivery", delivery.ID)
+	req.Header.Set("Vox-Signature", endpoint.Sign(time.Now(), delivery.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxWebhookResponseBytes))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (server *Server) handleAdminWebhooksList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoints, err := server.store(r.Context()).Webhooks().FindAll()
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		for _, endpoint := range endpoints {
+			endpoint.Sanitize()
+		}
+
+		server.respond(w, r, http.StatusOK, endpoints)
+	}
+}
+
+func (server *Server) handleAdminWebhooksCreate() http.HandlerFunc {
+	type request struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin := r.Context().Value(userContextKey).(*models.User)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			server.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		endpoint, err := models.NewWebhookEndpoint(admin.Login, req.URL, req.Events)
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, fmt.Errorf("failed to generate webhook: %w", err))
+			return
+		}
+
+		if err := server.store(r.Context()).Webhooks().Create(endpoint); err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		server.audit(r, models.AuditWebhookCreated, admin.Login, endpoint.ID, map[string]any{
+			"url":    endpoint.URL,
+			"events": endpoint.Events,
+		})
+
+		// the secret is shown only once, in this response
+		w.Header().Set("Cache-Control", "no-store")
+		server.respond(w, r, http.StatusCreated, endpoint)
+	}
+}
+
+// findWebhook answers 404 when the endpoint of the route doesn't exist
+func (server *Server) findWebhook(w http.ResponseWriter, r *http.Request) (*models.WebhookEndpoint, bool) {
+	id := mux.Vars(r)["id"]
+	endpoint, err := server.store(r.Context()).Webhooks().FindByID(id)
+	if err != nil {
+		server.error(w, r, http.StatusNotFound, fmt.Errorf("webhook '%s' not found", id))
+		return nil, false
+	}
+	return endpoint, true
+}
+
+func (server *Server) handleAdminWebhooksGet() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoint, ok := server.findWebhook(w, r)
+		if !ok {
+			return
+		}
+
+		endpoint.Sanitize()
+		server.respond(w, r, http.StatusOK, endpoint)
+	}
+}
+
+// handleAdminWebhooksUpdate changes the given fields. Enabling an endpoint again resets its failures
+func (server *Server) handleAdminWebhooksUpdate() http.HandlerFunc {
+	type request struct {
+		URL     *string  `json:"url"`
+		Events  []string `json:"events"`
+		Enabled *bool    `json:"enabled"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin := r.Context().Value(userContextKey).(*models.User)
+
+		endpoint, ok := server.findWebhook(w, r)
+		if !ok {
+			return
+		}
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			server.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if req.URL != nil {
+			endpoint.URL = *req.URL
+		}
+		if req.Events != nil {
+			endpoint.Events = req.Events
+		}
+		if req.Enabled != nil && *req.Enabled != endpoint.IsEnabled() {
+			if *req.Enabled {
+				endpoint.DisabledAt = nil
+				endpoint.Failures = 0
+			} else {
+				now := time.Now().UTC()
+				endpoint.DisabledAt = &now
+			}
+		}
+
+		if err := server.store(r.Context()).Webhooks().Update(endpoint); err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		server.audit(r, models.AuditWebhookUpdated, admin.Login, endpoint.ID, map[string]any{
+			"url":     endpoint.URL,
+			"events":  endpoint.Events,
+			"enabled": endpoint.IsEnabled(),
+		})
+
+		endpoint.Sanitize()
+		server.respond(w, r, http.StatusOK, endpoint)
+	}
+}
+
+func (server *Server) handleAdminWebhooksDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin := r.Context().Value(userContextKey).(*models.User)
+
+		endpoint, ok := server.findWebhook(w, r)
+		if !ok {
+			return
+		}
+
+		if err := server.store(r.Context()).Webhooks().Delete(endpoint.ID); err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		server.audit(r, models.AuditWebhookDeleted, admin.Login, endpoint.ID, map[string]any{"url": endpoint.URL})
+
+		server.respond(w, r, http.StatusNoContent, nil)
+	}
+}
+
+// handleAdminWebhookDeliveries lists the latest deliveries to the endpoint, newest first
+func (server *Server) handleAdminWebhookDeliveries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoint, ok := server.findWebhook(w, r)
+		if !ok {
+			return
+		}
+
+		limit := defaultWebhookPageSize
+		if v := r.URL.Query().Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxWebhookPageSize {
+				server.error(w, r, http.StatusBadRequest, fmt.Errorf("'limit' must be between 1 and %d", maxWebhookPageSize))
+				return
+			}
+		}
+
+		deliveries, err := server.store(r.Context()).WebhookDeliveries().FindByEndpoint(endpoint.ID, limit)
+		if err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		server.respond(w, r, http.StatusOK, deliveries)
+	}
+}
+
+// handleAdminWebhookReplay sends the event of a delivery again, as a new delivery
+func (server *Server) handleAdminWebhookReplay() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoint, ok := server.findWebhook(w, r)
+		if !ok {
+			return
+		}
+
+		id := mux.Vars(r)["delivery"]
+		delivery, err := server.store(r.Context()).WebhookDeliveries().FindByID(id)
+		if err != nil || delivery.EndpointID != endpoint.ID {
+			server.error(w, r, http.StatusNotFound, fmt.Errorf("delivery '%s' not found", id))
+			return
+		}
+
+		if !endpoint.IsEnabled() {
+			server.error(w, r, http.StatusConflict, errors.New("webhook is disabled, enable it to replay its deliveries"))
+			return
+		}
+
+		replay := delivery.Replay()
+		if err := server.store(r.Context()).WebhookDeliveries().Create(replay); err != nil {
+			server.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		server.wakeWebhooks()
+
+		server.respond(w, r, http.StatusAccepted, replay)
+	}
+}
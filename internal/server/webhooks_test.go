@@ -0,0 +1,337 @@
+package server_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookReceiver records the deliveries posted to it and answers them with its status
+type webhookReceiver struct {
+	*httptest.Server
+	status atomic.Int32
+
+	mu       sync.Mutex
+	received []receivedWebhook
+}
+
+type receivedWebhook struct {
+	header  http.Header
+	body    []byte
+	payload models.WebhookPayload
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	t.Helper()
+
+	receiver := &webhookReceiver{}
+	receiver.status.Store(http.StatusNoContent)
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		webhook := receivedWebhook{header: r.Header.Clone(), body: body}
+		require.NoError(t, json.Unmarshal(body, &webhook.payload))
+
+		receiver.mu.Lock()
+		receiver.received = append(receiver.received, webhook)
+		receiver.mu.Unlock()
+
+		w.WriteHeader(int(receiver.status.Load()))
+	}))
+	t.Cleanup(receiver.Close)
+
+	return receiver
+}
+
+func (receiver *webhookReceiver) deliveries() []receivedWebhook {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	return append([]receivedWebhook{}, receiver.received...)
+}
+
+func (receiver *webhookReceiver) waitFor(t *testing.T, n int) []receivedWebhook {
+	t.Helper()
+
+	require.Eventually(t, func() bool { return len(receiver.deliveries()) >= n }, 5*time.Second, 10*time.Millisecond)
+	return receiver.deliveries()
+}
+
+// newWebhookServer runs the jobs and delivers the webhooks of the server quickly, until the test ends
+func newWebhookServer(t *testing.T) *server.Server {
+	t.Helper()
+
+	cfg := &server.Config{Env: server.EnvLocal}
+	cfg.Webhooks.MaxAttempts = 3
+	cfg.Webhooks.Backoff = 10 * time.Millisecond
+	cfg.Webhooks.MaxBackoff = 20 * time.Millisecond
+	cfg.Webhooks.DisableAfter = 2
+	cfg.Webhooks.PollInterval = 10 * time.Millisecond
+	cfg.Jobs.PollInterval = 10 * time.Millisecond
+	// the events are published in order
+	cfg.Jobs.Workers = 1
+	s, err := server.NewInMemoryServer(cfg)
+	require.NoError(t, err)
+
+	// the events are published by jobs
+	ctx, cancel := context.WithCancel(context.Background())
+	var done sync.WaitGroup
+	done.Add(2)
+	go func() {
+		s.RunJobs(ctx)
+		done.Done()
+	}()
+	go func() {
+		s.DeliverWebhooks(ctx)
+		done.Done()
+	}()
+	t.Cleanup(func() {
+		cancel()
+		done.Wait()
+	})
+
+	return s
+}
+
+type webhookResponse struct {
+	ID         string     `json:"id"`
+	URL        string     `json:"url"`
+	Events     []string   `json:"events"`
+	Secret     string     `json:"secret"`
+	Failures   int        `json:"failures"`
+	DisabledAt *time.Time `json:"disabled_at"`
+}
+
+func createWebhook(t *testing.T, s http.Handler, auth, url string, events ...string) webhookResponse {
+	t.Helper()
+
+	rec := doRequest(s, http.MethodPost, "/admin/webhooks", auth, map[string]any{"url": url, "events": events})
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var webhook webhookResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&webhook))
+	return webhook
+}
+
+func getWebhook(t *testing.T, s http.Handler, auth, id string) webhookResponse {
+	t.Helper()
+
+	rec := doRequest(s, http.MethodGet, "/admin/webhooks/"+id, auth, nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var webhook webhookResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&webhook))
+	return webhook
+}
+
+func webhookDeliveries(t *testing.T, s http.Handler, auth, id string) []models.WebhookDelivery {
+	t.Helper()
+
+	rec := doRequest(s, http.MethodGet, "/admin/webhooks/"+id+"/deliveries", auth, nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var deliveries []models.WebhookDelivery
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&deliveries))
+	return deliveries
+}
+
+// verifySignature checks the Vox-Signature of a delivery the way receivers do
+func verifySignature(t *testing.T, secret string, webhook receivedWebhook) {
+	t.Helper()
+
+	parts := strings.Split(webhook.header.Get("Vox-Signature"), ",")
+	require.Len(t, parts, 2)
+	timestamp, ok := strings.CutPrefix(parts[0], "t=")
+	require.True(t, ok)
+	signature, ok := strings.CutPrefix(parts[1], "v1=")
+	require.True(t, ok)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + string(webhook.body)))
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), signature)
+}
+
+func TestWebhooks_Delivery(t *testing.T) {
+	s := newWebhookServer(t)
+	receiver := newWebhookReceiver(t)
+
+	root := "Bearer " + registerUser(t, s, "root")
+	promote(t, s, "root")
+
+	webhook := createWebhook(t, s, root, receiver.URL, models.AuditUserRegistered, models.AuditUserDeleted)
+	require.NotEmpty(t, webhook.Secret)
+
+	rec := doRequest(s, http.MethodGet, "/admin/webhooks", root, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var listed []webhookResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&listed))
+	require.Len(t, listed, 1)
+	assert.Empty(t, listed[0].Secret, "the secret is shown only once")
+
+	// the password change isn't subscribed to
+	alice := "Bearer " + registerUser(t, s, "alice")
+	rec = doRequest(s, http.MethodPut, "/private/password", alice, map[string]string{
+		"current_password": "password",
+		"new_password":     "password2",
+	})
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, http.StatusNoContent, doRequest(s, http.MethodDelete, "/admin/users/alice", root, nil).Code)
+
+	received := receiver.waitFor(t, 2)
+	require.Len(t, received, 2)
+
+	registered, deleted := received[0], received[1]
+	assert.Equal(t, models.AuditUserRegistered, registered.header.Get("Vox-Event"))
+	assert.Equal(t, "application/json", registered.header.Get("Content-Type"))
+	assert.Equal(t, models.AuditUserRegistered, registered.payload.Type)
+	assert.JSONEq(t, `{"login": "alice"}`, string(registered.payload.Data))
+	verifySignature(t, webhook.Secret, registered)
+
+	assert.Equal(t, models.AuditUserDeleted, deleted.payload.Type)
+	assert.JSONEq(t, `{"login": "alice", "actor": "root"}`, string(deleted.payload.Data))
+	assert.NotEqual(t, registered.payload.ID, deleted.payload.ID)
+	verifySignature(t, webhook.Secret, deleted)
+
+	require.Eventually(t, func() bool {
+		deliveries := webhookDeliveries(t, s, root, webhook.ID)
+		return len(deliveries) == 2 && deliveries[0].Status == models.WebhookDeliverySucceeded
+	}, 5*time.Second, 10*time.Millisecond)
+
+	deliveries := webhookDeliveries(t, s, root, webhook.ID)
+	assert.Equal(t, deleted.header.Get("Vox-Delivery"), deliveries[0].ID, "newest first")
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	assert.Equal(t, http.StatusNoContent, deliveries[0].ResponseStatus)
+	assert.NotNil(t, deliveries[0].DeliveredAt)
+	assert.Nil(t, deliveries[0].NextAttemptAt)
+}
+
+func TestWebhooks_RetriesDisableAndReplay(t *testing.T) {
+	s := newWebhookServer(t)
+	receiver := newWebhookReceiver(t)
+	receiver.status.Store(http.StatusServiceUnavailable)
+
+	root := "Bearer " + registerUser(t, s, "root")
+	promote(t, s, "root")
+	webhook := createWebhook(t, s, root, receiver.URL, models.AuditUserRegistered)
+
+	// each delivery is attempted 3 times, the endpoint is disabled after 2 failed deliveries
+	registerUser(t, s, "alice")
+	receiver.waitFor(t, 3)
+	registerUser(t, s, "bob")
+	received := receiver.waitFor(t, 6)
+
+	assert.Equal(t, received[0].payload.ID, received[2].payload.ID, "retries carry the same event")
+	assert.Equal(t, received[0].header.Get("Vox-Delivery"), received[2].header.Get("Vox-Delivery"))
+
+	require.Eventually(t, func() bool {
+		return getWebhook(t, s, root, webhook.ID).DisabledAt != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, getWebhook(t, s, root, webhook.ID).Failures)
+
+	deliveries := webhookDeliveries(t, s, root, webhook.ID)
+	require.Len(t, deliveries, 2)
+	for _, delivery := range deliveries {
+		assert.Equal(t, models.WebhookDeliveryFailed, delivery.Status)
+		assert.Equal(t, 3, delivery.Attempts)
+		assert.Equal(t, http.StatusServiceUnavailable, delivery.ResponseStatus)
+		assert.Contains(t, delivery.Error, "503")
+	}
+
+	require.NoError(t, s.Close(context.Background()))
+	events, err := s.Storage().Audit().Find(models.AuditFilter{Action: models.AuditWebhookDisabled})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, webhook.ID, events[0].Target)
+
+	// disabled endpoints get no new deliveries and no replays
+	registerUser(t, s, "carol")
+	replay := "/admin/webhooks/" + webhook.ID + "/deliveries/" + deliveries[1].ID + "/replay"
+	rec := doRequest(s, http.MethodPost, replay, root, nil)
+	require.Equal(t, http.StatusConflict, rec.Code)
+	assert.Len(t, webhookDeliveries(t, s, root, webhook.ID), 2)
+
+	receiver.status.Store(http.StatusOK)
+	rec = doRequest(s, http.MethodPatch, "/admin/webhooks/"+webhook.ID, root, map[string]bool{"enabled": true})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	enabled := getWebhook(t, s, root, webhook.ID)
+	assert.Nil(t, enabled.DisabledAt)
+	assert.Zero(t, enabled.Failures)
+
+	rec = doRequest(s, http.MethodPost, replay, root, nil)
+	require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	var replayed models.WebhookDelivery
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&replayed))
+	assert.NotEqual(t, deliveries[1].ID, replayed.ID)
+	assert.Equal(t, deliveries[1].EventID, replayed.EventID)
+
+	received = receiver.waitFor(t, 7)
+	assert.Equal(t, received[0].payload.ID, received[6].payload.ID, "the replay of the first event")
+	assert.Equal(t, replayed.ID, received[6].header.Get("Vox-Delivery"))
+}
+
+func TestWebhooks_Management(t *testing.T) {
+	s := newTestServer(t)
+	alice := "Bearer " + registerUser(t, s, "alice")
+	root := "Bearer " + registerUser(t, s, "root")
+	promote(t, s, "root")
+
+	payload := map[string]any{"url": "https://hooks.example.org/vox", "events": []string{models.AuditUserRegistered}}
+	require.Equal(t, http.StatusForbidden, doRequest(s, http.MethodPost, "/admin/webhooks", alice, payload).Code)
+
+	for _, invalid := range []map[string]any{
+		{"url": "https://hooks.example.org/vox"},
+		{"url": "https://hooks.example.org/vox", "events": []string{models.AuditLoginSucceeded}},
+		{"url": "ftp://hooks.example.org/vox", "events": []string{models.AuditUserRegistered}},
+	} {
+		rec := doRequest(s, http.MethodPost, "/admin/webhooks", root, invalid)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, "%v", invalid)
+	}
+
+	webhook := createWebhook(t, s, root, "https://hooks.example.org/vox", models.AuditUserRegistered)
+
+	rec := doRequest(s, http.MethodPatch, "/admin/webhooks/"+webhook.ID, root, map[string]any{
+		"events": []string{models.AuditUserDeleted, models.AuditPasswordChanged},
+	})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	updated := getWebhook(t, s, root, webhook.ID)
+	assert.Equal(t, webhook.URL, updated.URL)
+	assert.Equal(t, []string{models.AuditUserDeleted, models.AuditPasswordChanged}, updated.Events)
+
+	rec = doRequest(s, http.MethodPatch, "/admin/webhooks/"+webhook.ID, root, map[string]any{"url": "hooks"})
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = doRequest(s, http.MethodPatch, "/admin/webhooks/"+webhook.ID, root, map[string]bool{"enabled": false})
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotNil(t, getWebhook(t, s, root, webhook.ID).DisabledAt)
+
+	rec = doRequest(s, http.MethodGet, "/admin/webhooks/"+webhook.ID+"/deliveries?limit=0", root, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = doRequest(s, http.MethodPost, "/admin/webhooks/"+webhook.ID+"/deliveries/nope/replay", root, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	require.Equal(t, http.StatusNoContent, doRequest(s, http.MethodDelete, "/admin/webhooks/"+webhook.ID, root, nil).Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(s, http.MethodGet, "/admin/webhooks/"+webhook.ID, root, nil).Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(s, http.MethodDelete, "/admin/webhooks/"+webhook.ID, root, nil).Code)
+
+	require.NoError(t, s.Close(context.Background()))
+	for _, action := range []string{models.AuditWebhookCreated, models.AuditWebhookUpdated, models.AuditWebhookDeleted} {
+		events, err := s.Storage().Audit().Find(models.AuditFilter{Action: action, Target: webhook.ID})
+		require.NoError(t, err)
+		assert.NotEmpty(t, events, action)
+	}
+}
@@ -0,0 +1,272 @@
+package cached_storage
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+	"vox-server/internal/models"
+)
+
+// Results of the lookups passed to UserCacheOptions.Observe
+const (
+	ResultHit  = "hit"
+	ResultMiss = "miss"
+)
+
+var errLoadPanicked = errors.New("the lookup of the user panicked")
+
+// Invalidation names the users changed by a write, their entries are dropped from the caches
+type Invalidation struct {
+	Logins []string `json:"logins,omitempty"`
+	Emails []string `json:"emails,omitempty"`
+}
+
+func (invalidation Invalidation) isEmpty() bool {
+	return len(invalidation.Logins) == 0 && len(invalidation.Emails) == 0
+}
+
+// Notifier carries the invalidations between the caches of the replicas, e.g. over
+// postgres LISTEN/NOTIFY or a message bus. Without one, the writes of the other replicas
+// are seen once the entries expire
+type Notifier interface {
+	// Publish sends the invalidation of a write of this replica to the others
+	Publish(invalidation Invalidation) error
+	// Subscribe calls fn with the invalidations of the other replicas
+	Subscribe(fn func(invalidation Invalidation))
+}
+
+type UserCacheOptions struct {
+	// entries kept, the least recently used one is evicted beyond it
+	Size int
+	// found users are kept this long
+	TTL time.Duration
+	// unknown logins and emails are kept this long, 0 doesn't keep them
+	NegativeTTL time.Duration
+	// called with the result of every lookup, e.g. to count them
+	Observe func(result string)
+}
+
+type cacheEntry struct {
+	key     string
+	user    *models.User // nil for an unknown login or email
+	err     error        // the answer of the storage about an unknown one
+	expires time.Time
+}
+
+// a load of the storage the concurrent misses of the same key wait for
+type cacheCall struct {
+	done chan struct{}
+	user *models.User
+	err  error
+}
+
+// UserCache keeps the users found by login or email. Every entry of a user is indexed
+// by its login and its email, so that a write drops them all, whichever key they were found by
+type UserCache struct {
+	mu      sync.Mutex
+	options UserCacheOptions
+	lru     *list.List               // of *cacheEntry, the most recently used first
+	entries map[string]*list.Element // key -> element of lru
+	index   map[string]map[string]struct{}
+	calls   map[string]*cacheCall
+	// bumped by each invalidation, a load that started before one doesn't store its answer
+	generation uint64
+
+	notifier Notifier
+	now      func() time.Time
+}
+
+func NewUserCache(options UserCacheOptions) *UserCache {
+	return &UserCache{
+		options: options,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		index:   make(map[string]map[string]struct{}),
+		calls:   make(map[string]*cacheCall),
+		now:     time.Now,
+	}
+}
+
+func loginKey(login string) string { return "login:" + login }
+func emailKey(email string) string { return "email:" + email }
+
+// SetNotifier publishes the invalidations of this cache and applies the ones of the other replicas
+func (cache *UserCache) SetNotifier(notifier Notifier) {
+	cache.mu.Lock()
+	cache.notifier = notifier
+	cache.mu.Unlock()
+
+	notifier.Subscribe(cache.drop)
+}
+
+// Len is the number of entries, expired ones included until they are looked up or evicted
+func (cache *UserCache) Len() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.lru.Len()
+}
+
+// Invalidate drops the entries of the users and publishes the invalidation to the other replicas
+func (cache *UserCache) Invalidate(invalidation Invalidation) error {
+	if invalidation.isEmpty() {
+		return nil
+	}
+
+	cache.drop(invalidation)
+
+	cache.mu.Lock()
+	notifier := cache.notifier
+	cache.mu.Unlock()
+
+	if notifier == nil {
+		return nil
+	}
+	return notifier.Publish(invalidation)
+}
+
+func (cache *UserCache) drop(invalidation Invalidation) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.generation++
+
+	keys := make([]string, 0, len(invalidation.Logins)+len(invalidation.Emails))
+	for _, login := range invalidation.Logins {
+		keys = append(keys, loginKey(login))
+	}
+	for _, email := range invalidation.Emails {
+		keys = append(keys, emailKey(email))
+	}
+
+	for _, key := range keys {
+		for indexed := range cache.index[key] {
+			cache.remove(indexed)
+		}
+		cache.remove(key)
+		// the next misses don't wait for a load that may have read the user before the write
+		delete(cache.calls, key)
+	}
+}
+
+// load answers from the entry of the key, or from find run once for all the concurrent misses of the key
+func (cache *UserCache) load(key string, find func() (*models.User, error)) (*models.User, error) {
+	cache.mu.Lock()
+
+	if element, ok := cache.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		if cache.now().Before(entry.expires) {
+			cache.lru.MoveToFront(element)
+			cache.mu.Unlock()
+			cache.observe(ResultHit)
+			return copyUser(entry.user), entry.err
+		}
+		cache.remove(key)
+	}
+
+	if call, ok := cache.calls[key]; ok {
+		cache.mu.Unlock()
+		cache.observe(ResultMiss)
+		<-call.done
+		return copyUser(call.user), call.err
+	}
+
+	// the waiting misses get this error when find panics
+	call := &cacheCall{done: make(chan struct{}), err: errLoadPanicked}
+	cache.calls[key] = call
+	generation := cache.generation
+	cache.mu.Unlock()
+	cache.observe(ResultMiss)
+
+	defer func() {
+		cache.mu.Lock()
+		if cache.calls[key] == call {
+			delete(cache.calls, key)
+		}
+		cache.mu.Unlock()
+		close(call.done)
+	}()
+
+	user, err := find()
+	call.user, call.err = user, err
+
+	cache.mu.Lock()
+	if cache.generation == generation {
+		cache.store(key, user, err)
+	}
+	cache.mu.Unlock()
+
+	return copyUser(user), err
+}
+
+func (cache *UserCache) observe(result string) {
+	if cache.options.Observe != nil {
+		cache.options.Observe(result)
+	}
+}
+
+func (cache *UserCache) store(key string, user *models.User, err error) {
+	ttl := cache.options.TTL
+	if err != nil {
+		if !isNotFound(err) {
+			return
+		}
+		ttl = cache.options.NegativeTTL
+	}
+	if ttl <= 0 || cache.options.Size <= 0 {
+		return
+	}
+
+	cache.remove(key)
+	entry := &cacheEntry{key: key, user: copyUser(user), err: err, expires: cache.now().Add(ttl)}
+	cache.entries[key] = cache.lru.PushFront(entry)
+	if user != nil {
+		cache.indexEntry(entry, true)
+	}
+
+	for cache.lru.Len() > cache.options.Size {
+		cache.remove(cache.lru.Back().Value.(*cacheEntry).key)
+	}
+}
+
+// remove drops the entry of the key, the caller holds the lock
+func (cache *UserCache) remove(key string) {
+	element, ok := cache.entries[key]
+	if !ok {
+		return
+	}
+
+	entry := cache.lru.Remove(element).(*cacheEntry)
+	delete(cache.entries, key)
+	if entry.user != nil {
+		cache.indexEntry(entry, false)
+	}
+}
+
+// indexEntry adds or removes the entry of a user to the index of its login and of its email
+func (cache *UserCache) indexEntry(entry *cacheEntry, add bool) {
+	for _, indexKey := range []string{loginKey(entry.user.Login), emailKey(entry.user.Email)} {
+		if add {
+			if cache.index[indexKey] == nil {
+				cache.index[indexKey] = make(map[string]struct{})
+			}
+			cache.index[indexKey][entry.key] = struct{}{}
+			continue
+		}
+
+		delete(cache.index[indexKey], entry.key)
+		if len(cache.index[indexKey]) == 0 {
+			delete(cache.index, indexKey)
+		}
+	}
+}
+
+// copyUser keeps the entries from the changes of the callers
+func copyUser(user *models.User) *models.User {
+	if user == nil {
+		return nil
+	}
+	copied := *user
+	return &copied
+}
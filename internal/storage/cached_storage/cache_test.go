@@ -0,0 +1,268 @@
+package cached_storage_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+	"vox-server/internal/storage/cached_storage"
+	"vox-server/internal/storage/test_storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingUsers counts the lookups reaching the storage
+type countingUsers struct {
+	storage.UserRepository
+	finds   atomic.Int32
+	release chan struct{} // the lookups wait for it when set
+}
+
+func newCountingUsers(t *testing.T, users ...*models.User) *countingUsers {
+	repository := &countingUsers{UserRepository: test_storage.NewUserRepository()}
+	for _, user := range users {
+		assert.NoError(t, repository.Create(user))
+	}
+	return repository
+}
+
+func (repository *countingUsers) FindByLogin(login string) (*models.User, error) {
+	return repository.find(func() (*models.User, error) { return repository.UserRepository.FindByLogin(login) })
+}
+
+func (repository *countingUsers) FindByEmail(email string) (*models.User, error) {
+	return repository.find(func() (*models.User, error) { return repository.UserRepository.FindByEmail(email) })
+}
+
+func (repository *countingUsers) find(find func() (*models.User, error)) (*models.User, error) {
+	repository.finds.Add(1)
+	if repository.release != nil {
+		<-repository.release
+	}
+
+	return find()
+}
+
+func newTestUser(login string) *models.User {
+	return &models.User{Login: login, Username: login, Email: login + "@example.org", Password: "gooDPsswrA12", Role: models.RoleUser}
+}
+
+func TestUserCache_Lookups(t *testing.T) {
+	var hits, misses atomic.Int32
+	cache := cached_storage.NewUserCache(cached_storage.UserCacheOptions{
+		Size:        10,
+		TTL:         time.Minute,
+		NegativeTTL: time.Minute,
+		Observe: func(result string) {
+			if result == cached_storage.ResultHit {
+				hits.Add(1)
+			} else {
+				misses.Add(1)
+			}
+		},
+	})
+	inner := newCountingUsers(t, newTestUser("user"))
+	users := cached_storage.NewUserRepository(inner, cache)
+
+	// default case : the first lookup reaches the storage, the next ones don't
+	found, err := users.FindByLogin("user")
+	assert.NoError(t, err)
+	assert.Equal(t, "user@example.org", found.Email)
+
+	found.Role = models.RoleAdmin
+	found, err = users.FindByLogin("user")
+	assert.NoError(t, err)
+	assert.Equal(t, models.RoleUser, found.Role, "the changes of the callers aren't cached")
+	assert.Equal(t, int32(1), inner.finds.Load())
+
+	// case : lookups by email have their own entries
+	_, err = users.FindByEmail("user@example.org")
+	assert.NoError(t, err)
+	_, err = users.FindByEmail("user@example.org")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), inner.finds.Load())
+
+	// case : unknown logins are cached too
+	_, err = users.FindByLogin("unknown")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = users.FindByLogin("unknown")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.Equal(t, int32(3), inner.finds.Load())
+
+	assert.Equal(t, int32(3), hits.Load())
+	assert.Equal(t, int32(3), misses.Load())
+	assert.Equal(t, 3, cache.Len())
+}
+
+func TestUserCache_Expiration(t *testing.T) {
+	cache := cached_storage.NewUserCache(cached_storage.UserCacheOptions{Size: 10, TTL: 20 * time.Millisecond})
+	inner := newCountingUsers(t, newTestUser("user"))
+	users := cached_storage.NewUserRepository(inner, cache)
+
+	// default case : expired entries are looked up again
+	_, err := users.FindByLogin("user")
+	assert.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+	_, err = users.FindByLogin("user")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), inner.finds.Load())
+
+	// case : without a negative ttl, unknown logins aren't cached
+	_, err = users.FindByLogin("unknown")
+	assert.Error(t, err)
+	_, err = users.FindByLogin("unknown")
+	assert.Error(t, err)
+	assert.Equal(t, int32(4), inner.finds.Load())
+}
+
+func TestUserCache_Eviction(t *testing.T) {
+	cache := cached_storage.NewUserCache(cached_storage.UserCacheOptions{Size: 2, TTL: time.Minute})
+	inner := newCountingUsers(t, newTestUser("first"), newTestUser("second"), newTestUser("third"))
+	users := cached_storage.NewUserRepository(inner, cache)
+
+	for _, login := range []string{"first", "second", "first", "third"} {
+		_, err := users.FindByLogin(login)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, cache.Len())
+	assert.Equal(t, int32(3), inner.finds.Load())
+
+	// default case : the least recently used entry is evicted
+	_, err := users.FindByLogin("first")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), inner.finds.Load())
+	_, err = users.FindByLogin("second")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), inner.finds.Load())
+}
+
+func TestUserCache_ConcurrentMisses(t *testing.T) {
+	cache := cached_storage.NewUserCache(cached_storage.UserCacheOptions{Size: 10, TTL: time.Minute})
+	inner := newCountingUsers(t, newTestUser("user"))
+	inner.release = make(chan struct{})
+	users := cached_storage.NewUserRepository(inner, cache)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user, err := users.FindByLogin("user")
+			assert.NoError(t, err)
+			assert.Equal(t, "user", user.Login)
+		}()
+	}
+
+	// the lookups arriving before the release wait for the first one
+	for inner.finds.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(inner.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), inner.finds.Load())
+}
+
+func TestUserCache_Invalidate(t *testing.T) {
+	cache := cached_storage.NewUserCache(cached_storage.UserCacheOptions{Size: 10, TTL: time.Minute})
+	inner := newCountingUsers(t, newTestUser("user"))
+	users := cached_storage.NewUserRepository(inner, cache)
+
+	_, err := users.FindByLogin("user")
+	assert.NoError(t, err)
+	_, err = users.FindByEmail("user@example.org")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, cache.Len())
+
+	// default case : the invalidation of the login drops the entry found by email too
+	assert.NoError(t, cache.Invalidate(cached_storage.Invalidation{Logins: []string{"user"}}))
+	assert.Equal(t, 0, cache.Len())
+
+	// case : a load started before an invalidation isn't cached
+	inner.release = make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := users.FindByLogin("user")
+		assert.NoError(t, err)
+	}()
+	for inner.finds.Load() == 2 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.NoError(t, cache.Invalidate(cached_storage.Invalidation{Logins: []string{"user"}}))
+	close(inner.release)
+	<-done
+	assert.Equal(t, 0, cache.Len())
+}
+
+// linkedNotifiers passes the invalidations published by a cache to the other ones
+type linkedNotifiers struct {
+	mu          sync.Mutex
+	subscribers []func(cached_storage.Invalidation)
+	failed      error
+}
+
+type linkedNotifier struct {
+	links *linkedNotifiers
+	index int
+}
+
+func (links *linkedNotifiers) notifier() cached_storage.Notifier {
+	links.mu.Lock()
+	defer links.mu.Unlock()
+
+	links.subscribers = append(links.subscribers, nil)
+	return linkedNotifier{links: links, index: len(links.subscribers) - 1}
+}
+
+func (notifier linkedNotifier) Publish(invalidation cached_storage.Invalidation) error {
+	notifier.links.mu.Lock()
+	defer notifier.links.mu.Unlock()
+
+	for i, fn := range notifier.links.subscribers {
+		if i != notifier.index && fn != nil {
+			fn(invalidation)
+		}
+	}
+	return notifier.links.failed
+}
+
+func (notifier linkedNotifier) Subscribe(fn func(cached_storage.Invalidation)) {
+	notifier.links.mu.Lock()
+	defer notifier.links.mu.Unlock()
+
+	notifier.links.subscribers[notifier.index] = fn
+}
+
+func TestUserCache_Notifier(t *testing.T) {
+	links := &linkedNotifiers{}
+	inner := newCountingUsers(t, newTestUser("user"))
+
+	first := cached_storage.NewUserCache(cached_storage.UserCacheOptions{Size: 10, TTL: time.Minute})
+	first.SetNotifier(links.notifier())
+	second := cached_storage.NewUserCache(cached_storage.UserCacheOptions{Size: 10, TTL: time.Minute})
+	second.SetNotifier(links.notifier())
+
+	firstUsers := cached_storage.NewUserRepository(inner, first)
+	secondUsers := cached_storage.NewUserRepository(inner, second)
+	for _, users := range []cached_storage.UserRepository{firstUsers, secondUsers} {
+		_, err := users.FindByLogin("user")
+		assert.NoError(t, err)
+	}
+
+	// default case : a write through a replica drops the entries of the others
+	assert.NoError(t, firstUsers.UpdatePassword("user", "neWPsswrA1234"))
+	assert.Equal(t, 0, first.Len())
+	assert.Equal(t, 0, second.Len())
+
+	// case : a failed publication is reported by Invalidate
+	_, err := secondUsers.FindByLogin("user")
+	assert.NoError(t, err)
+	links.failed = errors.New("unreachable")
+	assert.ErrorIs(t, first.Invalidate(cached_storage.Invalidation{Logins: []string{"user"}}), links.failed)
+	assert.Equal(t, 0, second.Len())
+}
@@ -0,0 +1,98 @@
+package cached_storage
+
+import (
+	"errors"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+)
+
+// UserRepository answers FindByLogin and FindByEmail from the cache, its writes invalidate
+// the entries of the users. In a transaction, it reads through and invalidates once the transaction ends
+type UserRepository struct {
+	users storage.UserRepository
+	cache *UserCache
+	tx    *txInvalidations // set in WithTx
+}
+
+func NewUserRepository(users storage.UserRepository, cache *UserCache) UserRepository {
+	return UserRepository{users: users, cache: cache}
+}
+
+// isNotFound reports whether the storage doesn't know the user, such answers are cached too
+func isNotFound(err error) bool {
+	return errors.Is(err, storage.ErrNotFound)
+}
+
+func (repository UserRepository) Count() int {
+	return repository.users.Count()
+}
+
+func (repository UserRepository) IsEmpty() bool {
+	return repository.users.IsEmpty()
+}
+
+// Create invalidates the unknown login and email of the user
+func (repository UserRepository) Create(user *models.User) error {
+	err := repository.users.Create(user)
+	repository.invalidate(Invalidation{Logins: []string{user.Login}, Emails: []string{user.Email}})
+	return err
+}
+
+func (repository UserRepository) FindByLogin(login string) (*models.User, error) {
+	if repository.tx != nil {
+		return repository.users.FindByLogin(login)
+	}
+
+	return repository.cache.load(loginKey(login), func() (*models.User, error) {
+		return repository.users.FindByLogin(login)
+	})
+}
+
+func (repository UserRepository) FindByEmail(email string) (*models.User, error) {
+	if repository.tx != nil {
+		return repository.users.FindByEmail(email)
+	}
+
+	return repository.cache.load(emailKey(email), func() (*models.User, error) {
+		return repository.users.FindByEmail(email)
+	})
+}
+
+func (repository UserRepository) DeleteByLogin(login string) error {
+	err := repository.users.DeleteByLogin(login)
+	repository.invalidate(Invalidation{Logins: []string{login}})
+	return err
+}
+
+func (repository UserRepository) DeleteByEmail(email string) error {
+	err := repository.users.DeleteByEmail(email)
+	repository.invalidate(Invalidation{Emails: []string{email}})
+	return err
+}
+
+// Update invalidates the entries of the previous email too, they are indexed by the login
+func (repository UserRepository) Update(user *models.User) error {
+	err := repository.users.Update(user)
+	repository.invalidate(Invalidation{Logins: []string{user.Login}, Emails: []string{user.Email}})
+	return err
+}
+
+func (repository UserRepository) UpdatePassword(login, password string) error {
+	err := repository.users.UpdatePassword(login, password)
+	repository.invalidate(Invalidation{Logins: []string{login}})
+	return err
+}
+
+// invalidate runs even when the write fails, it may have failed after the commit.
+// A failed publication is not reported, the entries of the other replicas expire
+func (repository UserRepository) invalidate(invalidation Invalidation) {
+	if repository.tx != nil {
+		repository.tx.add(invalidation)
+		// a concurrent load reading the user before the commit stores it again,
+		// so the entries are dropped again once the transaction ends
+		repository.cache.drop(invalidation)
+		return
+	}
+
+	_ = repository.cache.Invalidate(invalidation)
+}
@@ -0,0 +1,78 @@
+package cached_storage_test
+
+import (
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+	"vox-server/internal/storage/cached_storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserRepository_Writes(t *testing.T) {
+	cache := cached_storage.NewUserCache(cached_storage.UserCacheOptions{Size: 10, TTL: time.Minute, NegativeTTL: time.Minute})
+	users := cached_storage.NewUserRepository(newCountingUsers(t), cache)
+
+	// default case : the creation drops the cached unknown login
+	_, err := users.FindByLogin("user")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.NoError(t, users.Create(newTestUser("user")))
+	found, err := users.FindByLogin("user")
+	assert.NoError(t, err)
+	assert.Equal(t, "user@example.org", found.Email)
+
+	// case : an update of the email drops the entry of the previous email
+	_, err = users.FindByEmail("user@example.org")
+	assert.NoError(t, err)
+	updated := *found
+	updated.Email = "updated@example.org"
+	assert.NoError(t, users.Update(&updated))
+
+	found, err = users.FindByLogin("user")
+	assert.NoError(t, err)
+	assert.Equal(t, "updated@example.org", found.Email)
+	_, err = users.FindByEmail("user@example.org")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// case : the deletion drops the entries
+	_, err = users.FindByEmail("updated@example.org")
+	assert.NoError(t, err)
+	assert.NoError(t, users.DeleteByLogin("user"))
+	_, err = users.FindByLogin("user")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = users.FindByEmail("updated@example.org")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// case : the deletion by email drops the entry found by login
+	assert.NoError(t, users.Create(newTestUser("other")))
+	_, err = users.FindByLogin("other")
+	assert.NoError(t, err)
+	assert.NoError(t, users.DeleteByEmail("other@example.org"))
+	_, err = users.FindByLogin("other")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.Equal(t, 0, users.Count())
+	assert.True(t, users.IsEmpty())
+}
+
+func TestUserRepository_UpdatePassword(t *testing.T) {
+	cache := cached_storage.NewUserCache(cached_storage.UserCacheOptions{Size: 10, TTL: time.Minute})
+	users := cached_storage.NewUserRepository(newCountingUsers(t, newTestUser("user")), cache)
+
+	before, err := users.FindByEmail("user@example.org")
+	assert.NoError(t, err)
+
+	// default case : the entry found by email has the new password
+	assert.NoError(t, users.UpdatePassword("user", "neWPsswrA1234"))
+	after, err := users.FindByEmail("user@example.org")
+	assert.NoError(t, err)
+	assert.NotEqual(t, before.EncryptedPassword, after.EncryptedPassword)
+	assert.True(t, after.ComparePassword("neWPsswrA1234"))
+
+	// case : a role change is seen at once
+	after.Role = models.RoleAdmin
+	assert.NoError(t, users.Update(after))
+	found, err := users.FindByLogin("user")
+	assert.NoError(t, err)
+	assert.Equal(t, models.RoleAdmin, found.Role)
+}
@@ -0,0 +1,62 @@
+package cached_storage
+
+import (
+	"context"
+	"sync"
+	"vox-server/internal/storage"
+)
+
+// Storage caches the users of the storage it wraps, its other repositories are the ones of the storage
+type Storage struct {
+	storage.Storage
+	cache *UserCache
+	tx    *txInvalidations // set in WithTx
+}
+
+func NewStorage(store storage.Storage, cache *UserCache) *Storage {
+	return &Storage{Storage: store, cache: cache}
+}
+
+func (store *Storage) WithContext(ctx context.Context) storage.Storage {
+	return &Storage{Storage: store.Storage.WithContext(ctx), cache: store.cache, tx: store.tx}
+}
+
+// WithTx invalidates the users written in the transaction once it ends, a joined one leaves it to the outer one
+func (store *Storage) WithTx(ctx context.Context, fn func(tx storage.Storage) error, opts ...storage.TxOption) error {
+	tx := store.tx
+	if tx == nil {
+		tx = &txInvalidations{}
+		defer func() { _ = store.cache.Invalidate(tx.take()) }()
+	}
+
+	return store.Storage.WithTx(ctx, func(inner storage.Storage) error {
+		return fn(&Storage{Storage: inner, cache: store.cache, tx: tx})
+	}, opts...)
+}
+
+func (store *Storage) Users() storage.UserRepository {
+	return UserRepository{users: store.Storage.Users(), cache: store.cache, tx: store.tx}
+}
+
+// txInvalidations collects the invalidations of a transaction, the runs of a retried one included
+type txInvalidations struct {
+	mu           sync.Mutex
+	invalidation Invalidation
+}
+
+func (tx *txInvalidations) add(invalidation Invalidation) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	tx.invalidation.Logins = append(tx.invalidation.Logins, invalidation.Logins...)
+	tx.invalidation.Emails = append(tx.invalidation.Emails, invalidation.Emails...)
+}
+
+func (tx *txInvalidations) take() Invalidation {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	invalidation := tx.invalidation
+	tx.invalidation = Invalidation{}
+	return invalidation
+}
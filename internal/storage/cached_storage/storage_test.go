@@ -0,0 +1,66 @@
+package cached_storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+	"vox-server/internal/storage/cached_storage"
+	"vox-server/internal/storage/test_storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorage_WithTx(t *testing.T) {
+	cache := cached_storage.NewUserCache(cached_storage.UserCacheOptions{Size: 10, TTL: time.Minute})
+	store := cached_storage.NewStorage(test_storage.NewInMemoryStorage(), cache)
+	ctx := context.Background()
+
+	assert.NoError(t, store.Users().Create(newTestUser("user")))
+	_, err := store.WithContext(ctx).Users().FindByLogin("user")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cache.Len())
+
+	// default case : the writes of a committed transaction are seen after it
+	err = store.WithTx(ctx, func(tx storage.Storage) error {
+		found, err := tx.Users().FindByLogin("user")
+		if err != nil {
+			return err
+		}
+		found.Role = models.RoleAdmin
+		if err := tx.Users().Update(found); err != nil {
+			return err
+		}
+
+		// a nested transaction joins the outer one
+		return tx.WithTx(ctx, func(nested storage.Storage) error {
+			return nested.Users().Create(newTestUser("nested"))
+		})
+	})
+	assert.NoError(t, err)
+
+	found, err := store.Users().FindByLogin("user")
+	assert.NoError(t, err)
+	assert.Equal(t, models.RoleAdmin, found.Role)
+	_, err = store.Users().FindByLogin("nested")
+	assert.NoError(t, err)
+
+	// case : a rolled back transaction leaves the users as they were
+	failed := errors.New("failed")
+	err = store.WithTx(ctx, func(tx storage.Storage) error {
+		if err := tx.Users().DeleteByLogin("user"); err != nil {
+			return err
+		}
+		_, err := store.Users().FindByLogin("nested")
+		assert.NoError(t, err)
+		return failed
+	})
+	assert.ErrorIs(t, err, failed)
+
+	found, err = store.Users().FindByLogin("user")
+	assert.NoError(t, err)
+	assert.Equal(t, models.RoleAdmin, found.Role)
+	assert.Equal(t, 2, cache.Len())
+}
@@ -0,0 +1,13 @@
+package storage
+
+import "errors"
+
+// The errors of the repositories wrap these, so that callers tell the cases apart whatever the backend
+var (
+	// the record looked up, changed or removed doesn't exist
+	ErrNotFound = errors.New("not found")
+	// the record clashes with a stored one, e.g. a taken login or email
+	ErrConflict = errors.New("already exists")
+	// the lease of a claimed record ended and the record was claimed again or changed meanwhile
+	ErrLeaseLost = errors.New("lease lost")
+)
@@ -1,6 +1,9 @@
 package storage
 
-import "vox-server/internal/models"
+import (
+	"time"
+	"vox-server/internal/models"
+)
 
 type UserRepository interface {
 	Count() int
@@ -10,5 +13,121 @@ type UserRepository interface {
 	FindByEmail(string) (*models.User, error)
 	DeleteByLogin(login string) error
 	DeleteByEmail(email string) error
+	// Update modifies non-unique fields and the email, but not the password
 	Update(user *models.User) error
+	UpdatePassword(login, password string) error
+}
+
+type APIKeyRepository interface {
+	Create(*models.APIKey) error
+	FindByID(id string) (*models.APIKey, error)
+	FindByPrefix(prefix string) (*models.APIKey, error)
+	FindByUser(login string) ([]*models.APIKey, error)
+	Revoke(id string) error
+	TouchLastUsed(id string, at time.Time) error
+}
+
+type SessionRepository interface {
+	Create(*models.Session) error
+	FindByID(id string) (*models.Session, error)
+	Revoke(id string) error
+}
+
+type OAuthClientRepository interface {
+	Create(*models.OAuthClient) error
+	FindByID(id string) (*models.OAuthClient, error)
+	FindByOwner(login string) ([]*models.OAuthClient, error)
+	Delete(id string) error
+}
+
+type AuthorizationCodeRepository interface {
+	Create(*models.AuthorizationCode) error
+	// Consume returns the code and removes it, so a code can be exchanged only once.
+	// A code issued to another client or redirect uri is left as it is and not found
+	Consume(codeHash, clientID, redirectURI string) (*models.AuthorizationCode, error)
+}
+
+type ConsentRepository interface {
+	// Grant adds scopes to the consent already given by the user to the client
+	Grant(*models.Consent) error
+	Find(login, clientID string) (*models.Consent, error)
+	Revoke(login, clientID string) error
+}
+
+type IdentityRepository interface {
+	Create(*models.Identity) error
+	Find(provider, subject string) (*models.Identity, error)
+	FindByUser(login string) ([]*models.Identity, error)
+	Delete(provider, subject string) error
+}
+
+// AuditRepository is append-only, events are never updated or deleted
+type AuditRepository interface {
+	// Append assigns ids to the events and stores them at once
+	Append(events ...*models.AuditEvent) error
+	Find(filter models.AuditFilter) ([]*models.AuditEvent, error)
+}
+
+// IdempotencyRepository keeps the responses of requests carrying an Idempotency-Key.
+// Expired records count as missing
+type IdempotencyRepository interface {
+	// Create fails when the key of the user is in use
+	Create(*models.IdempotencyRecord) error
+	Find(login, key string) (*models.IdempotencyRecord, error)
+	// Complete stores the response of the record with its expiry, which the response may shorten
+	Complete(*models.IdempotencyRecord) error
+	Delete(login, key string) error
+	// DeleteExpired removes the records expired at the time, returning how many
+	DeleteExpired(now time.Time) (int64, error)
+}
+
+type WebhookRepository interface {
+	Create(*models.WebhookEndpoint) error
+	FindByID(id string) (*models.WebhookEndpoint, error)
+	// FindAll returns every endpoint, oldest first
+	FindAll() ([]*models.WebhookEndpoint, error)
+	// FindSubscribed returns the enabled endpoints subscribed to the event
+	FindSubscribed(event string) ([]*models.WebhookEndpoint, error)
+	// Update modifies everything but the owner and the secret
+	Update(*models.WebhookEndpoint) error
+	// RecordFailure counts a delivery to the endpoint failed for good, returning the failures in a row
+	RecordFailure(id string) (int, error)
+	// ResetFailures forgets the failures of the endpoint once a delivery to it succeeded
+	ResetFailures(id string) error
+	// Disable disables the endpoint at the time, reporting false when it was disabled already
+	Disable(id string, at time.Time) (bool, error)
+	// Delete removes the endpoint with its deliveries
+	Delete(id string) error
+}
+
+type WebhookDeliveryRepository interface {
+	Create(*models.WebhookDelivery) error
+	FindByID(id string) (*models.WebhookDelivery, error)
+	// FindByEndpoint returns the latest deliveries to the endpoint, newest first
+	FindByEndpoint(endpointID string, limit int) ([]*models.WebhookDelivery, error)
+	// Claim returns the pending deliveries due at the time, oldest first, and postpones
+	// their next attempt until the lease ends, so that other servers don't send them meanwhile
+	Claim(now, leaseEnd time.Time, limit int) ([]*models.WebhookDelivery, error)
+	// Update stores the result of the attempt holding the lease ending at the time. It fails with
+	// ErrLeaseLost when the delivery isn't pending under the lease anymore, e.g. claimed again once it ended
+	Update(delivery *models.WebhookDelivery, lease time.Time) error
+}
+
+// JobRepository is the queue of the background jobs. Jobs are enqueued in the transaction of the
+// storage, so that they are run if and only if the writes they follow are committed
+type JobRepository interface {
+	// Enqueue stores a pending job, unless its key is taken: Enqueue reports false then
+	Enqueue(*models.Job) (bool, error)
+	FindByID(id string) (*models.Job, error)
+	Find(filter models.JobFilter) ([]*models.Job, error)
+	// Claim returns the pending jobs due at the time and the running jobs whose lease ended,
+	// due first, marks them running until the lease end and counts their attempt
+	Claim(now, leaseEnd time.Time, limit int) ([]*models.Job, error)
+	// Update stores the outcome of the attempt holding the lease ending at the time. It fails with
+	// ErrLeaseLost when the job isn't running under the lease anymore, e.g. claimed again once it ended
+	Update(job *models.Job, lease time.Time) error
+	// Retry gives the dead job all its attempts again, it fails with ErrNotFound when no such job is dead
+	Retry(id string, now time.Time) (*models.Job, error)
+	// DeleteFinished removes the jobs finished before the time, returning how many
+	DeleteFinished(before time.Time) (int64, error)
 }
@@ -1,5 +1,26 @@
 package storage
 
+import "context"
+
 type Storage interface {
+	// WithContext returns the storage running its queries in the context,
+	// e.g. to cancel them with the request or to record them in its trace
+	WithContext(ctx context.Context) Storage
+	// WithTx runs fn in a transaction, committed when fn returns nil and rolled back otherwise.
+	// The repositories of tx run their queries in it, a WithTx of tx joins it and ignores its options.
+	// A transaction failing to serialize is run again, see Retries
+	WithTx(ctx context.Context, fn func(tx Storage) error, opts ...TxOption) error
+
 	Users() UserRepository
+	APIKeys() APIKeyRepository
+	Sessions() SessionRepository
+	OAuthClients() OAuthClientRepository
+	AuthorizationCodes() AuthorizationCodeRepository
+	Consents() ConsentRepository
+	Identities() IdentityRepository
+	Audit() AuditRepository
+	Idempotency() IdempotencyRepository
+	Webhooks() WebhookRepository
+	WebhookDeliveries() WebhookDeliveryRepository
+	Jobs() JobRepository
 }
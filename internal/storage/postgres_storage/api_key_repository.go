@@ -0,0 +1,157 @@
+package postgres_storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type APIKeyRepository struct {
+	storage *DBStorage
+}
+
+const createAPIKey = `-- name: CreateAPIKey :one
+INSERT INTO api_keys (id, user_login, name, prefix, key_hash, scopes, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id`
+
+func (repository APIKeyRepository) Create(key *models.APIKey) error {
+	if err := key.Validate(); err != nil {
+		return err
+	}
+
+	if key.ID == "" {
+		key.ID = uuid.New().String()
+	}
+
+	row := repository.storage.conn().QueryRow(
+		createAPIKey,
+		key.ID,
+		key.UserLogin,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		pq.Array(key.Scopes),
+		key.CreatedAt,
+	)
+
+	return row.Scan(&key.ID)
+}
+
+const findAPIKeyByID = `-- name: FindAPIKeyByID :one
+SELECT id, user_login, name, prefix, key_hash, scopes, created_at, last_used_at, revoked_at FROM api_keys
+WHERE id = $1`
+
+func (repository APIKeyRepository) FindByID(id string) (*models.APIKey, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("api key with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	return scanAPIKey(repository.storage.conn().QueryRow(findAPIKeyByID, id))
+}
+
+const findAPIKeyByPrefix = `-- name: FindAPIKeyByPrefix :one
+SELECT id, user_login, name, prefix, key_hash, scopes, created_at, last_used_at, revoked_at FROM api_keys
+WHERE prefix = $1`
+
+func (repository APIKeyRepository) FindByPrefix(prefix string) (*models.APIKey, error) {
+	return scanAPIKey(repository.storage.conn().QueryRow(findAPIKeyByPrefix, prefix))
+}
+
+const findAPIKeysByUser = `-- name: FindAPIKeysByUser :many
+SELECT id, user_login, name, prefix, key_hash, scopes, created_at, last_used_at, revoked_at FROM api_keys
+WHERE user_login = $1
+ORDER BY created_at`
+
+func (repository APIKeyRepository) FindByUser(login string) ([]*models.APIKey, error) {
+	rows, err := repository.storage.conn().Query(findAPIKeysByUser, login)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+const revokeAPIKey = `-- name: RevokeAPIKey :exec
+UPDATE api_keys SET revoked_at = now()
+WHERE id = $1 AND revoked_at IS NULL`
+
+func (repository APIKeyRepository) Revoke(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return fmt.Errorf("api key with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	res, err := repository.storage.conn().Exec(revokeAPIKey, id)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(res, fmt.Errorf("active api key with id '%s' %w", id, storage.ErrNotFound))
+}
+
+const touchAPIKey = `-- name: TouchAPIKey :exec
+UPDATE api_keys SET last_used_at = $2
+WHERE id = $1`
+
+func (repository APIKeyRepository) TouchLastUsed(id string, at time.Time) error {
+	_, err := repository.storage.conn().Exec(touchAPIKey, id, at)
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row scanner) (*models.APIKey, error) {
+	var key models.APIKey
+	var lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(
+		&key.ID,
+		&key.UserLogin,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		pq.Array(&key.Scopes),
+		&key.CreatedAt,
+		&lastUsedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+
+	return &key, nil
+}
+
+func expectAffected(res sql.Result, notFound error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
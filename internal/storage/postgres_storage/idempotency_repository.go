@@ -0,0 +1,126 @@
+package postgres_storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+)
+
+type IdempotencyRepository struct {
+	storage *DBStorage
+}
+
+// an expired record of the key is replaced
+const createIdempotencyKey = `-- name: CreateIdempotencyKey :one
+INSERT INTO idempotency_keys (user_login, key, fingerprint, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (user_login, key) DO UPDATE
+SET fingerprint = EXCLUDED.fingerprint, status = 0, header = NULL, body = NULL,
+    created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at <= now()
+RETURNING key`
+
+func (repository IdempotencyRepository) Create(record *models.IdempotencyRecord) error {
+	row := repository.storage.conn().QueryRow(
+		createIdempotencyKey,
+		record.UserLogin,
+		record.Key,
+		record.Fingerprint,
+		record.CreatedAt,
+		record.ExpiresAt,
+	)
+
+	err := row.Scan(&record.Key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("idempotency key '%s' already in use: %w", record.Key, storage.ErrConflict)
+	}
+	return err
+}
+
+const findIdempotencyKey = `-- name: FindIdempotencyKey :one
+SELECT user_login, key, fingerprint, status, header, body, created_at, expires_at FROM idempotency_keys
+WHERE user_login = $1 AND key = $2 AND expires_at > now()`
+
+func (repository IdempotencyRepository) Find(login, key string) (*models.IdempotencyRecord, error) {
+	var record models.IdempotencyRecord
+	var header []byte
+	err := repository.storage.conn().QueryRow(findIdempotencyKey, login, key).Scan(
+		&record.UserLogin,
+		&record.Key,
+		&record.Fingerprint,
+		&record.Status,
+		&header,
+		&record.Body,
+		&record.CreatedAt,
+		&record.ExpiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("idempotency key '%s' %w", key, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(header) > 0 {
+		if err := json.Unmarshal(header, &record.Header); err != nil {
+			return nil, err
+		}
+	}
+
+	return &record, nil
+}
+
+const completeIdempotencyKey = `-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys SET status = $3, header = $4, body = $5, expires_at = $6
+WHERE user_login = $1 AND key = $2 AND expires_at > now()`
+
+func (repository IdempotencyRepository) Complete(record *models.IdempotencyRecord) error {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+
+	res, err := repository.storage.conn().Exec(
+		completeIdempotencyKey,
+		record.UserLogin,
+		record.Key,
+		record.Status,
+		// jsonb is sent as text, pq would encode []byte as bytea
+		string(header),
+		record.Body,
+		record.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(res, fmt.Errorf("idempotency key '%s' %w", record.Key, storage.ErrNotFound))
+}
+
+const deleteIdempotencyKey = `-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys WHERE user_login = $1 AND key = $2`
+
+func (repository IdempotencyRepository) Delete(login, key string) error {
+	res, err := repository.storage.conn().Exec(deleteIdempotencyKey, login, key)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(res, fmt.Errorf("idempotency key '%s' %w", key, storage.ErrNotFound))
+}
+
+const deleteExpiredIdempotencyKeys = `-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys WHERE expires_at <= $1`
+
+func (repository IdempotencyRepository) DeleteExpired(now time.Time) (int64, error) {
+	res, err := repository.storage.conn().Exec(deleteExpiredIdempotencyKeys, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,218 @@
+package postgres_storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+type JobRepository struct {
+	storage *DBStorage
+}
+
+// a job whose key is taken inserts no row, so RETURNING returns none
+const enqueueJob = `-- name: EnqueueJob :one
+INSERT INTO jobs (id, kind, key, payload, status, attempts, max_attempts, run_at, last_error, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+ON CONFLICT (key) DO NOTHING
+RETURNING id`
+
+func (repository JobRepository) Enqueue(job *models.Job) (bool, error) {
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+
+	row := repository.storage.conn().QueryRow(
+		enqueueJob,
+		job.ID,
+		job.Kind,
+		sql.NullString{String: job.Key, Valid: job.Key != ""},
+		// jsonb is sent as text, pq would encode []byte as bytea
+		sql.NullString{String: string(job.Payload), Valid: len(job.Payload) > 0},
+		job.Status,
+		job.Attempts,
+		job.MaxAttempts,
+		job.RunAt,
+		job.LastError,
+		job.CreatedAt,
+	)
+
+	if err := row.Scan(&job.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+const findJobByID = `-- name: FindJobByID :one
+SELECT id, kind, key, payload, status, attempts, max_attempts, run_at, locked_until, last_error,
+       created_at, finished_at FROM jobs
+WHERE id = $1`
+
+func (repository JobRepository) FindByID(id string) (*models.Job, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("job with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	return scanJob(repository.storage.conn().QueryRow(findJobByID, id))
+}
+
+const findJobs = `-- name: FindJobs :many
+SELECT id, kind, key, payload, status, attempts, max_attempts, run_at, locked_until, last_error,
+       created_at, finished_at FROM jobs
+WHERE ($1 = '' OR kind = $1)
+  AND ($2 = '' OR status = $2)
+ORDER BY created_at DESC
+LIMIT $3`
+
+func (repository JobRepository) Find(filter models.JobFilter) ([]*models.Job, error) {
+	return repository.find(findJobs, filter.Kind, filter.Status, sql.NullInt64{Int64: int64(filter.Limit), Valid: filter.Limit > 0})
+}
+
+// servers claiming at the same time skip the rows the others are claiming instead of waiting for them
+const claimJobs = `-- name: ClaimJobs :many
+UPDATE jobs SET status = 'running', attempts = attempts + 1, locked_until = $2
+WHERE id IN (
+    SELECT id FROM jobs
+    WHERE (status = 'pending' AND run_at <= $1) OR (status = 'running' AND locked_until <= $1)
+    ORDER BY run_at
+    LIMIT $3
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, kind, key, payload, status, attempts, max_attempts, run_at, locked_until, last_error,
+          created_at, finished_at`
+
+func (repository JobRepository) Claim(now, leaseEnd time.Time, limit int) ([]*models.Job, error) {
+	jobs, err := repository.find(claimJobs, now, leaseEnd, sql.NullInt64{Int64: int64(limit), Valid: limit > 0})
+	if err != nil {
+		return nil, err
+	}
+
+	// RETURNING keeps no order
+	slices.SortFunc(jobs, func(a, b *models.Job) int {
+		return a.RunAt.Compare(b.RunAt)
+	})
+
+	return jobs, nil
+}
+
+func (repository JobRepository) find(query string, args ...any) ([]*models.Job, error) {
+	rows, err := repository.storage.conn().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*models.Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+const updateJob = `-- name: UpdateJob :exec
+UPDATE jobs
+SET status = $2, attempts = $3, run_at = $4, locked_until = $5, last_error = $6, finished_at = $7
+WHERE id = $1 AND status = 'running' AND locked_until = $8`
+
+func (repository JobRepository) Update(job *models.Job, lease time.Time) error {
+	if _, err := uuid.Parse(job.ID); err != nil {
+		return fmt.Errorf("job with id '%s' %w", job.ID, storage.ErrLeaseLost)
+	}
+
+	res, err := repository.storage.conn().Exec(
+		updateJob,
+		job.ID,
+		job.Status,
+		job.Attempts,
+		job.RunAt,
+		job.LockedUntil,
+		job.LastError,
+		job.FinishedAt,
+		lease,
+	)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(res, fmt.Errorf("job with id '%s' %w", job.ID, storage.ErrLeaseLost))
+}
+
+const retryJob = `-- name: RetryJob :one
+UPDATE jobs SET status = 'pending', attempts = 0, run_at = $2, locked_until = NULL, finished_at = NULL
+WHERE id = $1 AND status = 'dead'
+RETURNING id, kind, key, payload, status, attempts, max_attempts, run_at, locked_until, last_error,
+          created_at, finished_at`
+
+func (repository JobRepository) Retry(id string, now time.Time) (*models.Job, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("dead job with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	job, err := scanJob(repository.storage.conn().QueryRow(retryJob, id, now))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("dead job with id '%s' %w", id, storage.ErrNotFound)
+	}
+	return job, err
+}
+
+const deleteFinishedJobs = `-- name: DeleteFinishedJobs :exec
+DELETE FROM jobs WHERE finished_at < $1`
+
+func (repository JobRepository) DeleteFinished(before time.Time) (int64, error) {
+	res, err := repository.storage.conn().Exec(deleteFinishedJobs, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func scanJob(row scanner) (*models.Job, error) {
+	var job models.Job
+	var key sql.NullString
+	var payload []byte
+	var lockedUntil, finishedAt sql.NullTime
+	err := row.Scan(
+		&job.ID,
+		&job.Kind,
+		&key,
+		&payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&lockedUntil,
+		&job.LastError,
+		&job.CreatedAt,
+		&finishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Key = key.String
+	job.Payload = payload
+	if lockedUntil.Valid {
+		job.LockedUntil = &lockedUntil.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+
+	return &job, nil
+}
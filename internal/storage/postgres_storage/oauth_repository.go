@@ -0,0 +1,225 @@
+package postgres_storage
+
+import (
+	"fmt"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type OAuthClientRepository struct {
+	storage *DBStorage
+}
+
+const createOAuthClient = `-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, secret_hash, owner_login, name, redirect_uris, public, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id`
+
+func (repository OAuthClientRepository) Create(client *models.OAuthClient) error {
+	if err := client.Validate(); err != nil {
+		return err
+	}
+
+	if client.ID == "" {
+		client.ID = uuid.New().String()
+	}
+
+	row := repository.storage.conn().QueryRow(
+		createOAuthClient,
+		client.ID,
+		client.SecretHash,
+		client.OwnerLogin,
+		client.Name,
+		pq.Array(client.RedirectURIs),
+		client.Public,
+		client.CreatedAt,
+	)
+
+	return row.Scan(&client.ID)
+}
+
+const findOAuthClientByID = `-- name: FindOAuthClientByID :one
+SELECT id, secret_hash, owner_login, name, redirect_uris, public, created_at FROM oauth_clients
+WHERE id = $1`
+
+func (repository OAuthClientRepository) FindByID(id string) (*models.OAuthClient, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("oauth client with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	return scanOAuthClient(repository.storage.conn().QueryRow(findOAuthClientByID, id))
+}
+
+const findOAuthClientsByOwner = `-- name: FindOAuthClientsByOwner :many
+SELECT id, secret_hash, owner_login, name, redirect_uris, public, created_at FROM oauth_clients
+WHERE owner_login = $1
+ORDER BY created_at`
+
+func (repository OAuthClientRepository) FindByOwner(login string) ([]*models.OAuthClient, error) {
+	rows, err := repository.storage.conn().Query(findOAuthClientsByOwner, login)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*models.OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+const deleteOAuthClient = `-- name: DeleteOAuthClient :exec
+DELETE FROM oauth_clients WHERE id = $1`
+
+func (repository OAuthClientRepository) Delete(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return fmt.Errorf("oauth client with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	res, err := repository.storage.conn().Exec(deleteOAuthClient, id)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(res, fmt.Errorf("oauth client with id '%s' %w", id, storage.ErrNotFound))
+}
+
+func scanOAuthClient(row scanner) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	err := row.Scan(
+		&client.ID,
+		&client.SecretHash,
+		&client.OwnerLogin,
+		&client.Name,
+		pq.Array(&client.RedirectURIs),
+		&client.Public,
+		&client.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+type AuthorizationCodeRepository struct {
+	storage *DBStorage
+}
+
+const createAuthorizationCode = `-- name: CreateAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, client_id, user_login, redirect_uri, scopes, nonce,
+    code_challenge, code_challenge_method, auth_time, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+func (repository AuthorizationCodeRepository) Create(code *models.AuthorizationCode) error {
+	_, err := repository.storage.conn().Exec(
+		createAuthorizationCode,
+		code.CodeHash,
+		code.ClientID,
+		code.UserLogin,
+		code.RedirectURI,
+		pq.Array(code.Scopes),
+		code.Nonce,
+		code.CodeChallenge,
+		code.CodeChallengeMethod,
+		code.AuthTime,
+		code.ExpiresAt,
+	)
+	return err
+}
+
+const consumeAuthorizationCode = `-- name: ConsumeAuthorizationCode :one
+DELETE FROM oauth_authorization_codes WHERE code_hash = $1 AND client_id = $2 AND redirect_uri = $3
+RETURNING code_hash, client_id, user_login, redirect_uri, scopes, nonce,
+    code_challenge, code_challenge_method, auth_time, expires_at`
+
+func (repository AuthorizationCodeRepository) Consume(codeHash, clientID, redirectURI string) (*models.AuthorizationCode, error) {
+	var code models.AuthorizationCode
+	err := repository.storage.conn().QueryRow(consumeAuthorizationCode, codeHash, clientID, redirectURI).Scan(
+		&code.CodeHash,
+		&code.ClientID,
+		&code.UserLogin,
+		&code.RedirectURI,
+		pq.Array(&code.Scopes),
+		&code.Nonce,
+		&code.CodeChallenge,
+		&code.CodeChallengeMethod,
+		&code.AuthTime,
+		&code.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &code, nil
+}
+
+type ConsentRepository struct {
+	storage *DBStorage
+}
+
+const grantConsent = `-- name: GrantConsent :exec
+INSERT INTO oauth_consents (user_login, client_id, scopes, granted_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_login, client_id) DO UPDATE
+SET scopes = ARRAY(SELECT DISTINCT unnest(oauth_consents.scopes || EXCLUDED.scopes)),
+    granted_at = EXCLUDED.granted_at`
+
+func (repository ConsentRepository) Grant(consent *models.Consent) error {
+	_, err := repository.storage.conn().Exec(
+		grantConsent,
+		consent.UserLogin,
+		consent.ClientID,
+		pq.Array(consent.Scopes),
+		consent.GrantedAt,
+	)
+	return err
+}
+
+const findConsent = `-- name: FindConsent :one
+SELECT user_login, client_id, scopes, granted_at FROM oauth_consents
+WHERE user_login = $1 AND client_id = $2`
+
+func (repository ConsentRepository) Find(login, clientID string) (*models.Consent, error) {
+	if _, err := uuid.Parse(clientID); err != nil {
+		return nil, fmt.Errorf("consent of '%s' for client '%s' %w", login, clientID, storage.ErrNotFound)
+	}
+
+	var consent models.Consent
+	err := repository.storage.conn().QueryRow(findConsent, login, clientID).Scan(
+		&consent.UserLogin,
+		&consent.ClientID,
+		pq.Array(&consent.Scopes),
+		&consent.GrantedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &consent, nil
+}
+
+const revokeConsent = `-- name: RevokeConsent :exec
+DELETE FROM oauth_consents WHERE user_login = $1 AND client_id = $2`
+
+func (repository ConsentRepository) Revoke(login, clientID string) error {
+	if _, err := uuid.Parse(clientID); err != nil {
+		return fmt.Errorf("consent of '%s' for client '%s' %w", login, clientID, storage.ErrNotFound)
+	}
+
+	res, err := repository.storage.conn().Exec(revokeConsent, login, clientID)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(res, fmt.Errorf("consent of '%s' for client '%s' %w", login, clientID, storage.ErrNotFound))
+}
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 	"vox-server/internal/models"
+	"vox-server/internal/storage"
 	"vox-server/internal/storage/postgres_storage"
+	"vox-server/internal/storage/storage_suite"
 
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
@@ -41,144 +44,30 @@ func MakeTestDB(t *testing.T) (*sql.DB, func(...string)) {
 	}
 }
 
-func TestUserRepository_Create(t *testing.T) {
-	db, cleanup := MakeTestDB(t)
-	defer cleanup("users")
-
-	storage := postgres_storage.NewDBStorage(db)
-	repo := storage.Users()
-
-	user := &models.User{
-		Login:    "testuser",
-		Username: "TestUser",
-		Email:    "test@example.com",
-		Password: "password",
-	}
-
-	err := repo.Create(user)
-
-	assert.NoError(t, err, "Create should not return an error")
-	assert.NotNil(t, user)
-
-	foundUser, err := repo.FindByLogin("testuser")
-	if err != nil {
-		t.Fatalf("Failed to find user: %v", err)
-	}
-
-	if foundUser.Login != "testuser" {
-		t.Errorf("Expected login 'testuser', got '%s'", foundUser.Login)
-	}
-
-}
-
-func TestUserRepository_Count(t *testing.T) {
-	db, cleanup := MakeTestDB(t)
-	defer cleanup("users")
-
-	storage := postgres_storage.NewDBStorage(db)
-	repo := storage.Users()
-
-	assert.Equal(t, 0, repo.Count(), "Expected 0 users in the database")
-
-	user := &models.User{
-		Login:    "testuser",
-		Username: "TestUser",
-		Email:    "test@example.com",
-		Password: "password",
-	}
-	err := repo.Create(user)
-	assert.NoError(t, err, "Create should not return an error")
-
-	assert.Equal(t, 1, repo.Count(), "Expected 1 user in the database")
-}
-
-func TestUserRepository_IsEmpty(t *testing.T) {
-	db, cleanup := MakeTestDB(t)
-	defer cleanup("users")
-
-	storage := postgres_storage.NewDBStorage(db)
-	repo := storage.Users()
-
-	assert.True(t, repo.IsEmpty(), "Expected database to be empty")
-
-	user := &models.User{
-		Login:    "testuser",
-		Username: "TestUser",
-		Email:    "test@example.com",
-		Password: "password",
-	}
-	err := repo.Create(user)
-	assert.NoError(t, err, "Create should not return an error")
-
-	assert.False(t, repo.IsEmpty(), "Expected database to not be empty")
-}
-
-func TestUserRepository_FindByEmail(t *testing.T) {
-	db, cleanup := MakeTestDB(t)
-	defer cleanup("users")
-
-	storage := postgres_storage.NewDBStorage(db)
-	repo := storage.Users()
-
-	user := &models.User{
-		Login:    "testuser",
-		Username: "TestUser",
-		Email:    "test@example.com",
-		Password: "password",
-	}
-	err := repo.Create(user)
-	assert.NoError(t, err, "Create should not return an error")
-
-	foundUser, err := repo.FindByEmail("test@example.com")
-	assert.NoError(t, err, "FindByEmail should not return an error")
-	assert.Equal(t, user.Login, foundUser.Login, "Expected login to match")
-	assert.Equal(t, user.Email, foundUser.Email, "Expected email to match")
+// tables are the tables of the schema, emptied after each test of the suite
+var tables = []string{
+	"users", "api_keys", "oauth_clients", "oauth_authorization_codes", "oauth_consents", "sessions",
+	"identities", "audit_events", "idempotency_keys", "webhook_endpoints", "webhook_deliveries", "jobs",
 }
 
-func TestUserRepository_DeleteByLogin(t *testing.T) {
-	db, cleanup := MakeTestDB(t)
-	defer cleanup("users")
-
-	storage := postgres_storage.NewDBStorage(db)
-	repo := storage.Users()
-
-	user := &models.User{
-		Login:    "testuser",
-		Username: "TestUser",
-		Email:    "test@example.com",
-		Password: "password",
-	}
-	err := repo.Create(user)
-	assert.NoError(t, err, "Create should not return an error")
+func TestStorageSuite(t *testing.T) {
+	storage_suite.Run(t, func(t *testing.T) storage.Storage {
+		db, cleanup := MakeTestDB(t)
+		t.Cleanup(func() { cleanup(tables...) })
 
-	err = repo.DeleteByLogin("testuser")
-	assert.NoError(t, err, "DeleteByLogin should not return an error")
-
-	_, err = repo.FindByLogin("testuser")
-	assert.Error(t, err, "Expected error when finding deleted user")
+		return postgres_storage.NewDBStorage(db)
+	})
 }
 
-func TestUserRepository_DeleteByEmail(t *testing.T) {
+func TestAuditRepository_AppendOnly(t *testing.T) {
 	db, cleanup := MakeTestDB(t)
-	defer cleanup("users")
-
-	storage := postgres_storage.NewDBStorage(db)
-	repo := storage.Users()
+	defer cleanup("audit_events")
 
-	user := &models.User{
-		Login:    "testuser",
-		Username: "TestUser",
-		Email:    "test@example.com",
-		Password: "password",
-	}
-	err := repo.Create(user)
-	assert.NoError(t, err, "Create should not return an error")
+	store := postgres_storage.NewDBStorage(db)
+	assert.NoError(t, store.Audit().Append(&models.AuditEvent{Time: time.Now(), Action: models.AuditLoginFailed}))
 
-	err = repo.DeleteByEmail("test@example.com")
-	assert.NoError(t, err, "DeleteByEmail should not return an error")
-
-	_, err = repo.FindByEmail("test@example.com")
-	assert.Error(t, err, "Expected error when finding deleted user")
+	_, err := db.Exec("DELETE FROM audit_events")
+	assert.Error(t, err, "Expected error when deleting audit events")
+	_, err = db.Exec("UPDATE audit_events SET actor = 'mallory'")
+	assert.Error(t, err, "Expected error when updating audit events")
 }
-
-// TODO : test update-method when it is implemented
@@ -0,0 +1,88 @@
+package postgres_storage
+
+import (
+	"database/sql"
+	"fmt"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type SessionRepository struct {
+	storage *DBStorage
+}
+
+const createSession = `-- name: CreateSession :one
+INSERT INTO sessions (id, user_login, client_id, scopes, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id`
+
+func (repository SessionRepository) Create(session *models.Session) error {
+	if session.ID == "" {
+		session.ID = uuid.New().String()
+	}
+
+	row := repository.storage.conn().QueryRow(
+		createSession,
+		session.ID,
+		session.UserLogin,
+		sql.NullString{String: session.ClientID, Valid: session.ClientID != ""},
+		pq.Array(session.Scopes),
+		session.CreatedAt,
+		session.ExpiresAt,
+	)
+
+	return row.Scan(&session.ID)
+}
+
+const findSessionByID = `-- name: FindSessionByID :one
+SELECT id, user_login, client_id, scopes, created_at, expires_at, revoked_at FROM sessions
+WHERE id = $1`
+
+func (repository SessionRepository) FindByID(id string) (*models.Session, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("session with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	var session models.Session
+	var clientID sql.NullString
+	var revokedAt sql.NullTime
+	err := repository.storage.conn().QueryRow(findSessionByID, id).Scan(
+		&session.ID,
+		&session.UserLogin,
+		&clientID,
+		pq.Array(&session.Scopes),
+		&session.CreatedAt,
+		&session.ExpiresAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	session.ClientID = clientID.String
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+
+	return &session, nil
+}
+
+const revokeSession = `-- name: RevokeSession :exec
+UPDATE sessions SET revoked_at = now()
+WHERE id = $1 AND revoked_at IS NULL`
+
+func (repository SessionRepository) Revoke(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return fmt.Errorf("session with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	res, err := repository.storage.conn().Exec(revokeSession, id)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(res, fmt.Errorf("active session with id '%s' %w", id, storage.ErrNotFound))
+}
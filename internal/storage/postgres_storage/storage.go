@@ -1,22 +1,155 @@
 package postgres_storage
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"time"
 	"vox-server/internal/storage"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+const txRetryBackoff = 10 * time.Millisecond
+
 type DBStorage struct {
-	db *sql.DB
+	db  *sql.DB
+	tx  *sql.Tx // set in WithTx
+	ctx context.Context
 }
 
 func NewDBStorage(db *sql.DB) *DBStorage {
 	return &DBStorage{
-		db: db,
+		db:  db,
+		ctx: context.Background(),
+	}
+}
+
+func (storage *DBStorage) WithContext(ctx context.Context) storage.Storage {
+	return &DBStorage{
+		db:  storage.db,
+		tx:  storage.tx,
+		ctx: ctx,
+	}
+}
+
+func (storage *DBStorage) WithTx(ctx context.Context, fn func(tx storage.Storage) error, opts ...storage.TxOption) error {
+	return storage.inTx(ctx, func(tx *sql.Tx) error {
+		return fn(&DBStorage{db: storage.db, tx: tx, ctx: ctx})
+	}, opts...)
+}
+
+// inTx runs fn in the transaction of the storage, or in a new one run again when it fails to serialize
+func (storage *DBStorage) inTx(ctx context.Context, fn func(tx *sql.Tx) error, opts ...storage.TxOption) error {
+	if storage.tx != nil {
+		return fn(storage.tx)
+	}
+
+	options := txOptions(opts)
+	for attempt := 0; ; attempt++ {
+		err := storage.runTx(ctx, options.Isolation, fn)
+		if err == nil || attempt >= options.Retries || !isSerializationFailure(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(txRetryDelay(attempt)):
+		}
 	}
 }
 
+func (storage *DBStorage) runTx(ctx context.Context, isolation sql.IsolationLevel, fn func(tx *sql.Tx) error) error {
+	tx, err := storage.db.BeginTx(ctx, &sql.TxOptions{Isolation: isolation})
+	if err != nil {
+		return err
+	}
+	// also when fn panics, a no-op once committed
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// txOptions is out of the methods of DBStorage, their receiver shadows the package storage
+func txOptions(opts []storage.TxOption) storage.TxOptions {
+	return storage.NewTxOptions(opts...)
+}
+
+// isSerializationFailure reports whether the transaction was aborted by a concurrent one,
+// it succeeds when run again. A commit fails so too at the serializable level
+func isSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	name := pqErr.Code.Name()
+	return name == "serialization_failure" || name == "deadlock_detected"
+}
+
+// txRetryDelay grows with the attempts, jittered so that the aborted transactions don't collide again
+func txRetryDelay(attempt int) time.Duration {
+	delay := time.Duration(attempt+1) * txRetryBackoff
+	return delay + rand.N(delay)
+}
+
+// conn runs queries in the context of the storage, in its transaction if any
+func (storage *DBStorage) conn() tracedDB {
+	if storage.tx != nil {
+		return tracedDB{db: storage.tx, ctx: storage.ctx}
+	}
+	return tracedDB{db: storage.db, ctx: storage.ctx}
+}
+
 func (storage *DBStorage) Users() storage.UserRepository {
 	return UserRepository{storage: storage}
 }
+
+func (storage *DBStorage) APIKeys() storage.APIKeyRepository {
+	return APIKeyRepository{storage: storage}
+}
+
+func (storage *DBStorage) Sessions() storage.SessionRepository {
+	return SessionRepository{storage: storage}
+}
+
+func (storage *DBStorage) OAuthClients() storage.OAuthClientRepository {
+	return OAuthClientRepository{storage: storage}
+}
+
+func (storage *DBStorage) AuthorizationCodes() storage.AuthorizationCodeRepository {
+	return AuthorizationCodeRepository{storage: storage}
+}
+
+func (storage *DBStorage) Consents() storage.ConsentRepository {
+	return ConsentRepository{storage: storage}
+}
+
+func (storage *DBStorage) Identities() storage.IdentityRepository {
+	return IdentityRepository{storage: storage}
+}
+
+func (storage *DBStorage) Audit() storage.AuditRepository {
+	return AuditRepository{storage: storage}
+}
+
+func (storage *DBStorage) Idempotency() storage.IdempotencyRepository {
+	return IdempotencyRepository{storage: storage}
+}
+
+func (storage *DBStorage) Webhooks() storage.WebhookRepository {
+	return WebhookRepository{storage: storage}
+}
+
+func (storage *DBStorage) WebhookDeliveries() storage.WebhookDeliveryRepository {
+	return WebhookDeliveryRepository{storage: storage}
+}
+
+func (storage *DBStorage) Jobs() storage.JobRepository {
+	return JobRepository{storage: storage}
+}
@@ -0,0 +1,128 @@
+package postgres_storage_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+	"vox-server/internal/storage/postgres_storage"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBStorage_WithTx(t *testing.T) {
+	db, cleanup := MakeTestDB(t)
+	defer cleanup("jobs", "users")
+
+	store := postgres_storage.NewDBStorage(db)
+	ctx := context.Background()
+
+	// case : rolled back, nothing is stored
+	failed := errors.New("failed")
+	user := &models.User{Login: "outbox", Username: "outbox", Email: "outbox@example.org", Password: "password"}
+	rolledBack, _ := models.NewJob("test.outbox", nil, 3)
+	err := store.WithTx(ctx, func(tx storage.Storage) error {
+		assert.NoError(t, tx.Users().Create(user))
+		_, err := tx.Jobs().Enqueue(rolledBack)
+		assert.NoError(t, err)
+		return failed
+	})
+	assert.ErrorIs(t, err, failed)
+
+	_, err = store.Users().FindByLogin(user.Login)
+	assert.Error(t, err)
+	_, err = store.Jobs().FindByID(rolledBack.ID)
+	assert.Error(t, err)
+
+	// case : committed, a nested transaction joins the outer one
+	committed, _ := models.NewJob("test.outbox", nil, 3)
+	err = store.WithTx(ctx, func(tx storage.Storage) error {
+		if err := tx.Users().Create(user); err != nil {
+			return err
+		}
+		return tx.WithTx(ctx, func(nested storage.Storage) error {
+			_, err := nested.Jobs().Enqueue(committed)
+			return err
+		})
+	})
+	assert.NoError(t, err)
+
+	_, err = store.Users().FindByLogin(user.Login)
+	assert.NoError(t, err)
+	_, err = store.Jobs().FindByID(committed.ID)
+	assert.NoError(t, err)
+}
+
+func TestDBStorage_WithTx_SerializationFailure(t *testing.T) {
+	db, cleanup := MakeTestDB(t)
+	defer cleanup("jobs")
+
+	store := postgres_storage.NewDBStorage(db)
+	ctx := context.Background()
+
+	counter, _ := models.NewJob("test.counter", nil, 3)
+	_, err := store.Jobs().Enqueue(counter)
+	assert.NoError(t, err)
+	// the counter is running, it is updated under its lease
+	_, err = store.Jobs().Claim(time.Now(), time.Now().Add(time.Hour), 1)
+	assert.NoError(t, err)
+
+	// both transactions read the counter before either writes it, on their first run only:
+	// the second to write fails to serialize
+	increment := func(reads *sync.WaitGroup, opts ...storage.TxOption) error {
+		var once sync.Once
+		opts = append(opts, storage.Isolation(sql.LevelSerializable))
+		return store.WithTx(ctx, func(tx storage.Storage) error {
+			job, err := tx.Jobs().FindByID(counter.ID)
+			if err != nil {
+				return err
+			}
+			once.Do(func() {
+				reads.Done()
+				reads.Wait()
+			})
+			job.Attempts++
+			return tx.Jobs().Update(job, *job.LockedUntil)
+		}, opts...)
+	}
+
+	concurrently := func(opts ...storage.TxOption) []error {
+		var reads sync.WaitGroup
+		reads.Add(2)
+		errs := make([]error, 2)
+		var wg sync.WaitGroup
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = increment(&reads, opts...)
+			}()
+		}
+		wg.Wait()
+		return errs
+	}
+
+	// case : the failed transaction is run again
+	for _, err := range concurrently() {
+		assert.NoError(t, err)
+	}
+	job, err := store.Jobs().FindByID(counter.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, job.Attempts)
+
+	// case : not retried
+	var pqErr *pq.Error
+	errs := concurrently(storage.Retries(0))
+	if assert.ErrorAs(t, errors.Join(errs...), &pqErr) {
+		assert.Equal(t, "serialization_failure", pqErr.Code.Name())
+	}
+
+	job, err = store.Jobs().FindByID(counter.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, job.Attempts)
+}
@@ -0,0 +1,386 @@
+package postgres_storage
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type WebhookRepository struct {
+	storage *DBStorage
+}
+
+const createWebhookEndpoint = `-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (id, owner_login, url, events, secret, failures, created_at, disabled_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id`
+
+func (repository WebhookRepository) Create(endpoint *models.WebhookEndpoint) error {
+	if err := endpoint.Validate(); err != nil {
+		return err
+	}
+
+	if endpoint.ID == "" {
+		endpoint.ID = uuid.New().String()
+	}
+
+	row := repository.storage.conn().QueryRow(
+		createWebhookEndpoint,
+		endpoint.ID,
+		endpoint.OwnerLogin,
+		endpoint.URL,
+		pq.Array(endpoint.Events),
+		endpoint.Secret,
+		endpoint.Failures,
+		endpoint.CreatedAt,
+		endpoint.DisabledAt,
+	)
+
+	return row.Scan(&endpoint.ID)
+}
+
+const findWebhookEndpointByID = `-- name: FindWebhookEndpointByID :one
+SELECT id, owner_login, url, events, secret, failures, created_at, disabled_at FROM webhook_endpoints
+WHERE id = $1`
+
+func (repository WebhookRepository) FindByID(id string) (*models.WebhookEndpoint, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("webhook with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	return scanWebhookEndpoint(repository.storage.conn().QueryRow(findWebhookEndpointByID, id))
+}
+
+const findWebhookEndpoints = `-- name: FindWebhookEndpoints :many
+SELECT id, owner_login, url, events, secret, failures, created_at, disabled_at FROM webhook_endpoints
+ORDER BY created_at`
+
+func (repository WebhookRepository) FindAll() ([]*models.WebhookEndpoint, error) {
+	return repository.find(findWebhookEndpoints)
+}
+
+const findSubscribedWebhookEndpoints = `-- name: FindSubscribedWebhookEndpoints :many
+SELECT id, owner_login, url, events, secret, failures, created_at, disabled_at FROM webhook_endpoints
+WHERE disabled_at IS NULL AND $1 = ANY (events)
+ORDER BY created_at`
+
+func (repository WebhookRepository) FindSubscribed(event string) ([]*models.WebhookEndpoint, error) {
+	return repository.find(findSubscribedWebhookEndpoints, event)
+}
+
+func (repository WebhookRepository) find(query string, args ...any) ([]*models.WebhookEndpoint, error) {
+	rows, err := repository.storage.conn().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := []*models.WebhookEndpoint{}
+	for rows.Next() {
+		endpoint, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	return endpoints, rows.Err()
+}
+
+const updateWebhookEndpoint = `-- name: UpdateWebhookEndpoint :exec
+UPDATE webhook_endpoints SET url = $2, events = $3, failures = $4, disabled_at = $5
+WHERE id = $1`
+
+func (repository WebhookRepository) Update(endpoint *models.WebhookEndpoint) error {
+	stored, err := repository.FindByID(endpoint.ID)
+	if err != nil {
+		return err
+	}
+
+	// validated with what is never changed
+	updated := *endpoint
+	updated.OwnerLogin = stored.OwnerLogin
+	updated.Secret = stored.Secret
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+
+	res, err := repository.storage.conn().Exec(
+		updateWebhookEndpoint,
+		endpoint.ID,
+		endpoint.URL,
+		pq.Array(endpoint.Events),
+		endpoint.Failures,
+		endpoint.DisabledAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(res, fmt.Errorf("webhook with id '%s' %w", endpoint.ID, storage.ErrNotFound))
+}
+
+const recordWebhookFailure = `-- name: RecordWebhookFailure :one
+UPDATE webhook_endpoints SET failures = failures + 1
+WHERE id = $1
+RETURNING failures`
+
+func (repository WebhookRepository) RecordFailure(id string) (int, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return 0, fmt.Errorf("webhook with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	var failures int
+	if err := repository.storage.conn().QueryRow(recordWebhookFailure, id).Scan(&failures); err != nil {
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+const resetWebhookFailures = `-- name: ResetWebhookFailures :exec
+UPDATE webhook_endpoints SET failures = 0
+WHERE id = $1 AND failures > 0`
+
+func (repository WebhookRepository) ResetFailures(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return fmt.Errorf("webhook with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	_, err := repository.storage.conn().Exec(resetWebhookFailures, id)
+	return err
+}
+
+const disableWebhookEndpoint = `-- name: DisableWebhookEndpoint :exec
+UPDATE webhook_endpoints SET disabled_at = $2
+WHERE id = $1 AND disabled_at IS NULL`
+
+func (repository WebhookRepository) Disable(id string, at time.Time) (bool, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return false, fmt.Errorf("webhook with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	res, err := repository.storage.conn().Exec(disableWebhookEndpoint, id, at)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+const deleteWebhookEndpoint = `-- name: DeleteWebhookEndpoint :exec
+DELETE FROM webhook_endpoints WHERE id = $1`
+
+func (repository WebhookRepository) Delete(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return fmt.Errorf("webhook with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	res, err := repository.storage.conn().Exec(deleteWebhookEndpoint, id)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(res, fmt.Errorf("webhook with id '%s' %w", id, storage.ErrNotFound))
+}
+
+func scanWebhookEndpoint(row scanner) (*models.WebhookEndpoint, error) {
+	var endpoint models.WebhookEndpoint
+	var disabledAt sql.NullTime
+	err := row.Scan(
+		&endpoint.ID,
+		&endpoint.OwnerLogin,
+		&endpoint.URL,
+		pq.Array(&endpoint.Events),
+		&endpoint.Secret,
+		&endpoint.Failures,
+		&endpoint.CreatedAt,
+		&disabledAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if disabledAt.Valid {
+		endpoint.DisabledAt = &disabledAt.Time
+	}
+
+	return &endpoint, nil
+}
+
+type WebhookDeliveryRepository struct {
+	storage *DBStorage
+}
+
+const createWebhookDelivery = `-- name: CreateWebhookDelivery :one
+INSERT INTO webhook_deliveries (id, endpoint_id, event_id, event, payload, status, attempts, created_at, next_attempt_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING id`
+
+func (repository WebhookDeliveryRepository) Create(delivery *models.WebhookDelivery) error {
+	if delivery.ID == "" {
+		delivery.ID = uuid.New().String()
+	}
+
+	row := repository.storage.conn().QueryRow(
+		createWebhookDelivery,
+		delivery.ID,
+		delivery.EndpointID,
+		delivery.EventID,
+		delivery.Event,
+		// jsonb is sent as text, pq would encode []byte as bytea
+		string(delivery.Payload),
+		delivery.Status,
+		delivery.Attempts,
+		delivery.CreatedAt,
+		delivery.NextAttemptAt,
+	)
+
+	return row.Scan(&delivery.ID)
+}
+
+const findWebhookDeliveryByID = `-- name: FindWebhookDeliveryByID :one
+SELECT id, endpoint_id, event_id, event, payload, status, attempts, response_status, error,
+       created_at, next_attempt_at, delivered_at FROM webhook_deliveries
+WHERE id = $1`
+
+func (repository WebhookDeliveryRepository) FindByID(id string) (*models.WebhookDelivery, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("webhook delivery with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	return scanWebhookDelivery(repository.storage.conn().QueryRow(findWebhookDeliveryByID, id))
+}
+
+const findWebhookDeliveriesByEndpoint = `-- name: FindWebhookDeliveriesByEndpoint :many
+SELECT id, endpoint_id, event_id, event, payload, status, attempts, response_status, error,
+       created_at, next_attempt_at, delivered_at FROM webhook_deliveries
+WHERE endpoint_id = $1
+ORDER BY created_at DESC
+LIMIT $2`
+
+func (repository WebhookDeliveryRepository) FindByEndpoint(endpointID string, limit int) ([]*models.WebhookDelivery, error) {
+	if _, err := uuid.Parse(endpointID); err != nil {
+		return []*models.WebhookDelivery{}, nil
+	}
+
+	return repository.find(findWebhookDeliveriesByEndpoint, endpointID, sql.NullInt64{Int64: int64(limit), Valid: limit > 0})
+}
+
+// servers claiming at the same time skip the rows the others are claiming instead of waiting for them
+const claimWebhookDeliveries = `-- name: ClaimWebhookDeliveries :many
+UPDATE webhook_deliveries SET next_attempt_at = $2
+WHERE id IN (
+    SELECT id FROM webhook_deliveries
+    WHERE status = 'pending' AND next_attempt_at <= $1
+    ORDER BY created_at
+    LIMIT $3
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, endpoint_id, event_id, event, payload, status, attempts, response_status, error,
+          created_at, next_attempt_at, delivered_at`
+
+func (repository WebhookDeliveryRepository) Claim(now, leaseEnd time.Time, limit int) ([]*models.WebhookDelivery, error) {
+	deliveries, err := repository.find(claimWebhookDeliveries, now, leaseEnd, sql.NullInt64{Int64: int64(limit), Valid: limit > 0})
+	if err != nil {
+		return nil, err
+	}
+
+	// RETURNING keeps no order
+	slices.SortFunc(deliveries, func(a, b *models.WebhookDelivery) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return deliveries, nil
+}
+
+func (repository WebhookDeliveryRepository) find(query string, args ...any) ([]*models.WebhookDelivery, error) {
+	rows, err := repository.storage.conn().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*models.WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+const updateWebhookDelivery = `-- name: UpdateWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET status = $2, attempts = $3, response_status = $4, error = $5, next_attempt_at = $6, delivered_at = $7
+WHERE id = $1 AND status = 'pending' AND next_attempt_at = $8`
+
+func (repository WebhookDeliveryRepository) Update(delivery *models.WebhookDelivery, lease time.Time) error {
+	if _, err := uuid.Parse(delivery.ID); err != nil {
+		return fmt.Errorf("webhook delivery with id '%s' %w", delivery.ID, storage.ErrLeaseLost)
+	}
+
+	res, err := repository.storage.conn().Exec(
+		updateWebhookDelivery,
+		delivery.ID,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.ResponseStatus,
+		delivery.Error,
+		delivery.NextAttemptAt,
+		delivery.DeliveredAt,
+		lease,
+	)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(res, fmt.Errorf("webhook delivery with id '%s' %w", delivery.ID, storage.ErrLeaseLost))
+}
+
+func scanWebhookDelivery(row scanner) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	var payload []byte
+	var nextAttemptAt, deliveredAt sql.NullTime
+	err := row.Scan(
+		&delivery.ID,
+		&delivery.EndpointID,
+		&delivery.EventID,
+		&delivery.Event,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.ResponseStatus,
+		&delivery.Error,
+		&delivery.CreatedAt,
+		&nextAttemptAt,
+		&deliveredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery.Payload = payload
+	if nextAttemptAt.Valid {
+		delivery.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+
+	return &delivery, nil
+}
@@ -0,0 +1,105 @@
+package sqlite_storage
+
+import (
+	"database/sql"
+	"vox-server/internal/models"
+)
+
+type AuditRepository struct {
+	storage *DBStorage
+}
+
+const appendAuditEvent = `-- name: AppendAuditEvent :one
+INSERT INTO audit_events (occurred_at, actor, target, action, ip, user_agent, request_id, metadata)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id`
+
+// Append stores a batch in one transaction, the one of the storage if any, recorded as a single span
+func (repository AuditRepository) Append(events ...*models.AuditEvent) (err error) {
+	conn := repository.storage.conn()
+	ctx, span := conn.startSpan(appendAuditEvent)
+	defer func() { endSpan(span, err) }()
+
+	return repository.storage.inTx(ctx, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, appendAuditEvent)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, event := range events {
+			// json is stored as text, []byte would be stored as a blob
+			metadata := sql.NullString{String: string(event.Metadata), Valid: len(event.Metadata) > 0}
+
+			err := stmt.QueryRowContext(
+				ctx,
+				formatTime(event.Time),
+				event.Actor,
+				event.Target,
+				event.Action,
+				event.IP,
+				event.UserAgent,
+				event.RequestID,
+				metadata,
+			).Scan(&event.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+const findAuditEvents = `-- name: FindAuditEvents :many
+SELECT id, occurred_at, actor, target, action, ip, user_agent, request_id, metadata FROM audit_events
+WHERE ($1 = '' OR actor = $1)
+  AND ($2 = '' OR target = $2)
+  AND ($3 = '' OR action = $3)
+  AND ($4 IS NULL OR occurred_at >= $4)
+  AND ($5 IS NULL OR occurred_at < $5)
+  AND ($6 = 0 OR id < $6)
+ORDER BY id DESC
+LIMIT $7`
+
+func (repository AuditRepository) Find(filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	rows, err := repository.storage.conn().Query(
+		findAuditEvents,
+		filter.Actor,
+		filter.Target,
+		filter.Action,
+		sql.NullTime{Time: filter.Since, Valid: !filter.Since.IsZero()},
+		sql.NullTime{Time: filter.Until, Valid: !filter.Until.IsZero()},
+		filter.Before,
+		maxRows(filter.Limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*models.AuditEvent{}
+	for rows.Next() {
+		var event models.AuditEvent
+		var metadata []byte
+		err := rows.Scan(
+			&event.ID,
+			&event.Time,
+			&event.Actor,
+			&event.Target,
+			&event.Action,
+			&event.IP,
+			&event.UserAgent,
+			&event.RequestID,
+			&metadata,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Metadata = metadata
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,87 @@
+package sqlite_storage
+
+import (
+	"fmt"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+)
+
+type IdentityRepository struct {
+	storage *DBStorage
+}
+
+const createIdentity = `-- name: CreateIdentity :exec
+INSERT INTO identities (provider, subject, user_login, email, created_at)
+VALUES ($1, $2, $3, $4, $5)`
+
+func (repository IdentityRepository) Create(identity *models.Identity) error {
+	_, err := repository.storage.conn().Exec(
+		createIdentity,
+		identity.Provider,
+		identity.Subject,
+		identity.UserLogin,
+		identity.Email,
+		identity.CreatedAt,
+	)
+	return err
+}
+
+const findIdentity = `-- name: FindIdentity :one
+SELECT provider, subject, user_login, email, created_at FROM identities
+WHERE provider = $1 AND subject = $2`
+
+func (repository IdentityRepository) Find(provider, subject string) (*models.Identity, error) {
+	return scanIdentity(repository.storage.conn().QueryRow(findIdentity, provider, subject))
+}
+
+const findIdentitiesByUser = `-- name: FindIdentitiesByUser :many
+SELECT provider, subject, user_login, email, created_at FROM identities
+WHERE user_login = $1
+ORDER BY created_at`
+
+func (repository IdentityRepository) FindByUser(login string) ([]*models.Identity, error) {
+	rows, err := repository.storage.conn().Query(findIdentitiesByUser, login)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*models.Identity{}
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+const deleteIdentity = `-- name: DeleteIdentity :exec
+DELETE FROM identities WHERE provider = $1 AND subject = $2`
+
+func (repository IdentityRepository) Delete(provider, subject string) error {
+	res, err := repository.storage.conn().Exec(deleteIdentity, provider, subject)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(res, fmt.Errorf("identity '%s' of provider '%s' %w", subject, provider, storage.ErrNotFound))
+}
+
+func scanIdentity(row scanner) (*models.Identity, error) {
+	var identity models.Identity
+	err := row.Scan(
+		&identity.Provider,
+		&identity.Subject,
+		&identity.UserLogin,
+		&identity.Email,
+		&identity.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
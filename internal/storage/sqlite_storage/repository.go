@@ -0,0 +1,160 @@
+package sqlite_storage
+
+import (
+	"fmt"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+)
+
+type UserRepository struct {
+	storage *DBStorage
+}
+
+const countUsers = `-- name: CountUsers :one
+SELECT COUNT(*) FROM users`
+
+func (repository UserRepository) Count() int {
+	var count int
+	err := repository.storage.conn().QueryRow(countUsers).Scan(&count)
+	if err != nil {
+		return 0
+	}
+	return count
+}
+
+func (repository UserRepository) IsEmpty() bool {
+	return repository.Count() == 0
+}
+
+const createUser = `-- name: CreateUser :one
+INSERT INTO users (login, username, email, encrypted_password, role)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING login`
+
+func (repository UserRepository) Create(arg_user *models.User) error {
+	if err := arg_user.Validate(true); err != nil {
+		return err
+	}
+
+	if err := arg_user.BeforeCreateContext(repository.storage.ctx); err != nil {
+		return err
+	}
+
+	row := repository.storage.conn().QueryRow(
+		createUser,
+		arg_user.Login,
+		arg_user.Username,
+		arg_user.Email,
+		arg_user.EncryptedPassword,
+		arg_user.Role,
+	)
+
+	err := row.Scan(
+		&arg_user.Login,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+const findUserByLogin = `-- name: FindByLogin :one
+SELECT login, username, email, encrypted_password, role FROM users
+WHERE login = $1`
+
+func (repository UserRepository) FindByLogin(login string) (*models.User, error) {
+	row := repository.storage.conn().QueryRow(findUserByLogin, login)
+	var u models.User
+	err := row.Scan(
+		&u.Login,
+		&u.Username,
+		&u.Email,
+		&u.EncryptedPassword,
+		&u.Role,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+const findUserByEmail = `-- name: FindByEmail :one
+SELECT login, username, email, encrypted_password, role FROM users
+WHERE email = $1`
+
+func (repository UserRepository) FindByEmail(email string) (*models.User, error) {
+	row := repository.storage.conn().QueryRow(findUserByEmail, email)
+	var u models.User
+	err := row.Scan(
+		&u.Login,
+		&u.Username,
+		&u.Email,
+		&u.EncryptedPassword,
+		&u.Role,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+const deleteUserByLogin = `-- name: DeleteByLogin :exec
+DELETE FROM users WHERE login = $1`
+
+func (repository UserRepository) DeleteByLogin(login string) error {
+	_, err := repository.storage.conn().Exec(deleteUserByLogin, login)
+	return err
+}
+
+const deleteUserByEmail = `-- name: DeleteByEmail :exec
+DELETE FROM users WHERE email = $1`
+
+func (repository UserRepository) DeleteByEmail(email string) error {
+	_, err := repository.storage.conn().Exec(deleteUserByEmail, email)
+	return err
+}
+
+const updateUser = `-- name: UpdateUser :exec
+UPDATE users
+SET username = $2, email = $3, role = COALESCE(NULLIF($4, ''), role)
+WHERE login = $1`
+
+// modifies non-unique fields and the email, passwords are changed by UpdatePassword
+func (repository UserRepository) Update(user *models.User) error {
+	res, err := repository.storage.conn().Exec(updateUser, user.Login, user.Username, user.Email, user.Role)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(res, fmt.Errorf("user with login '%s' %w", user.Login, storage.ErrNotFound))
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users SET encrypted_password = $2 WHERE login = $1`
+
+func (repository UserRepository) UpdatePassword(login, password string) error {
+	u, err := repository.FindByLogin(login)
+	if err != nil {
+		return err
+	}
+
+	u.Password = password
+	if err := u.Validate(true); err != nil {
+		return err
+	}
+
+	if err := u.BeforeCreateContext(repository.storage.ctx); err != nil {
+		return err
+	}
+
+	res, err := repository.storage.conn().Exec(updateUserPassword, login, u.EncryptedPassword)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(res, fmt.Errorf("user with login '%s' %w", login, storage.ErrNotFound))
+}
@@ -0,0 +1,63 @@
+package sqlite_storage_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+	"vox-server/internal/migrator"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+	"vox-server/internal/storage/sqlite_storage"
+	"vox-server/internal/storage/storage_suite"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// MakeTestDB opens a database of the latest schema in a file of its own, removed after the test
+func MakeTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	databaseURL := "sqlite://" + filepath.Join(t.TempDir(), "vox_test.db")
+
+	m, err := migrator.New(databaseURL)
+	if err != nil {
+		t.Fatalf("Failed to create migrator: %v", err)
+	}
+	defer m.Close()
+
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	db, err := sqlite_storage.Open(databaseURL)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Logf("Failed to close database connection: %v", err)
+		}
+	})
+
+	return db
+}
+
+func TestStorageSuite(t *testing.T) {
+	storage_suite.Run(t, func(t *testing.T) storage.Storage {
+		return sqlite_storage.NewDBStorage(MakeTestDB(t))
+	})
+}
+
+func TestAuditRepository_AppendOnly(t *testing.T) {
+	db := MakeTestDB(t)
+	store := sqlite_storage.NewDBStorage(db)
+
+	assert.NoError(t, store.Audit().Append(&models.AuditEvent{Time: time.Now(), Action: models.AuditLoginFailed}))
+
+	_, err := db.Exec("DELETE FROM audit_events")
+	assert.Error(t, err, "Expected error when deleting audit events")
+	_, err = db.Exec("UPDATE audit_events SET actor = 'mallory'")
+	assert.Error(t, err, "Expected error when updating audit events")
+}
@@ -0,0 +1,179 @@
+package sqlite_storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"time"
+	"vox-server/internal/storage"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const txRetryBackoff = 10 * time.Millisecond
+
+// the pragmas of every connection: writers wait for each other instead of failing at once,
+// readers don't block the writer and the foreign keys cascade as they do in postgres.
+// Transactions take the write lock when they begin, so that they never fail to upgrade their read lock
+const connParams = "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_txlock=immediate"
+
+// Open opens the database file of a sqlite://path url, the file is created when missing
+func Open(databaseURL string) (*sql.DB, error) {
+	path, ok := strings.CutPrefix(databaseURL, "sqlite://")
+	if !ok || path == "" {
+		return nil, errors.New("database url must be of the form sqlite://path")
+	}
+
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	return sql.Open("sqlite", path+separator+connParams)
+}
+
+type DBStorage struct {
+	db  *sql.DB
+	tx  *sql.Tx // set in WithTx
+	ctx context.Context
+}
+
+func NewDBStorage(db *sql.DB) *DBStorage {
+	return &DBStorage{
+		db:  db,
+		ctx: context.Background(),
+	}
+}
+
+func (storage *DBStorage) WithContext(ctx context.Context) storage.Storage {
+	return &DBStorage{
+		db:  storage.db,
+		tx:  storage.tx,
+		ctx: ctx,
+	}
+}
+
+// WithTx ignores the isolation level, transactions of sqlite are serializable
+func (storage *DBStorage) WithTx(ctx context.Context, fn func(tx storage.Storage) error, opts ...storage.TxOption) error {
+	return storage.inTx(ctx, func(tx *sql.Tx) error {
+		return fn(&DBStorage{db: storage.db, tx: tx, ctx: ctx})
+	}, opts...)
+}
+
+// inTx runs fn in the transaction of the storage, or in a new one run again when the database stays busy
+func (storage *DBStorage) inTx(ctx context.Context, fn func(tx *sql.Tx) error, opts ...storage.TxOption) error {
+	if storage.tx != nil {
+		return fn(storage.tx)
+	}
+
+	options := txOptions(opts)
+	for attempt := 0; ; attempt++ {
+		err := storage.runTx(ctx, fn)
+		if err == nil || attempt >= options.Retries || !isBusy(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(txRetryDelay(attempt)):
+		}
+	}
+}
+
+func (storage *DBStorage) runTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := storage.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// also when fn panics, a no-op once committed
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// txOptions is out of the methods of DBStorage, their receiver shadows the package storage
+func txOptions(opts []storage.TxOption) storage.TxOptions {
+	return storage.NewTxOptions(opts...)
+}
+
+// isBusy reports whether another connection held the lock of the database longer than the busy timeout,
+// the transaction succeeds when run again
+func isBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	// the extended codes keep the primary one in their low byte
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// txRetryDelay grows with the attempts, jittered so that the retried transactions don't collide again
+func txRetryDelay(attempt int) time.Duration {
+	delay := time.Duration(attempt+1) * txRetryBackoff
+	return delay + rand.N(delay)
+}
+
+// conn runs queries in the context of the storage, in its transaction if any
+func (storage *DBStorage) conn() tracedDB {
+	if storage.tx != nil {
+		return tracedDB{db: storage.tx, ctx: storage.ctx}
+	}
+	return tracedDB{db: storage.db, ctx: storage.ctx}
+}
+
+func (storage *DBStorage) Users() storage.UserRepository {
+	return UserRepository{storage: storage}
+}
+
+func (storage *DBStorage) APIKeys() storage.APIKeyRepository {
+	return APIKeyRepository{storage: storage}
+}
+
+func (storage *DBStorage) Sessions() storage.SessionRepository {
+	return SessionRepository{storage: storage}
+}
+
+func (storage *DBStorage) OAuthClients() storage.OAuthClientRepository {
+	return OAuthClientRepository{storage: storage}
+}
+
+func (storage *DBStorage) AuthorizationCodes() storage.AuthorizationCodeRepository {
+	return AuthorizationCodeRepository{storage: storage}
+}
+
+func (storage *DBStorage) Consents() storage.ConsentRepository {
+	return ConsentRepository{storage: storage}
+}
+
+func (storage *DBStorage) Identities() storage.IdentityRepository {
+	return IdentityRepository{storage: storage}
+}
+
+func (storage *DBStorage) Audit() storage.AuditRepository {
+	return AuditRepository{storage: storage}
+}
+
+func (storage *DBStorage) Idempotency() storage.IdempotencyRepository {
+	return IdempotencyRepository{storage: storage}
+}
+
+func (storage *DBStorage) Webhooks() storage.WebhookRepository {
+	return WebhookRepository{storage: storage}
+}
+
+func (storage *DBStorage) WebhookDeliveries() storage.WebhookDeliveryRepository {
+	return WebhookDeliveryRepository{storage: storage}
+}
+
+func (storage *DBStorage) Jobs() storage.JobRepository {
+	return JobRepository{storage: storage}
+}
@@ -0,0 +1,90 @@
+package sqlite_storage_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+	"vox-server/internal/storage/sqlite_storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBStorage_WithTx(t *testing.T) {
+	store := sqlite_storage.NewDBStorage(MakeTestDB(t))
+	ctx := context.Background()
+
+	// case : rolled back, nothing is stored
+	failed := errors.New("failed")
+	user := &models.User{Login: "outbox", Username: "outbox", Email: "outbox@example.org", Password: "password"}
+	rolledBack, _ := models.NewJob("test.outbox", nil, 3)
+	err := store.WithTx(ctx, func(tx storage.Storage) error {
+		assert.NoError(t, tx.Users().Create(user))
+		_, err := tx.Jobs().Enqueue(rolledBack)
+		assert.NoError(t, err)
+		return failed
+	})
+	assert.ErrorIs(t, err, failed)
+
+	_, err = store.Users().FindByLogin(user.Login)
+	assert.Error(t, err)
+	_, err = store.Jobs().FindByID(rolledBack.ID)
+	assert.Error(t, err)
+
+	// case : committed, a nested transaction joins the outer one
+	committed, _ := models.NewJob("test.outbox", nil, 3)
+	err = store.WithTx(ctx, func(tx storage.Storage) error {
+		if err := tx.Users().Create(user); err != nil {
+			return err
+		}
+		return tx.WithTx(ctx, func(nested storage.Storage) error {
+			_, err := nested.Jobs().Enqueue(committed)
+			return err
+		})
+	})
+	assert.NoError(t, err)
+
+	_, err = store.Users().FindByLogin(user.Login)
+	assert.NoError(t, err)
+	_, err = store.Jobs().FindByID(committed.ID)
+	assert.NoError(t, err)
+}
+
+func TestDBStorage_WithTx_Concurrent(t *testing.T) {
+	store := sqlite_storage.NewDBStorage(MakeTestDB(t))
+	ctx := context.Background()
+
+	counter, _ := models.NewJob("test.counter", nil, 3)
+	_, err := store.Jobs().Enqueue(counter)
+	assert.NoError(t, err)
+	// the counter is running, it is updated under its lease
+	_, err = store.Jobs().Claim(time.Now(), time.Now().Add(time.Hour), 1)
+	assert.NoError(t, err)
+
+	// the transactions take the write lock when they begin, the others wait for it
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := store.WithTx(ctx, func(tx storage.Storage) error {
+				job, err := tx.Jobs().FindByID(counter.ID)
+				if err != nil {
+					return err
+				}
+				job.Attempts++
+				return tx.Jobs().Update(job, *job.LockedUntil)
+			}, storage.Isolation(sql.LevelSerializable))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	job, err := store.Jobs().FindByID(counter.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 11, job.Attempts)
+}
@@ -0,0 +1,111 @@
+package sqlite_storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"vox-server/internal/storage"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is looked up on every use, so that it follows the current global provider
+func tracer() trace.Tracer {
+	return otel.Tracer("vox-server/internal/storage/sqlite_storage")
+}
+
+// querier is the database or the transaction the queries run in
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// tracedDB records a span for every query, named after the "-- name: X" comment of the query.
+// It binds the times of the arguments as text sqlite compares in order, see bindArgs
+type tracedDB struct {
+	db  querier
+	ctx context.Context
+}
+
+func queryName(query string) string {
+	if rest, ok := strings.CutPrefix(query, "-- name: "); ok {
+		if name, _, ok := strings.Cut(rest, " "); ok {
+			return name
+		}
+	}
+	return "query"
+}
+
+func (conn tracedDB) startSpan(query string) (context.Context, trace.Span) {
+	return tracer().Start(conn.ctx, queryName(query),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNameSQLite,
+			semconv.DBQueryText(query),
+		),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// storageError wraps the errors of the database in the ones of the storage: no rows in
+// storage.ErrNotFound and unique constraint failures in storage.ErrConflict
+func storageError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %w", storage.ErrNotFound, err)
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return fmt.Errorf("%w: %w", storage.ErrConflict, err)
+		}
+	}
+	return err
+}
+
+// tracedRow is the row of QueryRow, its Scan fails with the errors of storageError
+type tracedRow struct {
+	row *sql.Row
+}
+
+func (row tracedRow) Scan(dest ...any) error {
+	return storageError(row.row.Scan(dest...))
+}
+
+func (conn tracedDB) QueryRow(query string, args ...any) tracedRow {
+	ctx, span := conn.startSpan(query)
+	row := conn.db.QueryRowContext(ctx, query, bindArgs(args)...)
+	endSpan(span, row.Err())
+	return tracedRow{row: row}
+}
+
+func (conn tracedDB) Query(query string, args ...any) (*sql.Rows, error) {
+	ctx, span := conn.startSpan(query)
+	rows, err := conn.db.QueryContext(ctx, query, bindArgs(args)...)
+	endSpan(span, err)
+	return rows, err
+}
+
+func (conn tracedDB) Exec(query string, args ...any) (sql.Result, error) {
+	ctx, span := conn.startSpan(query)
+	res, err := conn.db.ExecContext(ctx, query, bindArgs(args)...)
+	endSpan(span, err)
+	return res, storageError(err)
+}
@@ -0,0 +1,91 @@
+package sqlite_storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// timeLayout has a fixed width and no zone, so that the times stored as text compare in order.
+// The driver parses it back into a UTC time.Time from the columns declared DATETIME
+const timeLayout = "2006-01-02 15:04:05.000000000"
+
+// bindArgs formats the times of the arguments with timeLayout, the driver would format them
+// with their zone and without the trailing zeros of their fraction
+func bindArgs(args []any) []any {
+	bound := make([]any, len(args))
+	for i, arg := range args {
+		switch arg := arg.(type) {
+		case time.Time:
+			bound[i] = formatTime(arg)
+		case *time.Time:
+			if arg != nil {
+				bound[i] = formatTime(*arg)
+			}
+		case sql.NullTime:
+			if arg.Valid {
+				bound[i] = formatTime(arg.Time)
+			}
+		default:
+			bound[i] = arg
+		}
+	}
+	return bound
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+// maxRows is the value of a LIMIT clause, LIMIT -1 is no limit
+func maxRows(n int) int {
+	if n > 0 {
+		return n
+	}
+	return -1
+}
+
+// stringArray stores a list of strings as a JSON array, sqlite has no array type
+type stringArray []string
+
+func (array stringArray) Value() (driver.Value, error) {
+	if array == nil {
+		return "[]", nil
+	}
+
+	data, err := json.Marshal([]string(array))
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// Scan reads an empty array as nil, as pq does
+func (array *stringArray) Scan(src any) error {
+	var data []byte
+	switch src := src.(type) {
+	case nil:
+		*array = nil
+		return nil
+	case string:
+		data = []byte(src)
+	case []byte:
+		data = src
+	default:
+		return fmt.Errorf("cannot scan %T into a string array", src)
+	}
+
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		values = nil
+	}
+
+	*array = values
+	return nil
+}
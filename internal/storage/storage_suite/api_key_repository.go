@@ -0,0 +1,80 @@
+package storage_suite
+
+import (
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestUser(t *testing.T, store storage.Storage) *models.User {
+	t.Helper()
+
+	user := &models.User{
+		Login:    "testuser",
+		Username: "TestUser",
+		Email:    "test@example.com",
+		Password: "password",
+	}
+	if err := store.Users().Create(user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	return user
+}
+
+func testAPIKeyRepositoryCreate(t *testing.T, store storage.Storage) {
+	user := createTestUser(t, store)
+
+	key, err := models.NewAPIKey(user.Login, "ci", []string{models.ScopeProfileRead, models.ScopeKeysRead})
+	assert.NoError(t, err)
+	assert.NoError(t, store.APIKeys().Create(key), "Create should not return an error")
+	assert.NotEmpty(t, key.ID)
+
+	found, err := store.APIKeys().FindByPrefix(key.Prefix)
+	assert.NoError(t, err, "FindByPrefix should not return an error")
+	assert.Equal(t, key.ID, found.ID)
+	assert.Equal(t, key.Scopes, found.Scopes)
+	assert.True(t, found.CompareKey(key.Key))
+	assert.Nil(t, found.LastUsedAt)
+	assert.Nil(t, found.RevokedAt)
+
+	duplicate, _ := models.NewAPIKey(user.Login, "ci", nil)
+	duplicate.Prefix = key.Prefix
+	assert.Error(t, store.APIKeys().Create(duplicate), "Expected error for duplicate prefix")
+}
+
+func testAPIKeyRepositoryFindByUser(t *testing.T, store storage.Storage) {
+	user := createTestUser(t, store)
+
+	key1, _ := models.NewAPIKey(user.Login, "first", nil)
+	key2, _ := models.NewAPIKey(user.Login, "second", nil)
+	key2.CreatedAt = key1.CreatedAt.Add(time.Second)
+	assert.NoError(t, store.APIKeys().Create(key1))
+	assert.NoError(t, store.APIKeys().Create(key2))
+
+	keys, err := store.APIKeys().FindByUser(user.Login)
+	assert.NoError(t, err, "FindByUser should not return an error")
+	if assert.Len(t, keys, 2) {
+		assert.Equal(t, "first", keys[0].Name)
+		assert.Equal(t, "second", keys[1].Name)
+	}
+}
+
+func testAPIKeyRepositoryRevokeAndTouch(t *testing.T, store storage.Storage) {
+	user := createTestUser(t, store)
+
+	key, _ := models.NewAPIKey(user.Login, "ci", nil)
+	assert.NoError(t, store.APIKeys().Create(key))
+
+	assert.NoError(t, store.APIKeys().TouchLastUsed(key.ID, time.Now()))
+	assert.NoError(t, store.APIKeys().Revoke(key.ID), "Revoke should not return an error")
+	assert.Error(t, store.APIKeys().Revoke(key.ID), "Expected error when revoking revoked key")
+
+	found, err := store.APIKeys().FindByID(key.ID)
+	assert.NoError(t, err)
+	assert.True(t, found.IsRevoked())
+	assert.NotNil(t, found.LastUsedAt)
+}
@@ -0,0 +1,44 @@
+package storage_suite
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testAuditRepository(t *testing.T, store storage.Storage) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	events := []*models.AuditEvent{
+		{Time: now.Add(-time.Hour), Actor: "alice", Target: "alice", Action: models.AuditUserRegistered},
+		{Time: now, Action: models.AuditLoginFailed, IP: "10.0.0.1", Metadata: json.RawMessage(`{"login":"bob"}`)},
+		{Time: now, Actor: "alice", Target: "bob", Action: models.AuditRoleChanged, RequestID: "req"},
+	}
+	assert.NoError(t, store.Audit().Append(events...), "Append should not return an error")
+	assert.NotZero(t, events[0].ID)
+	assert.Less(t, events[0].ID, events[2].ID)
+
+	// newest first
+	found, err := store.Audit().Find(models.AuditFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, found, 3)
+	assert.Equal(t, models.AuditRoleChanged, found[0].Action)
+	assert.JSONEq(t, `{"login":"bob"}`, string(found[1].Metadata))
+	assert.Nil(t, found[2].Metadata)
+
+	found, err = store.Audit().Find(models.AuditFilter{Actor: "alice", Since: now.Add(-time.Minute)})
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, "bob", found[0].Target)
+
+	// pagination
+	found, err = store.Audit().Find(models.AuditFilter{Before: events[2].ID, Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, events[1].ID, found[0].ID)
+
+}
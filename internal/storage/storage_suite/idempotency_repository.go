@@ -0,0 +1,71 @@
+package storage_suite
+
+import (
+	"net/http"
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testIdempotencyRepositoryCreateAndComplete(t *testing.T, store storage.Storage) {
+	record := models.NewIdempotencyRecord("user", "key", "fingerprint", time.Hour)
+	assert.NoError(t, store.Idempotency().Create(record))
+
+	// case : the key is in use
+	assert.Error(t, store.Idempotency().Create(models.NewIdempotencyRecord("user", "key", "other", time.Hour)))
+
+	// case : keys belong to their users
+	assert.NoError(t, store.Idempotency().Create(models.NewIdempotencyRecord("", "key", "fingerprint", time.Hour)))
+
+	found, err := store.Idempotency().Find("user", "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "fingerprint", found.Fingerprint)
+	assert.False(t, found.IsCompleted())
+
+	record.Status = http.StatusCreated
+	record.Header = http.Header{"Content-Type": {"application/json"}}
+	record.Body = []byte(`{"id":"1"}`)
+	record.ExpiresAt = time.Now().Add(time.Minute)
+	assert.NoError(t, store.Idempotency().Complete(record))
+
+	found, err = store.Idempotency().Find("user", "key")
+	assert.NoError(t, err)
+	assert.True(t, found.IsCompleted())
+	assert.Equal(t, http.StatusCreated, found.Status)
+	assert.Equal(t, record.Header, found.Header)
+	assert.Equal(t, record.Body, found.Body)
+	assert.WithinDuration(t, record.ExpiresAt, found.ExpiresAt, time.Millisecond, "the expiry is shortened")
+
+	_, err = store.Idempotency().Find("user", "nope")
+	assert.Error(t, err)
+	assert.Error(t, store.Idempotency().Complete(models.NewIdempotencyRecord("user", "nope", "fingerprint", time.Hour)))
+}
+
+func testIdempotencyRepositoryExpiry(t *testing.T, store storage.Storage) {
+	expired := models.NewIdempotencyRecord("user", "old", "fingerprint", time.Hour)
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	assert.NoError(t, store.Idempotency().Create(expired))
+	assert.NoError(t, store.Idempotency().Create(models.NewIdempotencyRecord("user", "new", "fingerprint", time.Hour)))
+
+	_, err := store.Idempotency().Find("user", "old")
+	assert.Error(t, err, "expired keys are not found")
+
+	deleted, err := store.Idempotency().DeleteExpired(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	// case : an expired key can be used again
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	assert.NoError(t, store.Idempotency().Create(expired))
+	assert.NoError(t, store.Idempotency().Create(models.NewIdempotencyRecord("user", "old", "other", time.Hour)))
+
+	found, err := store.Idempotency().Find("user", "old")
+	assert.NoError(t, err)
+	assert.Equal(t, "other", found.Fingerprint)
+
+	assert.NoError(t, store.Idempotency().Delete("user", "old"))
+	assert.Error(t, store.Idempotency().Delete("user", "old"))
+}
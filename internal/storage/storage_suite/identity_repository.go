@@ -0,0 +1,37 @@
+package storage_suite
+
+import (
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testIdentityRepository(t *testing.T, store storage.Storage) {
+	user := createTestUser(t, store)
+
+	identity := &models.Identity{
+		Provider:  "corp",
+		Subject:   "248289761001",
+		UserLogin: user.Login,
+		Email:     "user@corp.example",
+		CreatedAt: time.Now(),
+	}
+	assert.NoError(t, store.Identities().Create(identity), "Create should not return an error")
+	assert.Error(t, store.Identities().Create(identity), "Expected error for duplicate identity")
+
+	found, err := store.Identities().Find("corp", "248289761001")
+	assert.NoError(t, err, "Find should not return an error")
+	assert.Equal(t, user.Login, found.UserLogin)
+	assert.Equal(t, "user@corp.example", found.Email)
+
+	identities, err := store.Identities().FindByUser(user.Login)
+	assert.NoError(t, err)
+	assert.Len(t, identities, 1)
+
+	assert.NoError(t, store.Identities().Delete("corp", "248289761001"), "Delete should not return an error")
+	_, err = store.Identities().Find("corp", "248289761001")
+	assert.Error(t, err, "Expected error when finding deleted identity")
+}
@@ -0,0 +1,139 @@
+package storage_suite
+
+import (
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testJobRepository(t *testing.T, store storage.Storage) {
+	now := time.Now().UTC()
+
+	first, _ := models.NewJob("test.first", map[string]int{"n": 1}, 3)
+	first.RunAt = now.Add(-time.Minute)
+	second, _ := models.NewJob("test.second", nil, 3)
+	second.RunAt = now.Add(-time.Second)
+	second.CreatedAt = first.CreatedAt.Add(time.Second)
+	delayed, _ := models.NewJob("test.first", nil, 3)
+	delayed.RunAt = now.Add(time.Hour)
+
+	for _, job := range []*models.Job{second, first, delayed} {
+		created, err := store.Jobs().Enqueue(job)
+		assert.NoError(t, err)
+		assert.True(t, created)
+		assert.NotEmpty(t, job.ID)
+	}
+
+	// case : the key is taken
+	keyed, _ := models.NewJob("test.recurring", nil, 3)
+	keyed.Key = "tick@1700000000"
+	keyed.RunAt = now.Add(time.Hour)
+	created, err := store.Jobs().Enqueue(keyed)
+	assert.NoError(t, err)
+	assert.True(t, created)
+
+	again, _ := models.NewJob("test.recurring", nil, 3)
+	again.Key = keyed.Key
+	created, err = store.Jobs().Enqueue(again)
+	assert.NoError(t, err)
+	assert.False(t, created)
+
+	found, err := store.Jobs().FindByID(first.ID)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"n": 1}`, string(found.Payload))
+	assert.Equal(t, models.JobPending, found.Status)
+
+	// due first, the delayed job isn't due
+	leaseEnd := now.Add(time.Minute)
+	claimed, err := store.Jobs().Claim(now, leaseEnd, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 2) {
+		assert.Equal(t, first.ID, claimed[0].ID)
+		assert.Equal(t, second.ID, claimed[1].ID)
+		assert.Equal(t, models.JobRunning, claimed[0].Status)
+		assert.Equal(t, 1, claimed[0].Attempts)
+		if assert.NotNil(t, claimed[0].LockedUntil) {
+			assert.WithinDuration(t, leaseEnd, *claimed[0].LockedUntil, time.Millisecond)
+		}
+	}
+
+	claimed, err = store.Jobs().Claim(now, leaseEnd, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed, "running jobs aren't claimed until their lease ends")
+
+	claimed, err = store.Jobs().Claim(leaseEnd, leaseEnd.Add(time.Minute), 1)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, first.ID, claimed[0].ID)
+		assert.Equal(t, 2, claimed[0].Attempts)
+	}
+
+	// case : the attempt of the first claim outlived its lease
+	stale := *claimed[0]
+	stale.Status = models.JobPending
+	assert.ErrorIs(t, store.Jobs().Update(&stale, leaseEnd), storage.ErrLeaseLost)
+
+	job := claimed[0]
+	lease := *job.LockedUntil
+	job.Status = models.JobSucceeded
+	job.LockedUntil = nil
+	job.FinishedAt = &now
+	assert.NoError(t, store.Jobs().Update(job, lease))
+	assert.ErrorIs(t, store.Jobs().Update(job, lease), storage.ErrLeaseLost, "the job isn't running anymore")
+
+	// case : a dead job is retried once
+	_, err = store.Jobs().Retry(first.ID, now)
+	assert.ErrorIs(t, err, storage.ErrNotFound, "only dead jobs are retried")
+
+	dead := *second
+	dead.Status = models.JobDead
+	dead.Attempts = 3
+	dead.LockedUntil = nil
+	dead.FinishedAt = &now
+	assert.NoError(t, store.Jobs().Update(&dead, leaseEnd))
+
+	retried, err := store.Jobs().Retry(second.ID, now)
+	assert.NoError(t, err)
+	if assert.NotNil(t, retried) {
+		assert.Equal(t, models.JobPending, retried.Status)
+		assert.Equal(t, 0, retried.Attempts)
+		assert.Nil(t, retried.LockedUntil)
+		assert.Nil(t, retried.FinishedAt)
+	}
+	_, err = store.Jobs().Retry(second.ID, now)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	found, err = store.Jobs().FindByID(first.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.JobSucceeded, found.Status)
+	assert.Nil(t, found.LockedUntil)
+	assert.NotNil(t, found.FinishedAt)
+
+	jobs, err := store.Jobs().Find(models.JobFilter{Status: models.JobSucceeded})
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, first.ID, jobs[0].ID)
+	}
+
+	jobs, err = store.Jobs().Find(models.JobFilter{Kind: "test.first"})
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 2)
+
+	jobs, err = store.Jobs().Find(models.JobFilter{Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+
+	deleted, err := store.Jobs().DeleteFinished(now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	_, err = store.Jobs().FindByID(first.ID)
+	assert.Error(t, err)
+
+	missing := *job
+	missing.ID = "9d2f4b6a-1c3e-4f5a-8b7c-0e1d2f3a4b5c"
+	assert.ErrorIs(t, store.Jobs().Update(&missing, lease), storage.ErrLeaseLost)
+}
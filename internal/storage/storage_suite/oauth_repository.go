@@ -0,0 +1,81 @@
+package storage_suite
+
+import (
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testOAuthClientRepository(t *testing.T, store storage.Storage) {
+	user := createTestUser(t, store)
+
+	client, err := models.NewOAuthClient(user.Login, "wiki", []string{"https://wiki.local/callback"}, false)
+	assert.NoError(t, err)
+	assert.NoError(t, store.OAuthClients().Create(client), "Create should not return an error")
+
+	found, err := store.OAuthClients().FindByID(client.ID)
+	assert.NoError(t, err, "FindByID should not return an error")
+	assert.Equal(t, client.RedirectURIs, found.RedirectURIs)
+	assert.True(t, found.CompareSecret(client.Secret))
+
+	clients, err := store.OAuthClients().FindByOwner(user.Login)
+	assert.NoError(t, err)
+	assert.Len(t, clients, 1)
+
+	assert.NoError(t, store.OAuthClients().Delete(client.ID), "Delete should not return an error")
+	_, err = store.OAuthClients().FindByID(client.ID)
+	assert.Error(t, err, "Expected error when finding deleted client")
+}
+
+func testAuthorizationCodeRepositoryConsume(t *testing.T, store storage.Storage) {
+	user := createTestUser(t, store)
+
+	client, _ := models.NewOAuthClient(user.Login, "wiki", []string{"https://wiki.local/callback"}, true)
+	assert.NoError(t, store.OAuthClients().Create(client))
+
+	code, err := models.NewAuthorizationCode(client.ID, user.Login, "https://wiki.local/callback", []string{"openid"})
+	assert.NoError(t, err)
+	code.CodeChallenge = "challenge"
+	code.CodeChallengeMethod = models.CodeChallengeS256
+	assert.NoError(t, store.AuthorizationCodes().Create(code), "Create should not return an error")
+
+	_, err = store.AuthorizationCodes().Consume(code.CodeHash, "other", "https://wiki.local/callback")
+	assert.Error(t, err, "Expected error when consuming the code of another client")
+	_, err = store.AuthorizationCodes().Consume(code.CodeHash, client.ID, "https://evil.local/callback")
+	assert.Error(t, err, "Expected error when consuming the code with another redirect uri")
+
+	found, err := store.AuthorizationCodes().Consume(code.CodeHash, client.ID, "https://wiki.local/callback")
+	assert.NoError(t, err, "Consume should not return an error")
+	assert.Equal(t, []string{"openid"}, found.Scopes)
+	assert.Equal(t, "challenge", found.CodeChallenge)
+
+	_, err = store.AuthorizationCodes().Consume(code.CodeHash, client.ID, "https://wiki.local/callback")
+	assert.Error(t, err, "Expected error when consuming code twice")
+}
+
+func testConsentRepository(t *testing.T, store storage.Storage) {
+	user := createTestUser(t, store)
+
+	client, _ := models.NewOAuthClient(user.Login, "wiki", []string{"https://wiki.local/callback"}, true)
+	assert.NoError(t, store.OAuthClients().Create(client))
+
+	for _, scopes := range [][]string{{"openid", "email"}, {"openid", "profile"}} {
+		assert.NoError(t, store.Consents().Grant(&models.Consent{
+			UserLogin: user.Login,
+			ClientID:  client.ID,
+			Scopes:    scopes,
+			GrantedAt: time.Now(),
+		}), "Grant should not return an error")
+	}
+
+	consent, err := store.Consents().Find(user.Login, client.ID)
+	assert.NoError(t, err, "Find should not return an error")
+	assert.ElementsMatch(t, []string{"openid", "email", "profile"}, consent.Scopes)
+
+	assert.NoError(t, store.Consents().Revoke(user.Login, client.ID))
+	_, err = store.Consents().Find(user.Login, client.ID)
+	assert.Error(t, err)
+}
@@ -0,0 +1,162 @@
+package storage_suite
+
+import (
+	"testing"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testUserRepositoryCreate(t *testing.T, store storage.Storage) {
+	repo := store.Users()
+
+	user := &models.User{
+		Login:    "testuser",
+		Username: "TestUser",
+		Email:    "test@example.com",
+		Password: "password",
+	}
+
+	err := repo.Create(user)
+
+	assert.NoError(t, err, "Create should not return an error")
+	assert.NotNil(t, user)
+
+	foundUser, err := repo.FindByLogin("testuser")
+	if err != nil {
+		t.Fatalf("Failed to find user: %v", err)
+	}
+
+	if foundUser.Login != "testuser" {
+		t.Errorf("Expected login 'testuser', got '%s'", foundUser.Login)
+	}
+
+	// case : the login or the email is taken
+	takenLogin := &models.User{Login: "testuser", Username: "Other", Email: "other@example.com", Password: "password"}
+	assert.ErrorIs(t, repo.Create(takenLogin), storage.ErrConflict)
+	takenEmail := &models.User{Login: "other", Username: "Other", Email: "test@example.com", Password: "password"}
+	assert.ErrorIs(t, repo.Create(takenEmail), storage.ErrConflict)
+
+	_, err = repo.FindByLogin("other")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testUserRepositoryCount(t *testing.T, store storage.Storage) {
+	repo := store.Users()
+
+	assert.Equal(t, 0, repo.Count(), "Expected 0 users in the database")
+
+	user := &models.User{
+		Login:    "testuser",
+		Username: "TestUser",
+		Email:    "test@example.com",
+		Password: "password",
+	}
+	err := repo.Create(user)
+	assert.NoError(t, err, "Create should not return an error")
+
+	assert.Equal(t, 1, repo.Count(), "Expected 1 user in the database")
+}
+
+func testUserRepositoryIsEmpty(t *testing.T, store storage.Storage) {
+	repo := store.Users()
+
+	assert.True(t, repo.IsEmpty(), "Expected database to be empty")
+
+	user := &models.User{
+		Login:    "testuser",
+		Username: "TestUser",
+		Email:    "test@example.com",
+		Password: "password",
+	}
+	err := repo.Create(user)
+	assert.NoError(t, err, "Create should not return an error")
+
+	assert.False(t, repo.IsEmpty(), "Expected database to not be empty")
+}
+
+func testUserRepositoryFindByEmail(t *testing.T, store storage.Storage) {
+	repo := store.Users()
+
+	user := &models.User{
+		Login:    "testuser",
+		Username: "TestUser",
+		Email:    "test@example.com",
+		Password: "password",
+	}
+	err := repo.Create(user)
+	assert.NoError(t, err, "Create should not return an error")
+
+	foundUser, err := repo.FindByEmail("test@example.com")
+	assert.NoError(t, err, "FindByEmail should not return an error")
+	assert.Equal(t, user.Login, foundUser.Login, "Expected login to match")
+	assert.Equal(t, user.Email, foundUser.Email, "Expected email to match")
+}
+
+func testUserRepositoryDeleteByLogin(t *testing.T, store storage.Storage) {
+	repo := store.Users()
+
+	user := &models.User{
+		Login:    "testuser",
+		Username: "TestUser",
+		Email:    "test@example.com",
+		Password: "password",
+	}
+	err := repo.Create(user)
+	assert.NoError(t, err, "Create should not return an error")
+
+	err = repo.DeleteByLogin("testuser")
+	assert.NoError(t, err, "DeleteByLogin should not return an error")
+
+	_, err = repo.FindByLogin("testuser")
+	assert.Error(t, err, "Expected error when finding deleted user")
+}
+
+func testUserRepositoryDeleteByEmail(t *testing.T, store storage.Storage) {
+	repo := store.Users()
+
+	user := &models.User{
+		Login:    "testuser",
+		Username: "TestUser",
+		Email:    "test@example.com",
+		Password: "password",
+	}
+	err := repo.Create(user)
+	assert.NoError(t, err, "Create should not return an error")
+
+	err = repo.DeleteByEmail("test@example.com")
+	assert.NoError(t, err, "DeleteByEmail should not return an error")
+
+	_, err = repo.FindByEmail("test@example.com")
+	assert.Error(t, err, "Expected error when finding deleted user")
+}
+
+func testUserRepositoryUpdate(t *testing.T, store storage.Storage) {
+	repo := store.Users()
+
+	user := &models.User{
+		Login:    "testuser",
+		Username: "TestUser",
+		Email:    "test@example.com",
+		Password: "password",
+	}
+	assert.NoError(t, repo.Create(user), "Create should not return an error")
+	assert.Equal(t, models.RoleUser, user.Role)
+
+	user.Username = "Renamed"
+	user.Role = models.RoleAdmin
+	assert.NoError(t, repo.Update(user), "Update should not return an error")
+
+	found, err := repo.FindByLogin("testuser")
+	assert.NoError(t, err)
+	assert.Equal(t, "Renamed", found.Username)
+	assert.True(t, found.IsAdmin())
+
+	assert.NoError(t, repo.UpdatePassword("testuser", "n3wPassword"), "UpdatePassword should not return an error")
+	found, err = repo.FindByLogin("testuser")
+	assert.NoError(t, err)
+	assert.True(t, found.ComparePassword("n3wPassword"))
+
+	assert.Error(t, repo.Update(&models.User{Login: "nobody", Username: "x", Email: "x@example.com"}))
+}
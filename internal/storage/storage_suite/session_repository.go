@@ -0,0 +1,30 @@
+package storage_suite
+
+import (
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSessionRepositoryCreateAndRevoke(t *testing.T, store storage.Storage) {
+	user := createTestUser(t, store)
+
+	session := models.NewSession(user.Login, "", nil, time.Hour)
+	assert.NoError(t, store.Sessions().Create(session), "Create should not return an error")
+
+	found, err := store.Sessions().FindByID(session.ID)
+	assert.NoError(t, err, "FindByID should not return an error")
+	assert.Equal(t, user.Login, found.UserLogin)
+	assert.Empty(t, found.ClientID)
+	assert.True(t, found.IsActive(time.Now()))
+
+	assert.NoError(t, store.Sessions().Revoke(session.ID), "Revoke should not return an error")
+	assert.Error(t, store.Sessions().Revoke(session.ID), "Expected error when revoking revoked session")
+
+	found, err = store.Sessions().FindByID(session.ID)
+	assert.NoError(t, err)
+	assert.False(t, found.IsActive(time.Now()))
+}
@@ -0,0 +1,44 @@
+// Package storage_suite holds the tests every backend of the storage passes, so that the
+// backends behave the same whatever the server runs on
+package storage_suite
+
+import (
+	"testing"
+	"vox-server/internal/storage"
+)
+
+var tests = []struct {
+	name string
+	test func(t *testing.T, store storage.Storage)
+}{
+	{"UserRepository_Create", testUserRepositoryCreate},
+	{"UserRepository_Count", testUserRepositoryCount},
+	{"UserRepository_IsEmpty", testUserRepositoryIsEmpty},
+	{"UserRepository_FindByEmail", testUserRepositoryFindByEmail},
+	{"UserRepository_DeleteByLogin", testUserRepositoryDeleteByLogin},
+	{"UserRepository_DeleteByEmail", testUserRepositoryDeleteByEmail},
+	{"UserRepository_Update", testUserRepositoryUpdate},
+	{"APIKeyRepository_Create", testAPIKeyRepositoryCreate},
+	{"APIKeyRepository_FindByUser", testAPIKeyRepositoryFindByUser},
+	{"APIKeyRepository_RevokeAndTouch", testAPIKeyRepositoryRevokeAndTouch},
+	{"SessionRepository_CreateAndRevoke", testSessionRepositoryCreateAndRevoke},
+	{"OAuthClientRepository", testOAuthClientRepository},
+	{"AuthorizationCodeRepository_Consume", testAuthorizationCodeRepositoryConsume},
+	{"ConsentRepository", testConsentRepository},
+	{"IdentityRepository", testIdentityRepository},
+	{"AuditRepository", testAuditRepository},
+	{"IdempotencyRepository_CreateAndComplete", testIdempotencyRepositoryCreateAndComplete},
+	{"IdempotencyRepository_Expiry", testIdempotencyRepositoryExpiry},
+	{"WebhookRepository", testWebhookRepository},
+	{"WebhookDeliveryRepository", testWebhookDeliveryRepository},
+	{"JobRepository", testJobRepository},
+}
+
+// Run runs the tests of the suite, each on an empty storage made by newStorage
+func Run(t *testing.T, newStorage func(t *testing.T) storage.Storage) {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
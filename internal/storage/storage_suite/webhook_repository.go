@@ -0,0 +1,170 @@
+package storage_suite
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testWebhookRepository(t *testing.T, store storage.Storage) {
+	endpoint, _ := models.NewWebhookEndpoint("admin", "https://hooks.example.org/vox", []string{models.AuditUserRegistered})
+	assert.NoError(t, store.Webhooks().Create(endpoint))
+	assert.NotEmpty(t, endpoint.ID)
+
+	// case : invalid endpoint
+	invalid, _ := models.NewWebhookEndpoint("admin", "https://hooks.example.org/vox", []string{"everything"})
+	assert.Error(t, store.Webhooks().Create(invalid))
+
+	other, _ := models.NewWebhookEndpoint("admin", "https://other.example.org/vox", []string{models.AuditUserDeleted})
+	other.CreatedAt = endpoint.CreatedAt.Add(time.Second)
+	assert.NoError(t, store.Webhooks().Create(other))
+
+	found, err := store.Webhooks().FindByID(endpoint.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, endpoint.Secret, found.Secret)
+	assert.Equal(t, endpoint.Events, found.Events)
+	assert.True(t, found.IsEnabled())
+
+	endpoints, err := store.Webhooks().FindAll()
+	assert.NoError(t, err)
+	if assert.Len(t, endpoints, 2) {
+		assert.Equal(t, endpoint.ID, endpoints[0].ID)
+	}
+
+	subscribed, err := store.Webhooks().FindSubscribed(models.AuditUserRegistered)
+	assert.NoError(t, err)
+	if assert.Len(t, subscribed, 1) {
+		assert.Equal(t, endpoint.ID, subscribed[0].ID)
+	}
+
+	// disabled endpoints aren't subscribed
+	disabledAt := time.Now().UTC()
+	found.Failures = 5
+	found.DisabledAt = &disabledAt
+	found.Secret = "changed"
+	assert.NoError(t, store.Webhooks().Update(found))
+
+	found, err = store.Webhooks().FindByID(endpoint.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, found.Failures)
+	assert.False(t, found.IsEnabled())
+	assert.Equal(t, endpoint.Secret, found.Secret, "the secret is never changed")
+
+	subscribed, err = store.Webhooks().FindSubscribed(models.AuditUserRegistered)
+	assert.NoError(t, err)
+	assert.Empty(t, subscribed)
+
+	// the failures are counted in a row, whoever records them
+	failures, err := store.Webhooks().RecordFailure(other.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, failures)
+	failures, err = store.Webhooks().RecordFailure(other.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, failures)
+	assert.NoError(t, store.Webhooks().ResetFailures(other.ID))
+	failures, err = store.Webhooks().RecordFailure(other.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, failures)
+
+	_, err = store.Webhooks().RecordFailure("6d8e0f2a-4b6c-4d8e-9f0a-1b3c5d7e9f1a")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	disabled, err := store.Webhooks().Disable(other.ID, disabledAt)
+	assert.NoError(t, err)
+	assert.True(t, disabled)
+	disabled, err = store.Webhooks().Disable(other.ID, disabledAt.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, disabled, "the endpoint is disabled already")
+
+	found, err = store.Webhooks().FindByID(other.ID)
+	assert.NoError(t, err)
+	assert.False(t, found.IsEnabled())
+	assert.Equal(t, 1, found.Failures)
+
+	found.Events = nil
+	assert.Error(t, store.Webhooks().Update(found))
+
+	assert.NoError(t, store.Webhooks().Delete(other.ID))
+	assert.Error(t, store.Webhooks().Delete(other.ID))
+	_, err = store.Webhooks().FindByID(other.ID)
+	assert.Error(t, err)
+}
+
+func testWebhookDeliveryRepository(t *testing.T, store storage.Storage) {
+	endpoint, _ := models.NewWebhookEndpoint("admin", "https://hooks.example.org/vox", []string{models.AuditUserRegistered})
+	assert.NoError(t, store.Webhooks().Create(endpoint))
+
+	payload := json.RawMessage(`{"type": "user.registered"}`)
+	first := models.NewWebhookDelivery(endpoint.ID, "3f1c2a6e-5b7d-4c1e-9a0b-2d4e6f8a0b1c", models.AuditUserRegistered, payload)
+	second := models.NewWebhookDelivery(endpoint.ID, "7a9b1c3d-5e7f-4a1b-8c2d-4e6f8a0b2c3d", models.AuditUserRegistered, payload)
+	second.CreatedAt = first.CreatedAt.Add(time.Second)
+	assert.NoError(t, store.WebhookDeliveries().Create(first))
+	assert.NoError(t, store.WebhookDeliveries().Create(second))
+	assert.NotEmpty(t, first.ID)
+
+	// case : unknown endpoint
+	assert.Error(t, store.WebhookDeliveries().Create(models.NewWebhookDelivery("6d8e0f2a-4b6c-4d8e-9f0a-1b3c5d7e9f1a", first.EventID, first.Event, payload)))
+
+	// claimed deliveries wait for the end of the lease
+	now := time.Now()
+	claimed, err := store.WebhookDeliveries().Claim(now, now.Add(time.Minute), 10)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 2) {
+		assert.Equal(t, first.ID, claimed[0].ID, "oldest first")
+		assert.JSONEq(t, string(payload), string(claimed[0].Payload))
+	}
+
+	claimed, err = store.WebhookDeliveries().Claim(now, now.Add(time.Minute), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed)
+
+	claimed, err = store.WebhookDeliveries().Claim(now.Add(time.Minute), now.Add(2*time.Minute), 1)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, first.ID, claimed[0].ID)
+	}
+
+	// case : the attempt of the first claim outlived its lease
+	stale := *first
+	stale.Attempts = 1
+	assert.ErrorIs(t, store.WebhookDeliveries().Update(&stale, now.Add(time.Minute)), storage.ErrLeaseLost)
+
+	lease := *claimed[0].NextAttemptAt
+	deliveredAt := time.Now().UTC()
+	first.Status = models.WebhookDeliverySucceeded
+	first.Attempts = 1
+	first.ResponseStatus = 200
+	first.NextAttemptAt = nil
+	first.DeliveredAt = &deliveredAt
+	assert.NoError(t, store.WebhookDeliveries().Update(first, lease))
+	assert.ErrorIs(t, store.WebhookDeliveries().Update(first, lease), storage.ErrLeaseLost, "the delivery isn't pending anymore")
+
+	found, err := store.WebhookDeliveries().FindByID(first.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.WebhookDeliverySucceeded, found.Status)
+	assert.Equal(t, 200, found.ResponseStatus)
+	assert.Nil(t, found.NextAttemptAt)
+	assert.NotNil(t, found.DeliveredAt)
+
+	// finished deliveries are never claimed
+	claimed, err = store.WebhookDeliveries().Claim(now.Add(time.Hour), now.Add(2*time.Hour), 10)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, second.ID, claimed[0].ID)
+	}
+
+	deliveries, err := store.WebhookDeliveries().FindByEndpoint(endpoint.ID, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, deliveries, 2) {
+		assert.Equal(t, second.ID, deliveries[0].ID, "newest first")
+	}
+
+	// the deliveries are deleted with their endpoint
+	assert.NoError(t, store.Webhooks().Delete(endpoint.ID))
+	_, err = store.WebhookDeliveries().FindByID(first.ID)
+	assert.Error(t, err)
+}
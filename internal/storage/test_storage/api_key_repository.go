@@ -0,0 +1,144 @@
+package test_storage
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+type APIKeyRepository struct {
+	keys     map[string]*models.APIKey // id -> key
+	prefixes map[string]string         // prefix -> id
+	mu       *sync.RWMutex
+}
+
+func NewAPIKeyRepository() *APIKeyRepository {
+	return &APIKeyRepository{
+		keys:     make(map[string]*models.APIKey),
+		prefixes: make(map[string]string),
+		mu:       &sync.RWMutex{},
+	}
+}
+
+func (repository *APIKeyRepository) lock()   { repository.mu.Lock() }
+func (repository *APIKeyRepository) unlock() { repository.mu.Unlock() }
+
+func (repository *APIKeyRepository) clone() *APIKeyRepository {
+	return &APIKeyRepository{
+		keys:     cloneMap(repository.keys, copyOf),
+		prefixes: maps.Clone(repository.prefixes),
+		mu:       &sync.RWMutex{},
+	}
+}
+
+func (repository *APIKeyRepository) replace(copied *APIKeyRepository) {
+	replaceMap(repository.keys, copied.keys)
+	replaceMap(repository.prefixes, copied.prefixes)
+}
+
+func (repository APIKeyRepository) Create(key *models.APIKey) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	if err := key.Validate(); err != nil {
+		return err
+	}
+
+	if _, ok := repository.prefixes[key.Prefix]; ok {
+		return fmt.Errorf("api key with such prefix '%s' %w", key.Prefix, storage.ErrConflict)
+	}
+
+	if key.ID == "" {
+		key.ID = uuid.New().String()
+	}
+
+	// the plain key is never stored
+	stored := *key
+	stored.Key = ""
+	stored.Scopes = append([]string{}, key.Scopes...)
+
+	repository.keys[stored.ID] = &stored
+	repository.prefixes[stored.Prefix] = stored.ID
+
+	return nil
+}
+
+func (repository APIKeyRepository) FindByID(id string) (*models.APIKey, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	key, ok := repository.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("api key with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	found := *key
+	return &found, nil
+}
+
+func (repository APIKeyRepository) FindByPrefix(prefix string) (*models.APIKey, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	key, ok := repository.keys[repository.prefixes[prefix]]
+	if !ok {
+		return nil, fmt.Errorf("api key with prefix '%s' %w", prefix, storage.ErrNotFound)
+	}
+
+	found := *key
+	return &found, nil
+}
+
+func (repository APIKeyRepository) FindByUser(login string) ([]*models.APIKey, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	keys := []*models.APIKey{}
+	for _, key := range repository.keys {
+		if key.UserLogin == login {
+			found := *key
+			keys = append(keys, &found)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+func (repository APIKeyRepository) Revoke(id string) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	key, ok := repository.keys[id]
+	if !ok || key.IsRevoked() {
+		return fmt.Errorf("active api key with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	now := time.Now().UTC()
+	key.RevokedAt = &now
+
+	return nil
+}
+
+func (repository APIKeyRepository) TouchLastUsed(id string, at time.Time) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	key, ok := repository.keys[id]
+	if !ok {
+		return fmt.Errorf("api key with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	key.LastUsedAt = &at
+
+	return nil
+}
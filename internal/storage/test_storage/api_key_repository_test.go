@@ -0,0 +1,94 @@
+package test_storage_test
+
+import (
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage/test_storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyRepository_Create(t *testing.T) {
+	storage := test_storage.NewInMemoryStorage()
+
+	// default case: new valid key
+	key, _ := models.NewAPIKey("user", "ci", []string{models.ScopeProfileRead})
+	assert.NoError(t, storage.APIKeys().Create(key))
+	assert.NotEmpty(t, key.ID)
+
+	found, err := storage.APIKeys().FindByPrefix(key.Prefix)
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, found.ID)
+	assert.Empty(t, found.Key, "plain key must not be stored")
+	assert.True(t, found.CompareKey(key.Key))
+
+	// case : key with the same prefix
+	duplicate, _ := models.NewAPIKey("user", "ci", nil)
+	duplicate.Prefix = key.Prefix
+	assert.Error(t, storage.APIKeys().Create(duplicate))
+
+	// case : key with an unknown scope
+	invalid, _ := models.NewAPIKey("user", "ci", []string{"everything"})
+	assert.Error(t, storage.APIKeys().Create(invalid))
+}
+
+func TestAPIKeyRepository_FindByUser(t *testing.T) {
+	storage := test_storage.NewInMemoryStorage()
+
+	key1, _ := models.NewAPIKey("abra", "first", nil)
+	key2, _ := models.NewAPIKey("abra", "second", nil)
+	key3, _ := models.NewAPIKey("kadabra", "third", nil)
+	key2.CreatedAt = key1.CreatedAt.Add(time.Second)
+	storage.APIKeys().Create(key1)
+	storage.APIKeys().Create(key2)
+	storage.APIKeys().Create(key3)
+
+	keys, err := storage.APIKeys().FindByUser("abra")
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 2) {
+		assert.Equal(t, "first", keys[0].Name)
+		assert.Equal(t, "second", keys[1].Name)
+	}
+
+	keys, err = storage.APIKeys().FindByUser("nobody")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestAPIKeyRepository_Revoke(t *testing.T) {
+	storage := test_storage.NewInMemoryStorage()
+
+	key, _ := models.NewAPIKey("user", "ci", nil)
+	storage.APIKeys().Create(key)
+
+	// default case : revoke active key
+	assert.NoError(t, storage.APIKeys().Revoke(key.ID))
+	found, err := storage.APIKeys().FindByID(key.ID)
+	assert.NoError(t, err)
+	assert.True(t, found.IsRevoked())
+
+	// case : revoke already revoked key
+	assert.Error(t, storage.APIKeys().Revoke(key.ID))
+
+	// case : revoke key that never existed
+	assert.Error(t, storage.APIKeys().Revoke("unknown"))
+}
+
+func TestAPIKeyRepository_TouchLastUsed(t *testing.T) {
+	storage := test_storage.NewInMemoryStorage()
+
+	key, _ := models.NewAPIKey("user", "ci", nil)
+	storage.APIKeys().Create(key)
+
+	at := time.Now().UTC()
+	assert.NoError(t, storage.APIKeys().TouchLastUsed(key.ID, at))
+
+	found, err := storage.APIKeys().FindByID(key.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, found.LastUsedAt) {
+		assert.Equal(t, at, *found.LastUsedAt)
+	}
+
+	assert.Error(t, storage.APIKeys().TouchLastUsed("unknown", at))
+}
@@ -0,0 +1,72 @@
+package test_storage
+
+import (
+	"slices"
+	"sync"
+	"vox-server/internal/models"
+)
+
+type AuditRepository struct {
+	events []models.AuditEvent // ordered by id, the id of events[i] is i+1
+	mu     *sync.RWMutex
+}
+
+func NewAuditRepository() *AuditRepository {
+	return &AuditRepository{
+		mu: &sync.RWMutex{},
+	}
+}
+
+func (repository *AuditRepository) lock()   { repository.mu.Lock() }
+func (repository *AuditRepository) unlock() { repository.mu.Unlock() }
+
+func (repository *AuditRepository) clone() *AuditRepository {
+	return &AuditRepository{
+		events: slices.Clone(repository.events),
+		mu:     &sync.RWMutex{},
+	}
+}
+
+func (repository *AuditRepository) replace(copied *AuditRepository) {
+	repository.events = copied.events
+}
+
+func (repository *AuditRepository) Append(events ...*models.AuditEvent) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	for _, event := range events {
+		event.ID = int64(len(repository.events) + 1)
+		repository.events = append(repository.events, *event)
+	}
+
+	return nil
+}
+
+func (repository *AuditRepository) Find(filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	events := []*models.AuditEvent{}
+	for i := len(repository.events) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+
+		event := repository.events[i]
+		if matchesAuditFilter(&event, filter) {
+			events = append(events, &event)
+		}
+	}
+
+	return events, nil
+}
+
+func matchesAuditFilter(event *models.AuditEvent, filter models.AuditFilter) bool {
+	return (filter.Actor == "" || event.Actor == filter.Actor) &&
+		(filter.Target == "" || event.Target == filter.Target) &&
+		(filter.Action == "" || event.Action == filter.Action) &&
+		(filter.Since.IsZero() || !event.Time.Before(filter.Since)) &&
+		(filter.Until.IsZero() || event.Time.Before(filter.Until)) &&
+		(filter.Before == 0 || event.ID < filter.Before)
+}
@@ -0,0 +1,44 @@
+package test_storage_test
+
+import (
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage/test_storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditRepository(t *testing.T) {
+	storage := test_storage.NewInMemoryStorage()
+	now := time.Now()
+
+	events := []*models.AuditEvent{
+		{Time: now.Add(-time.Hour), Actor: "alice", Target: "alice", Action: models.AuditUserRegistered},
+		{Time: now, Action: models.AuditLoginFailed, IP: "10.0.0.1"},
+		{Time: now, Actor: "alice", Target: "bob", Action: models.AuditRoleChanged},
+	}
+	assert.NoError(t, storage.Audit().Append(events...))
+	assert.Equal(t, int64(1), events[0].ID)
+
+	// newest first
+	found, err := storage.Audit().Find(models.AuditFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, found, 3)
+	assert.Equal(t, models.AuditRoleChanged, found[0].Action)
+
+	found, err = storage.Audit().Find(models.AuditFilter{Actor: "alice", Since: now.Add(-time.Minute)})
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, "bob", found[0].Target)
+
+	found, err = storage.Audit().Find(models.AuditFilter{Action: models.AuditLoginFailed, Until: now.Add(time.Minute)})
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+
+	// pagination
+	found, err = storage.Audit().Find(models.AuditFilter{Before: events[2].ID, Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, events[1].ID, found[0].ID)
+}
@@ -0,0 +1,119 @@
+package test_storage
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+)
+
+type IdempotencyRepository struct {
+	records map[string]*models.IdempotencyRecord // login + key -> record
+	mu      *sync.RWMutex
+}
+
+func NewIdempotencyRepository() *IdempotencyRepository {
+	return &IdempotencyRepository{
+		records: make(map[string]*models.IdempotencyRecord),
+		mu:      &sync.RWMutex{},
+	}
+}
+
+func (repository *IdempotencyRepository) lock()   { repository.mu.Lock() }
+func (repository *IdempotencyRepository) unlock() { repository.mu.Unlock() }
+
+func (repository *IdempotencyRepository) clone() *IdempotencyRepository {
+	return &IdempotencyRepository{
+		records: cloneMap(repository.records, copyIdempotencyRecord),
+		mu:      &sync.RWMutex{},
+	}
+}
+
+func (repository *IdempotencyRepository) replace(copied *IdempotencyRepository) {
+	replaceMap(repository.records, copied.records)
+}
+
+func idempotencyKey(login, key string) string {
+	return login + "\x00" + key
+}
+
+func copyIdempotencyRecord(record *models.IdempotencyRecord) *models.IdempotencyRecord {
+	copied := *record
+	copied.Header = record.Header.Clone()
+	copied.Body = slices.Clone(record.Body)
+	return &copied
+}
+
+func (repository IdempotencyRepository) Create(record *models.IdempotencyRecord) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	key := idempotencyKey(record.UserLogin, record.Key)
+	if existing, ok := repository.records[key]; ok && !existing.IsExpired(time.Now()) {
+		return fmt.Errorf("idempotency key '%s' already in use: %w", record.Key, storage.ErrConflict)
+	}
+
+	repository.records[key] = copyIdempotencyRecord(record)
+
+	return nil
+}
+
+func (repository IdempotencyRepository) Find(login, key string) (*models.IdempotencyRecord, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	record, ok := repository.records[idempotencyKey(login, key)]
+	if !ok || record.IsExpired(time.Now()) {
+		return nil, fmt.Errorf("idempotency key '%s' %w", key, storage.ErrNotFound)
+	}
+
+	return copyIdempotencyRecord(record), nil
+}
+
+func (repository IdempotencyRepository) Complete(record *models.IdempotencyRecord) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	stored, ok := repository.records[idempotencyKey(record.UserLogin, record.Key)]
+	if !ok || stored.IsExpired(time.Now()) {
+		return fmt.Errorf("idempotency key '%s' %w", record.Key, storage.ErrNotFound)
+	}
+
+	completed := copyIdempotencyRecord(record)
+	stored.Status = completed.Status
+	stored.Header = completed.Header
+	stored.Body = completed.Body
+	stored.ExpiresAt = completed.ExpiresAt
+
+	return nil
+}
+
+func (repository IdempotencyRepository) Delete(login, key string) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	if _, ok := repository.records[idempotencyKey(login, key)]; !ok {
+		return fmt.Errorf("idempotency key '%s' %w", key, storage.ErrNotFound)
+	}
+
+	delete(repository.records, idempotencyKey(login, key))
+
+	return nil
+}
+
+func (repository IdempotencyRepository) DeleteExpired(now time.Time) (int64, error) {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	var deleted int64
+	for key, record := range repository.records {
+		if record.IsExpired(now) {
+			delete(repository.records, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,73 @@
+package test_storage_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage/test_storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyRepository_CreateAndComplete(t *testing.T) {
+	storage := test_storage.NewInMemoryStorage()
+
+	record := models.NewIdempotencyRecord("user", "key", "fingerprint", time.Hour)
+	assert.NoError(t, storage.Idempotency().Create(record))
+
+	// case : the key is in use
+	assert.Error(t, storage.Idempotency().Create(models.NewIdempotencyRecord("user", "key", "other", time.Hour)))
+
+	// case : keys belong to their users
+	assert.NoError(t, storage.Idempotency().Create(models.NewIdempotencyRecord("", "key", "fingerprint", time.Hour)))
+
+	found, err := storage.Idempotency().Find("user", "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "fingerprint", found.Fingerprint)
+	assert.False(t, found.IsCompleted())
+
+	record.Status = http.StatusCreated
+	record.Header = http.Header{"Content-Type": {"application/json"}}
+	record.Body = []byte(`{"id":"1"}`)
+	assert.NoError(t, storage.Idempotency().Complete(record))
+
+	found, err = storage.Idempotency().Find("user", "key")
+	assert.NoError(t, err)
+	assert.True(t, found.IsCompleted())
+	assert.Equal(t, http.StatusCreated, found.Status)
+	assert.Equal(t, record.Header, found.Header)
+	assert.Equal(t, record.Body, found.Body)
+
+	_, err = storage.Idempotency().Find("user", "nope")
+	assert.Error(t, err)
+	assert.Error(t, storage.Idempotency().Complete(models.NewIdempotencyRecord("user", "nope", "fingerprint", time.Hour)))
+}
+
+func TestIdempotencyRepository_Expiry(t *testing.T) {
+	storage := test_storage.NewInMemoryStorage()
+
+	expired := models.NewIdempotencyRecord("user", "old", "fingerprint", time.Hour)
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	assert.NoError(t, storage.Idempotency().Create(expired))
+	assert.NoError(t, storage.Idempotency().Create(models.NewIdempotencyRecord("user", "new", "fingerprint", time.Hour)))
+
+	_, err := storage.Idempotency().Find("user", "old")
+	assert.Error(t, err, "expired keys are not found")
+
+	deleted, err := storage.Idempotency().DeleteExpired(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	// case : an expired key can be used again
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	assert.NoError(t, storage.Idempotency().Create(expired))
+	assert.NoError(t, storage.Idempotency().Create(models.NewIdempotencyRecord("user", "old", "other", time.Hour)))
+
+	found, err := storage.Idempotency().Find("user", "old")
+	assert.NoError(t, err)
+	assert.Equal(t, "other", found.Fingerprint)
+
+	assert.NoError(t, storage.Idempotency().Delete("user", "old"))
+	assert.Error(t, storage.Idempotency().Delete("user", "old"))
+}
@@ -0,0 +1,100 @@
+package test_storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+)
+
+type IdentityRepository struct {
+	identities map[string]*models.Identity // provider + subject -> identity
+	mu         *sync.RWMutex
+}
+
+func NewIdentityRepository() *IdentityRepository {
+	return &IdentityRepository{
+		identities: make(map[string]*models.Identity),
+		mu:         &sync.RWMutex{},
+	}
+}
+
+func (repository *IdentityRepository) lock()   { repository.mu.Lock() }
+func (repository *IdentityRepository) unlock() { repository.mu.Unlock() }
+
+func (repository *IdentityRepository) clone() *IdentityRepository {
+	return &IdentityRepository{
+		identities: cloneMap(repository.identities, copyOf),
+		mu:         &sync.RWMutex{},
+	}
+}
+
+func (repository *IdentityRepository) replace(copied *IdentityRepository) {
+	replaceMap(repository.identities, copied.identities)
+}
+
+func identityKey(provider, subject string) string {
+	return provider + "\x00" + subject
+}
+
+func (repository IdentityRepository) Create(identity *models.Identity) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	key := identityKey(identity.Provider, identity.Subject)
+	if _, ok := repository.identities[key]; ok {
+		return fmt.Errorf("identity '%s' of provider '%s' already linked: %w", identity.Subject, identity.Provider, storage.ErrConflict)
+	}
+
+	stored := *identity
+	repository.identities[key] = &stored
+
+	return nil
+}
+
+func (repository IdentityRepository) Find(provider, subject string) (*models.Identity, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	identity, ok := repository.identities[identityKey(provider, subject)]
+	if !ok {
+		return nil, fmt.Errorf("identity '%s' of provider '%s' %w", subject, provider, storage.ErrNotFound)
+	}
+
+	found := *identity
+	return &found, nil
+}
+
+func (repository IdentityRepository) FindByUser(login string) ([]*models.Identity, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	identities := []*models.Identity{}
+	for _, identity := range repository.identities {
+		if identity.UserLogin == login {
+			found := *identity
+			identities = append(identities, &found)
+		}
+	}
+
+	sort.Slice(identities, func(i, j int) bool {
+		return identities[i].CreatedAt.Before(identities[j].CreatedAt)
+	})
+
+	return identities, nil
+}
+
+func (repository IdentityRepository) Delete(provider, subject string) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	key := identityKey(provider, subject)
+	if _, ok := repository.identities[key]; !ok {
+		return fmt.Errorf("identity '%s' of provider '%s' %w", subject, provider, storage.ErrNotFound)
+	}
+
+	delete(repository.identities, key)
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package test_storage_test
+
+import (
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage/test_storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentityRepository(t *testing.T) {
+	storage := test_storage.NewInMemoryStorage()
+
+	identity := &models.Identity{
+		Provider:  "corp",
+		Subject:   "248289761001",
+		UserLogin: "user",
+		Email:     "user@corp.example",
+		CreatedAt: time.Now(),
+	}
+	assert.NoError(t, storage.Identities().Create(identity))
+
+	// case : the same external account can be linked only once
+	assert.Error(t, storage.Identities().Create(identity))
+
+	found, err := storage.Identities().Find("corp", "248289761001")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", found.UserLogin)
+
+	// case : subjects are scoped by provider
+	_, err = storage.Identities().Find("other", "248289761001")
+	assert.Error(t, err)
+
+	identities, err := storage.Identities().FindByUser("user")
+	assert.NoError(t, err)
+	assert.Len(t, identities, 1)
+
+	assert.NoError(t, storage.Identities().Delete("corp", "248289761001"))
+	assert.Error(t, storage.Identities().Delete("corp", "248289761001"))
+}
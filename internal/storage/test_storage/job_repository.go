@@ -0,0 +1,198 @@
+package test_storage
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+type JobRepository struct {
+	jobs map[string]*models.Job // id -> job
+	mu   *sync.RWMutex
+}
+
+func NewJobRepository() *JobRepository {
+	return &JobRepository{
+		jobs: make(map[string]*models.Job),
+		mu:   &sync.RWMutex{},
+	}
+}
+
+func (repository *JobRepository) lock()   { repository.mu.Lock() }
+func (repository *JobRepository) unlock() { repository.mu.Unlock() }
+
+func (repository *JobRepository) clone() *JobRepository {
+	return &JobRepository{
+		jobs: cloneMap(repository.jobs, copyJob),
+		mu:   &sync.RWMutex{},
+	}
+}
+
+func (repository *JobRepository) replace(copied *JobRepository) {
+	replaceMap(repository.jobs, copied.jobs)
+}
+
+func copyJob(job *models.Job) *models.Job {
+	copied := *job
+	copied.Payload = slices.Clone(job.Payload)
+	if job.LockedUntil != nil {
+		lockedUntil := *job.LockedUntil
+		copied.LockedUntil = &lockedUntil
+	}
+	if job.FinishedAt != nil {
+		finishedAt := *job.FinishedAt
+		copied.FinishedAt = &finishedAt
+	}
+	return &copied
+}
+
+func (repository JobRepository) Enqueue(job *models.Job) (bool, error) {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	if job.Key != "" {
+		for _, stored := range repository.jobs {
+			if stored.Key == job.Key {
+				return false, nil
+			}
+		}
+	}
+
+	if job.ID == "" {
+		job.ID = uuid.New().String()
+	}
+
+	if _, ok := repository.jobs[job.ID]; ok {
+		return false, fmt.Errorf("job with id '%s' %w", job.ID, storage.ErrConflict)
+	}
+
+	repository.jobs[job.ID] = copyJob(job)
+
+	return true, nil
+}
+
+func (repository JobRepository) FindByID(id string) (*models.Job, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	job, ok := repository.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("job with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	return copyJob(job), nil
+}
+
+func (repository JobRepository) Find(filter models.JobFilter) ([]*models.Job, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	jobs := []*models.Job{}
+	for _, job := range repository.jobs {
+		if filter.Kind != "" && job.Kind != filter.Kind {
+			continue
+		}
+		if filter.Status != "" && job.Status != filter.Status {
+			continue
+		}
+		jobs = append(jobs, copyJob(job))
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+
+	if filter.Limit > 0 && len(jobs) > filter.Limit {
+		jobs = jobs[:filter.Limit]
+	}
+
+	return jobs, nil
+}
+
+func (repository JobRepository) Claim(now, leaseEnd time.Time, limit int) ([]*models.Job, error) {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	due := []*models.Job{}
+	for _, job := range repository.jobs {
+		pending := job.Status == models.JobPending && !job.RunAt.After(now)
+		expired := job.Status == models.JobRunning && job.LockedUntil != nil && !job.LockedUntil.After(now)
+		if pending || expired {
+			due = append(due, job)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].RunAt.Before(due[j].RunAt)
+	})
+
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]*models.Job, 0, len(due))
+	for _, job := range due {
+		lease := leaseEnd
+		job.Status = models.JobRunning
+		job.Attempts++
+		job.LockedUntil = &lease
+		claimed = append(claimed, copyJob(job))
+	}
+
+	return claimed, nil
+}
+
+func (repository JobRepository) Update(job *models.Job, lease time.Time) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	stored, ok := repository.jobs[job.ID]
+	if !ok || stored.Status != models.JobRunning || stored.LockedUntil == nil || !stored.LockedUntil.Equal(lease) {
+		return fmt.Errorf("job with id '%s' %w", job.ID, storage.ErrLeaseLost)
+	}
+
+	updated := copyJob(job)
+	stored.Status = updated.Status
+	stored.Attempts = updated.Attempts
+	stored.RunAt = updated.RunAt
+	stored.LockedUntil = updated.LockedUntil
+	stored.LastError = updated.LastError
+	stored.FinishedAt = updated.FinishedAt
+
+	return nil
+}
+
+func (repository JobRepository) Retry(id string, now time.Time) (*models.Job, error) {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	stored, ok := repository.jobs[id]
+	if !ok || stored.Status != models.JobDead {
+		return nil, fmt.Errorf("dead job with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	stored.Retry(now)
+
+	return copyJob(stored), nil
+}
+
+func (repository JobRepository) DeleteFinished(before time.Time) (int64, error) {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	var deleted int64
+	for id, job := range repository.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(before) {
+			delete(repository.jobs, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,246 @@
+package test_storage
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+type OAuthClientRepository struct {
+	clients map[string]*models.OAuthClient // id -> client
+	mu      *sync.RWMutex
+}
+
+func NewOAuthClientRepository() *OAuthClientRepository {
+	return &OAuthClientRepository{
+		clients: make(map[string]*models.OAuthClient),
+		mu:      &sync.RWMutex{},
+	}
+}
+
+func (repository *OAuthClientRepository) lock()   { repository.mu.Lock() }
+func (repository *OAuthClientRepository) unlock() { repository.mu.Unlock() }
+
+func (repository *OAuthClientRepository) clone() *OAuthClientRepository {
+	return &OAuthClientRepository{
+		clients: cloneMap(repository.clients, copyOf),
+		mu:      &sync.RWMutex{},
+	}
+}
+
+func (repository *OAuthClientRepository) replace(copied *OAuthClientRepository) {
+	replaceMap(repository.clients, copied.clients)
+}
+
+func (repository OAuthClientRepository) Create(client *models.OAuthClient) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	if err := client.Validate(); err != nil {
+		return err
+	}
+
+	if client.ID == "" {
+		client.ID = uuid.New().String()
+	}
+
+	if _, ok := repository.clients[client.ID]; ok {
+		return fmt.Errorf("oauth client with id '%s' %w", client.ID, storage.ErrConflict)
+	}
+
+	// the plain secret is never stored
+	stored := *client
+	stored.Secret = ""
+	stored.RedirectURIs = append([]string{}, client.RedirectURIs...)
+	repository.clients[stored.ID] = &stored
+
+	return nil
+}
+
+func (repository OAuthClientRepository) FindByID(id string) (*models.OAuthClient, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	client, ok := repository.clients[id]
+	if !ok {
+		return nil, fmt.Errorf("oauth client with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	found := *client
+	return &found, nil
+}
+
+func (repository OAuthClientRepository) FindByOwner(login string) ([]*models.OAuthClient, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	clients := []*models.OAuthClient{}
+	for _, client := range repository.clients {
+		if client.OwnerLogin == login {
+			found := *client
+			clients = append(clients, &found)
+		}
+	}
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].CreatedAt.Before(clients[j].CreatedAt)
+	})
+
+	return clients, nil
+}
+
+func (repository OAuthClientRepository) Delete(id string) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	if _, ok := repository.clients[id]; !ok {
+		return fmt.Errorf("oauth client with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	delete(repository.clients, id)
+
+	return nil
+}
+
+type AuthorizationCodeRepository struct {
+	codes map[string]*models.AuthorizationCode // code hash -> code
+	mu    *sync.Mutex
+}
+
+func NewAuthorizationCodeRepository() *AuthorizationCodeRepository {
+	return &AuthorizationCodeRepository{
+		codes: make(map[string]*models.AuthorizationCode),
+		mu:    &sync.Mutex{},
+	}
+}
+
+func (repository *AuthorizationCodeRepository) lock()   { repository.mu.Lock() }
+func (repository *AuthorizationCodeRepository) unlock() { repository.mu.Unlock() }
+
+func (repository *AuthorizationCodeRepository) clone() *AuthorizationCodeRepository {
+	return &AuthorizationCodeRepository{
+		codes: cloneMap(repository.codes, copyOf),
+		mu:    &sync.Mutex{},
+	}
+}
+
+func (repository *AuthorizationCodeRepository) replace(copied *AuthorizationCodeRepository) {
+	replaceMap(repository.codes, copied.codes)
+}
+
+func (repository AuthorizationCodeRepository) Create(code *models.AuthorizationCode) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	if _, ok := repository.codes[code.CodeHash]; ok {
+		return fmt.Errorf("authorization code %w", storage.ErrConflict)
+	}
+
+	stored := *code
+	stored.Code = ""
+	repository.codes[stored.CodeHash] = &stored
+
+	return nil
+}
+
+func (repository AuthorizationCodeRepository) Consume(codeHash, clientID, redirectURI string) (*models.AuthorizationCode, error) {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	code, ok := repository.codes[codeHash]
+	if !ok || code.ClientID != clientID || code.RedirectURI != redirectURI {
+		return nil, fmt.Errorf("authorization code %w", storage.ErrNotFound)
+	}
+
+	delete(repository.codes, codeHash)
+
+	return code, nil
+}
+
+type ConsentRepository struct {
+	consents map[string]*models.Consent // login + client id -> consent
+	mu       *sync.RWMutex
+}
+
+func NewConsentRepository() *ConsentRepository {
+	return &ConsentRepository{
+		consents: make(map[string]*models.Consent),
+		mu:       &sync.RWMutex{},
+	}
+}
+
+func (repository *ConsentRepository) lock()   { repository.mu.Lock() }
+func (repository *ConsentRepository) unlock() { repository.mu.Unlock() }
+
+func (repository *ConsentRepository) clone() *ConsentRepository {
+	return &ConsentRepository{
+		consents: cloneMap(repository.consents, copyOf),
+		mu:       &sync.RWMutex{},
+	}
+}
+
+func (repository *ConsentRepository) replace(copied *ConsentRepository) {
+	replaceMap(repository.consents, copied.consents)
+}
+
+func consentKey(login, clientID string) string {
+	return login + "\x00" + clientID
+}
+
+func (repository ConsentRepository) Grant(consent *models.Consent) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	key := consentKey(consent.UserLogin, consent.ClientID)
+
+	stored, ok := repository.consents[key]
+	if !ok {
+		stored = &models.Consent{
+			UserLogin: consent.UserLogin,
+			ClientID:  consent.ClientID,
+		}
+		repository.consents[key] = stored
+	}
+
+	for _, scope := range consent.Scopes {
+		if !slices.Contains(stored.Scopes, scope) {
+			stored.Scopes = append(stored.Scopes, scope)
+		}
+	}
+	stored.GrantedAt = consent.GrantedAt
+
+	return nil
+}
+
+func (repository ConsentRepository) Find(login, clientID string) (*models.Consent, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	consent, ok := repository.consents[consentKey(login, clientID)]
+	if !ok {
+		return nil, fmt.Errorf("consent of '%s' for client '%s' %w", login, clientID, storage.ErrNotFound)
+	}
+
+	found := *consent
+	found.Scopes = append([]string{}, consent.Scopes...)
+	return &found, nil
+}
+
+func (repository ConsentRepository) Revoke(login, clientID string) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	key := consentKey(login, clientID)
+	if _, ok := repository.consents[key]; !ok {
+		return fmt.Errorf("consent of '%s' for client '%s' %w", login, clientID, storage.ErrNotFound)
+	}
+
+	delete(repository.consents, key)
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package test_storage_test
+
+import (
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage/test_storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOAuthClientRepository(t *testing.T) {
+	storage := test_storage.NewInMemoryStorage()
+
+	client, err := models.NewOAuthClient("owner", "wiki", []string{"https://wiki.local/callback"}, false)
+	assert.NoError(t, err)
+	assert.NoError(t, storage.OAuthClients().Create(client))
+	assert.NotEmpty(t, client.ID)
+
+	found, err := storage.OAuthClients().FindByID(client.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, found.Secret, "plain secret must not be stored")
+	assert.True(t, found.CompareSecret(client.Secret))
+	assert.True(t, found.HasRedirectURI("https://wiki.local/callback"))
+
+	clients, err := storage.OAuthClients().FindByOwner("owner")
+	assert.NoError(t, err)
+	assert.Len(t, clients, 1)
+
+	// case : client without redirect uris
+	invalid, _ := models.NewOAuthClient("owner", "wiki", nil, false)
+	assert.Error(t, storage.OAuthClients().Create(invalid))
+
+	assert.NoError(t, storage.OAuthClients().Delete(client.ID))
+	assert.Error(t, storage.OAuthClients().Delete(client.ID))
+	_, err = storage.OAuthClients().FindByID(client.ID)
+	assert.Error(t, err)
+}
+
+func TestAuthorizationCodeRepository_Consume(t *testing.T) {
+	storage := test_storage.NewInMemoryStorage()
+
+	code, err := models.NewAuthorizationCode("client", "user", "https://wiki.local/callback", []string{"openid"})
+	assert.NoError(t, err)
+	assert.NoError(t, storage.AuthorizationCodes().Create(code))
+
+	// case : the code of another client or redirect uri is not consumed
+	_, err = storage.AuthorizationCodes().Consume(models.HashSecret(code.Code), "other", "https://wiki.local/callback")
+	assert.Error(t, err)
+	_, err = storage.AuthorizationCodes().Consume(models.HashSecret(code.Code), "client", "https://evil.local/callback")
+	assert.Error(t, err)
+
+	// default case : first exchange
+	found, err := storage.AuthorizationCodes().Consume(models.HashSecret(code.Code), "client", "https://wiki.local/callback")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", found.UserLogin)
+	assert.Empty(t, found.Code)
+
+	// case : code can be exchanged only once
+	_, err = storage.AuthorizationCodes().Consume(models.HashSecret(code.Code), "client", "https://wiki.local/callback")
+	assert.Error(t, err)
+}
+
+func TestConsentRepository(t *testing.T) {
+	storage := test_storage.NewInMemoryStorage()
+
+	_, err := storage.Consents().Find("user", "client")
+	assert.Error(t, err)
+
+	assert.NoError(t, storage.Consents().Grant(&models.Consent{
+		UserLogin: "user",
+		ClientID:  "client",
+		Scopes:    []string{"openid", "email"},
+		GrantedAt: time.Now(),
+	}))
+	assert.NoError(t, storage.Consents().Grant(&models.Consent{
+		UserLogin: "user",
+		ClientID:  "client",
+		Scopes:    []string{"openid", "profile"},
+		GrantedAt: time.Now(),
+	}))
+
+	// scopes are merged
+	consent, err := storage.Consents().Find("user", "client")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"openid", "email", "profile"}, consent.Scopes)
+	assert.True(t, consent.Covers([]string{"email", "profile"}))
+	assert.False(t, consent.Covers([]string{"keys:write"}))
+
+	assert.NoError(t, storage.Consents().Revoke("user", "client"))
+	assert.Error(t, storage.Consents().Revoke("user", "client"))
+}
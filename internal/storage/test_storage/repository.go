@@ -1,15 +1,19 @@
 package test_storage
 
 import (
+	"context"
 	"fmt"
+	"maps"
 	"sync"
 	"vox-server/internal/models"
+	"vox-server/internal/storage"
 )
 
 type UserRepository struct {
 	users  map[string]*models.User // login -> user
 	emails map[string]string       // email -> login
 	mu     *sync.RWMutex
+	ctx    context.Context
 }
 
 func NewUserRepository() *UserRepository {
@@ -17,9 +21,27 @@ func NewUserRepository() *UserRepository {
 		users:  make(map[string]*models.User),
 		emails: make(map[string]string),
 		mu:     &sync.RWMutex{},
+		ctx:    context.Background(),
 	}
 }
 
+func (repository *UserRepository) lock()   { repository.mu.Lock() }
+func (repository *UserRepository) unlock() { repository.mu.Unlock() }
+
+func (repository *UserRepository) clone() *UserRepository {
+	return &UserRepository{
+		users:  cloneMap(repository.users, copyOf),
+		emails: maps.Clone(repository.emails),
+		mu:     &sync.RWMutex{},
+		ctx:    repository.ctx,
+	}
+}
+
+func (repository *UserRepository) replace(copied *UserRepository) {
+	replaceMap(repository.users, copied.users)
+	replaceMap(repository.emails, copied.emails)
+}
+
 func (repository UserRepository) Count() int {
 	return len(repository.users)
 }
@@ -33,18 +55,18 @@ func (repository UserRepository) Create(user *models.User) error {
 	defer repository.mu.Unlock()
 
 	if _, ok := repository.users[user.Login]; ok {
-		return fmt.Errorf("user with such login '%s' already exist", user.Login)
+		return fmt.Errorf("user with such login '%s' %w", user.Login, storage.ErrConflict)
 	}
 
 	if _, ok := repository.emails[user.Email]; ok {
-		return fmt.Errorf("user with such email '%s' already exist", user.Email)
+		return fmt.Errorf("user with such email '%s' %w", user.Email, storage.ErrConflict)
 	}
 
 	if err := user.Validate(true); err != nil {
 		return err
 	}
 
-	if err := user.BeforeCreate(); err != nil {
+	if err := user.BeforeCreateContext(repository.ctx); err != nil {
 		return err
 	}
 
@@ -64,7 +86,7 @@ func (repository UserRepository) FindByLogin(login string) (*models.User, error)
 		return user, nil
 	}
 
-	return nil, fmt.Errorf("user with login '%s' not found", login)
+	return nil, fmt.Errorf("user with login '%s' %w", login, storage.ErrNotFound)
 }
 
 func (repository UserRepository) FindByEmail(email string) (*models.User, error) {
@@ -77,7 +99,7 @@ func (repository UserRepository) FindByEmail(email string) (*models.User, error)
 		return user, nil
 	}
 
-	return nil, fmt.Errorf("user with email '%s' not found", email)
+	return nil, fmt.Errorf("user with email '%s' %w", email, storage.ErrNotFound)
 }
 
 // O(n) search pair (email -> login) in repository.emails
@@ -87,7 +109,7 @@ func (repository UserRepository) DeleteByLogin(login string) error {
 
 	user, ok := repository.users[login]
 	if !ok {
-		return fmt.Errorf("user with login '%s' not found", login)
+		return fmt.Errorf("user with login '%s' %w", login, storage.ErrNotFound)
 	}
 
 	delete(repository.users, login)
@@ -111,7 +133,7 @@ func (repository UserRepository) DeleteByEmail(email string) error {
 
 	login, ok := repository.emails[email]
 	if !ok {
-		return fmt.Errorf("user with email '%s' not found", email)
+		return fmt.Errorf("user with email '%s' %w", email, storage.ErrNotFound)
 	}
 
 	delete(repository.users, login)
@@ -120,29 +142,61 @@ func (repository UserRepository) DeleteByEmail(email string) error {
 	return nil
 }
 
-// modifies non-unique fields and (so far) does not change passwords, i.e. only username
+// modifies non-unique fields and the email, passwords are changed by UpdatePassword
 func (repository UserRepository) Update(user *models.User) error {
 	repository.mu.Lock()
 	defer repository.mu.Unlock()
 
 	found_user, ok := repository.users[user.Login]
 	if !ok {
-		return fmt.Errorf("user with login '%s' not found", user.Login)
+		return fmt.Errorf("user with login '%s' %w", user.Login, storage.ErrNotFound)
 	}
 
 	// if err := user.Validate(false); err != nil {
 	// 	return err
 	// }
 
+	if user.Role != "" && user.Role != models.RoleUser && user.Role != models.RoleAdmin {
+		return fmt.Errorf("unknown role '%s'", user.Role)
+	}
+
 	if found_user.Email != user.Email {
-		if _, ok := repository.emails[user.Email]; !ok {
-			return fmt.Errorf("user with email '%s' already exists", user.Email)
+		if _, ok := repository.emails[user.Email]; ok {
+			return fmt.Errorf("user with email '%s' %w", user.Email, storage.ErrConflict)
 		}
 		delete(repository.emails, found_user.Email)
 		repository.emails[user.Email] = user.Login
+		found_user.Email = user.Email
 	}
 
 	found_user.Username = user.Username
+	if user.Role != "" {
+		found_user.Role = user.Role
+	}
+
+	return nil
+}
+
+func (repository UserRepository) UpdatePassword(login, password string) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	found_user, ok := repository.users[login]
+	if !ok {
+		return fmt.Errorf("user with login '%s' %w", login, storage.ErrNotFound)
+	}
+
+	updated := *found_user
+	updated.Password = password
+	if err := updated.Validate(true); err != nil {
+		return err
+	}
+
+	if err := updated.BeforeCreateContext(repository.ctx); err != nil {
+		return err
+	}
+
+	found_user.EncryptedPassword = updated.EncryptedPassword
 
 	return nil
 }
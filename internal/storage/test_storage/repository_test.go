@@ -199,3 +199,28 @@ func TestUserRepository_Update(t *testing.T) {
 	assert.EqualError(t, err, "user with login 'non_existent' not found")
 
 }
+
+func TestUserRepository_UpdatePassword(t *testing.T) {
+	storage := test_storage.NewInMemoryStorage()
+	user := &models.User{
+		Login:    "user",
+		Username: "username",
+		Email:    "example@tmail.com",
+		Password: "gooDPsswrA12",
+	}
+	assert.NoError(t, storage.Users().Create(user))
+
+	assert.NoError(t, storage.Users().UpdatePassword("user", "n3wPassword"))
+
+	foundUser, err := storage.Users().FindByLogin("user")
+	assert.NoError(t, err)
+	assert.True(t, foundUser.ComparePassword("n3wPassword"))
+	assert.False(t, foundUser.ComparePassword("gooDPsswrA12"))
+
+	// case : the new password is validated
+	assert.Error(t, storage.Users().UpdatePassword("user", "short"))
+	assert.True(t, foundUser.ComparePassword("n3wPassword"))
+
+	// case : update non-existent user
+	assert.EqualError(t, storage.Users().UpdatePassword("non_existent", "n3wPassword"), "user with login 'non_existent' not found")
+}
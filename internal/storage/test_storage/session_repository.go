@@ -0,0 +1,83 @@
+package test_storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+type SessionRepository struct {
+	sessions map[string]*models.Session // id -> session
+	mu       *sync.RWMutex
+}
+
+func NewSessionRepository() *SessionRepository {
+	return &SessionRepository{
+		sessions: make(map[string]*models.Session),
+		mu:       &sync.RWMutex{},
+	}
+}
+
+func (repository *SessionRepository) lock()   { repository.mu.Lock() }
+func (repository *SessionRepository) unlock() { repository.mu.Unlock() }
+
+func (repository *SessionRepository) clone() *SessionRepository {
+	return &SessionRepository{
+		sessions: cloneMap(repository.sessions, copyOf),
+		mu:       &sync.RWMutex{},
+	}
+}
+
+func (repository *SessionRepository) replace(copied *SessionRepository) {
+	replaceMap(repository.sessions, copied.sessions)
+}
+
+func (repository SessionRepository) Create(session *models.Session) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	if session.ID == "" {
+		session.ID = uuid.New().String()
+	}
+
+	if _, ok := repository.sessions[session.ID]; ok {
+		return fmt.Errorf("session with id '%s' %w", session.ID, storage.ErrConflict)
+	}
+
+	stored := *session
+	repository.sessions[stored.ID] = &stored
+
+	return nil
+}
+
+func (repository SessionRepository) FindByID(id string) (*models.Session, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	session, ok := repository.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("session with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	found := *session
+	return &found, nil
+}
+
+func (repository SessionRepository) Revoke(id string) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+
+	session, ok := repository.sessions[id]
+	if !ok || session.RevokedAt != nil {
+		return fmt.Errorf("active session with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	now := time.Now().UTC()
+	session.RevokedAt = &now
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package test_storage_test
+
+import (
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage/test_storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionRepository_CreateAndRevoke(t *testing.T) {
+	storage := test_storage.NewInMemoryStorage()
+
+	session := models.NewSession("user", "", nil, time.Hour)
+	assert.NoError(t, storage.Sessions().Create(session))
+	assert.NotEmpty(t, session.ID)
+
+	found, err := storage.Sessions().FindByID(session.ID)
+	assert.NoError(t, err)
+	assert.True(t, found.IsActive(time.Now()))
+	assert.False(t, found.IsRestricted())
+
+	// default case : revoke active session
+	assert.NoError(t, storage.Sessions().Revoke(session.ID))
+	found, err = storage.Sessions().FindByID(session.ID)
+	assert.NoError(t, err)
+	assert.False(t, found.IsActive(time.Now()))
+
+	// case : revoke revoked session
+	assert.Error(t, storage.Sessions().Revoke(session.ID))
+
+	// case : session that never existed
+	_, err = storage.Sessions().FindByID("unknown")
+	assert.Error(t, err)
+}
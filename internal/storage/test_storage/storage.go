@@ -1,23 +1,137 @@
 package test_storage
 
 import (
+	"context"
+	"sync"
 	"vox-server/internal/storage"
 )
 
 type InMemoryStorage struct {
-	userRepository *UserRepository
+	userRepository              *UserRepository
+	apiKeyRepository            *APIKeyRepository
+	sessionRepository           *SessionRepository
+	oauthClientRepository       *OAuthClientRepository
+	authorizationCodeRepository *AuthorizationCodeRepository
+	consentRepository           *ConsentRepository
+	identityRepository          *IdentityRepository
+	auditRepository             *AuditRepository
+	idempotencyRepository       *IdempotencyRepository
+	webhookRepository           *WebhookRepository
+	jobRepository               *JobRepository
+
+	ctx context.Context
+
+	// runs the transactions one at a time, so that they don't wait for the tables locked by each other
+	txMu *sync.Mutex
+	tx   *transaction // set in WithTx
 }
 
 func NewInMemoryStorage() *InMemoryStorage {
-	return &InMemoryStorage{}
+	return &InMemoryStorage{
+		userRepository:              NewUserRepository(),
+		apiKeyRepository:            NewAPIKeyRepository(),
+		sessionRepository:           NewSessionRepository(),
+		oauthClientRepository:       NewOAuthClientRepository(),
+		authorizationCodeRepository: NewAuthorizationCodeRepository(),
+		consentRepository:           NewConsentRepository(),
+		identityRepository:          NewIdentityRepository(),
+		auditRepository:             NewAuditRepository(),
+		idempotencyRepository:       NewIdempotencyRepository(),
+		webhookRepository:           NewWebhookRepository(),
+		jobRepository:               NewJobRepository(),
+
+		ctx: context.Background(),
+
+		txMu: &sync.Mutex{},
+	}
+}
+
+// WithContext shares the repositories, only hashing of passwords is recorded in the context
+func (storage *InMemoryStorage) WithContext(ctx context.Context) storage.Storage {
+	bound := *storage
+	bound.ctx = ctx
+	return &bound
+}
+
+// WithTx runs fn on copies of the repositories it uses, which replace them on commit: the others
+// see all of its writes or none. The repositories are locked from their first use in fn until it
+// returns, fn using them out of tx waits for itself. The transactions are serializable and never
+// retried, their options are ignored
+func (storage *InMemoryStorage) WithTx(ctx context.Context, fn func(tx storage.Storage) error, _ ...storage.TxOption) error {
+	if storage.tx != nil {
+		return fn(storage.WithContext(ctx))
+	}
+
+	storage.txMu.Lock()
+	defer storage.txMu.Unlock()
+
+	bound := *storage
+	bound.ctx = ctx
+	bound.tx = newTransaction()
+
+	// also when fn panics
+	committed := false
+	defer func() { bound.tx.end(committed) }()
+
+	if err := fn(&bound); err != nil {
+		return err
+	}
+	committed = true
+
+	return nil
 }
 
 func (storage *InMemoryStorage) Users() storage.UserRepository {
-	if storage.userRepository != nil {
-		return storage.userRepository
+	if storage.userRepository == nil {
+		storage.userRepository = NewUserRepository()
 	}
 
-	storage.userRepository = NewUserRepository()
+	repository := *copyOnWrite(storage.tx, storage.userRepository)
+	repository.ctx = storage.ctx
+
+	return repository
+}
+
+func (storage *InMemoryStorage) APIKeys() storage.APIKeyRepository {
+	return copyOnWrite(storage.tx, storage.apiKeyRepository)
+}
+
+func (storage *InMemoryStorage) Sessions() storage.SessionRepository {
+	return copyOnWrite(storage.tx, storage.sessionRepository)
+}
+
+func (storage *InMemoryStorage) OAuthClients() storage.OAuthClientRepository {
+	return copyOnWrite(storage.tx, storage.oauthClientRepository)
+}
+
+func (storage *InMemoryStorage) AuthorizationCodes() storage.AuthorizationCodeRepository {
+	return copyOnWrite(storage.tx, storage.authorizationCodeRepository)
+}
+
+func (storage *InMemoryStorage) Consents() storage.ConsentRepository {
+	return copyOnWrite(storage.tx, storage.consentRepository)
+}
+
+func (storage *InMemoryStorage) Identities() storage.IdentityRepository {
+	return copyOnWrite(storage.tx, storage.identityRepository)
+}
+
+func (storage *InMemoryStorage) Audit() storage.AuditRepository {
+	return copyOnWrite(storage.tx, storage.auditRepository)
+}
+
+func (storage *InMemoryStorage) Idempotency() storage.IdempotencyRepository {
+	return copyOnWrite(storage.tx, storage.idempotencyRepository)
+}
+
+func (storage *InMemoryStorage) Webhooks() storage.WebhookRepository {
+	return WebhookRepository{webhooks: copyOnWrite(storage.tx, storage.webhookRepository.webhooks)}
+}
+
+func (storage *InMemoryStorage) WebhookDeliveries() storage.WebhookDeliveryRepository {
+	return WebhookDeliveryRepository{webhooks: copyOnWrite(storage.tx, storage.webhookRepository.webhooks)}
+}
 
-	return *storage.userRepository
+func (storage *InMemoryStorage) Jobs() storage.JobRepository {
+	return copyOnWrite(storage.tx, storage.jobRepository)
 }
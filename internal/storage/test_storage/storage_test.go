@@ -0,0 +1,149 @@
+package test_storage_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+	"vox-server/internal/storage/storage_suite"
+	"vox-server/internal/storage/test_storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorageSuite(t *testing.T) {
+	storage_suite.Run(t, func(*testing.T) storage.Storage {
+		return test_storage.NewInMemoryStorage()
+	})
+}
+
+func TestInMemoryStorage_WithTx(t *testing.T) {
+	store := test_storage.NewInMemoryStorage()
+	ctx := context.Background()
+
+	user := &models.User{Login: "outbox", Username: "outbox", Email: "outbox@example.org", Password: "password"}
+
+	// case : rolled back, nothing is stored
+	failed := errors.New("failed")
+	rolledBack, _ := models.NewJob("test.outbox", nil, 3)
+	err := store.WithTx(ctx, func(tx storage.Storage) error {
+		assert.NoError(t, tx.Users().Create(user))
+		_, err := tx.Jobs().Enqueue(rolledBack)
+		assert.NoError(t, err)
+		assert.NoError(t, tx.Audit().Append(&models.AuditEvent{Action: models.AuditUserRegistered}))
+
+		_, err = tx.Users().FindByLogin(user.Login)
+		assert.NoError(t, err, "the transaction sees its writes")
+		return failed
+	})
+	assert.ErrorIs(t, err, failed)
+
+	_, err = store.Users().FindByLogin(user.Login)
+	assert.Error(t, err)
+	_, err = store.Jobs().FindByID(rolledBack.ID)
+	assert.Error(t, err)
+	events, _ := store.Audit().Find(models.AuditFilter{})
+	assert.Empty(t, events)
+
+	// case : rolled back by a panic, the repositories are unlocked
+	assert.Panics(t, func() {
+		_ = store.WithTx(ctx, func(tx storage.Storage) error {
+			assert.NoError(t, tx.Users().Create(user))
+			panic("boom")
+		})
+	})
+	_, err = store.Users().FindByLogin(user.Login)
+	assert.Error(t, err)
+
+	// case : committed, a nested transaction joins the outer one
+	committed, _ := models.NewJob("test.outbox", nil, 3)
+	err = store.WithTx(ctx, func(tx storage.Storage) error {
+		if err := tx.Users().Create(user); err != nil {
+			return err
+		}
+		return tx.WithTx(ctx, func(nested storage.Storage) error {
+			_, err := nested.Jobs().Enqueue(committed)
+			return err
+		})
+	})
+	assert.NoError(t, err)
+
+	_, err = store.Users().FindByLogin(user.Login)
+	assert.NoError(t, err)
+	_, err = store.Jobs().FindByID(committed.ID)
+	assert.NoError(t, err)
+
+	// case : a failed nested transaction rolls the outer one back when returned
+	err = store.WithTx(ctx, func(tx storage.Storage) error {
+		assert.NoError(t, tx.Users().DeleteByLogin(user.Login))
+		return tx.WithTx(ctx, func(storage.Storage) error {
+			return failed
+		})
+	})
+	assert.ErrorIs(t, err, failed)
+
+	_, err = store.Users().FindByLogin(user.Login)
+	assert.NoError(t, err)
+}
+
+func TestInMemoryStorage_WithTx_Isolation(t *testing.T) {
+	store := test_storage.NewInMemoryStorage()
+	ctx := context.Background()
+
+	counter, _ := models.NewJob("test.counter", nil, 3)
+	_, err := store.Jobs().Enqueue(counter)
+	assert.NoError(t, err)
+	// the counter is running, it is updated under its lease
+	_, err = store.Jobs().Claim(time.Now(), time.Now().Add(time.Hour), 1)
+	assert.NoError(t, err)
+
+	// the transactions reading then writing the counter lose no update
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := store.WithTx(ctx, func(tx storage.Storage) error {
+				job, err := tx.Jobs().FindByID(counter.ID)
+				if err != nil {
+					return err
+				}
+				job.Attempts++
+				return tx.Jobs().Update(job, *job.LockedUntil)
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	job, err := store.Jobs().FindByID(counter.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 21, job.Attempts)
+
+	// case : the writes are seen by the others once committed
+	written := make(chan struct{})
+	read := make(chan error)
+	err = store.WithTx(ctx, func(tx storage.Storage) error {
+		job, _ := tx.Jobs().FindByID(counter.ID)
+		job.Status = models.JobDead
+		if err := tx.Jobs().Update(job, *job.LockedUntil); err != nil {
+			return err
+		}
+
+		go func() {
+			close(written)
+			job, err := store.Jobs().FindByID(counter.ID)
+			if err == nil && job.Status != models.JobDead {
+				err = errors.New("the write isn't seen")
+			}
+			read <- err
+		}()
+		<-written
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, <-read)
+}
@@ -0,0 +1,89 @@
+package test_storage
+
+import (
+	"maps"
+	"sync"
+)
+
+// table is the state of a repository, copied by a transaction on its first use
+type table[T any] interface {
+	comparable
+	lock()
+	unlock()
+	// clone returns a copy of the state with its own lock, the lock of the state is held
+	clone() T
+	// replace replaces the state by the one of the copy, the lock of the state is held
+	replace(copied T)
+}
+
+// transaction runs on copies of the tables it uses, locked from their first use until it ends
+type transaction struct {
+	mu     sync.Mutex
+	copies map[any]any // table -> copy
+	ends   []func(commit bool)
+	done   bool
+}
+
+func newTransaction() *transaction {
+	return &transaction{copies: make(map[any]any)}
+}
+
+// copyOnWrite returns the copy of the table in the transaction, the table itself out of one
+func copyOnWrite[T table[T]](tx *transaction, table T) T {
+	if tx == nil {
+		return table
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.done {
+		return table
+	}
+	if copied, ok := tx.copies[table]; ok {
+		return copied.(T)
+	}
+
+	table.lock()
+	copied := table.clone()
+	tx.copies[table] = copied
+	tx.ends = append(tx.ends, func(commit bool) {
+		if commit {
+			table.replace(copied)
+		}
+		table.unlock()
+	})
+
+	return copied
+}
+
+// end replaces the tables by their copies on commit and unlocks them
+func (tx *transaction) end(commit bool) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	tx.done = true
+	for _, end := range tx.ends {
+		end(commit)
+	}
+}
+
+func copyOf[V any](value *V) *V {
+	copied := *value
+	return &copied
+}
+
+// cloneMap copies the map and its values, so that the copy is changed in place safely
+func cloneMap[K comparable, V any](m map[K]*V, copyValue func(*V) *V) map[K]*V {
+	cloned := make(map[K]*V, len(m))
+	for k, v := range m {
+		cloned[k] = copyValue(v)
+	}
+	return cloned
+}
+
+// replaceMap replaces the entries of dst in place, it stays shared by the copies of its repository
+func replaceMap[K comparable, V any](dst, src map[K]V) {
+	clear(dst)
+	maps.Copy(dst, src)
+}
@@ -0,0 +1,322 @@
+package test_storage
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"vox-server/internal/models"
+	"vox-server/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// webhooks are shared by the repositories of endpoints and deliveries,
+// so that deleting an endpoint deletes its deliveries
+type webhooks struct {
+	endpoints  map[string]*models.WebhookEndpoint // id -> endpoint
+	deliveries map[string]*models.WebhookDelivery // id -> delivery
+	mu         *sync.RWMutex
+}
+
+func newWebhooks() *webhooks {
+	return &webhooks{
+		endpoints:  make(map[string]*models.WebhookEndpoint),
+		deliveries: make(map[string]*models.WebhookDelivery),
+		mu:         &sync.RWMutex{},
+	}
+}
+
+func (shared *webhooks) lock()   { shared.mu.Lock() }
+func (shared *webhooks) unlock() { shared.mu.Unlock() }
+
+func (shared *webhooks) clone() *webhooks {
+	return &webhooks{
+		endpoints:  cloneMap(shared.endpoints, copyWebhookEndpoint),
+		deliveries: cloneMap(shared.deliveries, copyWebhookDelivery),
+		mu:         &sync.RWMutex{},
+	}
+}
+
+func (shared *webhooks) replace(copied *webhooks) {
+	replaceMap(shared.endpoints, copied.endpoints)
+	replaceMap(shared.deliveries, copied.deliveries)
+}
+
+func copyWebhookEndpoint(endpoint *models.WebhookEndpoint) *models.WebhookEndpoint {
+	copied := *endpoint
+	copied.Events = slices.Clone(endpoint.Events)
+	if endpoint.DisabledAt != nil {
+		disabledAt := *endpoint.DisabledAt
+		copied.DisabledAt = &disabledAt
+	}
+	return &copied
+}
+
+func copyWebhookDelivery(delivery *models.WebhookDelivery) *models.WebhookDelivery {
+	copied := *delivery
+	copied.Payload = slices.Clone(delivery.Payload)
+	if delivery.NextAttemptAt != nil {
+		nextAttemptAt := *delivery.NextAttemptAt
+		copied.NextAttemptAt = &nextAttemptAt
+	}
+	if delivery.DeliveredAt != nil {
+		deliveredAt := *delivery.DeliveredAt
+		copied.DeliveredAt = &deliveredAt
+	}
+	return &copied
+}
+
+type WebhookRepository struct {
+	webhooks *webhooks
+}
+
+func NewWebhookRepository() *WebhookRepository {
+	return &WebhookRepository{webhooks: newWebhooks()}
+}
+
+func (repository WebhookRepository) Create(endpoint *models.WebhookEndpoint) error {
+	repository.webhooks.mu.Lock()
+	defer repository.webhooks.mu.Unlock()
+
+	if err := endpoint.Validate(); err != nil {
+		return err
+	}
+
+	if endpoint.ID == "" {
+		endpoint.ID = uuid.New().String()
+	}
+
+	if _, ok := repository.webhooks.endpoints[endpoint.ID]; ok {
+		return fmt.Errorf("webhook with id '%s' %w", endpoint.ID, storage.ErrConflict)
+	}
+
+	repository.webhooks.endpoints[endpoint.ID] = copyWebhookEndpoint(endpoint)
+
+	return nil
+}
+
+func (repository WebhookRepository) FindByID(id string) (*models.WebhookEndpoint, error) {
+	repository.webhooks.mu.RLock()
+	defer repository.webhooks.mu.RUnlock()
+
+	endpoint, ok := repository.webhooks.endpoints[id]
+	if !ok {
+		return nil, fmt.Errorf("webhook with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	return copyWebhookEndpoint(endpoint), nil
+}
+
+func (repository WebhookRepository) FindAll() ([]*models.WebhookEndpoint, error) {
+	return repository.find(func(*models.WebhookEndpoint) bool { return true })
+}
+
+func (repository WebhookRepository) FindSubscribed(event string) ([]*models.WebhookEndpoint, error) {
+	return repository.find(func(endpoint *models.WebhookEndpoint) bool {
+		return endpoint.IsEnabled() && endpoint.Subscribed(event)
+	})
+}
+
+func (repository WebhookRepository) find(match func(*models.WebhookEndpoint) bool) ([]*models.WebhookEndpoint, error) {
+	repository.webhooks.mu.RLock()
+	defer repository.webhooks.mu.RUnlock()
+
+	endpoints := []*models.WebhookEndpoint{}
+	for _, endpoint := range repository.webhooks.endpoints {
+		if match(endpoint) {
+			endpoints = append(endpoints, copyWebhookEndpoint(endpoint))
+		}
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].CreatedAt.Before(endpoints[j].CreatedAt)
+	})
+
+	return endpoints, nil
+}
+
+func (repository WebhookRepository) Update(endpoint *models.WebhookEndpoint) error {
+	repository.webhooks.mu.Lock()
+	defer repository.webhooks.mu.Unlock()
+
+	stored, ok := repository.webhooks.endpoints[endpoint.ID]
+	if !ok {
+		return fmt.Errorf("webhook with id '%s' %w", endpoint.ID, storage.ErrNotFound)
+	}
+
+	// the owner and the secret are never changed
+	updated := copyWebhookEndpoint(endpoint)
+	updated.OwnerLogin = stored.OwnerLogin
+	updated.Secret = stored.Secret
+	updated.CreatedAt = stored.CreatedAt
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+
+	repository.webhooks.endpoints[endpoint.ID] = updated
+
+	return nil
+}
+
+func (repository WebhookRepository) RecordFailure(id string) (int, error) {
+	repository.webhooks.mu.Lock()
+	defer repository.webhooks.mu.Unlock()
+
+	endpoint, ok := repository.webhooks.endpoints[id]
+	if !ok {
+		return 0, fmt.Errorf("webhook with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	endpoint.Failures++
+
+	return endpoint.Failures, nil
+}
+
+func (repository WebhookRepository) ResetFailures(id string) error {
+	repository.webhooks.mu.Lock()
+	defer repository.webhooks.mu.Unlock()
+
+	if endpoint, ok := repository.webhooks.endpoints[id]; ok {
+		endpoint.Failures = 0
+	}
+
+	return nil
+}
+
+func (repository WebhookRepository) Disable(id string, at time.Time) (bool, error) {
+	repository.webhooks.mu.Lock()
+	defer repository.webhooks.mu.Unlock()
+
+	endpoint, ok := repository.webhooks.endpoints[id]
+	if !ok || !endpoint.IsEnabled() {
+		return false, nil
+	}
+
+	endpoint.DisabledAt = &at
+
+	return true, nil
+}
+
+func (repository WebhookRepository) Delete(id string) error {
+	repository.webhooks.mu.Lock()
+	defer repository.webhooks.mu.Unlock()
+
+	if _, ok := repository.webhooks.endpoints[id]; !ok {
+		return fmt.Errorf("webhook with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	delete(repository.webhooks.endpoints, id)
+	for deliveryID, delivery := range repository.webhooks.deliveries {
+		if delivery.EndpointID == id {
+			delete(repository.webhooks.deliveries, deliveryID)
+		}
+	}
+
+	return nil
+}
+
+type WebhookDeliveryRepository struct {
+	webhooks *webhooks
+}
+
+func (repository WebhookDeliveryRepository) Create(delivery *models.WebhookDelivery) error {
+	repository.webhooks.mu.Lock()
+	defer repository.webhooks.mu.Unlock()
+
+	if _, ok := repository.webhooks.endpoints[delivery.EndpointID]; !ok {
+		return fmt.Errorf("webhook with id '%s' %w", delivery.EndpointID, storage.ErrNotFound)
+	}
+
+	if delivery.ID == "" {
+		delivery.ID = uuid.New().String()
+	}
+
+	repository.webhooks.deliveries[delivery.ID] = copyWebhookDelivery(delivery)
+
+	return nil
+}
+
+func (repository WebhookDeliveryRepository) FindByID(id string) (*models.WebhookDelivery, error) {
+	repository.webhooks.mu.RLock()
+	defer repository.webhooks.mu.RUnlock()
+
+	delivery, ok := repository.webhooks.deliveries[id]
+	if !ok {
+		return nil, fmt.Errorf("webhook delivery with id '%s' %w", id, storage.ErrNotFound)
+	}
+
+	return copyWebhookDelivery(delivery), nil
+}
+
+func (repository WebhookDeliveryRepository) FindByEndpoint(endpointID string, limit int) ([]*models.WebhookDelivery, error) {
+	repository.webhooks.mu.RLock()
+	defer repository.webhooks.mu.RUnlock()
+
+	deliveries := []*models.WebhookDelivery{}
+	for _, delivery := range repository.webhooks.deliveries {
+		if delivery.EndpointID == endpointID {
+			deliveries = append(deliveries, copyWebhookDelivery(delivery))
+		}
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+	})
+
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+
+	return deliveries, nil
+}
+
+func (repository WebhookDeliveryRepository) Claim(now, leaseEnd time.Time, limit int) ([]*models.WebhookDelivery, error) {
+	repository.webhooks.mu.Lock()
+	defer repository.webhooks.mu.Unlock()
+
+	due := []*models.WebhookDelivery{}
+	for _, delivery := range repository.webhooks.deliveries {
+		if delivery.Status == models.WebhookDeliveryPending && delivery.NextAttemptAt != nil && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].CreatedAt.Before(due[j].CreatedAt)
+	})
+
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]*models.WebhookDelivery, 0, len(due))
+	for _, delivery := range due {
+		lease := leaseEnd
+		delivery.NextAttemptAt = &lease
+		claimed = append(claimed, copyWebhookDelivery(delivery))
+	}
+
+	return claimed, nil
+}
+
+func (repository WebhookDeliveryRepository) Update(delivery *models.WebhookDelivery, lease time.Time) error {
+	repository.webhooks.mu.Lock()
+	defer repository.webhooks.mu.Unlock()
+
+	stored, ok := repository.webhooks.deliveries[delivery.ID]
+	if !ok || stored.Status != models.WebhookDeliveryPending || stored.NextAttemptAt == nil || !stored.NextAttemptAt.Equal(lease) {
+		return fmt.Errorf("webhook delivery with id '%s' %w", delivery.ID, storage.ErrLeaseLost)
+	}
+
+	updated := copyWebhookDelivery(delivery)
+	stored.Status = updated.Status
+	stored.Attempts = updated.Attempts
+	stored.ResponseStatus = updated.ResponseStatus
+	stored.Error = updated.Error
+	stored.NextAttemptAt = updated.NextAttemptAt
+	stored.DeliveredAt = updated.DeliveredAt
+
+	return nil
+}
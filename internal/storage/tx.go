@@ -0,0 +1,40 @@
+package storage
+
+import "database/sql"
+
+// DefaultTxRetries is the number of times WithTx runs again a transaction failing to serialize
+const DefaultTxRetries = 3
+
+// TxOptions configure a transaction of WithTx
+type TxOptions struct {
+	// sql.LevelDefault is the level of the database, read committed for postgres
+	Isolation sql.IsolationLevel
+	// the runs of fn after the first one, when the transaction fails to serialize or deadlocks
+	Retries int
+}
+
+type TxOption func(*TxOptions)
+
+// NewTxOptions returns the options of a transaction, retried DefaultTxRetries times unless set
+func NewTxOptions(opts ...TxOption) TxOptions {
+	options := TxOptions{Retries: DefaultTxRetries}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// Isolation runs the transaction at the isolation level, e.g. sql.LevelSerializable
+func Isolation(level sql.IsolationLevel) TxOption {
+	return func(options *TxOptions) {
+		options.Isolation = level
+	}
+}
+
+// Retries sets the runs of fn after a serialization failure, 0 runs it once.
+// fn must be safe to run again: its effects outside of tx aren't rolled back
+func Retries(n int) TxOption {
+	return func(options *TxOptions) {
+		options.Retries = max(n, 0)
+	}
+}
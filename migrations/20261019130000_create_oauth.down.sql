@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS oauth_consents;
+
+DROP TABLE IF EXISTS oauth_authorization_codes;
+
+DROP INDEX IF EXISTS idx_oauth_clients_owner_login;
+
+DROP TABLE IF EXISTS oauth_clients;
@@ -0,0 +1,11 @@
+CREATE TABLE sessions (
+    id UUID PRIMARY KEY,
+    user_login TEXT NOT NULL REFERENCES users (login) ON DELETE CASCADE,
+    client_id UUID REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_sessions_user_login ON sessions (user_login);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_jobs_finished_at;
+
+DROP INDEX IF EXISTS idx_jobs_locked_until;
+
+DROP INDEX IF EXISTS idx_jobs_run_at;
+
+DROP TABLE IF EXISTS jobs;
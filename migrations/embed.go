@@ -0,0 +1,11 @@
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
+
+// SQLiteFS holds the same migrations, in the sqlite dialect
+//
+//go:embed sqlite/*.sql
+var SQLiteFS embed.FS
@@ -0,0 +1,2 @@
+-- the email is unique since the users table is created, constraints can't be added to a table
+CREATE INDEX idx_users_email ON users (email);
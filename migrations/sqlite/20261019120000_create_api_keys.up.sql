@@ -0,0 +1,14 @@
+-- uuids are stored as text, arrays as JSON arrays and times as text in UTC
+CREATE TABLE api_keys (
+    id TEXT PRIMARY KEY,
+    user_login TEXT NOT NULL REFERENCES users (login) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL UNIQUE,
+    key_hash TEXT NOT NULL,
+    scopes TEXT NOT NULL DEFAULT '[]',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at DATETIME,
+    revoked_at DATETIME
+);
+
+CREATE INDEX idx_api_keys_user_login ON api_keys (user_login);
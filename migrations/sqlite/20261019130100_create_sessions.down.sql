@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_sessions_user_login;
+
+DROP TABLE IF EXISTS sessions;
@@ -0,0 +1,11 @@
+CREATE TABLE sessions (
+    id TEXT PRIMARY KEY,
+    user_login TEXT NOT NULL REFERENCES users (login) ON DELETE CASCADE,
+    client_id TEXT REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    scopes TEXT NOT NULL DEFAULT '[]',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL,
+    revoked_at DATETIME
+);
+
+CREATE INDEX idx_sessions_user_login ON sessions (user_login);
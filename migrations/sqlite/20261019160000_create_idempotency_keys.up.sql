@@ -0,0 +1,15 @@
+CREATE TABLE idempotency_keys (
+    -- empty for anonymous requests
+    user_login TEXT NOT NULL DEFAULT '',
+    key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    -- 0 while the first request is in flight
+    status INTEGER NOT NULL DEFAULT 0,
+    header TEXT,
+    body BLOB,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL,
+    PRIMARY KEY (user_login, key)
+);
+
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
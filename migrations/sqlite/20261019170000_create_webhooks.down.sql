@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_webhook_deliveries_next_attempt_at;
+
+DROP INDEX IF EXISTS idx_webhook_deliveries_endpoint_id;
+
+DROP TABLE IF EXISTS webhook_deliveries;
+
+DROP TABLE IF EXISTS webhook_endpoints;
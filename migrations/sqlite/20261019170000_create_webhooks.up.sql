@@ -0,0 +1,33 @@
+CREATE TABLE webhook_endpoints (
+    id TEXT PRIMARY KEY,
+    -- the admin who registered it, kept when the account is deleted
+    owner_login TEXT NOT NULL,
+    url TEXT NOT NULL,
+    events TEXT NOT NULL,
+    -- signs the deliveries, so it is stored in plain
+    secret TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    disabled_at DATETIME
+);
+
+CREATE TABLE webhook_deliveries (
+    id TEXT PRIMARY KEY,
+    endpoint_id TEXT NOT NULL REFERENCES webhook_endpoints (id) ON DELETE CASCADE,
+    event_id TEXT NOT NULL,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_status INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    next_attempt_at DATETIME,
+    delivered_at DATETIME
+);
+
+CREATE INDEX idx_webhook_deliveries_endpoint_id ON webhook_deliveries (endpoint_id, created_at);
+
+-- only pending deliveries are claimed
+CREATE INDEX idx_webhook_deliveries_next_attempt_at ON webhook_deliveries (next_attempt_at)
+WHERE status = 'pending';
@@ -0,0 +1,25 @@
+CREATE TABLE jobs (
+    id TEXT PRIMARY KEY,
+    kind TEXT NOT NULL,
+    -- e.g. of a run of a recurring job, so that only one server enqueues it
+    key TEXT UNIQUE,
+    payload TEXT,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    run_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until DATETIME,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at DATETIME
+);
+
+-- only pending jobs and running jobs whose lease may end are claimed
+CREATE INDEX idx_jobs_run_at ON jobs (run_at)
+WHERE status = 'pending';
+
+CREATE INDEX idx_jobs_locked_until ON jobs (locked_until)
+WHERE status = 'running';
+
+CREATE INDEX idx_jobs_finished_at ON jobs (finished_at)
+WHERE finished_at IS NOT NULL;